@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	accessLogCommon   = "common"
+	accessLogCombined = "combined"
+)
+
+// accessLogger writes one line per request in the Common or Combined Log
+// Format, for tooling that only parses those. Query strings are left out of
+// the request line and the referer: search terms are personal data, and the
+// access log is not redacted like the structured log.
+type accessLogger struct {
+	mu       sync.Mutex
+	w        io.Writer
+	combined bool
+	now      func() time.Time
+}
+
+// newAccessLogger opens dest, a file appended to or "stdout" or "stderr".
+// An empty dest disables the access log.
+func newAccessLogger(dest, format string) (*accessLogger, error) {
+	var w io.Writer
+	switch dest {
+	case "":
+		return nil, nil
+	case "stdout":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	default:
+		f, err := os.OpenFile(dest, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open access log: %w", err)
+		}
+		w = f
+	}
+	return &accessLogger{w: w, combined: format == accessLogCombined, now: time.Now}, nil
+}
+
+func (l *accessLogger) middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := l.now()
+		cw := &countingWriter{ResponseWriter: w}
+		next.ServeHTTP(cw, r)
+		l.write(r, start, max(cw.status, http.StatusOK), cw.bytes)
+	})
+}
+
+func (l *accessLogger) write(r *http.Request, start time.Time, status int, bytes int64) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	size := "-"
+	if bytes > 0 {
+		size = fmt.Sprint(bytes)
+	}
+	line := fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s",
+		host, start.Format("02/Jan/2006:15:04:05 -0700"), r.Method, r.URL.EscapedPath(), r.Proto, status, size)
+	if l.combined {
+		line += fmt.Sprintf(" \"%s\" \"%s\"", clfQuote(withoutQuery(r.Referer())), clfQuote(r.UserAgent()))
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	io.WriteString(l.w, line+"\n")
+}
+
+func withoutQuery(ref string) string {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	u.RawQuery, u.Fragment = "", ""
+	return u.String()
+}
+
+// clfQuote escapes s for a quoted field, with "-" standing for empty.
+func clfQuote(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+type countingWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (cw *countingWriter) WriteHeader(code int) {
+	if cw.status == 0 {
+		cw.status = code
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	n, err := cw.ResponseWriter.Write(p)
+	cw.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the connection, for flushing.
+func (cw *countingWriter) Unwrap() http.ResponseWriter { return cw.ResponseWriter }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAccessLog(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	})
+	at := time.Date(2024, 3, 5, 14, 7, 9, 0, time.FixedZone("", 3*60*60))
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{accessLogCommon, `192.0.2.1 - - [05/Mar/2024:14:07:09 +0300] "POST /api/v1/persons HTTP/1.1" 201 5` + "\n"},
+		{accessLogCombined, `192.0.2.1 - - [05/Mar/2024:14:07:09 +0300] "POST /api/v1/persons HTTP/1.1" 201 5 "http://ui.example/persons" "curl/8.0 \"test\""` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			l := &accessLogger{w: &buf, combined: tt.format == accessLogCombined, now: func() time.Time { return at }}
+			req := httptest.NewRequest("POST", "/api/v1/persons?q=Ann", nil)
+			req.RemoteAddr = "192.0.2.1:51234"
+			req.Header.Set("Referer", "http://ui.example/persons?q=Ann")
+			req.Header.Set("User-Agent", `curl/8.0 "test"`)
+			l.middleware(h).ServeHTTP(httptest.NewRecorder(), req)
+
+			if buf.String() != tt.want {
+				t.Errorf("Unexpected line\n got: %s\nwant: %s", buf.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+)
+
+type LogLevelRequest struct {
+	Level string `json:"level"`
+}
+
+type LogLevelResponse struct {
+	Level string `json:"level"`
+}
+
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// requireAdmin only lets requests through that carry the configured admin
+// token as a bearer token, or as the password of HTTP basic auth so browsers
+// can open the dashboard. With no token configured every request is refused.
+func (app *application) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			_, token, ok = r.BasicAuth()
+		}
+		if !ok || app.cfg.adminToken == "" ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(app.cfg.adminToken)) != 1 {
+			w.Header().Add("WWW-Authenticate", `Bearer realm="admin"`)
+			w.Header().Add("WWW-Authenticate", `Basic realm="admin", charset="UTF-8"`)
+			sendError(w, apierr.Unauthorized, "Admin token required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) getLogLevel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LogLevelResponse{Level: strings.ToLower(app.logLevel.Level().String())})
+}
+
+// setLogLevel switches the level of the process logger without a restart.
+func (app *application) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req LogLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendValidationError(w, apierr.InvalidJSON, "Invalid json", []apierr.FieldError{
+			apierr.NewFieldError("body", apierr.KeyInvalidJSON, nil),
+		})
+		return
+	}
+	level, ok := logLevels[strings.ToLower(req.Level)]
+	if !ok {
+		sendValidationError(w, apierr.ValidationFailed, "Invalid log level", []apierr.FieldError{
+			apierr.NewFieldError("level", apierr.KeyOneOf, map[string]any{"allowed": "debug, info, warn, error", "actual": req.Level}),
+		})
+		return
+	}
+
+	previous := app.logLevel.Level()
+	app.logLevel.Set(level)
+	slog.Warn("log level changed", "from", previous, "to", level)
+	app.getLogLevel(w, r)
+}
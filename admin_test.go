@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestAdminLogLevel(t *testing.T) {
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	app.cfg.adminToken = "s3cret"
+	router := app.routes()
+
+	do := func(method, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/admin/loglevel", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	testCases := []struct {
+		name         string
+		method       string
+		token        string
+		body         string
+		expectedCode int
+		expectedLvl  slog.Level
+	}{
+		{"No token", "PUT", "", `{"level":"debug"}`, http.StatusUnauthorized, slog.LevelInfo},
+		{"Wrong token", "PUT", "guess", `{"level":"debug"}`, http.StatusUnauthorized, slog.LevelInfo},
+		{"Unknown level", "PUT", "s3cret", `{"level":"verbose"}`, http.StatusBadRequest, slog.LevelInfo},
+		{"Switch to debug", "PUT", "s3cret", `{"level":"debug"}`, http.StatusOK, slog.LevelDebug},
+		{"Read back", "GET", "s3cret", "", http.StatusOK, slog.LevelDebug},
+		{"Switch to warn", "PUT", "s3cret", `{"level":"WARN"}`, http.StatusOK, slog.LevelWarn},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := do(tc.method, tc.token, tc.body)
+			if rr.Code != tc.expectedCode {
+				t.Fatalf("Expected %d, got %d: %s", tc.expectedCode, rr.Code, rr.Body.String())
+			}
+			if got := app.logLevel.Level(); got != tc.expectedLvl {
+				t.Errorf("Expected level %s, got %s", tc.expectedLvl, got)
+			}
+			if rr.Code == http.StatusUnauthorized && decodeErrorCode(t, rr) != apierr.Unauthorized {
+				t.Errorf("Expected %s error code", apierr.Unauthorized)
+			}
+			if rr.Code == http.StatusOK {
+				var resp LogLevelResponse
+				json.NewDecoder(rr.Body).Decode(&resp)
+				if !strings.EqualFold(resp.Level, tc.expectedLvl.String()) {
+					t.Errorf("Expected response level %s, got %q", tc.expectedLvl, resp.Level)
+				}
+			}
+		})
+	}
+}
+
+func TestAdminDisabledWithoutToken(t *testing.T) {
+	router := newTestAppWithStore(testutil.NewMemoryStore()).routes()
+
+	req := httptest.NewRequest("GET", "/admin/loglevel", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 when no admin token is configured, got %d", rr.Code)
+	}
+}
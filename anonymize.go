@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+const (
+	anonymizeBatch = 100
+	anonymizeEvery = time.Hour
+	// anonymizedName replaces the name of anonymized persons, as a name
+	// cannot be empty.
+	anonymizedName = "Anonymized"
+)
+
+// errUpdatedSince skips a person updated after it was picked for
+// anonymization.
+var errUpdatedSince = errors.New("updated since")
+
+// anonymizeStale anonymizes the persons last updated before before, a batch
+// at a time, and counts them. Each keeps its ID, but loses its name, every
+// other detail, its photo, its attachments and its history in the change
+// feed and the event log.
+func (app *application) anonymizeStale(ctx context.Context, before time.Time) (int, error) {
+	anonymized := 0
+	done := map[int32]bool{}
+	for {
+		// Anonymizing updates a person, so each batch starts over from the
+		// first stale one.
+		batch, err := app.store.ListPersons(ctx, store.ListFilter{UpdatedBefore: before, Limit: anonymizeBatch})
+		if err != nil {
+			return anonymized, err
+		}
+		if len(batch) == 0 {
+			return anonymized, nil
+		}
+		for _, p := range batch {
+			// Listed again, so anonymizing it did not make it newer.
+			if done[p.ID] {
+				return anonymized, fmt.Errorf("person %d is still listed as stale after anonymizing it", p.ID)
+			}
+			done[p.ID] = true
+			ok, err := app.anonymize(ctx, p.ID, before)
+			if err != nil {
+				return anonymized, fmt.Errorf("anonymize person %d: %w", p.ID, err)
+			}
+			if ok {
+				anonymized++
+			}
+		}
+		slog.InfoContext(ctx, "anonymizing stale persons", "anonymized", anonymized, "before", before)
+	}
+}
+
+// anonymize reports false for a person deleted or updated since it was
+// listed. Its history is deleted after the person is anonymized, taking the
+// anonymizing change with it.
+func (app *application) anonymize(ctx context.Context, id int32, before time.Time) (bool, error) {
+	_, err := app.store.ModifyPerson(ctx, id, func(p *store.Person) error {
+		if !p.UpdatedAt.Before(before) {
+			return errUpdatedSince
+		}
+		*p = store.Person{ID: p.ID, Name: anonymizedName, UpdatedAt: p.UpdatedAt}
+		return nil
+	})
+	if errors.Is(err, errUpdatedSince) || errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if app.historyPurge != nil {
+		if _, err := app.historyPurge.PurgePersonHistory(ctx, id); err != nil {
+			return true, fmt.Errorf("purge history: %w", err)
+		}
+	}
+	if err := app.deleteAttachmentsOf(ctx, id); err != nil {
+		return true, fmt.Errorf("delete attachments: %w", err)
+	}
+	if app.photos == nil {
+		return true, nil
+	}
+	photo, err := app.photos.DeletePhoto(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return true, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("delete photo: %w", err)
+	}
+	if app.blobs != nil {
+		app.deletePhotoObjects(ctx, photo.ObjectKey)
+	}
+	return true, nil
+}
+
+// deleteAttachmentsOf deletes the attachments of the person and their
+// objects. What it fails to delete of the objects is left to
+// sweepOrphanObjects.
+func (app *application) deleteAttachmentsOf(ctx context.Context, id int32) error {
+	if app.attachments == nil {
+		return nil
+	}
+	list, err := app.attachments.ListAttachments(ctx, id, store.AttachmentFilter{})
+	if err != nil {
+		return err
+	}
+	for _, a := range list {
+		if _, err := app.attachments.DeleteAttachment(ctx, id, a.ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		if app.blobs == nil {
+			continue
+		}
+		if err := app.blobs.Delete(context.WithoutCancel(ctx), a.ObjectKey); err != nil {
+			slog.WarnContext(ctx, "failed to delete attachment object", "key", a.ObjectKey, "err", err)
+		}
+	}
+	return nil
+}
+
+// anonymizeStaleEvery anonymizes the persons not updated for anonymizeAfter
+// every anonymizeEvery until ctx is done.
+func (app *application) anonymizeStaleEvery(ctx context.Context) {
+	ticker := time.NewTicker(anonymizeEvery)
+	defer ticker.Stop()
+	for {
+		n, err := app.anonymizeStale(ctx, time.Now().Add(-app.cfg.anonymizeAfter))
+		if err != nil {
+			slog.WarnContext(ctx, "failed to anonymize stale persons", "anonymized", n, "err", err)
+		} else if n > 0 {
+			slog.InfoContext(ctx, "anonymized stale persons", "anonymized", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runAnonymizeCommand implements `lab1 anonymize [-after duration]`, which
+// anonymizes the stale persons right away, and returns the exit status.
+func runAnonymizeCommand(ctx context.Context, app *application, args []string, w io.Writer) int {
+	fs := flag.NewFlagSet("anonymize", flag.ContinueOnError)
+	fs.SetOutput(w)
+	after := fs.Duration("after", app.cfg.anonymizeAfter, "anonymize persons not updated for this long (default ANONYMIZE_AFTER)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *after <= 0 {
+		fmt.Fprintln(w, "anonymize needs -after or ANONYMIZE_AFTER")
+		return 2
+	}
+	n, err := app.anonymizeStale(ctx, time.Now().Add(-*after))
+	if err != nil {
+		slog.Error("failed to anonymize stale persons", "anonymized", n, "err", err)
+		return 1
+	}
+	slog.Info("anonymized stale persons", "anonymized", n)
+	return 0
+}
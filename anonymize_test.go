@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/blob"
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestAnonymizeStale(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	old, recent := now.AddDate(-6, 0, 0), now.AddDate(-1, 0, 0)
+	age, email := int32(40), "ann@example.com"
+	st := testutil.NewMemoryStore()
+	st.Now = func() time.Time { return now }
+	for i := range anonymizeBatch + 2 {
+		st.Put(store.Person{Name: "Ann", Age: &age, Email: &email, UpdatedAt: old.Add(time.Duration(i) * time.Second)})
+	}
+	kept := st.Put(store.Person{Name: "Bob", Age: &age, UpdatedAt: recent})
+	app := newTestAppWithStore(st)
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.photos, app.attachments, app.historyPurge, app.blobs = st, st, st, blobs
+	ctx := context.Background()
+	id := int32(1)
+	if _, _, err := st.SetPhoto(ctx, store.Photo{PersonID: &id, ObjectKey: "photos/1/a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := blobs.Put(ctx, "attachments/1/a", strings.NewReader("x"), 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.CreateAttachment(ctx, store.Attachment{PersonID: &id, ObjectKey: "attachments/1/a", Filename: "a.pdf"}); err != nil {
+		t.Fatal(err)
+	}
+	// History of person 1 and of the person that stays, clearing the e-mail
+	// address the other persons share.
+	for _, p := range []struct {
+		id int32
+		at time.Time
+	}{{1, old}, {kept.ID, recent}} {
+		st.Now = func() time.Time { return p.at }
+		if _, err := st.ModifyPerson(ctx, p.id, func(p *store.Person) error { p.Email = nil; return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	st.Now = func() time.Time { return now }
+
+	n, err := app.anonymizeStale(ctx, now.AddDate(-5, 0, 0))
+	if err != nil || n != anonymizeBatch+2 {
+		t.Fatalf("Expected %d persons anonymized, got %d: %v", anonymizeBatch+2, n, err)
+	}
+	p, err := st.GetPerson(ctx, 1)
+	if err != nil || p.Name != anonymizedName || p.Age != nil || p.Email != nil || !p.UpdatedAt.Equal(now) {
+		t.Errorf("Expected person 1 anonymized now, got %+v %v", p, err)
+	}
+	if _, err := st.Photo(ctx, 1); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected the photo deleted, got %v", err)
+	}
+	if p, _ := st.GetPerson(ctx, kept.ID); p.Name != "Bob" || p.Age == nil {
+		t.Errorf("Expected a recently updated person kept, got %+v", p)
+	}
+	changes, err := st.Changes(ctx, 0, 100)
+	if err != nil || len(changes) != 1 || changes[0].Person.ID != kept.ID {
+		t.Errorf("Expected only the history of the kept person left, got %+v %v", changes, err)
+	}
+	if list, err := st.ListAttachments(ctx, 1, store.AttachmentFilter{}); err != nil || len(list) != 0 {
+		t.Errorf("Expected the attachments deleted, got %+v %v", list, err)
+	}
+	if _, err := blobs.Stat(ctx, "attachments/1/a"); !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("Expected the attachment object deleted, got %v", err)
+	}
+
+	if n, err := app.anonymizeStale(ctx, now.AddDate(-5, 0, 0)); err != nil || n != 0 {
+		t.Errorf("Expected nothing left to anonymize, got %d: %v", n, err)
+	}
+
+	var out bytes.Buffer
+	if got := runAnonymizeCommand(ctx, app, nil, &out); got != 2 {
+		t.Errorf("Expected exit status 2 without a period, got %d", got)
+	}
+	st.Now = time.Now
+	if got := runAnonymizeCommand(ctx, app, []string{"-after", "1h"}, &out); got != 0 {
+		t.Errorf("Expected exit status 0, got %d", got)
+	}
+	if p, _ := st.GetPerson(ctx, kept.ID); p.Name != anonymizedName {
+		t.Errorf("Expected -after to anonymize persons not updated for an hour, got %+v", p)
+	}
+}
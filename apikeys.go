@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+const apiKeyHeader = "X-API-Key"
+
+type UsageResponse struct {
+	Key          string `json:"key"`
+	Month        string `json:"month"`
+	Requests     int64  `json:"requests"`
+	RequestQuota *int64 `json:"request_quota,omitempty"`
+	RowsWritten  int64  `json:"rows_written"`
+	WriteQuota   *int64 `json:"write_quota,omitempty"`
+}
+
+// hashSecret is how API keys and refresh tokens are stored; the plain value
+// is never persisted.
+func hashSecret(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+// usageMonth is the first day of t's month in UTC, the period quotas cover.
+func usageMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func apiKeyFromContext(ctx context.Context) (store.APIKey, bool) {
+	k, ok := ctx.Value(apiKeyKey).(store.APIKey)
+	return k, ok
+}
+
+func isWrite(method string) bool {
+	return method != http.MethodGet && method != http.MethodHead && method != http.MethodOptions
+}
+
+func requiredScope(write bool) string {
+	if write {
+		return store.ScopeWrite
+	}
+	return store.ScopeRead
+}
+
+// withAPIKey authenticates the X-API-Key header, checks the key's scopes,
+// counts the request against its monthly quota and refuses it with 429 once
+// the quota is used up. Every successful write counts as one row written;
+// write requests are refused when the write quota is used up. Requests
+// without a key pass through unless requireAPIKey is set.
+func (app *application) withAPIKey(next http.Handler) http.Handler {
+	if app.keys == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw := r.Header.Get(apiKeyHeader)
+		if raw == "" {
+			if app.cfg.requireAPIKey {
+				w.Header().Set("WWW-Authenticate", `APIKey header="`+apiKeyHeader+`"`)
+				sendError(w, apierr.Unauthorized, "API key required")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key, err := app.keys.APIKeyByHash(r.Context(), hashSecret(raw))
+		if errors.Is(err, store.ErrNotFound) {
+			w.Header().Set("WWW-Authenticate", `APIKey header="`+apiKeyHeader+`"`)
+			sendError(w, apierr.Unauthorized, "Invalid API key")
+			return
+		}
+		if err != nil {
+			sendStoreError(w, r, err)
+			return
+		}
+		now := time.Now()
+		if !key.Active(now) {
+			w.Header().Set("WWW-Authenticate", `APIKey header="`+apiKeyHeader+`"`)
+			sendError(w, apierr.Unauthorized, "API key expired or revoked")
+			return
+		}
+		write := isWrite(r.Method)
+		if scope := requiredScope(write); !slices.Contains(key.Scopes, scope) {
+			sendError(w, apierr.Forbidden, "API key lacks the "+scope+" scope")
+			return
+		}
+
+		month := usageMonth(now)
+		usage, err := app.keys.RecordRequest(r.Context(), key.ID, month)
+		if err != nil {
+			sendStoreError(w, r, err)
+			return
+		}
+		retryAfter := strconv.Itoa(max(int(month.AddDate(0, 1, 0).Sub(now)/time.Second), 1))
+		if key.MonthlyRequests != nil && usage.Requests > *key.MonthlyRequests {
+			w.Header().Set("Retry-After", retryAfter)
+			sendProblem(w, r, apierr.QuotaExceeded, "monthly request quota of this API key is used up")
+			return
+		}
+		if write && key.MonthlyWrites != nil && usage.RowsWritten >= *key.MonthlyWrites {
+			w.Header().Set("Retry-After", retryAfter)
+			sendProblem(w, r, apierr.QuotaExceeded, "monthly write quota of this API key is used up")
+			return
+		}
+
+		sr := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(sr, r.WithContext(context.WithValue(r.Context(), apiKeyKey, key)))
+		if !write || sr.status >= 300 {
+			return
+		}
+		if err := app.keys.RecordWrites(r.Context(), key.ID, month, 1); err != nil {
+			slog.WarnContext(r.Context(), "failed to record api key writes", "key_id", key.ID, "err", err)
+		}
+	})
+}
+
+// rateLimitKey limits each API key on its own and anonymous clients by IP.
+func rateLimitKey(r *http.Request) string {
+	if k, ok := apiKeyFromContext(r.Context()); ok {
+		return "key:" + strconv.Itoa(int(k.ID))
+	}
+	return remoteIP(r)
+}
+
+func (app *application) getUsage(w http.ResponseWriter, r *http.Request) {
+	key, ok := apiKeyFromContext(r.Context())
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `APIKey header="`+apiKeyHeader+`"`)
+		sendError(w, apierr.Unauthorized, "API key required")
+		return
+	}
+	usage, err := app.keys.KeyUsage(r.Context(), key.ID, usageMonth(time.Now()))
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UsageResponse{
+		Key:          key.Name,
+		Month:        usage.Month.Format("2006-01"),
+		Requests:     usage.Requests,
+		RequestQuota: key.MonthlyRequests,
+		RowsWritten:  usage.RowsWritten,
+		WriteQuota:   key.MonthlyWrites,
+	})
+}
+
+type APIKeyRequest struct {
+	Name            *string    `json:"name"`
+	Scopes          []string   `json:"scopes,omitempty"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+	MonthlyRequests *int64     `json:"monthly_requests,omitempty"`
+	MonthlyWrites   *int64     `json:"monthly_writes,omitempty"`
+}
+
+type APIKeyResponse struct {
+	ID              int32      `json:"id"`
+	Name            string     `json:"name"`
+	Prefix          string     `json:"prefix"`
+	Scopes          []string   `json:"scopes"`
+	MonthlyRequests *int64     `json:"monthly_requests,omitempty"`
+	MonthlyWrites   *int64     `json:"monthly_writes,omitempty"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+	RevokedAt       *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// NewAPIKeyResponse is the only place the plain key is ever shown.
+type NewAPIKeyResponse struct {
+	APIKeyResponse
+	Key string `json:"key"`
+}
+
+func toAPIKeyResponse(k store.APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:              k.ID,
+		Name:            k.Name,
+		Prefix:          k.Prefix,
+		Scopes:          k.Scopes,
+		MonthlyRequests: k.MonthlyRequests,
+		MonthlyWrites:   k.MonthlyWrites,
+		ExpiresAt:       k.ExpiresAt,
+		RevokedAt:       k.RevokedAt,
+		CreatedAt:       k.CreatedAt.UTC(),
+	}
+}
+
+const (
+	apiKeyPrefix    = "pk_"
+	apiKeyShownSize = len(apiKeyPrefix) + 8
+)
+
+// newAPIKey returns a random key and the prefix listings show for it.
+func newAPIKey() (key, prefix string, err error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key = apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, key[:apiKeyShownSize], nil
+}
+
+func validateAPIKeyRequest(req APIKeyRequest, now time.Time) []apierr.FieldError {
+	var errs []apierr.FieldError
+	if req.Name == nil || strings.TrimSpace(*req.Name) == "" {
+		errs = append(errs, apierr.NewFieldError("name", apierr.KeyRequired, nil))
+	}
+	errs = appendMaxLength(errs, "name", req.Name, maxNameLength)
+	for _, s := range req.Scopes {
+		if !slices.Contains(store.Scopes, s) {
+			errs = append(errs, apierr.NewFieldError("scopes", apierr.KeyOneOf, map[string]any{
+				"allowed": strings.Join(store.Scopes, ", "), "actual": s,
+			}))
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		errs = append(errs, apierr.NewFieldError("expires_at", apierr.KeyRejected, map[string]any{"reason": "must be in the future"}))
+	}
+	errs = appendNonNegative(errs, "monthly_requests", req.MonthlyRequests)
+	errs = appendNonNegative(errs, "monthly_writes", req.MonthlyWrites)
+	return errs
+}
+
+func appendNonNegative(errs []apierr.FieldError, field string, v *int64) []apierr.FieldError {
+	if v != nil && *v < 0 {
+		errs = append(errs, apierr.NewFieldError(field, apierr.KeyMinValue, map[string]any{"limit": 0, "actual": *v}))
+	}
+	return errs
+}
+
+// createAPIKey issues a key for a new consumer. The response is the only time
+// the key is shown; only its hash is stored.
+func (app *application) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var req APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, apierr.InvalidJSON, "json decoding error")
+		return
+	}
+	if errs := validateAPIKeyRequest(req, time.Now()); len(errs) > 0 {
+		sendValidationError(w, apierr.ValidationFailed, "api key validation error", errs)
+		return
+	}
+	key, prefix, err := newAPIKey()
+	if err != nil {
+		sendDebugError(w, apierr.Internal, "Failed to generate key", errorDebug(r.Context(), err, 0))
+		return
+	}
+	scopes := slices.Clone(store.Scopes)
+	if len(req.Scopes) > 0 {
+		scopes = slices.Clone(req.Scopes)
+		slices.Sort(scopes)
+		scopes = slices.Compact(scopes)
+	}
+	created, err := app.keys.CreateAPIKey(r.Context(), store.APIKey{
+		Name:            *req.Name,
+		Prefix:          prefix,
+		Scopes:          scopes,
+		MonthlyRequests: req.MonthlyRequests,
+		MonthlyWrites:   req.MonthlyWrites,
+		ExpiresAt:       req.ExpiresAt,
+	}, hashSecret(key))
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "api key created", "id", created.ID, "name", created.Name)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/admin/api-keys/%d", created.ID))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(NewAPIKeyResponse{APIKeyResponse: toAPIKeyResponse(created), Key: key})
+}
+
+func (app *application) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.keys.ListAPIKeys(r.Context())
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	resp := make([]APIKeyResponse, 0, len(keys))
+	for _, k := range keys {
+		resp = append(resp, toAPIKeyResponse(k))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// rotateAPIKey replaces the key, keeping its settings and usage; the old key
+// stops working immediately.
+func (app *application) rotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		sendError(w, apierr.InvalidID, "Invalid ID format")
+		return
+	}
+	key, prefix, err := newAPIKey()
+	if err != nil {
+		sendDebugError(w, apierr.Internal, "Failed to generate key", errorDebug(r.Context(), err, 0))
+		return
+	}
+	rotated, err := app.keys.RotateAPIKey(r.Context(), id, hashSecret(key), prefix)
+	if errors.Is(err, store.ErrNotFound) {
+		sendError(w, apierr.APIKeyNotFound, "API key not found or revoked")
+		return
+	}
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "api key rotated", "id", id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(NewAPIKeyResponse{APIKeyResponse: toAPIKeyResponse(rotated), Key: key})
+}
+
+// revokeAPIKey disables a key for good. The row stays for its usage history.
+func (app *application) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		sendError(w, apierr.InvalidID, "Invalid ID format")
+		return
+	}
+	err = app.keys.RevokeAPIKey(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		sendError(w, apierr.APIKeyNotFound, "API key not found")
+		return
+	}
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "api key revoked", "id", id)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func doWithKey(h http.Handler, method, target, key string, body any) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if body != nil {
+		req = httptest.NewRequest(method, target, testutil.JSONBody(body))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key != "" {
+		req.Header.Set(apiKeyHeader, key)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAPIKeyQuotas(t *testing.T) {
+	keys := testutil.NewMemoryKeyStore()
+	keys.Add(hashSecret("secret"), store.APIKey{Name: "importer", MonthlyRequests: testutil.Ptr[int64](4), MonthlyWrites: testutil.Ptr[int64](1)})
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	app.keys = keys
+	router := withContractCheck(t, app.routes())
+
+	testCases := []struct {
+		name     string
+		method   string
+		target   string
+		key      string
+		body     any
+		wantCode int
+	}{
+		{"unknown key", "GET", "/api/v1/persons", "wrong", nil, http.StatusUnauthorized},
+		{"first write", "POST", "/api/v1/persons", "secret", PersonRequest{Name: stringPtr("Ann")}, http.StatusCreated},
+		{"write quota used up", "POST", "/api/v1/persons", "secret", PersonRequest{Name: stringPtr("Bob")}, http.StatusTooManyRequests},
+		{"reads still allowed", "GET", "/api/v1/persons", "secret", nil, http.StatusOK},
+		{"usage", "GET", "/api/v1/account/usage", "secret", nil, http.StatusOK},
+		{"request quota used up", "GET", "/api/v1/persons", "secret", nil, http.StatusTooManyRequests},
+		{"anonymous", "GET", "/api/v1/persons", "", nil, http.StatusOK},
+	}
+	for _, tc := range testCases {
+		rr := doWithKey(router, tc.method, tc.target, tc.key, tc.body)
+		if rr.Code != tc.wantCode {
+			t.Fatalf("%s: expected %d, got %d: %s", tc.name, tc.wantCode, rr.Code, rr.Body.String())
+		}
+		if rr.Code == http.StatusTooManyRequests && rr.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: expected Retry-After on quota errors", tc.name)
+		}
+		if tc.name == "usage" {
+			var usage UsageResponse
+			json.NewDecoder(rr.Body).Decode(&usage)
+			if usage.Key != "importer" || usage.Requests != 4 || usage.RowsWritten != 1 || *usage.RequestQuota != 4 {
+				t.Errorf("Unexpected usage: %+v", usage)
+			}
+		}
+	}
+}
+
+func TestAPIKeyRequired(t *testing.T) {
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	app.keys = testutil.NewMemoryKeyStore()
+	router := withContractCheck(t, app.routes())
+
+	rr := doWithKey(router, "GET", "/api/v1/account/usage", "", nil)
+	if rr.Code != http.StatusUnauthorized || decodeErrorCode(t, rr) != apierr.Unauthorized {
+		t.Errorf("Expected 401 for usage without a key, got %d", rr.Code)
+	}
+
+	app.cfg.requireAPIKey = true
+	router = withContractCheck(t, app.routes())
+	if rr := doWithKey(router, "GET", "/api/v1/persons", "", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a key when keys are required, got %d", rr.Code)
+	}
+}
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	app.keys = testutil.NewMemoryKeyStore()
+	app.cfg.adminToken = "s3cret"
+	router := withContractCheck(t, app.routes())
+
+	admin := func(method, target string, body any) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if body != nil {
+			req = httptest.NewRequest(method, target, testutil.JSONBody(body))
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	issue := func(rr *httptest.ResponseRecorder, wantCode int) NewAPIKeyResponse {
+		t.Helper()
+		if rr.Code != wantCode {
+			t.Fatalf("Expected %d, got %d: %s", wantCode, rr.Code, rr.Body.String())
+		}
+		var k NewAPIKeyResponse
+		json.NewDecoder(rr.Body).Decode(&k)
+		if k.Key == "" || !strings.HasPrefix(k.Key, k.Prefix) {
+			t.Fatalf("Expected a key starting with its prefix, got %+v", k)
+		}
+		return k
+	}
+
+	rr := admin("POST", "/admin/api-keys", APIKeyRequest{Name: stringPtr("importer")})
+	created := issue(rr, http.StatusCreated)
+	if rr.Header().Get("Location") != "/admin/api-keys/1" || len(created.Scopes) != 2 {
+		t.Errorf("Unexpected created key %+v at %q", created, rr.Header().Get("Location"))
+	}
+	if rr := doWithKey(router, "GET", "/api/v1/persons", created.Key, nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected the new key to work, got %d", rr.Code)
+	}
+
+	readOnly := issue(admin("POST", "/admin/api-keys", APIKeyRequest{Name: stringPtr("reporting"), Scopes: []string{"read"}}), http.StatusCreated)
+	if rr := doWithKey(router, "POST", "/api/v1/persons", readOnly.Key, PersonRequest{Name: stringPtr("Ann")}); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 writing with a read-only key, got %d", rr.Code)
+	}
+
+	rotated := issue(admin("POST", "/admin/api-keys/1/rotate", nil), http.StatusOK)
+	if rr := doWithKey(router, "GET", "/api/v1/persons", created.Key, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the old key to stop working after rotation, got %d", rr.Code)
+	}
+	if rr := doWithKey(router, "GET", "/api/v1/persons", rotated.Key, nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected the rotated key to work, got %d", rr.Code)
+	}
+
+	if rr := admin("DELETE", "/admin/api-keys/1", nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 on revoke, got %d", rr.Code)
+	}
+	if rr := doWithKey(router, "GET", "/api/v1/persons", rotated.Key, nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a revoked key to be refused, got %d", rr.Code)
+	}
+	if rr := admin("POST", "/admin/api-keys/1/rotate", nil); rr.Code != http.StatusNotFound || decodeErrorCode(t, rr) != apierr.APIKeyNotFound {
+		t.Errorf("Expected API_KEY_NOT_FOUND rotating a revoked key, got %d", rr.Code)
+	}
+
+	rr = admin("GET", "/admin/api-keys", nil)
+	var list []map[string]any
+	json.NewDecoder(rr.Body).Decode(&list)
+	if rr.Code != http.StatusOK || len(list) != 2 || list[0]["revoked_at"] == nil {
+		t.Errorf("Expected both keys listed with the first revoked, got %d %v", rr.Code, list)
+	}
+	for _, k := range list {
+		if _, ok := k["key"]; ok {
+			t.Errorf("Expected listings to never include the key, got %v", k)
+		}
+	}
+}
+
+func TestValidateAPIKeyRequest(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Hour)
+	testCases := []struct {
+		name string
+		req  APIKeyRequest
+		want []string
+	}{
+		{"Valid", APIKeyRequest{Name: stringPtr("importer"), Scopes: []string{"read"}}, nil},
+		{"Missing name", APIKeyRequest{}, []string{"name"}},
+		{"Unknown scope", APIKeyRequest{Name: stringPtr("x"), Scopes: []string{"admin"}}, []string{"scopes"}},
+		{"Expired", APIKeyRequest{Name: stringPtr("x"), ExpiresAt: &past}, []string{"expires_at"}},
+		{"Negative quota", APIKeyRequest{Name: stringPtr("x"), MonthlyWrites: testutil.Ptr[int64](-1)}, []string{"monthly_writes"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var fields []string
+			for _, e := range validateAPIKeyRequest(tc.req, now) {
+				fields = append(fields, e.Field)
+			}
+			if fmt.Sprint(fields) != fmt.Sprint(tc.want) {
+				t.Errorf("Expected errors on %v, got %v", tc.want, fields)
+			}
+		})
+	}
+}
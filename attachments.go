@@ -0,0 +1,484 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/blob"
+	"ci_cd/rsoi_lab_1/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	storageLocal = "local"
+	storageS3    = "s3"
+
+	// attachmentPrefix is where attachment objects are kept, one directory
+	// per person.
+	attachmentPrefix   = "attachments/"
+	orphanSweepEvery   = time.Hour
+	orphanSweepBatch   = 500
+	maxFilenameLength  = 255
+	defaultContentType = "application/octet-stream"
+)
+
+type AttachmentResponse struct {
+	ID          int64     `json:"id"`
+	PersonID    int32     `json:"person_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Checksum    string    `json:"checksum"`
+	UploadedBy  string    `json:"uploaded_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func toAttachmentResponse(a store.Attachment) AttachmentResponse {
+	return AttachmentResponse{
+		ID:          a.ID,
+		PersonID:    *a.PersonID,
+		Filename:    a.Filename,
+		ContentType: a.ContentType,
+		Size:        a.Size,
+		Checksum:    a.Checksum,
+		UploadedBy:  a.UploadedBy,
+		CreatedAt:   a.CreatedAt.UTC(),
+	}
+}
+
+// newBlobStore opens the object storage selected by cfg.storageDriver, or
+// returns nil when none is.
+func newBlobStore(cfg config) (blob.Store, error) {
+	switch cfg.storageDriver {
+	case storageLocal:
+		l, err := blob.NewLocal(cfg.storageDir)
+		if err != nil {
+			return nil, err
+		}
+		return l, nil
+	case storageS3:
+		s, err := blob.NewS3(cfg.s3)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	return nil, nil
+}
+
+func parseAttachmentID(r *http.Request) (int32, int64, error) {
+	personID, err := parseID(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	id, err := strconv.ParseInt(mux.Vars(r)["attachmentId"], 10, 64)
+	return personID, id, err
+}
+
+// uploadAttachment stores the request body as a file of the person, named by
+// ?filename= and typed by Content-Type, once inspectUpload and scanUpload
+// accepted it. The object is written before its row, so a failure in
+// between leaves an object for sweepOrphanObjects rather than a row without
+// content.
+func (app *application) uploadAttachment(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		sendError(w, apierr.InvalidID, "Invalid ID format")
+		return
+	}
+	filename := r.URL.Query().Get("filename")
+	if errs := validateFilename(filename); len(errs) > 0 {
+		sendValidationError(w, apierr.ValidationFailed, "Invalid query parameters", errs)
+		return
+	}
+	if r.ContentLength < 0 {
+		sendError(w, apierr.LengthRequired, "Content-Length is required")
+		return
+	}
+	if r.ContentLength > app.cfg.attachmentMaxSize {
+		sendError(w, apierr.PayloadTooLarge, fmt.Sprintf("Attachments are limited to %d bytes", app.cfg.attachmentMaxSize))
+		return
+	}
+	contentType := normalizeContentType(r.Header.Get("Content-Type"))
+	// Checked up front so that nothing is uploaded for a missing person.
+	if _, err := app.store.GetPerson(r.Context(), id); err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+
+	uploadID, err := newUploadID()
+	if err != nil {
+		sendDebugError(w, apierr.Internal, "Failed to name attachment", errorDebug(r.Context(), err, 0))
+		return
+	}
+	key := attachmentKey(id, uploadID)
+	h := sha256.New()
+	if err := app.blobs.Put(r.Context(), key, io.TeeReader(r.Body, h), r.ContentLength, contentType); err != nil {
+		sendStorageError(w, r, err)
+		return
+	}
+	a := store.Attachment{
+		PersonID:    &id,
+		ObjectKey:   key,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        r.ContentLength,
+		Checksum:    hex.EncodeToString(h.Sum(nil)),
+		UploadedBy:  requestActor(r),
+	}
+	if a, err = app.inspectUpload(r.Context(), a); err != nil {
+		app.discardUpload(r.Context(), key)
+		sendUploadError(w, r, err)
+		return
+	}
+	if err := app.scanUpload(r.Context(), a); err != nil {
+		app.discardUpload(r.Context(), key)
+		sendUploadError(w, r, err)
+		return
+	}
+	a, err = app.attachments.CreateAttachment(r.Context(), a)
+	if err != nil {
+		app.discardUpload(r.Context(), key)
+		sendStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/persons/%d/attachments/%d", id, a.ID))
+	w.Header().Set("Repr-Digest", reprDigest(a.Checksum))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toAttachmentResponse(a))
+}
+
+func (app *application) discardUpload(ctx context.Context, key string) {
+	if err := app.blobs.Delete(context.WithoutCancel(ctx), key); err != nil {
+		slog.WarnContext(ctx, "failed to delete object of failed upload", "key", key, "err", err)
+	}
+}
+
+func validateFilename(name string) []apierr.FieldError {
+	switch {
+	case name == "":
+		return []apierr.FieldError{apierr.NewFieldError("filename", apierr.KeyRequired, nil)}
+	case len(name) > maxFilenameLength:
+		return []apierr.FieldError{apierr.NewFieldError("filename", apierr.KeyMaxLength, map[string]any{"limit": maxFilenameLength, "actual": len(name)})}
+	case strings.ContainsAny(name, `/\`) || strings.ContainsFunc(name, unicode.IsControl):
+		return []apierr.FieldError{apierr.NewFieldError("filename", apierr.KeyRejected, map[string]any{"reason": "must not contain slashes or control characters"})}
+	}
+	return nil
+}
+
+// normalizeContentType is the media type attachments are stored with:
+// contentType when it is one, defaultContentType otherwise.
+func normalizeContentType(contentType string) string {
+	if mt, params, err := mime.ParseMediaType(contentType); err == nil {
+		return mime.FormatMediaType(mt, params)
+	}
+	return defaultContentType
+}
+
+// newUploadID picks the random part of a new object's key, so that keys
+// cannot be guessed and a retried upload never overwrites another.
+func newUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func attachmentKey(personID int32, uploadID string) string {
+	return fmt.Sprintf("%s%d/%s", attachmentPrefix, personID, uploadID)
+}
+
+// checksumObject is the hex SHA-256 of the object at key.
+func (app *application) checksumObject(ctx context.Context, key string) (string, error) {
+	body, err := app.blobs.Get(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// listAttachments lists the files of a person, oldest first. ?type= keeps
+// those of one media type, or of a whole kind with "image/*".
+func (app *application) listAttachments(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		sendError(w, apierr.InvalidID, "Invalid ID format")
+		return
+	}
+	q := r.URL.Query()
+	var errs []apierr.FieldError
+	var f store.AttachmentFilter
+	if raw := q.Get("type"); raw != "" {
+		f.MediaType = strings.ToLower(raw)
+		if !validTypeFilter(f.MediaType) {
+			errs = append(errs, apierr.NewFieldError("type", apierr.KeyRejected, map[string]any{"reason": `must be a media type such as "application/pdf" or "image/*"`}))
+		}
+	}
+	f.Limit, f.Offset, errs = parsePage(q, app.cfg.page, errs)
+	if len(errs) > 0 {
+		sendValidationError(w, apierr.ValidationFailed, "Invalid query parameters", errs)
+		return
+	}
+	if _, err := app.store.GetPerson(r.Context(), id); err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	list, err := app.attachments.ListAttachments(r.Context(), id, f)
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	resp := make([]AttachmentResponse, 0, len(list))
+	for _, a := range list {
+		resp = append(resp, toAttachmentResponse(a))
+	}
+	if len(list) == f.Limit {
+		w.Header().Set("Link", "<"+nextPageURL(r, f.Limit, f.Offset)+`>; rel="next"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		sendError(w, apierr.Internal, "Encoding error")
+	}
+}
+
+// validTypeFilter accepts a media type without parameters, or a type with
+// "*" as its subtype.
+func validTypeFilter(t string) bool {
+	typ, sub, ok := strings.Cut(t, "/")
+	if !ok || typ == "" || typ == "*" || sub == "" || strings.Contains(t, "*") && sub != "*" {
+		return false
+	}
+	if sub == "*" {
+		t = typ + "/x"
+	}
+	mt, params, err := mime.ParseMediaType(t)
+	return err == nil && mt == t && len(params) == 0
+}
+
+// getAttachment downloads the file, or the single byte range asked for with
+// Range, so that large files can be resumed and viewers can seek in them.
+func (app *application) getAttachment(w http.ResponseWriter, r *http.Request) {
+	personID, id, err := parseAttachmentID(r)
+	if err != nil {
+		sendError(w, apierr.InvalidID, "Invalid ID format")
+		return
+	}
+	a, err := app.attachments.Attachment(r.Context(), personID, id)
+	if errors.Is(err, store.ErrNotFound) {
+		sendError(w, apierr.AttachmentNotFound, "Attachment not found")
+		return
+	}
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	var etag string
+	if a.Checksum != "" {
+		etag = `"` + a.Checksum + `"`
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Repr-Digest", reprDigest(a.Checksum))
+	}
+	w.Header().Set("Last-Modified", a.CreatedAt.UTC().Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	var rng byteRange
+	var partial bool
+	if ifRangeMatches(r.Header.Get("If-Range"), etag, a.CreatedAt) {
+		rng, partial, err = parseRange(r.Header.Get("Range"), a.Size)
+		if errors.Is(err, errUnsatisfiable) {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", a.Size))
+			sendError(w, apierr.RangeNotSatisfiable, fmt.Sprintf("The attachment has %d bytes", a.Size))
+			return
+		}
+	}
+
+	var body io.ReadCloser
+	if partial {
+		body, err = app.blobs.GetRange(r.Context(), a.ObjectKey, rng.start, rng.length)
+	} else {
+		body, err = app.blobs.Get(r.Context(), a.ObjectKey)
+	}
+	if err != nil {
+		sendStorageError(w, r, err)
+		return
+	}
+	defer body.Close()
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+	// Stored content is whatever clients uploaded; browsers must not guess
+	// it is something they would run.
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if partial {
+		w.Header().Set("Content-Length", strconv.FormatInt(rng.length, 10))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", rng.start, rng.start+rng.length-1, a.Size))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		slog.WarnContext(r.Context(), "attachment download aborted", "attachment_id", a.ID, "err", err)
+	}
+}
+
+// deleteAttachment deletes the row first: once it is gone the object is an
+// orphan, which sweepOrphanObjects deletes should deleting it here fail.
+func (app *application) deleteAttachment(w http.ResponseWriter, r *http.Request) {
+	personID, id, err := parseAttachmentID(r)
+	if err != nil {
+		sendError(w, apierr.InvalidID, "Invalid ID format")
+		return
+	}
+	a, err := app.attachments.DeleteAttachment(r.Context(), personID, id)
+	if errors.Is(err, store.ErrNotFound) {
+		sendError(w, apierr.AttachmentNotFound, "Attachment not found")
+		return
+	}
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	if err := app.blobs.Delete(context.WithoutCancel(r.Context()), a.ObjectKey); err != nil {
+		slog.WarnContext(r.Context(), "failed to delete attachment object", "key", a.ObjectKey, "err", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// sendStorageError answers for object storage that failed. A missing object
+// means its row outlived it, which should not happen.
+func sendStorageError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, blob.ErrNotFound) {
+		slog.ErrorContext(r.Context(), "attachment object is missing", "err", err)
+		sendError(w, apierr.AttachmentNotFound, "Attachment not found")
+		return
+	}
+	slog.ErrorContext(r.Context(), "object storage failed", "err", err)
+	sendDebugError(w, apierr.StorageUnavailable, "Object storage unavailable", errorDebug(r.Context(), err, 1))
+}
+
+// sweepOrphanObjects deletes the attachment and photo objects no row
+// references every orphanSweepEvery until ctx is done. They are left by
+// deleted persons and by uploads or deletes that failed halfway. Objects
+// younger than orphanGracePeriod are kept, as their upload may still be
+// about to create its row.
+func (app *application) sweepOrphanObjects(ctx context.Context) {
+	ticker := time.NewTicker(orphanSweepEvery)
+	defer ticker.Stop()
+	for {
+		n, err := app.sweepOrphans(ctx, time.Now().Add(-app.cfg.orphanGracePeriod), false)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to sweep orphaned objects", "deleted", n, "err", err)
+		} else if n > 0 {
+			slog.InfoContext(ctx, "swept orphaned objects", "deleted", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// orphanSweep describes the objects under prefix: each belongs to the row
+// referencing owner(key), and unreferenced tells which owners no row
+// references.
+type orphanSweep struct {
+	prefix       string
+	owner        func(key string) string
+	unreferenced func(ctx context.Context, owners []string) ([]string, error)
+}
+
+func (app *application) orphanSweeps() []orphanSweep {
+	var sweeps []orphanSweep
+	if app.attachments != nil {
+		sweeps = append(sweeps, orphanSweep{attachmentPrefix, func(key string) string { return key }, app.attachments.UnreferencedKeys})
+	}
+	if app.photos != nil {
+		sweeps = append(sweeps, orphanSweep{photoPrefix, photoDir, app.photos.UnreferencedPhotoKeys})
+	}
+	return sweeps
+}
+
+// sweepOrphans deletes the unreferenced objects modified before before and
+// counts them. With dryRun it only counts them.
+func (app *application) sweepOrphans(ctx context.Context, before time.Time, dryRun bool) (int, error) {
+	deleted := 0
+	for _, sweep := range app.orphanSweeps() {
+		n, err := app.sweep(ctx, sweep, before, dryRun)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+func (app *application) sweep(ctx context.Context, sweep orphanSweep, before time.Time, dryRun bool) (int, error) {
+	var batch []string
+	deleted := 0
+	flush := func() error {
+		seen := map[string]bool{}
+		var owners []string
+		for _, k := range batch {
+			if o := sweep.owner(k); !seen[o] {
+				seen[o] = true
+				owners = append(owners, o)
+			}
+		}
+		unreferenced, err := sweep.unreferenced(ctx, owners)
+		if err != nil {
+			return err
+		}
+		orphaned := map[string]bool{}
+		for _, o := range unreferenced {
+			orphaned[o] = true
+		}
+		for _, k := range batch {
+			if !orphaned[sweep.owner(k)] {
+				continue
+			}
+			if !dryRun {
+				if err := app.blobs.Delete(ctx, k); err != nil {
+					return err
+				}
+			}
+			deleted++
+		}
+		batch = batch[:0]
+		return nil
+	}
+	err := app.blobs.List(ctx, sweep.prefix, func(o blob.Object) error {
+		if !o.ModTime.Before(before) {
+			return nil
+		}
+		batch = append(batch, o.Key)
+		if len(batch) == orphanSweepBatch {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil && len(batch) > 0 {
+		err = flush()
+	}
+	return deleted, err
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/blob"
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestAttachments(t *testing.T) {
+	st := testutil.NewMemoryStore(store.Person{Name: "Ann"}, store.Person{Name: "Bob"})
+	app := newTestAppWithStore(st)
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.blobs, app.attachments = blobs, st
+	app.cfg.attachmentMaxSize = 16
+	app.cfg.validateRequests = true
+	app.spec = loadSpecRouter(t)
+	router := withContractCheck(t, app.routes())
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/pdf")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do("POST", "/api/v1/persons/1/attachments?filename=notes.pdf", "%PDF-1.7")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created AttachmentResponse
+	json.NewDecoder(rr.Body).Decode(&created)
+	if created.Size != 8 || created.ContentType != "application/pdf" || rr.Header().Get("Location") != "/api/v1/persons/1/attachments/1" {
+		t.Errorf("Unexpected attachment %+v at %s", created, rr.Header().Get("Location"))
+	}
+
+	rr = do("GET", "/api/v1/persons/1/attachments/1", "")
+	if rr.Code != http.StatusOK || rr.Body.String() != "%PDF-1.7" || rr.Header().Get("Content-Disposition") != `attachment; filename=notes.pdf` {
+		t.Errorf("Unexpected download %d %v %q", rr.Code, rr.Header(), rr.Body.String())
+	}
+	if rr := do("GET", "/api/v1/persons/2/attachments/1", ""); rr.Code != http.StatusNotFound || decodeErrorCode(t, rr) != apierr.AttachmentNotFound {
+		t.Errorf("Expected another person's attachment to be hidden, got %d", rr.Code)
+	}
+
+	if rr := do("POST", "/api/v1/persons/1/attachments", "hello"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a missing filename to be refused, got %d", rr.Code)
+	}
+	if rr := do("POST", "/api/v1/persons/1/attachments?filename=big", strings.Repeat("x", 17)); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413, got %d", rr.Code)
+	}
+	if rr := do("POST", "/api/v1/persons/9/attachments?filename=a", "hello"); rr.Code != http.StatusNotFound || decodeErrorCode(t, rr) != apierr.PersonNotFound {
+		t.Errorf("Expected 404 for a missing person, got %d", rr.Code)
+	}
+
+	if rr := do("DELETE", "/api/v1/persons/1/attachments/1", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", rr.Code)
+	}
+	if rr := do("GET", "/api/v1/persons/1/attachments/1", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected the attachment to be gone, got %d", rr.Code)
+	}
+	err = blobs.List(context.Background(), attachmentPrefix, func(o blob.Object) error {
+		return errors.New("left " + o.Key)
+	})
+	if err != nil {
+		t.Errorf("Expected no objects left: %v", err)
+	}
+}
+
+func TestAttachmentRanges(t *testing.T) {
+	st := testutil.NewMemoryStore(store.Person{Name: "Ann"})
+	app := newTestAppWithStore(st)
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.blobs, app.attachments = blobs, st
+	app.cfg.attachmentMaxSize = 1 << 10
+	router := withContractCheck(t, app.routes())
+
+	const content = "%PDF-0123456789"
+	req := httptest.NewRequest("POST", "/api/v1/persons/1/attachments?filename=a.pdf", strings.NewReader(content))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	get := func(header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/persons/1/attachments/1", nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	rr = get()
+	etag := rr.Header().Get("ETag")
+	if rr.Code != http.StatusOK || rr.Body.String() != content || rr.Header().Get("Accept-Ranges") != "bytes" || etag == "" || rr.Header().Get("Repr-Digest") == "" {
+		t.Fatalf("Unexpected download %d %v", rr.Code, rr.Header())
+	}
+
+	testCases := []struct {
+		name       string
+		header     []string
+		wantCode   int
+		wantBody   string
+		wantRange  string
+		wantLength string
+	}{
+		{"first bytes", []string{"Range", "bytes=0-4"}, http.StatusPartialContent, "%PDF-", "bytes 0-4/15", "5"},
+		{"resume", []string{"Range", "bytes=10-"}, http.StatusPartialContent, "56789", "bytes 10-14/15", "5"},
+		{"suffix", []string{"Range", "bytes=-3"}, http.StatusPartialContent, "789", "bytes 12-14/15", "3"},
+		{"several ranges", []string{"Range", "bytes=0-1,3-4"}, http.StatusOK, content, "", "15"},
+		{"unchanged", []string{"Range", "bytes=10-", "If-Range", etag}, http.StatusPartialContent, "56789", "bytes 10-14/15", "5"},
+		{"changed", []string{"Range", "bytes=10-", "If-Range", `"other"`}, http.StatusOK, content, "", "15"},
+		{"beyond the end", []string{"Range", "bytes=15-"}, http.StatusRequestedRangeNotSatisfiable, "", "bytes */15", ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := get(tc.header...)
+			if rr.Code != tc.wantCode || rr.Header().Get("Content-Range") != tc.wantRange {
+				t.Fatalf("Expected %d with range %q, got %d %v", tc.wantCode, tc.wantRange, rr.Code, rr.Header())
+			}
+			if tc.wantCode == http.StatusRequestedRangeNotSatisfiable {
+				if code := decodeErrorCode(t, rr); code != apierr.RangeNotSatisfiable {
+					t.Errorf("Expected RANGE_NOT_SATISFIABLE, got %s", code)
+				}
+				return
+			}
+			if rr.Body.String() != tc.wantBody || rr.Header().Get("Content-Length") != tc.wantLength {
+				t.Errorf("Expected %q of length %s, got %q %v", tc.wantBody, tc.wantLength, rr.Body.String(), rr.Header())
+			}
+		})
+	}
+}
+
+func TestListAttachments(t *testing.T) {
+	st := testutil.NewMemoryStore(store.Person{Name: "Ann"})
+	app := newTestAppWithStore(st)
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.blobs, app.attachments = blobs, st
+	app.cfg.attachmentMaxSize = 1 << 20
+	router := withContractCheck(t, app.routes())
+
+	for _, f := range []struct{ name, body string }{{"a.pdf", "%PDF-1"}, {"b.png", testPNG(t, 2, 2)}, {"c.pdf", "%PDF-3"}} {
+		req := httptest.NewRequest("POST", "/api/v1/persons/1/attachments?filename="+f.name, strings.NewReader(f.body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+
+	list := func(target string) ([]AttachmentResponse, *httptest.ResponseRecorder) {
+		rr := testutil.Do(router, "GET", target, nil)
+		var got []AttachmentResponse
+		json.NewDecoder(rr.Body).Decode(&got)
+		return got, rr
+	}
+	testCases := []struct {
+		query string
+		want  string
+	}{
+		{"", "a.pdf b.png c.pdf"},
+		{"?type=application/pdf", "a.pdf c.pdf"},
+		{"?type=image/*", "b.png"},
+		{"?type=text/plain", ""},
+		{"?limit=2&offset=1", "b.png c.pdf"},
+	}
+	for _, tc := range testCases {
+		got, rr := list("/api/v1/persons/1/attachments" + tc.query)
+		var names []string
+		for _, a := range got {
+			names = append(names, a.Filename)
+		}
+		if rr.Code != http.StatusOK || strings.Join(names, " ") != tc.want {
+			t.Errorf("%q: expected %q, got %d %v", tc.query, tc.want, rr.Code, names)
+		}
+	}
+
+	got, rr := list("/api/v1/persons/1/attachments?limit=1")
+	if link := rr.Header().Get("Link"); !strings.Contains(link, "offset=1") {
+		t.Errorf("Expected a link to the next page, got %q", link)
+	}
+	// The SHA-256 of "%PDF-1".
+	if len(got) != 1 || got[0].Checksum != "21af8e71c8703196df7fe1ff901869a88fe64c07bbaa83d838efb45a52b4f303" || got[0].UploadedBy != "anonymous" {
+		t.Errorf("Unexpected attachment %+v", got)
+	}
+
+	for _, query := range []string{"?type=pdf", "?type=*/*", "?type=image/p*", "?type=text/plain%3Bcharset=utf-8", "?limit=0"} {
+		if _, rr := list("/api/v1/persons/1/attachments" + query); rr.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", query, rr.Code)
+		}
+	}
+	if rr := testutil.Do(router, "GET", "/api/v1/persons/9/attachments", nil); rr.Code != http.StatusNotFound || decodeErrorCode(t, rr) != apierr.PersonNotFound {
+		t.Errorf("Expected 404 for a missing person, got %d", rr.Code)
+	}
+}
+
+func TestSweepOrphans(t *testing.T) {
+	st := testutil.NewMemoryStore(store.Person{Name: "Ann"})
+	app := newTestAppWithStore(st)
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.blobs, app.attachments = blobs, st
+	ctx := context.Background()
+	for _, key := range []string{"attachments/1/kept", "attachments/1/orphan", "other/orphan"} {
+		if err := blobs.Put(ctx, key, strings.NewReader("x"), 1, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	id := int32(1)
+	if _, err := st.CreateAttachment(ctx, store.Attachment{PersonID: &id, ObjectKey: "attachments/1/kept"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := app.sweepOrphans(ctx, time.Now().Add(-time.Hour), false); err != nil || n != 0 {
+		t.Errorf("Expected recent objects to be kept, deleted %d: %v", n, err)
+	}
+	if n, err := app.sweepOrphans(ctx, time.Now().Add(time.Hour), false); err != nil || n != 1 {
+		t.Errorf("Expected one orphan deleted, got %d: %v", n, err)
+	}
+	for key, want := range map[string]bool{"attachments/1/kept": true, "attachments/1/orphan": false, "other/orphan": true} {
+		r, err := blobs.Get(ctx, key)
+		if err == nil {
+			r.Close()
+		}
+		if (err == nil) != want {
+			t.Errorf("%s: expected kept %v, got %v", key, want, err)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+const (
+	auditFormatCSV   = "csv"
+	auditFormatJSONL = "jsonl"
+	auditExportBatch = 500
+	auditPruneEvery  = time.Hour
+)
+
+type AuditEntryResponse struct {
+	ID        int64     `json:"id"`
+	At        time.Time `json:"at"`
+	Actor     string    `json:"actor"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// auditWrites records every write request in the audit trail once it is
+// served, whatever its outcome, under the actor named by actor.
+func (app *application) auditWrites(actor func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if app.audit == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isWrite(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			sr := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(sr, r)
+			ctx := context.WithoutCancel(r.Context())
+			err := app.audit.RecordAudit(ctx, store.AuditEntry{
+				Actor:     actor(r),
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Status:    max(sr.status, http.StatusOK),
+				RequestID: requestIDFromContext(ctx),
+			})
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to record audit entry", "method", r.Method, "route", routeTemplate(r), "err", err)
+			}
+		})
+	}
+}
+
+// requestActor names who sent an API or UI request: the signed-in user, else
+// the API key, else anonymous.
+func requestActor(r *http.Request) string {
+	if id, ok := r.Context().Value(userIDKey).(int32); ok {
+		return fmt.Sprintf("user:%d", id)
+	}
+	if k, ok := apiKeyFromContext(r.Context()); ok {
+		return fmt.Sprintf("api_key:%d", k.ID)
+	}
+	return "anonymous"
+}
+
+func adminActor(*http.Request) string { return "admin" }
+
+// exportAudit streams the audit entries matching ?from=&to=&actor= as JSON
+// lines or, with ?format=csv, CSV. Entries come in ID order; a client reading
+// in pages passes ?limit= and continues with ?after= set to the last ID it
+// got. Entries older than the retention window are never exported, even
+// before they are pruned. With Prefer: respond-async the export is written
+// to object storage by a job instead, when there are object storage and a
+// job queue.
+func (app *application) exportAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var errs []apierr.FieldError
+	var f store.AuditFilter
+	f.From, errs = parseTimeParam(q.Get("from"), "from", false, errs)
+	f.To, errs = parseTimeParam(q.Get("to"), "to", false, errs)
+	f.Actor = q.Get("actor")
+	f.After, errs = parseNonNegative(q.Get("after"), "after", errs)
+	limit, errs := parseNonNegative(q.Get("limit"), "limit", errs)
+	format := q.Get("format")
+	switch format {
+	case "":
+		format = auditFormatJSONL
+	case auditFormatJSONL, auditFormatCSV:
+	default:
+		errs = append(errs, apierr.NewFieldError("format", apierr.KeyOneOf, map[string]any{"allowed": []string{auditFormatJSONL, auditFormatCSV}, "actual": format}))
+	}
+	if len(errs) > 0 {
+		sendValidationError(w, apierr.ValidationFailed, "Invalid query parameters", errs)
+		return
+	}
+	if app.cfg.auditRetention > 0 {
+		if cutoff := time.Now().Add(-app.cfg.auditRetention); f.From.Before(cutoff) {
+			f.From = cutoff
+		}
+	}
+
+	if wantsAsync(r) && app.jobQueue != nil && app.blobs != nil {
+		app.startJob(w, r, "audit_export", auditExportPayload{Filter: f, Limit: limit, Format: format})
+		return
+	}
+	var out auditWriter
+	sent, err := app.writeAudit(r.Context(), f, limit, nil, func() auditWriter {
+		out = newAuditWriter(w, format)
+		return out
+	})
+	if err != nil {
+		if out == nil {
+			sendStoreError(w, r, err)
+			return
+		}
+		slog.ErrorContext(r.Context(), "audit export aborted", "sent", sent, "err", err)
+	}
+}
+
+// writeAudit pages through the entries f matches, up to limit unless it is
+// zero, writing them to what open returns, called once the first page is
+// read. It counts the entries written, reporting them to progress after
+// every page.
+func (app *application) writeAudit(ctx context.Context, f store.AuditFilter, limit int64, progress *jobReport, open func() auditWriter) (int, error) {
+	var out auditWriter
+	sent := 0
+	for {
+		f.Limit = auditExportBatch
+		if limit > 0 {
+			f.Limit = min(f.Limit, int(limit)-sent)
+		}
+		entries, err := app.audit.AuditEntries(ctx, f)
+		if err != nil {
+			return sent, err
+		}
+		if out == nil {
+			out = open()
+		}
+		for _, e := range entries {
+			if err := out.write(e); err != nil {
+				return sent, err
+			}
+		}
+		if err := out.flush(); err != nil {
+			return sent, err
+		}
+		sent += len(entries)
+		var percent int
+		if limit > 0 {
+			percent = int(int64(sent) * 100 / limit)
+		}
+		progress.logf(ctx, percent, "Exported %d entries", sent)
+		if len(entries) < f.Limit || limit > 0 && sent >= int(limit) {
+			return sent, nil
+		}
+		f.After = entries[len(entries)-1].ID
+	}
+}
+
+type auditExportPayload struct {
+	Filter store.AuditFilter `json:"filter"`
+	Limit  int64             `json:"limit"`
+	Format string            `json:"format"`
+}
+
+func (app *application) auditExportJob(ctx context.Context, j store.Job, progress *jobReport) (jobOutput, error) {
+	var p auditExportPayload
+	if err := json.Unmarshal(j.Payload, &p); err != nil {
+		return jobOutput{}, err
+	}
+	return app.exportAuditFile(ctx, j.ID, p.Filter, p.Limit, p.Format, progress)
+}
+
+// exportAuditFile writes the export to a temporary file first, as object
+// storage needs to know its size.
+func (app *application) exportAuditFile(ctx context.Context, jobID string, f store.AuditFilter, limit int64, format string, progress *jobReport) (jobOutput, error) {
+	tmp, err := os.CreateTemp("", "audit-export-*")
+	if err != nil {
+		return jobOutput{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	sent, err := app.writeAudit(ctx, f, limit, progress, func() auditWriter { return newAuditEncoder(tmp, format, nil) })
+	if err != nil {
+		return jobOutput{}, err
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return jobOutput{}, err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return jobOutput{}, err
+	}
+	contentType, filename := auditContentType(format)
+	file := &store.JobFile{Key: exportPrefix + jobID + "/" + filename, ContentType: contentType, Filename: filename}
+	if err := app.blobs.Put(ctx, file.Key, tmp, size, contentType); err != nil {
+		return jobOutput{}, err
+	}
+	return jobOutput{result: map[string]int{"entries": sent}, file: file}, nil
+}
+
+func parseNonNegative(raw, field string, errs []apierr.FieldError) (int64, []apierr.FieldError) {
+	if raw == "" {
+		return 0, errs
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, append(errs, apierr.NewFieldError(field, apierr.KeyNotInteger, map[string]any{"actual": raw}))
+	}
+	if n < 0 {
+		return 0, append(errs, apierr.NewFieldError(field, apierr.KeyMinValue, map[string]any{"limit": 0, "actual": n}))
+	}
+	return n, errs
+}
+
+type auditWriter interface {
+	write(e store.AuditEntry) error
+	flush() error
+}
+
+func auditContentType(format string) (contentType, filename string) {
+	if format == auditFormatCSV {
+		return "text/csv; charset=utf-8", "audit.csv"
+	}
+	return "application/x-ndjson", "audit.jsonl"
+}
+
+// newAuditWriter starts the export response in format.
+func newAuditWriter(w http.ResponseWriter, format string) auditWriter {
+	contentType, filename := auditContentType(format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	rc := http.NewResponseController(w)
+	// Not every writer flushes; those that do not send it all at the end.
+	return newAuditEncoder(w, format, func() error {
+		rc.Flush()
+		return nil
+	})
+}
+
+// newAuditEncoder writes entries to w in format, calling flush, if set,
+// after every page.
+func newAuditEncoder(w io.Writer, format string, flush func() error) auditWriter {
+	if flush == nil {
+		flush = func() error { return nil }
+	}
+	if format == auditFormatCSV {
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"id", "at", "actor", "method", "path", "status", "request_id"})
+		return &csvAuditWriter{w: cw, flushOut: flush}
+	}
+	return &jsonlAuditWriter{enc: json.NewEncoder(w), flushOut: flush}
+}
+
+type csvAuditWriter struct {
+	w        *csv.Writer
+	flushOut func() error
+}
+
+func (c *csvAuditWriter) write(e store.AuditEntry) error {
+	return c.w.Write([]string{
+		strconv.FormatInt(e.ID, 10), e.At.UTC().Format(time.RFC3339Nano), e.Actor, e.Method, e.Path, strconv.Itoa(e.Status), e.RequestID,
+	})
+}
+
+func (c *csvAuditWriter) flush() error {
+	c.w.Flush()
+	if err := c.w.Error(); err != nil {
+		return err
+	}
+	return c.flushOut()
+}
+
+type jsonlAuditWriter struct {
+	enc      *json.Encoder
+	flushOut func() error
+}
+
+func (j *jsonlAuditWriter) write(e store.AuditEntry) error {
+	return j.enc.Encode(AuditEntryResponse{
+		ID: e.ID, At: e.At.UTC(), Actor: e.Actor, Method: e.Method, Path: e.Path, Status: e.Status, RequestID: e.RequestID,
+	})
+}
+
+func (j *jsonlAuditWriter) flush() error { return j.flushOut() }
+
+// pruneAudit deletes audit entries older than the retention window every
+// auditPruneEvery until ctx is done.
+func (app *application) pruneAudit(ctx context.Context) {
+	ticker := time.NewTicker(auditPruneEvery)
+	defer ticker.Stop()
+	for {
+		n, err := app.audit.PruneAudit(ctx, time.Now().Add(-app.cfg.auditRetention))
+		if err != nil {
+			slog.WarnContext(ctx, "failed to prune audit trail", "err", err)
+		} else if n > 0 {
+			slog.InfoContext(ctx, "pruned audit trail", "entries", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestAuditExport(t *testing.T) {
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	app.cfg.adminToken = "s3cret"
+	app.cfg.auditRetention = 24 * time.Hour
+	audit := testutil.NewMemoryAuditLog()
+	app.audit = audit
+	router := app.routes()
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if strings.HasPrefix(target, "/admin") {
+			req.Header.Set("Authorization", "Bearer s3cret")
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	audit.Now = func() time.Time { return time.Now().Add(-48 * time.Hour) }
+	do("POST", "/api/v1/persons", `{"name":"Expired"}`)
+	audit.Now = time.Now
+	do("POST", "/api/v1/persons", `{"name":"Ann"}`)
+	do("GET", "/api/v1/persons", "")
+	do("PUT", "/admin/loglevel", `{"level":"debug"}`)
+	do("DELETE", "/api/v1/persons/99", "")
+
+	entries := func(rr *httptest.ResponseRecorder) []AuditEntryResponse {
+		t.Helper()
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var out []AuditEntryResponse
+		sc := bufio.NewScanner(rr.Body)
+		for sc.Scan() {
+			var e AuditEntryResponse
+			if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+				t.Fatalf("Invalid line %q: %v", sc.Text(), err)
+			}
+			out = append(out, e)
+		}
+		return out
+	}
+
+	rr := do("GET", "/admin/audit/export", "")
+	if !rr.Flushed {
+		t.Error("Expected the export flushed page by page")
+	}
+	got := entries(rr)
+	if len(got) != 3 || got[0].Actor != "anonymous" || got[0].Method != "POST" || got[0].Status != http.StatusCreated ||
+		got[1].Actor != "admin" || got[1].Path != "/admin/loglevel" || got[2].Status != http.StatusNotFound {
+		t.Errorf("Expected the writes inside the retention window, got %+v", got)
+	}
+	if got := entries(do("GET", "/admin/audit/export?actor=admin", "")); len(got) != 1 || got[0].Method != "PUT" {
+		t.Errorf("Expected the admin write, got %+v", got)
+	}
+	page := entries(do("GET", "/admin/audit/export?limit=1", ""))
+	next := entries(do("GET", "/admin/audit/export?limit=1&after="+strconv.FormatInt(page[0].ID, 10), ""))
+	if len(page) != 1 || len(next) != 1 || next[0].ID <= page[0].ID {
+		t.Errorf("Expected consecutive pages, got %+v then %+v", page, next)
+	}
+	if got := entries(do("GET", "/admin/audit/export?to="+time.Now().Add(-time.Hour).Format(time.RFC3339), "")); len(got) != 0 {
+		t.Errorf("Expected nothing before to, got %+v", got)
+	}
+
+	rr = do("GET", "/admin/audit/export?format=csv", "")
+	rows, err := csv.NewReader(rr.Body).ReadAll()
+	if err != nil || rr.Header().Get("Content-Type") != "text/csv; charset=utf-8" || len(rows) != 4 || rows[0][2] != "actor" || rows[2][2] != "admin" {
+		t.Errorf("Unexpected CSV export %v %v", rows, err)
+	}
+
+	if rr := do("GET", "/admin/audit/export?format=xml&after=-1", ""); rr.Code != http.StatusBadRequest || decodeErrorCode(t, rr) != apierr.ValidationFailed {
+		t.Errorf("Expected invalid parameters to be rejected, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/store"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minPasswordLength = 8
+	// maxPasswordBytes is where bcrypt stops looking at the password.
+	maxPasswordBytes = 72
+	maxEmailLength   = 254
+)
+
+// bcryptCost is a variable so tests can use bcrypt.MinCost.
+var bcryptCost = bcrypt.DefaultCost
+
+type CredentialsRequest struct {
+	Email    *string `json:"email"`
+	Password *string `json:"password"`
+}
+
+type UserResponse struct {
+	ID        int32     `json:"id"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RefreshRequest struct {
+	RefreshToken *string `json:"refresh_token"`
+}
+
+type LogoutRequest struct {
+	RefreshToken *string `json:"refresh_token"`
+	// All ends every session of the user, not just this one.
+	All bool `json:"all,omitempty"`
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func validateCredentials(req CredentialsRequest) []apierr.FieldError {
+	var errs []apierr.FieldError
+	if req.Email == nil || strings.TrimSpace(*req.Email) == "" {
+		errs = append(errs, apierr.NewFieldError("email", apierr.KeyRequired, nil))
+	} else {
+		errs = appendEmail(errs, "email", req.Email)
+	}
+	if req.Password == nil || *req.Password == "" {
+		errs = append(errs, apierr.NewFieldError("password", apierr.KeyRequired, nil))
+		return errs
+	}
+	if n := len([]rune(*req.Password)); n < minPasswordLength {
+		errs = append(errs, apierr.NewFieldError("password", apierr.KeyMinLength, map[string]any{"limit": minPasswordLength, "actual": n}))
+	}
+	if n := len(*req.Password); n > maxPasswordBytes {
+		errs = append(errs, apierr.NewFieldError("password", apierr.KeyMaxLength, map[string]any{"limit": maxPasswordBytes, "actual": n}))
+	}
+	return errs
+}
+
+func (app *application) register(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var req CredentialsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, apierr.InvalidJSON, "json decoding error")
+		return
+	}
+	if errs := validateCredentials(req); len(errs) > 0 {
+		sendValidationError(w, apierr.ValidationFailed, "registration validation error", errs)
+		return
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(*req.Password), bcryptCost)
+	if err != nil {
+		sendDebugError(w, apierr.Internal, "Failed to hash password", errorDebug(r.Context(), err, 0))
+		return
+	}
+	user, err := app.users.CreateUser(r.Context(), normalizeEmail(*req.Email), string(hash))
+	if errors.Is(err, store.ErrConflict) {
+		sendError(w, apierr.Conflict, "Email is already registered")
+		return
+	}
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "user registered", "user_id", user.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(UserResponse{ID: user.ID, Email: user.Email, CreatedAt: user.CreatedAt.UTC()})
+}
+
+// dummyHash is compared against when the email is unknown, so a login takes
+// as long for unknown emails as for wrong passwords.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not a password"), bcryptCost)
+	return hash
+})
+
+func (app *application) login(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var req CredentialsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, apierr.InvalidJSON, "json decoding error")
+		return
+	}
+	if req.Email == nil || req.Password == nil {
+		sendError(w, apierr.Unauthorized, "Invalid email or password")
+		return
+	}
+	user, err := app.users.UserByEmail(r.Context(), normalizeEmail(*req.Email))
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		sendStoreError(w, r, err)
+		return
+	}
+	hash := dummyHash()
+	if err == nil {
+		hash = []byte(user.PasswordHash)
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(*req.Password)) != nil || err != nil {
+		sendError(w, apierr.Unauthorized, "Invalid email or password")
+		return
+	}
+
+	refresh, err := newRefreshToken()
+	if err != nil {
+		sendDebugError(w, apierr.Internal, "Failed to issue token", errorDebug(r.Context(), err, 0))
+		return
+	}
+	now := time.Now()
+	sess, err := app.users.CreateSession(r.Context(), user.ID, hashSecret(refresh), now.Add(app.cfg.refreshTokenTTL))
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	app.sendTokens(w, sess, refresh, now)
+}
+
+func newRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func (app *application) sendTokens(w http.ResponseWriter, sess store.Session, refresh string, now time.Time) {
+	token, err := app.issueAccessToken(sess.UserID, sess.ID, now)
+	if err != nil {
+		sendError(w, apierr.Internal, "Failed to issue token")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(TokenResponse{
+		AccessToken:  token,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(app.cfg.accessTokenTTL / time.Second),
+		RefreshToken: refresh,
+	})
+}
+
+// refresh exchanges a refresh token for a new access token and a new refresh
+// token. Each refresh token works once; presenting one again revokes the
+// session, since either the client or an attacker holds a stolen copy.
+func (app *application) refresh(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, apierr.InvalidJSON, "json decoding error")
+		return
+	}
+	if req.RefreshToken == nil {
+		sendError(w, apierr.Unauthorized, "Invalid or expired refresh token")
+		return
+	}
+	refresh, err := newRefreshToken()
+	if err != nil {
+		sendDebugError(w, apierr.Internal, "Failed to issue token", errorDebug(r.Context(), err, 0))
+		return
+	}
+	sess, err := app.users.RotateSession(r.Context(), hashSecret(*req.RefreshToken), hashSecret(refresh))
+	switch {
+	case errors.Is(err, store.ErrTokenReused):
+		slog.WarnContext(r.Context(), "refresh token reused, session revoked")
+		sendError(w, apierr.Unauthorized, "Invalid or expired refresh token")
+		return
+	case errors.Is(err, store.ErrNotFound):
+		sendError(w, apierr.Unauthorized, "Invalid or expired refresh token")
+		return
+	case err != nil:
+		sendStoreError(w, r, err)
+		return
+	}
+	app.sendTokens(w, sess, refresh, time.Now())
+}
+
+// logout revokes the session of a refresh token. Access tokens already issued
+// stay valid until they expire, which accessTokenTTL keeps short.
+func (app *application) logout(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var req LogoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, apierr.InvalidJSON, "json decoding error")
+		return
+	}
+	if req.RefreshToken == nil {
+		sendValidationError(w, apierr.ValidationFailed, "logout validation error", []apierr.FieldError{
+			apierr.NewFieldError("refresh_token", apierr.KeyRequired, nil),
+		})
+		return
+	}
+	err := app.users.RevokeSession(r.Context(), hashSecret(*req.RefreshToken), req.All)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		sendStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// issueAccessToken signs a token for the user, with the session as its ID.
+func (app *application) issueAccessToken(userID int32, sessionID int64, now time.Time) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   strconv.Itoa(int(userID)),
+		ID:        strconv.FormatInt(sessionID, 10),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(app.cfg.accessTokenTTL)),
+	}).SignedString([]byte(app.cfg.jwtSecret))
+}
+
+func (app *application) parseAccessToken(raw string) (int32, error) {
+	var claims jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(raw, &claims, func(*jwt.Token) (any, error) {
+		return []byte(app.cfg.jwtSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseInt(claims.Subject, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid subject %q", claims.Subject)
+	}
+	return int32(id), nil
+}
+
+// withUser authenticates a bearer access token when one is sent and logs
+// successful writes with the user that made them. Requests without a token
+// stay anonymous.
+func (app *application) withUser(next http.Handler) http.Handler {
+	if app.users == nil || app.cfg.jwtSecret == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		userID, err := app.parseAccessToken(raw)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			sendError(w, apierr.Unauthorized, "Invalid or expired access token")
+			return
+		}
+
+		sr := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(sr, r.WithContext(context.WithValue(r.Context(), userIDKey, userID)))
+		if isWrite(r.Method) && sr.status < 300 {
+			slog.InfoContext(r.Context(), "write by user",
+				"user_id", userID, "method", r.Method, "route", routeTemplate(r), "path", r.URL.Path)
+		}
+	})
+}
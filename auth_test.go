@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newAuthTestApp(t *testing.T) (*application, http.Handler) {
+	t.Helper()
+	cost := bcryptCost
+	bcryptCost = bcrypt.MinCost
+	t.Cleanup(func() { bcryptCost = cost })
+
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	app.users = testutil.NewMemoryUserStore()
+	app.cfg.jwtSecret = "test-secret"
+	return app, withContractCheck(t, app.routes())
+}
+
+func doWithToken(h http.Handler, method, target, token string, body any) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if body != nil {
+		req = httptest.NewRequest(method, target, testutil.JSONBody(body))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAuth_RegisterAndLogin(t *testing.T) {
+	_, router := newAuthTestApp(t)
+	creds := CredentialsRequest{Email: stringPtr("Ann@Example.com"), Password: stringPtr("correct horse")}
+
+	rr := testutil.Do(router, "POST", "/api/v1/auth/register", creds)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var user UserResponse
+	json.NewDecoder(rr.Body).Decode(&user)
+	if user.ID != 1 || user.Email != "ann@example.com" {
+		t.Errorf("Unexpected user %+v", user)
+	}
+
+	again := CredentialsRequest{Email: stringPtr("ann@example.com"), Password: stringPtr("another one")}
+	if rr := testutil.Do(router, "POST", "/api/v1/auth/register", again); rr.Code != http.StatusConflict || decodeErrorCode(t, rr) != apierr.Conflict {
+		t.Errorf("Expected 409 for a taken email, got %d", rr.Code)
+	}
+
+	testCases := []struct {
+		name     string
+		email    string
+		password string
+		wantCode int
+	}{
+		{"Wrong password", "ann@example.com", "wrong horse", http.StatusUnauthorized},
+		{"Unknown email", "bob@example.com", "correct horse", http.StatusUnauthorized},
+		{"Email in other case", "ANN@example.com", "correct horse", http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := testutil.Do(router, "POST", "/api/v1/auth/login", CredentialsRequest{Email: &tc.email, Password: &tc.password})
+			if rr.Code != tc.wantCode {
+				t.Fatalf("Expected %d, got %d: %s", tc.wantCode, rr.Code, rr.Body.String())
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			var tok TokenResponse
+			json.NewDecoder(rr.Body).Decode(&tok)
+			if tok.TokenType != "Bearer" || tok.AccessToken == "" || tok.ExpiresIn != 900 || tok.RefreshToken == "" {
+				t.Errorf("Unexpected token response %+v", tok)
+			}
+			if rr := doWithToken(router, "POST", "/api/v1/persons", tok.AccessToken, PersonRequest{Name: stringPtr("Bob")}); rr.Code != http.StatusCreated {
+				t.Errorf("Expected the access token to be accepted, got %d", rr.Code)
+			}
+		})
+	}
+}
+
+func TestAuth_RegisterValidation(t *testing.T) {
+	_, router := newAuthTestApp(t)
+	testCases := []struct {
+		name  string
+		req   CredentialsRequest
+		field string
+	}{
+		{"Missing email", CredentialsRequest{Password: stringPtr("long enough")}, "email"},
+		{"Invalid email", CredentialsRequest{Email: stringPtr("Ann <ann@example.com>"), Password: stringPtr("long enough")}, "email"},
+		{"Short password", CredentialsRequest{Email: stringPtr("ann@example.com"), Password: stringPtr("short")}, "password"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := testutil.Do(router, "POST", "/api/v1/auth/register", tc.req)
+			var body ValidationErrorResponse
+			json.NewDecoder(rr.Body).Decode(&body)
+			if _, ok := body.Errors[tc.field]; rr.Code != http.StatusBadRequest || !ok {
+				t.Errorf("Expected 400 on %s, got %d %v", tc.field, rr.Code, body.Errors)
+			}
+		})
+	}
+}
+
+func TestAuth_RejectsBadTokens(t *testing.T) {
+	app, router := newAuthTestApp(t)
+	expired, _ := app.issueAccessToken(1, 1, time.Now().Add(-2*time.Hour))
+	other := *app
+	other.cfg.jwtSecret = "other-secret"
+	forged, _ := other.issueAccessToken(1, 1, time.Now())
+
+	for name, token := range map[string]string{"expired": expired, "wrong key": forged, "garbage": "abc.def.ghi"} {
+		rr := doWithToken(router, "GET", "/api/v1/persons", token, nil)
+		if rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: expected 401 with WWW-Authenticate, got %d", name, rr.Code)
+		}
+	}
+}
+
+func TestAuth_RefreshAndLogout(t *testing.T) {
+	_, router := newAuthTestApp(t)
+	creds := CredentialsRequest{Email: stringPtr("ann@example.com"), Password: stringPtr("correct horse")}
+	testutil.Do(router, "POST", "/api/v1/auth/register", creds)
+	login := func() TokenResponse {
+		t.Helper()
+		rr := testutil.Do(router, "POST", "/api/v1/auth/login", creds)
+		var tok TokenResponse
+		json.NewDecoder(rr.Body).Decode(&tok)
+		return tok
+	}
+	refresh := func(token string) (*httptest.ResponseRecorder, TokenResponse) {
+		rr := testutil.Do(router, "POST", "/api/v1/auth/refresh", RefreshRequest{RefreshToken: &token})
+		var tok TokenResponse
+		if rr.Code == http.StatusOK {
+			json.NewDecoder(rr.Body).Decode(&tok)
+		}
+		return rr, tok
+	}
+
+	first := login()
+	rr, second := refresh(first.RefreshToken)
+	if rr.Code != http.StatusOK || second.RefreshToken == first.RefreshToken || second.AccessToken == "" {
+		t.Fatalf("Expected a rotated refresh token, got %d %+v", rr.Code, second)
+	}
+	if rr, _ := refresh(first.RefreshToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a used refresh token to be refused, got %d", rr.Code)
+	}
+	if rr, _ := refresh(second.RefreshToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected reuse to revoke the whole session, got %d", rr.Code)
+	}
+
+	other := login()
+	third := login()
+	rr = testutil.Do(router, "POST", "/api/v1/auth/logout", LogoutRequest{RefreshToken: &third.RefreshToken})
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 on logout, got %d", rr.Code)
+	}
+	if rr, _ := refresh(third.RefreshToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a logged out session to be refused, got %d", rr.Code)
+	}
+	rr, other = refresh(other.RefreshToken)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected other sessions to survive a logout, got %d", rr.Code)
+	}
+
+	last := login()
+	testutil.Do(router, "POST", "/api/v1/auth/logout", LogoutRequest{RefreshToken: &last.RefreshToken, All: true})
+	if rr, _ := refresh(other.RefreshToken); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected logout with all to end every session, got %d", rr.Code)
+	}
+}
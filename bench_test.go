@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+var benchmarkSizes = []int{10_000, 100_000}
+
+func seedMemoryStore(n int) *testutil.MemoryStore {
+	return testutil.NewMemoryStore(testutil.NewFactory(1).Persons(n)...)
+}
+
+// setupBenchmarkPostgres returns a router over a persons table holding n rows,
+// skipping the benchmark when no Postgres is available.
+func setupBenchmarkPostgres(b *testing.B, n int) http.Handler {
+	db := setupTestDB(b)
+	testutil.InsertPersons(b, db, testutil.NewFactory(1).Persons(n)...)
+	return newApplication(loadConfig(), db).routes()
+}
+
+func benchmarkRequest(b *testing.B, router http.Handler, target string) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+		if rr.Code != http.StatusOK {
+			b.Fatalf("Expected status 200, got %d", rr.Code)
+		}
+	}
+}
+
+func BenchmarkListPersons(b *testing.B) {
+	for _, n := range benchmarkSizes {
+		b.Run(fmt.Sprintf("memory/rows=%d", n), func(b *testing.B) {
+			benchmarkRequest(b, newTestAppWithStore(seedMemoryStore(n)).routes(), "/api/v1/persons?limit=1000")
+		})
+		b.Run(fmt.Sprintf("postgres/rows=%d", n), func(b *testing.B) {
+			benchmarkRequest(b, setupBenchmarkPostgres(b, n), "/api/v1/persons?limit=1000")
+		})
+	}
+}
+
+func BenchmarkGetPerson(b *testing.B) {
+	const rows = 10_000
+	b.Run("memory", func(b *testing.B) {
+		benchmarkRequest(b, newTestAppWithStore(seedMemoryStore(rows)).routes(), "/api/v1/persons/5000")
+	})
+	b.Run("postgres", func(b *testing.B) {
+		router := setupBenchmarkPostgres(b, rows)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/persons", nil))
+		benchmarkRequest(b, router, "/api/v1/persons/"+firstPersonID(b, rr))
+	})
+}
+
+func firstPersonID(b *testing.B, rr *httptest.ResponseRecorder) string {
+	var persons []PersonResponse
+	if err := json.NewDecoder(rr.Body).Decode(&persons); err != nil || len(persons) == 0 {
+		b.Fatalf("Failed to list seeded persons: %v", err)
+	}
+	return fmt.Sprint(persons[0].ID)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+const (
+	// cacheRingPoints is how many places each replica takes on the ring.
+	cacheRingPoints = 100
+	// cachePeerTimeout bounds a request to a peer, which is then bypassed.
+	cachePeerTimeout = time.Second
+)
+
+// httpCachePeers reaches the caches of other replicas over their /peer
+// routes. Peers are named by their base URLs.
+type httpCachePeers struct {
+	client *http.Client
+	token  string
+}
+
+func (p *httpCachePeers) GetPerson(ctx context.Context, peer string, id int32) (store.Person, error) {
+	var person store.Person
+	err := p.do(ctx, http.MethodGet, peer, id, func(resp *http.Response) error {
+		return json.NewDecoder(resp.Body).Decode(&person)
+	})
+	return person, err
+}
+
+func (p *httpCachePeers) Invalidate(ctx context.Context, peer string, id int32) error {
+	return p.do(ctx, http.MethodDelete, peer, id, nil)
+}
+
+func (p *httpCachePeers) do(ctx context.Context, method, peer string, id int32, decode func(resp *http.Response) error) error {
+	ctx, cancel := context.WithTimeout(ctx, cachePeerTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, peer+"/peer/cache/persons/"+strconv.Itoa(int(id)), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		// Only a missing person, not a peer without the routes.
+		var body ErrorResponse
+		if json.NewDecoder(resp.Body).Decode(&body) == nil && body.Code == apierr.PersonNotFound {
+			return store.ErrNotFound
+		}
+		return fmt.Errorf("%s %s: %s", method, req.URL, resp.Status)
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("%s %s: %s", method, req.URL, resp.Status)
+	case decode != nil:
+		return decode(resp)
+	}
+	return nil
+}
+
+// partitionCache shares the cache out between the replicas at
+// cfg.cachePeers.
+func (app *application) partitionCache() {
+	self := strings.TrimSuffix(app.cfg.cacheSelf, "/")
+	peers := make([]string, len(app.cfg.cachePeers))
+	for i, peer := range app.cfg.cachePeers {
+		peers[i] = strings.TrimSuffix(peer, "/")
+	}
+	if !slices.Contains(peers, self) {
+		slog.Error("cache not partitioned: CACHE_SELF must be one of CACHE_PEERS", "self", app.cfg.cacheSelf)
+		return
+	}
+	if app.cfg.adminToken == "" {
+		slog.Error("cache not partitioned: peers authenticate with ADMIN_TOKEN, which is not set")
+		return
+	}
+	app.cache.Partition(self, store.NewHashRing(cacheRingPoints, peers...), &httpCachePeers{
+		client: &http.Client{},
+		token:  app.cfg.adminToken,
+	})
+}
+
+// peerGetPerson serves a person from this replica's cache to the peers it
+// is partitioned with; peerInvalidatePerson drops one they wrote.
+func (app *application) peerGetPerson(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		sendError(w, apierr.InvalidID, "Invalid ID format")
+		return
+	}
+	p, err := app.cache.GetCachedPerson(r.Context(), id)
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+func (app *application) peerInvalidatePerson(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		sendError(w, apierr.InvalidID, "Invalid ID format")
+		return
+	}
+	app.cache.Invalidate(id)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestPartitionedCache(t *testing.T) {
+	st := testutil.NewMemoryStore(store.Person{Name: "Ann"})
+	// Two replicas over one database.
+	apps := make([]*application, 2)
+	servers := make([]*httptest.Server, 2)
+	routers := make([]http.Handler, 2)
+	for i := range apps {
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			routers[i].ServeHTTP(w, r)
+		}))
+		defer servers[i].Close()
+	}
+	for i := range apps {
+		app := newTestAppWithStore(st)
+		app.cfg.adminToken = "secret"
+		app.cfg.cachePeers = []string{servers[0].URL, servers[1].URL + "/"}
+		app.cfg.cacheSelf = servers[i].URL
+		app.cache = store.NewCache(st, time.Hour)
+		app.store = app.cache
+		app.partitionCache()
+		apps[i], routers[i] = app, app.routes()
+	}
+	owner := 0
+	if store.NewHashRing(cacheRingPoints, servers[0].URL, servers[1].URL).Node("1") != servers[0].URL {
+		owner = 1
+	}
+	other := 1 - owner
+
+	get := func(replica int) string {
+		t.Helper()
+		rr := testutil.Do(routers[replica], "GET", "/api/v1/persons/1", nil)
+		var p PersonResponse
+		json.NewDecoder(rr.Body).Decode(&p)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected the person, got %d", rr.Code)
+		}
+		return p.Name
+	}
+	get(other)
+	st.Put(store.Person{ID: 1, Name: "Changed elsewhere"})
+	if name := get(owner); name != "Ann" {
+		t.Errorf("Expected the owner to have cached the read through the other replica, got %q", name)
+	}
+	if rr := testutil.Do(routers[other], "PATCH", "/api/v1/persons/1", map[string]any{"name": "Patched"}); rr.Code != http.StatusOK {
+		t.Fatalf("Expected the update to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if name := get(owner); name != "Patched" {
+		t.Errorf("Expected the write through the other replica to invalidate the owner, got %q", name)
+	}
+	if rr := testutil.Do(routers[other], "GET", "/api/v1/persons/99", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected a missing person to be missing through the owner, got %d", rr.Code)
+	}
+
+	if rr := testutil.Do(routers[owner], "GET", "/peer/cache/persons/1", nil); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected peers to authenticate, got %d", rr.Code)
+	}
+}
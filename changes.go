@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+)
+
+type ChangeResponse struct {
+	Seq       int64          `json:"seq"`
+	Op        string         `json:"op"`
+	Person    PersonResponse `json:"person"`
+	ChangedAt time.Time      `json:"changed_at"`
+}
+
+type ChangesResponse struct {
+	Changes []ChangeResponse `json:"changes"`
+	Next    int64            `json:"next"`
+}
+
+// listChanges serves the change feed page after ?since=. Consumers keep the
+// returned next and pass it back; an empty page means they are caught up.
+func (app *application) listChanges(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var errs []apierr.FieldError
+	var since int64
+	if raw := q.Get("since"); raw != "" {
+		n, err := strconv.ParseInt(raw, 10, 64)
+		switch {
+		case err != nil:
+			errs = append(errs, apierr.NewFieldError("since", apierr.KeyNotInteger, map[string]any{"actual": raw}))
+		case n < 0:
+			errs = append(errs, apierr.NewFieldError("since", apierr.KeyMinValue, map[string]any{"limit": 0, "actual": n}))
+		default:
+			since = n
+		}
+	}
+	limit, errs := parseLimit(q, app.cfg.page, errs)
+	if len(errs) > 0 {
+		sendValidationError(w, apierr.ValidationFailed, "Invalid query parameters", errs)
+		return
+	}
+
+	changes, err := app.changes.Changes(r.Context(), since, limit)
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+
+	resp := ChangesResponse{Changes: make([]ChangeResponse, 0, len(changes)), Next: since}
+	for _, c := range changes {
+		resp.Changes = append(resp.Changes, ChangeResponse{
+			Seq:       c.Seq,
+			Op:        c.Op,
+			Person:    toPersonResponse(c.Person),
+			ChangedAt: c.ChangedAt.UTC(),
+		})
+		resp.Next = c.Seq
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		sendError(w, apierr.Internal, "Encoding error")
+	}
+}
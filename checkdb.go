@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+// checkDB runs the data checks for the checkdb command, writing the report
+// as JSON to w, and returns the exit status: 0 without anomalies, 2 with
+// some and 1 when the checks could not run.
+func checkDB(ctx context.Context, checker store.DataChecker, w io.Writer) int {
+	report, err := checker.CheckData(ctx)
+	if err != nil {
+		slog.Error("failed to check data", "err", err)
+		return 1
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+	if len(report.Anomalies) > 0 {
+		return 2
+	}
+	return 0
+}
+
+func (app *application) getDataCheck(w http.ResponseWriter, r *http.Request) {
+	report, err := app.dataCheck.CheckData(r.Context())
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
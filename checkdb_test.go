@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+type fakeDataChecker struct {
+	report store.DataReport
+	err    error
+}
+
+func (c *fakeDataChecker) CheckData(ctx context.Context) (store.DataReport, error) {
+	return c.report, c.err
+}
+
+func TestCheckDB(t *testing.T) {
+	clean := &fakeDataChecker{report: store.DataReport{Checks: []string{"blank_name"}, Anomalies: []store.Anomaly{}}}
+	dirty := &fakeDataChecker{report: store.DataReport{
+		Checks:    []string{"blank_name", "negative_age"},
+		Anomalies: []store.Anomaly{{Check: "negative_age", Description: "persons with a negative age", Count: 1, IDs: []int64{7}}},
+	}}
+	testCases := []struct {
+		name    string
+		checker store.DataChecker
+		want    int
+	}{
+		{"clean", clean, 0},
+		{"anomalies", dirty, 2},
+		{"database down", &fakeDataChecker{err: store.ErrUnavailable}, 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if got := checkDB(context.Background(), tc.checker, &out); got != tc.want {
+				t.Errorf("Expected exit status %d, got %d", tc.want, got)
+			}
+			if tc.want != 1 && !json.Valid(out.Bytes()) {
+				t.Errorf("Expected a JSON report, got %q", out.String())
+			}
+		})
+	}
+
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	app.cfg.adminToken = "s3cret"
+	app.dataCheck = dirty
+	req := httptest.NewRequest("GET", "/admin/checkdb", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+	var report store.DataReport
+	if err := json.NewDecoder(rr.Body).Decode(&report); err != nil || rr.Code != http.StatusOK || len(report.Anomalies) != 1 || report.Anomalies[0].IDs[0] != 7 {
+		t.Errorf("Expected the anomalies, got %d %+v %v", rr.Code, report, err)
+	}
+
+	app.dataCheck = &fakeDataChecker{err: errors.New("boom")}
+	rr = httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Expected a failed check to be a server error, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+const historyPurgeEvery = time.Hour
+
+// CleanupReport counts what a cleanup deleted, or would have with DryRun.
+type CleanupReport struct {
+	DryRun bool `json:"dry_run"`
+	// Objects are attachment and photo objects no row references.
+	Objects int `json:"objects"`
+	// History are change feed entries and events of persons deleted longer
+	// than the history retention ago.
+	History int64 `json:"history"`
+}
+
+// cleanup deletes the data nothing needs any more, which the leader also
+// does every so often, or with dryRun only counts it. What is turned off is
+// skipped.
+func (app *application) cleanup(ctx context.Context, dryRun bool, progress *jobReport) (CleanupReport, error) {
+	report := CleanupReport{DryRun: dryRun}
+	verb := "Deleted"
+	if dryRun {
+		verb = "Would delete"
+	}
+	var err error
+	if app.blobs != nil {
+		if report.Objects, err = app.sweepOrphans(ctx, time.Now().Add(-app.cfg.orphanGracePeriod), dryRun); err != nil {
+			return report, fmt.Errorf("sweep orphaned objects: %w", err)
+		}
+		progress.logf(ctx, 50, "%s %d orphaned objects", verb, report.Objects)
+	}
+	if app.historyPurge != nil && app.cfg.historyRetention > 0 {
+		if report.History, err = app.historyPurge.PurgeHistory(ctx, time.Now().Add(-app.cfg.historyRetention), dryRun); err != nil {
+			return report, err
+		}
+		progress.logf(ctx, 100, "%s %d history entries of deleted persons", verb, report.History)
+	}
+	return report, nil
+}
+
+// purgeHistory deletes the history of persons deleted longer than
+// historyRetention ago every historyPurgeEvery until ctx is done.
+func (app *application) purgeHistory(ctx context.Context) {
+	ticker := time.NewTicker(historyPurgeEvery)
+	defer ticker.Stop()
+	for {
+		n, err := app.historyPurge.PurgeHistory(ctx, time.Now().Add(-app.cfg.historyRetention), false)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to purge history", "err", err)
+		} else if n > 0 {
+			slog.InfoContext(ctx, "purged history of deleted persons", "entries", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type cleanupPayload struct {
+	DryRun bool `json:"dry_run"`
+}
+
+func (app *application) cleanupJob(ctx context.Context, j store.Job, progress *jobReport) (jobOutput, error) {
+	var p cleanupPayload
+	if err := json.Unmarshal(j.Payload, &p); err != nil {
+		return jobOutput{}, err
+	}
+	report, err := app.cleanup(ctx, p.DryRun, progress)
+	return jobOutput{result: report}, err
+}
+
+// runCleanupCommand implements `lab1 cleanup [-dry-run]`, writing the report
+// as JSON to w, and returns the exit status.
+func runCleanupCommand(ctx context.Context, app *application, args []string, w io.Writer) int {
+	fs := flag.NewFlagSet("cleanup", flag.ContinueOnError)
+	fs.SetOutput(w)
+	dryRun := fs.Bool("dry-run", false, "count what would be deleted without deleting it")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	report, err := app.cleanup(ctx, *dryRun, nil)
+	if err != nil {
+		slog.Error("failed to clean up", "err", err)
+		return 1
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+	return 0
+}
+
+func (app *application) runCleanup(w http.ResponseWriter, r *http.Request) {
+	dryRun, errs := parseBoolParam(r.URL.Query().Get("dry_run"), "dry_run", nil)
+	if len(errs) > 0 {
+		sendValidationError(w, apierr.ValidationFailed, "Invalid query parameters", errs)
+		return
+	}
+	if wantsAsync(r) && app.jobQueue != nil {
+		app.startJob(w, r, "cleanup", cleanupPayload{DryRun: dryRun})
+		return
+	}
+	report, err := app.cleanup(r.Context(), dryRun, nil)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "cleanup failed", "err", err)
+		sendDebugError(w, apierr.Internal, "Cleanup failed", errorDebug(r.Context(), err, 1))
+		return
+	}
+	slog.InfoContext(r.Context(), "cleanup run by admin", "dry_run", dryRun, "objects", report.Objects, "history", report.History)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/blob"
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+type fakeHistoryPurger struct {
+	entries int64
+	before  time.Time
+	dryRuns []bool
+}
+
+func (p *fakeHistoryPurger) PurgeHistory(ctx context.Context, before time.Time, dryRun bool) (int64, error) {
+	p.before = before
+	p.dryRuns = append(p.dryRuns, dryRun)
+	n := p.entries
+	if !dryRun {
+		p.entries = 0
+	}
+	return n, nil
+}
+
+func (p *fakeHistoryPurger) PurgePersonHistory(ctx context.Context, id int32) (int64, error) {
+	return 0, nil
+}
+
+func TestCleanup(t *testing.T) {
+	st := testutil.NewMemoryStore(store.Person{Name: "Ann"})
+	app := newTestAppWithStore(st)
+	app.cfg.adminToken = "s3cret"
+	app.cfg.orphanGracePeriod = -time.Hour
+	app.cfg.historyRetention = 24 * time.Hour
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	purger := &fakeHistoryPurger{entries: 3}
+	app.blobs, app.attachments, app.historyPurge = blobs, st, purger
+	app.jobQueue = testutil.NewMemoryJobQueue()
+	ctx := context.Background()
+	if err := blobs.Put(ctx, "attachments/1/orphan", strings.NewReader("x"), 1, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	cleanup := func(target string) (int, CleanupReport) {
+		t.Helper()
+		req := httptest.NewRequest("POST", target, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, req)
+		var report CleanupReport
+		if rr.Code == http.StatusOK {
+			if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rr.Code, report
+	}
+
+	want := CleanupReport{DryRun: true, Objects: 1, History: 3}
+	if code, report := cleanup("/admin/cleanup?dry_run=true"); code != http.StatusOK || report != want {
+		t.Errorf("Expected %+v, got %d %+v", want, code, report)
+	}
+	if _, err := blobs.Stat(ctx, "attachments/1/orphan"); err != nil {
+		t.Errorf("Expected a dry run to keep the orphan: %v", err)
+	}
+	if got := time.Since(purger.before); got < 24*time.Hour || got > 25*time.Hour {
+		t.Errorf("Expected history deleted a day ago to be purged, got a cutoff %s ago", got)
+	}
+
+	want = CleanupReport{Objects: 1, History: 3}
+	if code, report := cleanup("/admin/cleanup"); code != http.StatusOK || report != want {
+		t.Errorf("Expected %+v, got %d %+v", want, code, report)
+	}
+	if _, err := blobs.Stat(ctx, "attachments/1/orphan"); err == nil {
+		t.Error("Expected the orphan to be deleted")
+	}
+	if want := []bool{true, false}; len(purger.dryRuns) != 2 || purger.dryRuns[0] != want[0] || purger.dryRuns[1] != want[1] {
+		t.Errorf("Expected purges %v, got %v", want, purger.dryRuns)
+	}
+
+	req := httptest.NewRequest("POST", "/admin/cleanup?dry_run=true", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	req.Header.Set("Prefer", "respond-async")
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+	var j JobResponse
+	if err := json.NewDecoder(rr.Body).Decode(&j); err != nil || rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected the cleanup to start as a job, got %d %v", rr.Code, err)
+	}
+	if ran, err := app.runNextJob(ctx); !ran || err != nil {
+		t.Fatalf("Expected the job to run, got %v %v", ran, err)
+	}
+	if j, _ := app.jobQueue.Job(ctx, j.ID); j.Status != store.JobDone || string(j.Result) != `{"dry_run":true,"objects":0,"history":0}` {
+		t.Errorf("Expected the job's report, got %+v", j)
+	}
+
+	if code, _ := cleanup("/admin/cleanup?dry_run=yes"); code != http.StatusBadRequest {
+		t.Errorf("Expected dry_run=yes to be refused, got %d", code)
+	}
+
+	app.cfg.historyRetention = 0
+	var out bytes.Buffer
+	if got := runCleanupCommand(ctx, app, []string{"-dry-run"}, &out); got != 0 {
+		t.Fatalf("Expected exit status 0, got %d: %s", got, out.String())
+	}
+	var report CleanupReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil || report != (CleanupReport{DryRun: true}) {
+		t.Errorf("Expected nothing left and history kept forever, got %+v %v", report, err)
+	}
+}
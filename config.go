@@ -0,0 +1,553 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/blob"
+	"ci_cd/rsoi_lab_1/internal/discovery"
+	"ci_cd/rsoi_lab_1/internal/imaging"
+	"ci_cd/rsoi_lab_1/internal/redis"
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+type routeTimeouts struct {
+	get   time.Duration
+	list  time.Duration
+	write time.Duration
+}
+
+type config struct {
+	port        string
+	databaseURL string
+	// shardURLs are the databases persons are spread over, when there are
+	// several; databaseURL keeps everything else. Attachments, photos,
+	// nearby search, the change feed and the event store need persons in the
+	// same database as their other tables and are off while sharded.
+	shardURLs    []string
+	timeouts     routeTimeouts
+	maxInFlight  int
+	maxQueueWait time.Duration
+
+	// expensiveMaxConcurrent bounds the audit exports, cleanups, data checks
+	// and plans running at once, with up to expensiveMaxQueue more waiting.
+	expensiveMaxConcurrent int
+	expensiveMaxQueue      int
+
+	// rateLimit is the number of API requests a client may make per
+	// rateLimitWindow. Zero disables rate limiting.
+	rateLimit       int
+	rateLimitWindow time.Duration
+	// redis is where replicas count requests together against rateLimit;
+	// without an address each counts its own.
+	redis redis.Config
+
+	// rowLocking makes PATCH lock the row with SELECT ... FOR UPDATE instead of
+	// relying on a single UPDATE statement.
+	rowLocking  bool
+	lockTimeout time.Duration
+
+	// slowQueryThreshold is how long a query may take before it is logged
+	// and counted as slow; zero turns that off.
+	slowQueryThreshold time.Duration
+
+	page pageLimits
+
+	logLevel slog.Level
+	// adminToken is the bearer token for /admin endpoints; empty disables them.
+	adminToken string
+	// adminTOTPRequired refuses destructive admin endpoints until a TOTP
+	// secret is enrolled. Once one is, codes are always required.
+	adminTOTPRequired bool
+
+	healthCheckTimeout time.Duration
+
+	sentryDSN         string
+	sentryEnvironment string
+
+	// putCreates lets PUT on an unknown ID create the person under that ID
+	// instead of answering 404, for imports that must keep legacy IDs.
+	putCreates bool
+
+	// cacheTTL enables the in-process person cache; replicas keep it fresh
+	// through LISTEN/NOTIFY and the TTL only bounds staleness. Zero disables it.
+	cacheTTL time.Duration
+	// cachePeers are the base URLs of all replicas, this one being cacheSelf.
+	// With them, each person is cached only by the replica the URLs hash it
+	// to, and the others ask that one for it over /peer, authenticated with
+	// the admin token.
+	cachePeers []string
+	cacheSelf  string
+
+	// changeFeed records every change to persons and serves them at
+	// /api/v1/changes.
+	changeFeed bool
+
+	// storeMode selects the persistence model: storeModeCRUD updates persons
+	// in place, storeModeEvents appends every change to an event log and keeps
+	// persons as its projection.
+	storeMode string
+
+	// requireAPIKey refuses API requests without an X-API-Key header. Keys are
+	// always checked and counted when sent.
+	requireAPIKey bool
+
+	// jwtSecret signs the access tokens issued at /api/v1/auth/login; empty
+	// disables user accounts. Access tokens are short-lived and checked
+	// without a database round trip; sessions are extended with refresh
+	// tokens, which rotate on every use, for up to refreshTokenTTL.
+	jwtSecret       string
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+
+	// staticDir is served at / for a bundled frontend, with index.html for
+	// unknown paths so client-side routes work; empty disables it. Files other
+	// than index.html may be cached for staticMaxAge.
+	staticDir    string
+	staticMaxAge time.Duration
+
+	// searchBackend serves /api/v1/persons/search from Postgres full-text
+	// search, or from an Elasticsearch/OpenSearch index at elasticsearchURL
+	// that every instance keeps in sync.
+	searchBackend      string
+	elasticsearchURL   string
+	elasticsearchIndex string
+
+	// ui serves a small htmx frontend at /ui. It has no login of its own, so
+	// it is not served when requireAPIKey closes the API.
+	ui bool
+
+	// geocoder looks up coordinates for addresses in the background when
+	// clients set an address without them: "nominatim", "google" or empty for
+	// none. Provider calls are at least geocoderInterval apart and answers are
+	// cached for geocoderCacheTTL. geocoderURL overrides the provider's
+	// default, e.g. for a self-hosted Nominatim.
+	geocoder         string
+	geocoderURL      string
+	geocoderAPIKey   string
+	geocoderInterval time.Duration
+	geocoderCacheTTL time.Duration
+
+	// addressValidatorURL is a webhook that verifies addresses before they
+	// are stored (see geocode.WebhookValidator); empty disables validation.
+	// Verdicts are cached for addressValidationCacheTTL.
+	addressValidatorURL       string
+	addressValidationCacheTTL time.Duration
+
+	// phoneRegion is the ISO 3166 region phone numbers without a country code
+	// are assumed to be from, e.g. "RU". Empty requires the country code.
+	phoneRegion string
+
+	// deletePolicies overrides what deleting a person does to each relation
+	// referencing it, see store.Relations.
+	deletePolicies map[string]store.DeletePolicy
+
+	// validateRequests checks API requests against openapi.yaml before
+	// handlers run, refusing mismatches with 400.
+	validateRequests bool
+	// validateResponses checks API responses against openapi.yaml and turns
+	// mismatches into 500s. It buffers every response and is meant for
+	// development and tests only.
+	validateResponses bool
+
+	// environment names the deployment, e.g. production or development.
+	environment string
+	// debug adds the underlying error, the last database query and where the
+	// error was raised to 5xx responses. It is ignored in production.
+	debug bool
+
+	// accessLog is where requests are logged in accessLogFormat, the Common
+	// or Combined Log Format: a file, "stdout" or "stderr". Empty disables
+	// the access log.
+	accessLog       string
+	accessLogFormat string
+
+	// auditRetention is how long the audit trail of write requests is kept
+	// and exported. Zero keeps it forever.
+	auditRetention time.Duration
+	// historyRetention is how long the history of a deleted person, in the
+	// change feed or the event log, outlives it. Zero keeps it forever.
+	historyRetention time.Duration
+	// anonymizeAfter is how long a person may go without being updated
+	// before the leader anonymizes it. Zero never does.
+	anonymizeAfter time.Duration
+
+	// jobWorkers is how many jobs this instance runs at once; zero leaves
+	// them to other instances.
+	jobWorkers int
+
+	// metricsExport selects how metrics leave the process: scraped from
+	// /metrics, pushed to statsdAddr, or both.
+	metricsExport string
+	statsdAddr    string
+	statsdPrefix  string
+	// statsdTags sends labels as DogStatsD tags instead of appending them to
+	// metric names.
+	statsdTags bool
+
+	// storageDriver selects where attachments are stored: "local" under
+	// storageDir, "s3" in the bucket described by s3, or empty to disable
+	// attachments.
+	storageDriver     string
+	storageDir        string
+	s3                blob.S3Config
+	attachmentMaxSize int64
+	// presignTTL is how long presigned upload and download URLs work, and
+	// presignedMaxSize how large presigned uploads may be. Only S3 storage
+	// presigns.
+	presignTTL       time.Duration
+	presignedMaxSize int64
+	// orphanGracePeriod is how old an object nothing references must be
+	// before it is deleted; younger ones may belong to uploads in progress.
+	orphanGracePeriod time.Duration
+	// integrityCheckInterval is how often every attachment is read back and
+	// checked against its checksum, or 0 to check only when an admin asks.
+	integrityCheckInterval time.Duration
+
+	// malwareScanner scans every upload before it becomes an attachment:
+	// "clamav" for the clamd at clamavAddr, or empty for no scanning.
+	// Infected uploads are quarantined and refused; while the scanner is
+	// unavailable uploads are refused too.
+	malwareScanner string
+	clamavAddr     string
+	clamavTimeout  time.Duration
+
+	// photoVariants are the sizes photos are scaled down to on upload, besides
+	// the original.
+	photoVariants []imaging.Variant
+	photoMaxSize  int64
+	// photoCacheMaxAge is how long photos may be cached under URLs naming
+	// their content, which never change.
+	photoCacheMaxAge time.Duration
+	// imageMaxDimension bounds the longer side of uploaded images, photos
+	// and attachments alike.
+	imageMaxDimension int
+
+	// serviceRegistry is where the instance registers on startup and
+	// deregisters on shutdown: "consul" for the agent at consulAddr, or empty
+	// not to register.
+	serviceRegistry string
+	consulAddr      string
+	consulToken     string
+	// service is the instance as registered; the registry checks its health
+	// at /readyz.
+	service discovery.Service
+	// shutdownTimeout bounds how long requests in flight may finish on
+	// shutdown.
+	shutdownTimeout time.Duration
+
+	// shadowURL is the base URL of a secondary deployment, such as a canary,
+	// that shadowSampleRate of API reads are mirrored to; differences from
+	// the responses served are listed at /admin/shadow.
+	shadowURL        string
+	shadowSampleRate float64
+}
+
+const (
+	metricsPrometheus = "prometheus"
+	metricsStatsD     = "statsd"
+	metricsBoth       = "both"
+)
+
+const environmentProduction = "production"
+
+const registryConsul = "consul"
+
+const (
+	storeModeCRUD   = "crud"
+	storeModeEvents = "events"
+)
+
+const (
+	searchBackendPostgres = "postgres"
+	searchBackendElastic  = "elasticsearch"
+)
+
+func loadConfig() config {
+	environment := envString("APP_ENV", environmentProduction)
+	return config{
+		port:        envString("PORT", "8080"),
+		databaseURL: envString("DATABASE_URL", "postgres://localhost:5432/persons?sslmode=disable"),
+		shardURLs:   envList("SHARD_DATABASE_URLS"),
+		timeouts: routeTimeouts{
+			get:   envDuration("TIMEOUT_GET", 2*time.Second),
+			list:  envDuration("TIMEOUT_LIST", 10*time.Second),
+			write: envDuration("TIMEOUT_WRITE", 5*time.Second),
+		},
+		maxInFlight:  envInt("SHED_MAX_IN_FLIGHT", 256),
+		maxQueueWait: envDuration("SHED_MAX_QUEUE_WAIT", 100*time.Millisecond),
+
+		expensiveMaxConcurrent: envInt("EXPENSIVE_MAX_CONCURRENT", 4),
+		expensiveMaxQueue:      envInt("EXPENSIVE_MAX_QUEUE", 16),
+
+		rateLimit:       envInt("RATE_LIMIT", 0),
+		rateLimitWindow: envDuration("RATE_LIMIT_WINDOW", time.Minute),
+		redis: redis.Config{
+			Addr:     os.Getenv("REDIS_ADDR"),
+			Password: os.Getenv("REDIS_PASSWORD"),
+			DB:       envInt("REDIS_DB", 0),
+			Timeout:  envDuration("REDIS_TIMEOUT", 100*time.Millisecond),
+		},
+
+		rowLocking:  envBool("UPDATE_ROW_LOCK", false),
+		lockTimeout: envDuration("LOCK_TIMEOUT", 2*time.Second),
+		putCreates:  envBool("PUT_CREATES", false),
+		cacheTTL:    envDuration("CACHE_TTL", 0),
+		cachePeers:  envList("CACHE_PEERS"),
+		cacheSelf:   os.Getenv("CACHE_SELF"),
+		changeFeed:  envBool("CHANGE_FEED", false),
+		storeMode:   envOneOf("STORE_MODE", storeModeCRUD, storeModeCRUD, storeModeEvents),
+
+		slowQueryThreshold: envDuration("SLOW_QUERY_THRESHOLD", 500*time.Millisecond),
+
+		requireAPIKey: envBool("REQUIRE_API_KEY", false),
+
+		jwtSecret:       os.Getenv("JWT_SECRET"),
+		accessTokenTTL:  envDuration("ACCESS_TOKEN_TTL", 15*time.Minute),
+		refreshTokenTTL: envDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+
+		staticDir:    os.Getenv("STATIC_DIR"),
+		staticMaxAge: envDuration("STATIC_MAX_AGE", time.Hour),
+		ui:           envBool("UI_ENABLED", false),
+
+		searchBackend:      envOneOf("SEARCH_BACKEND", searchBackendPostgres, searchBackendPostgres, searchBackendElastic),
+		elasticsearchURL:   os.Getenv("ELASTICSEARCH_URL"),
+		elasticsearchIndex: envString("ELASTICSEARCH_INDEX", "persons"),
+
+		geocoder:         envOneOf("GEOCODER", "", geocoderNominatim, geocoderGoogle),
+		geocoderURL:      os.Getenv("GEOCODER_URL"),
+		geocoderAPIKey:   os.Getenv("GEOCODER_API_KEY"),
+		geocoderInterval: envDuration("GEOCODER_INTERVAL", time.Second),
+		geocoderCacheTTL: envDuration("GEOCODER_CACHE_TTL", 24*time.Hour),
+
+		addressValidatorURL:       os.Getenv("ADDRESS_VALIDATOR_URL"),
+		addressValidationCacheTTL: envDuration("ADDRESS_VALIDATION_CACHE_TTL", 24*time.Hour),
+
+		phoneRegion: strings.ToUpper(os.Getenv("PHONE_DEFAULT_REGION")),
+
+		deletePolicies: envDeletePolicies("DELETE_POLICIES"),
+
+		validateRequests:  envBool("VALIDATE_REQUESTS", false),
+		validateResponses: envBool("VALIDATE_RESPONSES", false),
+
+		logLevel:          envLogLevel("LOG_LEVEL", slog.LevelInfo),
+		adminToken:        os.Getenv("ADMIN_TOKEN"),
+		adminTOTPRequired: envBool("ADMIN_TOTP_REQUIRED", false),
+
+		healthCheckTimeout: envDuration("HEALTH_CHECK_TIMEOUT", time.Second),
+
+		sentryDSN:         os.Getenv("SENTRY_DSN"),
+		sentryEnvironment: envString("SENTRY_ENVIRONMENT", environment),
+
+		environment: environment,
+		debug:       envDebug("DEBUG", environment),
+
+		accessLog:       os.Getenv("ACCESS_LOG"),
+		accessLogFormat: envOneOf("ACCESS_LOG_FORMAT", accessLogCombined, accessLogCommon, accessLogCombined),
+
+		auditRetention:   envDuration("AUDIT_RETENTION", 365*24*time.Hour),
+		historyRetention: envDuration("HISTORY_RETENTION", 0),
+		anonymizeAfter:   envDuration("ANONYMIZE_AFTER", 0),
+
+		jobWorkers: envInt("JOB_WORKERS", 2),
+
+		metricsExport: envOneOf("METRICS_EXPORT", metricsPrometheus, metricsPrometheus, metricsStatsD, metricsBoth),
+		statsdAddr:    envString("STATSD_ADDR", "127.0.0.1:8125"),
+		statsdPrefix:  os.Getenv("STATSD_PREFIX"),
+		statsdTags:    envBool("STATSD_DOGSTATSD", false),
+
+		storageDriver: envOneOf("STORAGE_DRIVER", "", storageLocal, storageS3),
+		storageDir:    envString("STORAGE_DIR", "data/attachments"),
+		s3: blob.S3Config{
+			Endpoint:       os.Getenv("S3_ENDPOINT"),
+			Region:         envString("S3_REGION", "us-east-1"),
+			Bucket:         os.Getenv("S3_BUCKET"),
+			AccessKey:      os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretKey:      os.Getenv("S3_SECRET_ACCESS_KEY"),
+			Prefix:         os.Getenv("S3_PREFIX"),
+			PathStyle:      envBool("S3_PATH_STYLE", false),
+			PublicEndpoint: os.Getenv("S3_PUBLIC_ENDPOINT"),
+		},
+		attachmentMaxSize: int64(envInt("ATTACHMENT_MAX_SIZE", 25<<20)),
+		presignTTL:        envDuration("PRESIGN_TTL", 15*time.Minute),
+		presignedMaxSize:  int64(envInt("ATTACHMENT_PRESIGNED_MAX_SIZE", 5<<30)),
+		orphanGracePeriod: envDuration("ORPHAN_GRACE_PERIOD", time.Hour),
+
+		integrityCheckInterval: envDuration("INTEGRITY_CHECK_INTERVAL", 0),
+
+		malwareScanner: envOneOf("MALWARE_SCANNER", "", scannerClamAV),
+		clamavAddr:     envString("CLAMAV_ADDR", "127.0.0.1:3310"),
+		clamavTimeout:  envDuration("CLAMAV_TIMEOUT", 2*time.Minute),
+
+		photoVariants:    envPhotoVariants("PHOTO_VARIANTS", "thumbnail=128,medium=512"),
+		photoMaxSize:     int64(envInt("PHOTO_MAX_SIZE", 10<<20)),
+		photoCacheMaxAge: envDuration("PHOTO_CACHE_MAX_AGE", 365*24*time.Hour),
+
+		imageMaxDimension: envInt("IMAGE_MAX_DIMENSION", 12000),
+
+		serviceRegistry: envOneOf("SERVICE_REGISTRY", "", registryConsul),
+		consulAddr:      envString("CONSUL_ADDR", "http://127.0.0.1:8500"),
+		consulToken:     os.Getenv("CONSUL_TOKEN"),
+		service:         envService(envString("PORT", "8080")),
+		shutdownTimeout: envDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+
+		shadowURL:        os.Getenv("SHADOW_URL"),
+		shadowSampleRate: envFloat("SHADOW_SAMPLE_RATE", 0.01),
+
+		page: pageLimits{
+			defaultSize: envInt("PAGE_SIZE_DEFAULT", 50),
+			maxSize:     envInt("PAGE_SIZE_MAX", 1000),
+		},
+	}
+}
+
+// envDebug reads a boolean that stays false in production whatever it is set
+// to, so that debug output cannot leak from a production deployment.
+func envDebug(key, environment string) bool {
+	if !envBool(key, false) {
+		return false
+	}
+	if environment == environmentProduction {
+		slog.Warn("debug mode is not available in production, set APP_ENV to enable it", "key", key)
+		return false
+	}
+	return true
+}
+
+func envString(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envList reads a comma separated list.
+func envList(key string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// envService describes the instance listening on port for a service
+// registry. Its address defaults to the host name, which must then resolve
+// for the registry and clients.
+func envService(port string) discovery.Service {
+	hostname, _ := os.Hostname()
+	address := envString("SERVICE_ADDRESS", hostname)
+	name := envString("SERVICE_NAME", "persons")
+	p, _ := strconv.Atoi(port)
+	return discovery.Service{
+		ID:              envString("SERVICE_ID", name+"-"+address+"-"+port),
+		Name:            name,
+		Address:         address,
+		Port:            p,
+		Tags:            envList("SERVICE_TAGS"),
+		Check:           "http://" + net.JoinHostPort(address, port) + "/readyz",
+		CheckInterval:   envDuration("SERVICE_CHECK_INTERVAL", 10*time.Second),
+		DeregisterAfter: envDuration("SERVICE_DEREGISTER_AFTER", time.Minute),
+	}
+}
+
+func envOneOf(key, def string, allowed ...string) string {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	if !slices.Contains(allowed, v) {
+		slog.Warn("invalid environment variable, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return v
+}
+
+func envDeletePolicies(key string) map[string]store.DeletePolicy {
+	policies, err := store.ParseDeletePolicies(os.Getenv(key))
+	if err != nil {
+		slog.Warn("invalid environment variable, using default policies", "key", key, "err", err)
+		return nil
+	}
+	return policies
+}
+
+func envPhotoVariants(key, def string) []imaging.Variant {
+	variants, err := imaging.ParseVariants(envString(key, def), photoOriginal)
+	if err != nil {
+		slog.Warn("invalid environment variable, using default", "key", key, "err", err, "default", def)
+		variants, _ = imaging.ParseVariants(def, photoOriginal)
+	}
+	return variants
+}
+
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		slog.Warn("invalid environment variable, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return n
+}
+
+func envFloat(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		slog.Warn("invalid environment variable, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return f
+}
+
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		slog.Warn("invalid environment variable, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return b
+}
+
+func envLogLevel(key string, def slog.Level) slog.Level {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(v)); err != nil {
+		slog.Warn("invalid environment variable, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return level
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		slog.Warn("invalid environment variable, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return d
+}
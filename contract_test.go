@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+)
+
+const specPath = "openapi.yaml"
+
+var (
+	specOnce   sync.Once
+	specRouter routers.Router
+	specErr    error
+)
+
+func loadSpecRouter(t testing.TB) routers.Router {
+	t.Helper()
+	specOnce.Do(func() { specRouter, specErr = loadSpec() })
+	if specErr != nil {
+		t.Fatalf("Failed to load %s: %v", specPath, specErr)
+	}
+	return specRouter
+}
+
+// contractChecker validates every response of the wrapped handler against the
+// OpenAPI document, so tests fail as soon as the spec and the code disagree.
+type contractChecker struct {
+	t      testing.TB
+	router routers.Router
+	next   http.Handler
+}
+
+func withContractCheck(t testing.TB, next http.Handler) http.Handler {
+	return &contractChecker{t: t, router: loadSpecRouter(t), next: next}
+}
+
+func (c *contractChecker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rr := httptest.NewRecorder()
+	c.next.ServeHTTP(rr, r)
+	c.check(r, rr)
+
+	for k, v := range rr.Header() {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rr.Code)
+	w.Write(rr.Body.Bytes())
+}
+
+func (c *contractChecker) check(r *http.Request, rr *httptest.ResponseRecorder) {
+	c.t.Helper()
+	if !strings.HasPrefix(r.URL.Path, "/api/") {
+		return
+	}
+	route, pathParams, err := c.router.FindRoute(r)
+	if err != nil {
+		if rr.Code < 400 {
+			c.t.Errorf("%s %s answered %d but is not described in %s", r.Method, r.URL.Path, rr.Code, specPath)
+		}
+		return
+	}
+	input := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: pathParams,
+			Route:      route,
+		},
+		Status: rr.Code,
+		Header: rr.Header(),
+		Body:   io.NopCloser(bytes.NewReader(rr.Body.Bytes())),
+		Options: &openapi3filter.Options{
+			IncludeResponseStatus: true,
+		},
+	}
+	if err := openapi3filter.ValidateResponse(context.Background(), input); err != nil {
+		c.t.Errorf("%s %s response violates %s: %v\nbody: %s", r.Method, r.URL.Path, specPath, err, rr.Body.String())
+	}
+}
+
+func TestContractChecker_DetectsDrift(t *testing.T) {
+	drifting := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"one","name":"Ann"}`))
+	})
+	recorder := &testRecorder{TB: t}
+	h := withContractCheck(recorder, drifting)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/persons/1", nil))
+
+	if !recorder.failed {
+		t.Error("Expected a response with a string id to violate the spec")
+	}
+}
+
+// testRecorder captures failures instead of failing the enclosing test.
+type testRecorder struct {
+	testing.TB
+	failed bool
+}
+
+func (r *testRecorder) Errorf(format string, args ...any) { r.failed = true }
+func (r *testRecorder) Helper()                           {}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+var customFieldTypes = []string{store.FieldString, store.FieldInteger, store.FieldNumber, store.FieldBoolean, store.FieldDate, store.FieldEnum}
+
+var customFieldName = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+// builtinFields are the fields of PersonResponse, which custom fields may not
+// be named after so clients can't mistake one for the other.
+var builtinFields = []string{"id", "name", "age", "address", "work", "updated_at", "latitude", "longitude", "phone", "phone_region", "email", "custom"}
+
+type CustomFieldRequest struct {
+	Name        string                 `json:"name"`
+	Type        string                 `json:"type"`
+	Required    bool                   `json:"required"`
+	Constraints store.FieldConstraints `json:"constraints"`
+}
+
+type CustomFieldResponse struct {
+	Name        string                 `json:"name"`
+	Type        string                 `json:"type"`
+	Required    bool                   `json:"required"`
+	Constraints store.FieldConstraints `json:"constraints"`
+	CreatedAt   time.Time              `json:"created_at"`
+}
+
+func toCustomFieldResponse(f store.CustomField) CustomFieldResponse {
+	return CustomFieldResponse{Name: f.Name, Type: f.Type, Required: f.Required, Constraints: f.Constraints, CreatedAt: f.CreatedAt.UTC()}
+}
+
+// validateCustomField checks that a definition can be applied to values:
+// every constraint must make sense for the type and be satisfiable.
+func validateCustomField(req CustomFieldRequest) []apierr.FieldError {
+	var errs []apierr.FieldError
+	if !customFieldName.MatchString(req.Name) {
+		errs = append(errs, apierr.NewFieldError("name", apierr.KeyRejected, map[string]any{"reason": "must be lowercase letters, digits and underscores, starting with a letter"}))
+	} else if slices.Contains(builtinFields, req.Name) {
+		errs = append(errs, apierr.NewFieldError("name", apierr.KeyRejected, map[string]any{"reason": "is a built-in field"}))
+	}
+	if !slices.Contains(customFieldTypes, req.Type) {
+		return append(errs, apierr.NewFieldError("type", apierr.KeyOneOf, map[string]any{"allowed": customFieldTypes, "actual": req.Type}))
+	}
+
+	c := req.Constraints
+	notFor := func(field string) {
+		errs = append(errs, apierr.NewFieldError("constraints."+field, apierr.KeyRejected, map[string]any{"reason": "does not apply to " + req.Type + " fields"}))
+	}
+	if req.Type == store.FieldString {
+		if c.MinLength != nil && *c.MinLength < 0 {
+			errs = append(errs, apierr.NewFieldError("constraints.min_length", apierr.KeyMinValue, map[string]any{"limit": 0, "actual": *c.MinLength}))
+		}
+		if c.MaxLength != nil && (*c.MaxLength < 1 || *c.MaxLength > maxTextLength) {
+			errs = append(errs, apierr.NewFieldError("constraints.max_length", apierr.KeyRejected, map[string]any{"reason": "must be between 1 and 1024"}))
+		}
+		if c.MinLength != nil && c.MaxLength != nil && *c.MinLength > *c.MaxLength {
+			errs = append(errs, apierr.NewFieldError("constraints.min_length", apierr.KeyRejected, map[string]any{"reason": "is greater than max_length"}))
+		}
+		if _, err := regexp.Compile(c.Pattern); err != nil {
+			errs = append(errs, apierr.NewFieldError("constraints.pattern", apierr.KeyRejected, map[string]any{"reason": err.Error()}))
+		}
+	} else {
+		if c.MinLength != nil {
+			notFor("min_length")
+		}
+		if c.MaxLength != nil {
+			notFor("max_length")
+		}
+		if c.Pattern != "" {
+			notFor("pattern")
+		}
+	}
+	if req.Type == store.FieldInteger || req.Type == store.FieldNumber {
+		if c.Min != nil && c.Max != nil && *c.Min > *c.Max {
+			errs = append(errs, apierr.NewFieldError("constraints.min", apierr.KeyRejected, map[string]any{"reason": "is greater than max"}))
+		}
+	} else {
+		if c.Min != nil {
+			notFor("min")
+		}
+		if c.Max != nil {
+			notFor("max")
+		}
+	}
+	if req.Type == store.FieldEnum {
+		if len(c.Values) == 0 {
+			errs = append(errs, apierr.NewFieldError("constraints.values", apierr.KeyRequired, nil))
+		}
+	} else if c.Values != nil {
+		notFor("values")
+	}
+	return errs
+}
+
+// validateCustom checks the custom field values of a person request against
+// fields. A full write (partial == false) needs every required field; a
+// PATCH removes values set to null, which required fields can't have.
+func validateCustom(custom map[string]any, fields []store.CustomField, partial bool) []apierr.FieldError {
+	var errs []apierr.FieldError
+	byName := make(map[string]store.CustomField, len(fields))
+	for _, f := range fields {
+		byName[f.Name] = f
+		if v, ok := custom[f.Name]; f.Required && v == nil && (!partial || ok) {
+			errs = append(errs, apierr.NewFieldError("custom."+f.Name, apierr.KeyRequired, nil))
+		}
+	}
+	names := make([]string, 0, len(custom))
+	for name := range custom {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f, ok := byName[name]
+		if !ok {
+			errs = append(errs, apierr.NewFieldError("custom."+name, apierr.KeyRejected, map[string]any{"reason": "no such custom field"}))
+			continue
+		}
+		if custom[name] != nil {
+			errs = appendCustomValue(errs, f, custom[name])
+		}
+	}
+	return errs
+}
+
+// appendCustomValue checks a value decoded from JSON against f.
+func appendCustomValue(errs []apierr.FieldError, f store.CustomField, value any) []apierr.FieldError {
+	field, c := "custom."+f.Name, f.Constraints
+	wrongType := func() []apierr.FieldError {
+		return append(errs, apierr.NewFieldError(field, apierr.KeyType, map[string]any{"type": f.Type, "actual": value}))
+	}
+	switch f.Type {
+	case store.FieldString:
+		s, ok := value.(string)
+		if !ok {
+			return wrongType()
+		}
+		n := utf8.RuneCountInString(s)
+		if c.MinLength != nil && n < *c.MinLength {
+			errs = append(errs, apierr.NewFieldError(field, apierr.KeyMinLength, map[string]any{"limit": *c.MinLength, "actual": n}))
+		}
+		errs = appendMaxLength(errs, field, &s, intOr(c.MaxLength, maxTextLength))
+		if c.Pattern != "" {
+			if re, err := customPattern(c.Pattern); err != nil || !re.MatchString(s) {
+				errs = append(errs, apierr.NewFieldError(field, apierr.KeyPattern, map[string]any{"pattern": c.Pattern, "actual": s}))
+			}
+		}
+	case store.FieldInteger, store.FieldNumber:
+		n, ok := value.(float64)
+		if !ok {
+			return append(errs, apierr.NewFieldError(field, apierr.KeyNotNumber, map[string]any{"actual": value}))
+		}
+		if f.Type == store.FieldInteger && n != math.Trunc(n) {
+			return append(errs, apierr.NewFieldError(field, apierr.KeyNotInteger, map[string]any{"actual": value}))
+		}
+		if c.Min != nil && n < *c.Min {
+			errs = append(errs, apierr.NewFieldError(field, apierr.KeyMinValue, map[string]any{"limit": *c.Min, "actual": n}))
+		}
+		if c.Max != nil && n > *c.Max {
+			errs = append(errs, apierr.NewFieldError(field, apierr.KeyMaxValue, map[string]any{"limit": *c.Max, "actual": n}))
+		}
+	case store.FieldBoolean:
+		if _, ok := value.(bool); !ok {
+			return wrongType()
+		}
+	case store.FieldDate:
+		s, ok := value.(string)
+		if !ok {
+			return wrongType()
+		}
+		if _, err := time.Parse(time.DateOnly, s); err != nil {
+			return wrongType()
+		}
+	case store.FieldEnum:
+		if s, ok := value.(string); !ok || !slices.Contains(c.Values, s) {
+			return append(errs, apierr.NewFieldError(field, apierr.KeyOneOf, map[string]any{"allowed": c.Values, "actual": value}))
+		}
+	}
+	return errs
+}
+
+// customPatterns holds the compiled patterns of custom fields by their
+// source, so that each is compiled once rather than for every value.
+var customPatterns sync.Map
+
+// customPattern compiles pattern, which matches anywhere in a value unless
+// anchored, as JSON Schema patterns do.
+func customPattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := customPatterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	customPatterns.Store(pattern, re)
+	return re, nil
+}
+
+func intOr(v *int, fallback int) int {
+	if v == nil {
+		return fallback
+	}
+	return *v
+}
+
+// checkCustom adds the failures of validateCustom to errs. The definitions
+// are only loaded when there is something to check against them.
+func (app *application) checkCustom(ctx context.Context, custom map[string]any, partial bool, errs []apierr.FieldError) ([]apierr.FieldError, error) {
+	if custom == nil && (partial || app.customFields == nil) {
+		return errs, nil
+	}
+	var fields []store.CustomField
+	if app.customFields != nil {
+		var err error
+		if fields, err = app.customFields.CustomFields(ctx); err != nil {
+			return errs, err
+		}
+	}
+	return append(errs, validateCustom(custom, fields, partial)...), nil
+}
+
+// customValues drops the nulls of a full write, which mean the same as
+// leaving the field out.
+func customValues(custom map[string]any) map[string]any {
+	if custom == nil {
+		return nil
+	}
+	values := make(map[string]any, len(custom))
+	for name, v := range custom {
+		if v != nil {
+			values[name] = v
+		}
+	}
+	return values
+}
+
+func (app *application) defineCustomField(w http.ResponseWriter, r *http.Request) {
+	var req CustomFieldRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendValidationError(w, apierr.InvalidJSON, "Invalid json", []apierr.FieldError{
+			apierr.NewFieldError("body", apierr.KeyInvalidJSON, nil),
+		})
+		return
+	}
+	if errs := validateCustomField(req); len(errs) > 0 {
+		sendValidationError(w, apierr.ValidationFailed, "custom field validation error", errs)
+		return
+	}
+	f, err := app.customFields.DefineCustomField(r.Context(), store.CustomField{Name: req.Name, Type: req.Type, Required: req.Required, Constraints: req.Constraints})
+	if errors.Is(err, store.ErrConflict) {
+		sendError(w, apierr.Conflict, "A custom field with this name exists")
+		return
+	}
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "custom field defined", "name", f.Name, "type", f.Type)
+	w.Header().Set("Location", "/api/v1/schema/fields/"+f.Name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toCustomFieldResponse(f))
+}
+
+func (app *application) listCustomFields(w http.ResponseWriter, r *http.Request) {
+	fields, err := app.customFields.CustomFields(r.Context())
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	resp := make([]CustomFieldResponse, 0, len(fields))
+	for _, f := range fields {
+		resp = append(resp, toCustomFieldResponse(f))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// deleteCustomField forgets a definition. Values persons already have are
+// kept and returned, but can no longer be written.
+func (app *application) deleteCustomField(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	err := app.customFields.DeleteCustomField(r.Context(), name)
+	if errors.Is(err, store.ErrNotFound) {
+		sendError(w, apierr.FieldNotFound, "No custom field with this name")
+		return
+	}
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "custom field deleted", "name", name)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestCustomFieldDefinitions(t *testing.T) {
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	app.customFields = testutil.NewMemoryFieldStore()
+	router := withContractCheck(t, app.routes())
+
+	rr := testutil.Do(router, "POST", "/api/v1/schema/fields", map[string]any{
+		"name": "department", "type": "enum", "required": true, "constraints": map[string]any{"values": []string{"sales", "it"}},
+	})
+	var f CustomFieldResponse
+	if err := json.NewDecoder(rr.Body).Decode(&f); err != nil || rr.Code != http.StatusCreated || f.Name != "department" || len(f.Constraints.Values) != 2 {
+		t.Fatalf("Expected the field defined, got %d %+v %v", rr.Code, f, err)
+	}
+	if loc := rr.Header().Get("Location"); loc != "/api/v1/schema/fields/department" {
+		t.Errorf("Expected the field's URL in Location, got %q", loc)
+	}
+	rr = testutil.Do(router, "POST", "/api/v1/schema/fields", map[string]any{"name": "department", "type": "string"})
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a taken name, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	invalid := []struct {
+		name  string
+		body  map[string]any
+		field string
+	}{
+		{"Bad name", map[string]any{"name": "Dept", "type": "string"}, "name"},
+		{"Built-in name", map[string]any{"name": "email", "type": "string"}, "name"},
+		{"Unknown type", map[string]any{"name": "x", "type": "money"}, "type"},
+		{"Enum without values", map[string]any{"name": "x", "type": "enum"}, "constraints.values"},
+		{"Length of a number", map[string]any{"name": "x", "type": "number", "constraints": map[string]any{"max_length": 3}}, "constraints.max_length"},
+		{"Bad pattern", map[string]any{"name": "x", "type": "string", "constraints": map[string]any{"pattern": "("}}, "constraints.pattern"},
+		{"Min above max", map[string]any{"name": "x", "type": "integer", "constraints": map[string]any{"min": 5, "max": 1}}, "constraints.min"},
+	}
+	for _, tc := range invalid {
+		rr := testutil.Do(router, "POST", "/api/v1/schema/fields", tc.body)
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), `"field":"`+tc.field+`"`) {
+			t.Errorf("%s: expected 400 on %s, got %d: %s", tc.name, tc.field, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr = testutil.Do(router, "GET", "/api/v1/schema/fields", nil)
+	var list []CustomFieldResponse
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil || len(list) != 1 {
+		t.Errorf("Expected one field, got %d %+v %v", rr.Code, list, err)
+	}
+	rr = testutil.Do(router, "DELETE", "/api/v1/schema/fields/department", nil)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", rr.Code)
+	}
+	rr = testutil.Do(router, "DELETE", "/api/v1/schema/fields/department", nil)
+	if rr.Code != http.StatusNotFound || decodeErrorCode(t, rr) != apierr.FieldNotFound {
+		t.Errorf("Expected FIELD_NOT_FOUND, got %d", rr.Code)
+	}
+}
+
+func TestCustomFieldValues(t *testing.T) {
+	st := testutil.NewMemoryStore()
+	app := newTestAppWithStore(st)
+	app.customFields = testutil.NewMemoryFieldStore(
+		store.CustomField{Name: "badge", Type: store.FieldInteger, Required: true, Constraints: store.FieldConstraints{Min: float64Ptr(1)}},
+		store.CustomField{Name: "code", Type: store.FieldString, Constraints: store.FieldConstraints{Pattern: `^[A-Z]{3}$`}},
+		store.CustomField{Name: "hired", Type: store.FieldDate},
+	)
+	router := withContractCheck(t, app.routes())
+
+	rr := testutil.Do(router, "POST", "/api/v1/persons", PersonRequest{Name: stringPtr("Ann")})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), `"field":"custom.badge"`) {
+		t.Errorf("Expected the required field missing, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = testutil.Do(router, "POST", "/api/v1/persons", PersonRequest{Name: stringPtr("Ann"), Custom: map[string]any{
+		"badge": 1.5, "code": "abc", "hired": "31.05.2024", "shoe_size": 40,
+	}})
+	body := rr.Body.String()
+	for _, want := range []string{apierr.KeyNotInteger, apierr.KeyPattern, apierr.KeyType, `"field":"custom.shoe_size"`} {
+		if rr.Code != http.StatusBadRequest || !strings.Contains(body, want) {
+			t.Errorf("Expected %s to be rejected, got %d: %s", want, rr.Code, body)
+		}
+	}
+
+	rr = testutil.Do(router, "POST", "/api/v1/persons", PersonRequest{Name: stringPtr("Ann"), Custom: map[string]any{
+		"badge": 7, "code": "ABC", "hired": "2024-05-31",
+	}})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = testutil.Do(router, "PATCH", "/api/v1/persons/1", PersonRequest{Custom: map[string]any{"code": nil, "hired": "2024-06-01"}})
+	var got PersonResponse
+	json.NewDecoder(rr.Body).Decode(&got)
+	if rr.Code != http.StatusOK || len(got.Custom) != 2 || got.Custom["badge"] != 7.0 || got.Custom["hired"] != "2024-06-01" {
+		t.Errorf("Expected code removed and hired changed, got %d %+v", rr.Code, got.Custom)
+	}
+	rr = testutil.Do(router, "PATCH", "/api/v1/persons/1", PersonRequest{Custom: map[string]any{"badge": nil}})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a required field not to be removed, got %d", rr.Code)
+	}
+	rr = testutil.Do(router, "PATCH", "/api/v1/persons/1", PersonRequest{Name: stringPtr("Bea")})
+	if p, _ := st.GetPerson(context.Background(), 1); rr.Code != http.StatusOK || p.Custom["badge"] != 7.0 {
+		t.Errorf("Expected a patch without custom values to keep them, got %d %+v", rr.Code, p.Custom)
+	}
+
+	rr = testutil.Do(router, "PUT", "/api/v1/persons/1", PersonRequest{Name: stringPtr("Bea"), Custom: map[string]any{"badge": 8, "code": nil}})
+	if p, _ := st.GetPerson(context.Background(), 1); rr.Code != http.StatusOK || len(p.Custom) != 1 || p.Custom["badge"] != 8.0 {
+		t.Errorf("Expected the values replaced, got %d %+v", rr.Code, p.Custom)
+	}
+}
+
+func TestCustomPatternMatchesAnywhere(t *testing.T) {
+	f := store.CustomField{Name: "team", Type: store.FieldString, Constraints: store.FieldConstraints{Pattern: "ops"}}
+	if errs := appendCustomValue(nil, f, "devops team"); len(errs) != 0 {
+		t.Errorf("Expected an unanchored pattern to match inside the value, got %+v", errs)
+	}
+	if errs := appendCustomValue(nil, f, "sales"); len(errs) != 1 || errs[0].Key != apierr.KeyPattern {
+		t.Errorf("Expected a value without the pattern to be rejected, got %+v", errs)
+	}
+}
+
+func TestCustomValuesWithoutDefinitions(t *testing.T) {
+	router := setupTestRouterWithStore(t, testutil.NewMemoryStore())
+
+	rr := testutil.Do(router, "POST", "/api/v1/persons", PersonRequest{Name: stringPtr("Ann"), Custom: map[string]any{"badge": 7}})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected custom values to be rejected, got %d", rr.Code)
+	}
+	rr = testutil.Do(router, "POST", "/api/v1/persons", PersonRequest{Name: stringPtr("Ann")})
+	if rr.Code != http.StatusCreated {
+		t.Errorf("Expected 201, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+var dashboardTemplates = template.Must(template.ParseFS(templateFS, "templates/*.html"))
+
+// personForm holds the edit form as typed, so a rejected form is shown again
+// unchanged.
+type personForm struct {
+	Name    string
+	Age     string
+	Address string
+	Work    string
+}
+
+type personsPage struct {
+	Title      string
+	Query      string
+	Persons    []store.Person
+	PrevOffset int // -1 when there is no such page
+	NextOffset int
+	// TOTP asks for a code with each delete.
+	TOTP bool
+}
+
+type personPage struct {
+	Title  string
+	ID     int32
+	Form   personForm
+	Errors map[string]string
+}
+
+// messagePage explains why an action was refused.
+type messagePage struct {
+	Title   string
+	Message string
+}
+
+func renderPage(w http.ResponseWriter, r *http.Request, status int, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := dashboardTemplates.ExecuteTemplate(w, name, data); err != nil {
+		slog.ErrorContext(r.Context(), "failed to render admin page", "template", name, "err", err)
+	}
+}
+
+func renderError(w http.ResponseWriter, status int) {
+	http.Error(w, http.StatusText(status), status)
+}
+
+// sameOrigin rejects form posts from other sites. Browsers attach basic auth
+// credentials to those too, so without it any page could delete persons.
+func sameOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin" || site == "none"
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// requireSameOrigin applies sameOrigin ahead of guards that would otherwise
+// act on a forged post, such as requireTOTP using up the admin's code.
+func requireSameOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !sameOrigin(r) {
+			renderError(w, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (app *application) dashboardPersons(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	offset = max(offset, 0)
+	limit := app.cfg.page.defaultSize
+	list, err := app.store.ListPersons(r.Context(), store.ListFilter{
+		Name:   q,
+		Sort:   []store.SortKey{{Field: "id"}},
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "admin dashboard failed to list persons", "err", err)
+		renderError(w, http.StatusServiceUnavailable)
+		return
+	}
+	page := personsPage{Title: "Persons", Query: q, Persons: list, PrevOffset: -1, NextOffset: -1, TOTP: app.totp != nil}
+	if offset > 0 {
+		page.PrevOffset = max(offset-limit, 0)
+	}
+	if len(list) == limit {
+		page.NextOffset = offset + limit
+	}
+	renderPage(w, r, http.StatusOK, "persons.html", page)
+}
+
+func (app *application) dashboardPerson(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		renderError(w, http.StatusNotFound)
+		return
+	}
+	p, err := app.store.GetPerson(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		renderError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "admin dashboard failed to load person", "id", id, "err", err)
+		renderError(w, http.StatusServiceUnavailable)
+		return
+	}
+	renderPage(w, r, http.StatusOK, "person.html", personPage{Title: "Edit " + p.Name, ID: id, Form: formFromPerson(p)})
+}
+
+func formFromPerson(p store.Person) personForm {
+	form := personForm{Name: p.Name}
+	if p.Age != nil {
+		form.Age = strconv.Itoa(int(*p.Age))
+	}
+	if p.Address != nil {
+		form.Address = *p.Address
+	}
+	if p.Work != nil {
+		form.Work = *p.Work
+	}
+	return form
+}
+
+func readPersonForm(r *http.Request) personForm {
+	return personForm{
+		Name:    r.PostFormValue("name"),
+		Age:     strings.TrimSpace(r.PostFormValue("age")),
+		Address: r.PostFormValue("address"),
+		Work:    r.PostFormValue("work"),
+	}
+}
+
+// personFromForm turns the form into a full replacement of the person. Empty
+// optional fields clear them.
+func personFromForm(form personForm) (PersonRequest, map[string]string) {
+	errs := map[string]string{}
+	req := PersonRequest{Name: &form.Name}
+	if form.Age != "" {
+		age, err := strconv.ParseInt(form.Age, 10, 32)
+		if err != nil {
+			errs["age"] = "age must be a whole number"
+		}
+		req.Age = new(int32)
+		*req.Age = int32(age)
+	}
+	if form.Address != "" {
+		req.Address = &form.Address
+	}
+	if form.Work != "" {
+		req.Work = &form.Work
+	}
+	for _, e := range validatePersonRequest(req, false) {
+		if _, ok := errs[e.Field]; !ok {
+			errs[e.Field] = e.Message
+		}
+	}
+	return req, errs
+}
+
+// checkPersonForm is personFromForm plus the custom fields and address
+// validation, which is only worth asking for once everything else is right.
+// The form has no custom fields, so creating a person fails when any is
+// required; edits keep the values the person has.
+func (app *application) checkPersonForm(ctx context.Context, form personForm, create bool) (PersonRequest, map[string]string) {
+	req, errs := personFromForm(form)
+	if len(errs) > 0 {
+		return req, errs
+	}
+	custom, err := app.checkCustom(ctx, nil, !create, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load custom fields", "err", err)
+		errs["form"] = "Saving failed, try again."
+		return req, errs
+	}
+	for _, e := range custom {
+		errs[e.Field] = e.Message
+	}
+	if len(errs) > 0 {
+		return req, errs
+	}
+	if v := app.unverifiedAddress(ctx, req); v != nil {
+		msg := "Address could not be verified."
+		if len(v.Suggestions) > 0 {
+			msg += " Did you mean " + v.Suggestions[0].Address + "?"
+		}
+		errs["address"] = msg
+	}
+	return req, errs
+}
+
+func (app *application) dashboardSavePerson(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		renderError(w, http.StatusNotFound)
+		return
+	}
+	if !sameOrigin(r) {
+		renderError(w, http.StatusForbidden)
+		return
+	}
+	form := readPersonForm(r)
+	page := personPage{Title: "Edit person", ID: id, Form: form}
+	req, errs := app.checkPersonForm(r.Context(), form, false)
+	if len(errs) > 0 {
+		page.Errors = errs
+		renderPage(w, r, http.StatusBadRequest, "person.html", page)
+		return
+	}
+	// The form always carries the address, so only a changed one is geocoded.
+	var moved bool
+	_, err = app.store.ModifyPerson(r.Context(), id, func(p *store.Person) error {
+		moved = !equalPtr(p.Address, req.Address)
+		p.Name, p.Age, p.Address, p.Work = *req.Name, req.Age, req.Address, req.Work
+		return nil
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		renderError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "admin dashboard failed to save person", "id", id, "err", err)
+		page.Errors = map[string]string{"form": "Saving failed, try again."}
+		renderPage(w, r, http.StatusServiceUnavailable, "person.html", page)
+		return
+	}
+	slog.InfoContext(r.Context(), "person edited from admin dashboard", "id", id)
+	if moved {
+		app.geocodeAddress(id, req)
+	}
+	http.Redirect(w, r, "/admin/ui", http.StatusSeeOther)
+}
+
+func (app *application) dashboardDeletePerson(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		renderError(w, http.StatusNotFound)
+		return
+	}
+	err = app.store.DeletePerson(r.Context(), id)
+	var derr *store.DependentsError
+	switch {
+	case err == nil || errors.Is(err, store.ErrNotFound):
+	case errors.As(err, &derr):
+		renderPage(w, r, http.StatusConflict, "message.html", messagePage{Title: "Not deleted", Message: fmt.Sprintf("Person still has %d %s.", derr.Count, derr.Relation)})
+		return
+	case errors.Is(err, store.ErrConflict) || errors.Is(err, store.ErrConstraint):
+		renderPage(w, r, http.StatusConflict, "message.html", messagePage{Title: "Not deleted", Message: "Person conflicts with existing data."})
+		return
+	default:
+		slog.ErrorContext(r.Context(), "admin dashboard failed to delete person", "id", id, "err", err)
+		renderError(w, http.StatusServiceUnavailable)
+		return
+	}
+	slog.InfoContext(r.Context(), "person deleted from admin dashboard", "id", id)
+	// Back to the same search and page, but never off the dashboard.
+	target := "/admin/ui"
+	if ref, err := url.Parse(r.Referer()); err == nil && ref.Path == target {
+		target = ref.RequestURI()
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+
+	"github.com/pquerna/otp/totp"
+)
+
+func TestDashboard(t *testing.T) {
+	ctx := context.Background()
+	st := testutil.NewMemoryStore()
+	st.CreatePerson(ctx, store.Person{Name: "Ann", Age: testutil.Ptr[int32](30)})
+	st.CreatePerson(ctx, store.Person{Name: "Bob <script>"})
+	app := newTestAppWithStore(st)
+	app.cfg.adminToken = "s3cret"
+	router := app.routes()
+
+	do := func(method, target string, form url.Values, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		if form != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		req.SetBasicAuth("admin", "s3cret")
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	req := httptest.NewRequest("GET", "/admin/ui", nil)
+	req.SetBasicAuth("admin", "guess")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized || !strings.Contains(strings.Join(rr.Header().Values("WWW-Authenticate"), ","), "Basic") {
+		t.Fatalf("Expected a basic auth challenge for a wrong password, got %d %v", rr.Code, rr.Header())
+	}
+
+	rr = do("GET", "/admin/ui?q=bob", nil, nil)
+	body := rr.Body.String()
+	if rr.Code != http.StatusOK || strings.Contains(body, "Ann") || !strings.Contains(body, "Bob &lt;script&gt;") {
+		t.Fatalf("Expected only Bob, escaped, got %d: %s", rr.Code, body)
+	}
+
+	rr = do("GET", "/admin/ui/persons/1", nil, nil)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `value="30"`) {
+		t.Fatalf("Expected the edit form filled in, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := do("GET", "/admin/ui/persons/99", nil, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown person, got %d", rr.Code)
+	}
+
+	rr = do("POST", "/admin/ui/persons/1", url.Values{"name": {"Ann"}, "age": {"200"}}, nil)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "class=\"error\"") {
+		t.Errorf("Expected the form back with an error, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = do("POST", "/admin/ui/persons/1", url.Values{"name": {"Anna"}, "work": {"Lab"}}, nil)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("Expected a redirect after saving, got %d: %s", rr.Code, rr.Body.String())
+	}
+	p, _ := st.GetPerson(ctx, 1)
+	if p.Name != "Anna" || p.Age != nil || p.Work == nil || *p.Work != "Lab" {
+		t.Errorf("Expected the person replaced by the form, got %+v", p)
+	}
+
+	crossSite := http.Header{"Origin": {"https://evil.example"}}
+	if rr := do("POST", "/admin/ui/persons/2/delete", url.Values{}, crossSite); rr.Code != http.StatusForbidden {
+		t.Errorf("Expected cross-site posts to be refused, got %d", rr.Code)
+	}
+	rr = do("POST", "/admin/ui/persons/2/delete", url.Values{}, http.Header{"Referer": {"http://example.com/admin/ui?q=bob"}})
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/ui?q=bob" {
+		t.Errorf("Expected a redirect back to the search, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if _, err := st.GetPerson(ctx, 2); err == nil {
+		t.Error("Expected the person to be deleted")
+	}
+}
+
+func TestDashboardDeleteNeedsTOTP(t *testing.T) {
+	// Keep the code below within one step of the clock for the whole test.
+	if into := time.Now().Unix() % totpPeriod; into > totpPeriod-3 {
+		time.Sleep(time.Duration(totpPeriod-into) * time.Second)
+	}
+	ctx := context.Background()
+	st := testutil.NewMemoryStore(store.Person{Name: "Ann"})
+	app := newTestAppWithStore(st)
+	app.cfg.adminToken = "s3cret"
+	totps := testutil.NewMemoryTOTPStore()
+	secret, _ := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: "admin"})
+	totps.EnrollAdminTOTP(ctx, secret.Secret())
+	totps.ConfirmAdminTOTP(ctx, time.Now().Unix()/totpPeriod-1)
+	app.totp = totps
+	router := app.routes()
+
+	del := func(form url.Values) int {
+		req := httptest.NewRequest("POST", "/admin/ui/persons/1/delete", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("admin", "s3cret")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	req := httptest.NewRequest("GET", "/admin/ui", nil)
+	req.SetBasicAuth("admin", "s3cret")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), `name="totp_code"`) {
+		t.Errorf("Expected a TOTP field in the delete form, got %s", rr.Body.String())
+	}
+	code := totpCode(t, secret.Secret(), time.Now())
+	req = httptest.NewRequest("POST", "/admin/ui/persons/1/delete", strings.NewReader(url.Values{"totp_code": {code}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://evil.example")
+	req.SetBasicAuth("admin", "s3cret")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("Expected a cross-site delete refused, got %d", rr.Code)
+	}
+	if code := del(url.Values{}); code != http.StatusUnauthorized {
+		t.Errorf("Expected a delete without a code refused, got %d", code)
+	}
+	if _, err := st.GetPerson(ctx, 1); err != nil {
+		t.Fatalf("Expected the person kept, got %v", err)
+	}
+	// The cross-site post must not have used up the code.
+	if code := del(url.Values{"totp_code": {code}}); code != http.StatusSeeOther {
+		t.Errorf("Expected a delete with a valid code, got %d", code)
+	}
+	if _, err := st.GetPerson(ctx, 1); err == nil {
+		t.Error("Expected the person to be deleted")
+	}
+}
+
+// restrictedStore refuses deletes the way a restrict delete policy does.
+type restrictedStore struct {
+	*testutil.MemoryStore
+}
+
+func (restrictedStore) DeletePerson(ctx context.Context, id int32) error {
+	return fmt.Errorf("delete person %d: %w", id, &store.DependentsError{Relation: "attachments", Count: 2})
+}
+
+func TestDashboardDeleteRestricted(t *testing.T) {
+	app := newTestAppWithStore(restrictedStore{testutil.NewMemoryStore(store.Person{Name: "Ann"})})
+	app.cfg.adminToken = "s3cret"
+	req := httptest.NewRequest("POST", "/admin/ui/persons/1/delete", nil)
+	req.SetBasicAuth("admin", "s3cret")
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusConflict || !strings.Contains(rr.Body.String(), "2 attachments") {
+		t.Errorf("Expected a conflict naming the attachments, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
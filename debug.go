@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// debugInfo collects, in debug mode, what a 5xx response reports about the
+// request beyond its error.
+type debugInfo struct {
+	mu    sync.Mutex
+	query string
+}
+
+func (d *debugInfo) setQuery(name string) {
+	d.mu.Lock()
+	d.query = name
+	d.mu.Unlock()
+}
+
+// withDebug makes the request collect debugInfo when debug mode is on.
+func (app *application) withDebug(next http.Handler) http.Handler {
+	if !app.cfg.debug {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), debugKey, &debugInfo{})))
+	})
+}
+
+// errorDebug describes err for the response to a request in debug mode, and
+// is nil otherwise. The stack hint names the caller of errorDebug, or with
+// skip the one that many frames further up.
+func errorDebug(ctx context.Context, err error, skip int) *ErrorDebug {
+	d, _ := ctx.Value(debugKey).(*debugInfo)
+	if d == nil || err == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return &ErrorDebug{Error: err.Error(), Query: d.query, Stack: callerHint(skip + 1)}
+}
+
+// callerHint names the caller of callerHint, or with skip the one that many
+// frames further up, with its file and line. Runtime frames are passed over
+// so that the hint for a panic is the function that panicked.
+func callerHint(skip int) string {
+	pc := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(skip+2, pc)])
+	for {
+		f, more := frames.Next()
+		if !strings.HasPrefix(f.Function, "runtime.") {
+			name := f.Function[strings.LastIndex(f.Function, "/")+1:]
+			return fmt.Sprintf("%s (%s:%d)", name, filepath.Base(f.File), f.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestDebugErrors(t *testing.T) {
+	st := testutil.NewMemoryStore()
+	st.Err = errors.New("sql: Scan error on column index 2")
+	app := newTestAppWithStore(st)
+
+	decode := func(rr *httptest.ResponseRecorder) ErrorResponse {
+		var body ErrorResponse
+		json.NewDecoder(rr.Body).Decode(&body)
+		return body
+	}
+	if body := decode(testutil.Do(app.routes(), "GET", "/api/v1/persons/1", nil)); body.Debug != nil {
+		t.Errorf("Expected no debug details outside debug mode, got %+v", body.Debug)
+	}
+
+	app.cfg.debug = true
+	router := withContractCheck(t, app.routes())
+	rr := testutil.Do(router, "GET", "/api/v1/persons/1", nil)
+	body := decode(rr)
+	if rr.Code != http.StatusInternalServerError || body.Debug == nil {
+		t.Fatalf("Expected a 500 with debug details, got %d %+v", rr.Code, body)
+	}
+	if !strings.Contains(body.Debug.Error, "Scan error") || !strings.Contains(body.Debug.Stack, "getPerson") {
+		t.Errorf("Expected the error and the handler, got %+v", body.Debug)
+	}
+
+	st.Err = nil
+	rr = testutil.Do(router, "GET", "/api/v1/persons/1", nil)
+	if body := decode(rr); rr.Code != http.StatusNotFound || body.Debug != nil {
+		t.Errorf("Expected no debug details on a 4xx, got %d %+v", rr.Code, body.Debug)
+	}
+}
+
+func TestDebugQueryAndPanic(t *testing.T) {
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	app.cfg.debug = true
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.metrics.timeQuery(r.Context(), "get_person")()
+		panicInHandler()
+	})
+	mws := middlewares(app.serverStages())
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/persons/1", nil))
+	var body ErrorResponse
+	json.NewDecoder(rr.Body).Decode(&body)
+	if body.Debug == nil || body.Debug.Query != "get_person" || !strings.Contains(body.Debug.Stack, "panicInHandler") {
+		t.Errorf("Expected the last query and the panicking function, got %+v", body.Debug)
+	}
+}
+
+func panicInHandler() { panic("boom") }
+
+func TestDebugNeverInProduction(t *testing.T) {
+	t.Setenv("DEBUG", "true")
+	if loadConfig().debug {
+		t.Error("Expected DEBUG to be ignored in production")
+	}
+	t.Setenv("APP_ENV", "development")
+	if !loadConfig().debug {
+		t.Error("Expected DEBUG to apply in development")
+	}
+}
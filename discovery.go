@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/discovery"
+)
+
+const registryTimeout = 5 * time.Second
+
+// registerService adds the instance to the service registry, if one is
+// configured, and returns what removes it again. An instance the registry
+// cannot be told about still serves; it is only not discovered.
+func (app *application) registerService(ctx context.Context) (deregister func()) {
+	if app.cfg.serviceRegistry != registryConsul {
+		return func() {}
+	}
+	consul := discovery.NewConsul(app.cfg.consulAddr, app.cfg.consulToken)
+	s := app.cfg.service
+	ctx, cancel := context.WithTimeout(ctx, registryTimeout)
+	defer cancel()
+	if err := consul.Register(ctx, s); err != nil {
+		slog.ErrorContext(ctx, "failed to register with consul", "addr", app.cfg.consulAddr, "err", err)
+		return func() {}
+	}
+	slog.InfoContext(ctx, "registered with consul", "service", s.Name, "id", s.ID, "check", s.Check)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), registryTimeout)
+		defer cancel()
+		if err := consul.Deregister(ctx, s.ID); err != nil {
+			slog.ErrorContext(ctx, "failed to deregister from consul", "id", s.ID, "err", err)
+			return
+		}
+		slog.InfoContext(ctx, "deregistered from consul", "id", s.ID)
+	}
+}
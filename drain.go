@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var errDraining = errors.New("draining")
+
+type DrainStatus struct {
+	Draining bool       `json:"draining"`
+	Since    *time.Time `json:"since,omitempty"`
+	InFlight int64      `json:"in_flight"`
+}
+
+// drainer counts the requests in flight and knows whether the server is
+// draining: still serving, but no longer ready, so that load balancers send
+// nothing new while what they sent finishes. Draining lasts until the
+// process stops.
+type drainer struct {
+	inFlight atomic.Int64
+	// onDrain runs once when draining starts.
+	onDrain func()
+
+	mu    sync.Mutex
+	since *time.Time
+}
+
+func (d *drainer) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.inFlight.Add(1)
+		defer d.inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// start starts draining, unless it already has.
+func (d *drainer) start() {
+	d.mu.Lock()
+	if d.since != nil {
+		d.mu.Unlock()
+		return
+	}
+	now := time.Now().UTC()
+	d.since = &now
+	d.mu.Unlock()
+	slog.Info("draining", "in_flight", d.inFlight.Load())
+	if d.onDrain != nil {
+		d.onDrain()
+	}
+}
+
+func (d *drainer) status() DrainStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return DrainStatus{Draining: d.since != nil, Since: d.since, InFlight: d.inFlight.Load()}
+}
+
+// othersStatus is the status for a request asking for it, which does not
+// count itself, so that it reads 0 once drained.
+func (d *drainer) othersStatus() DrainStatus {
+	s := d.status()
+	s.InFlight = max(s.InFlight-1, 0)
+	return s
+}
+
+// check fails readiness while draining.
+func (d *drainer) check(ctx context.Context) error {
+	if d.status().Draining {
+		return errDraining
+	}
+	return nil
+}
+
+// startDrain drains ahead of a planned restart; the process keeps serving
+// until it is stopped.
+func (app *application) startDrain(w http.ResponseWriter, r *http.Request) {
+	app.drain.start()
+	w.Header().Set("Location", "/admin/drain-status")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(app.drain.othersStatus())
+}
+
+func (app *application) getDrainStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(app.drain.othersStatus())
+}
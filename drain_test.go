@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestDrain(t *testing.T) {
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	app.cfg.adminToken = "s3cret"
+	drained := 0
+	app.drain.onDrain = func() { drained++ }
+	router := app.routes()
+	do := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	status := func() DrainStatus {
+		t.Helper()
+		var s DrainStatus
+		rr := do("GET", "/admin/drain-status")
+		if err := json.NewDecoder(rr.Body).Decode(&s); err != nil || rr.Code != http.StatusOK {
+			t.Fatalf("Expected the drain status, got %d %v", rr.Code, err)
+		}
+		return s
+	}
+
+	// A request being served while the status is asked for.
+	release, served := make(chan struct{}), make(chan struct{})
+	slow := app.drain.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served <- struct{}{}
+		<-release
+	}))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		slow.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}()
+	<-served
+	if s := status(); s.Draining || s.InFlight != 1 {
+		t.Errorf("Expected one request in flight and no draining, got %+v", s)
+	}
+	if rr := do("GET", "/readyz"); rr.Code != http.StatusOK {
+		t.Errorf("Expected to be ready before draining, got %d", rr.Code)
+	}
+
+	for range 2 {
+		if rr := do("POST", "/admin/drain"); rr.Code != http.StatusAccepted {
+			t.Fatalf("Expected 202, got %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+	if s := status(); !s.Draining || s.Since == nil {
+		t.Errorf("Expected draining, got %+v", s)
+	}
+	if drained != 1 {
+		t.Errorf("Expected draining to start once, it started %d times", drained)
+	}
+	if rr := do("GET", "/readyz"); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected not to be ready while draining, got %d", rr.Code)
+	}
+	if rr := do("GET", "/api/v1/persons"); rr.Code != http.StatusOK {
+		t.Errorf("Expected requests to be served while draining, got %d", rr.Code)
+	}
+
+	close(release)
+	<-done
+	if s := status(); s.InFlight != 0 {
+		t.Errorf("Expected nothing in flight once drained, got %+v", s)
+	}
+}
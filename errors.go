@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+func sendError(w http.ResponseWriter, code apierr.Code, message string) {
+	sendDebugError(w, code, message, nil)
+}
+
+// sendDebugError is sendError for 5xx responses, with what errorDebug found
+// about the error in debug mode.
+func sendDebugError(w http.ResponseWriter, code apierr.Code, message string, debug *ErrorDebug) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code.Status())
+	json.NewEncoder(w).Encode(ErrorResponse{Code: code, Message: message, TraceID: w.Header().Get(traceIDHeader), Debug: debug})
+}
+
+func sendProblem(w http.ResponseWriter, r *http.Request, code apierr.Code, detail string) {
+	statusCode := code.Status()
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(ProblemResponse{
+		Type:     "about:blank",
+		Title:    http.StatusText(statusCode),
+		Status:   statusCode,
+		Code:     code,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		TraceID:  w.Header().Get(traceIDHeader),
+	})
+}
+
+func sendValidationError(w http.ResponseWriter, code apierr.Code, message string, details []apierr.FieldError) {
+	errors := make(map[string]string, len(details))
+	for _, d := range details {
+		if _, ok := errors[d.Field]; !ok {
+			errors[d.Field] = d.Message
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code.Status())
+	json.NewEncoder(w).Encode(ValidationErrorResponse{
+		Code:    code,
+		Message: message,
+		Errors:  errors,
+		Details: details,
+		TraceID: w.Header().Get(traceIDHeader),
+	})
+}
+
+func sendConflict(w http.ResponseWriter, cerr *store.ConflictError) {
+	resp := ConflictErrorResponse{Code: apierr.Conflict, Field: cerr.Field, TraceID: w.Header().Get(traceIDHeader)}
+	if cerr.ExistingID != 0 {
+		resp.ExistingID = &cerr.ExistingID
+		resp.Message = fmt.Sprintf("%s is already used by person %d", cerr.Field, cerr.ExistingID)
+	} else {
+		resp.Message = fmt.Sprintf("%s is already used by another person", cerr.Field)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apierr.Conflict.Status())
+	json.NewEncoder(w).Encode(resp)
+}
+
+func sendDependents(w http.ResponseWriter, derr *store.DependentsError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apierr.Conflict.Status())
+	json.NewEncoder(w).Encode(ConflictErrorResponse{
+		Code:     apierr.Conflict,
+		Message:  fmt.Sprintf("Person still has %d %s", derr.Count, derr.Relation),
+		Relation: derr.Relation,
+		TraceID:  w.Header().Get(traceIDHeader),
+	})
+}
+
+// sendStoreError is the single place where errors coming out of the store are
+// turned into HTTP responses. Handlers should not inspect store errors themselves.
+func sendStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	var verr *store.ValidationError
+	var cerr *store.ConflictError
+	var derr *store.DependentsError
+	switch {
+	case errors.Is(err, store.ErrConstraint):
+		var details []apierr.FieldError
+		if errors.As(err, &verr) && verr.Field != "" {
+			details = append(details, apierr.NewFieldError(verr.Field, apierr.KeyRejected, map[string]any{"reason": verr.Message}))
+		}
+		sendValidationError(w, apierr.ConstraintViolation, "Person was rejected by the database", details)
+	case errors.As(err, &verr):
+		sendValidationError(w, apierr.ValidationFailed, "Validation failed", []apierr.FieldError{
+			apierr.NewFieldError(verr.Field, apierr.KeyRejected, map[string]any{"reason": verr.Message}),
+		})
+	case errors.Is(err, store.ErrValidation):
+		sendValidationError(w, apierr.ValidationFailed, "Validation failed", nil)
+	case errors.Is(err, store.ErrNotFound):
+		sendError(w, apierr.PersonNotFound, "Person not found")
+	case errors.As(err, &cerr):
+		sendConflict(w, cerr)
+	case errors.As(err, &derr):
+		sendDependents(w, derr)
+	case errors.Is(err, store.ErrConflict):
+		sendError(w, apierr.Conflict, "Person conflicts with existing data")
+	case errors.Is(err, store.ErrPreconditionFailed):
+		sendError(w, apierr.PreconditionFail, "Person was modified after If-Unmodified-Since")
+	case errors.Is(err, store.ErrLockTimeout):
+		w.Header().Set("Retry-After", "1")
+		sendError(w, apierr.LockTimeout, "Person is being modified by another request, retry later")
+	case errors.Is(err, store.ErrUnavailable), errors.Is(err, context.DeadlineExceeded):
+		slog.ErrorContext(r.Context(), "database unavailable", "err", err)
+		sendDebugError(w, apierr.DBUnavailable, "Database unavailable", errorDebug(r.Context(), err, 1))
+	default:
+		slog.ErrorContext(r.Context(), "database error", "err", err)
+		sendDebugError(w, apierr.DBError, "Database error", errorDebug(r.Context(), err, 1))
+	}
+}
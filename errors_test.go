@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+func TestSendStoreError(t *testing.T) {
+	testCases := []struct {
+		name         string
+		err          error
+		expectedCode apierr.Code
+	}{
+		{"Not found", fmt.Errorf("get person 1: %w", store.ErrNotFound), apierr.PersonNotFound},
+		{"Conflict", fmt.Errorf("%w: duplicate", store.ErrConflict), apierr.Conflict},
+		{"Validation", &store.ValidationError{Field: "name", Message: "must not be null"}, apierr.ValidationFailed},
+		{"Unavailable", fmt.Errorf("%w: connection refused", store.ErrUnavailable), apierr.DBUnavailable},
+		{"Unknown", errors.New("boom"), apierr.DBError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			sendStoreError(rr, httptest.NewRequest("GET", "/", nil), tc.err)
+
+			if status := rr.Code; status != tc.expectedCode.Status() {
+				t.Errorf("Expected status %d, got %d", tc.expectedCode.Status(), status)
+			}
+			var body ErrorResponse
+			if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if body.Code != tc.expectedCode {
+				t.Errorf("Expected code %s, got %s", tc.expectedCode, body.Code)
+			}
+		})
+	}
+}
+
+func TestSendStoreError_ValidationField(t *testing.T) {
+	rr := httptest.NewRecorder()
+	sendStoreError(rr, httptest.NewRequest("GET", "/", nil), &store.ValidationError{Field: "name", Message: "must not be null"})
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", status)
+	}
+	var body ValidationErrorResponse
+	json.NewDecoder(rr.Body).Decode(&body)
+	if body.Errors["name"] != "name was rejected: must not be null" {
+		t.Errorf("Expected field error for name, got %v", body.Errors)
+	}
+	if len(body.Details) != 1 || body.Details[0].Key != apierr.KeyRejected || body.Details[0].Params["reason"] != "must not be null" {
+		t.Errorf("Expected structured detail for name, got %+v", body.Details)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"slices"
+	"strings"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+)
+
+// Relations ?expand= can name.
+const (
+	expandAttachments = "attachments"
+	expandPhoto       = "photo"
+)
+
+// expansions are the relations that can be expanded: those whose feature is
+// on.
+func (app *application) expansions() []string {
+	var names []string
+	if app.blobs != nil && app.attachments != nil {
+		names = append(names, expandAttachments)
+	}
+	if app.blobs != nil && app.photos != nil {
+		names = append(names, expandPhoto)
+	}
+	return names
+}
+
+// parseExpand reads ?expand=, a comma separated list of relations.
+func (app *application) parseExpand(q url.Values, errs []apierr.FieldError) ([]string, []apierr.FieldError) {
+	raw := q.Get("expand")
+	if raw == "" {
+		return nil, errs
+	}
+	allowed := app.expansions()
+	var expand []string
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if !slices.Contains(allowed, name) {
+			errs = append(errs, apierr.NewFieldError("expand", apierr.KeyOneOf, map[string]any{"allowed": strings.Join(allowed, ", "), "actual": name}))
+			continue
+		}
+		if !slices.Contains(expand, name) {
+			expand = append(expand, name)
+		}
+	}
+	return expand, errs
+}
+
+// expand adds the relations named in expand to persons, with one query per
+// relation however many persons there are.
+func (app *application) expand(ctx context.Context, persons []PersonResponse, expand []string) error {
+	if len(expand) == 0 || len(persons) == 0 {
+		return nil
+	}
+	ids := make([]int32, len(persons))
+	for i, p := range persons {
+		ids[i] = p.ID
+	}
+	for _, name := range expand {
+		switch name {
+		case expandAttachments:
+			byPerson, err := app.attachments.AttachmentsOf(ctx, ids)
+			if err != nil {
+				return err
+			}
+			for i := range persons {
+				list := []AttachmentResponse{}
+				for _, a := range byPerson[persons[i].ID] {
+					list = append(list, toAttachmentResponse(a))
+				}
+				persons[i].Attachments = &list
+			}
+		case expandPhoto:
+			photos, err := app.photos.PhotosOf(ctx, ids)
+			if err != nil {
+				return err
+			}
+			for i := range persons {
+				if p, ok := photos[persons[i].ID]; ok {
+					photo := app.toPhotoResponse(p)
+					persons[i].Photo = &photo
+				}
+			}
+		}
+	}
+	return nil
+}
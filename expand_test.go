@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/blob"
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+// countingStore counts the batched loads expand makes.
+type countingStore struct {
+	*testutil.MemoryStore
+	attachmentLoads, photoLoads int
+}
+
+func (c *countingStore) AttachmentsOf(ctx context.Context, persons []int32) (map[int32][]store.Attachment, error) {
+	c.attachmentLoads++
+	return c.MemoryStore.AttachmentsOf(ctx, persons)
+}
+
+func (c *countingStore) PhotosOf(ctx context.Context, persons []int32) (map[int32]store.Photo, error) {
+	c.photoLoads++
+	return c.MemoryStore.PhotosOf(ctx, persons)
+}
+
+func TestExpand(t *testing.T) {
+	st := testutil.NewMemoryStore(store.Person{Name: "Ann"}, store.Person{Name: "Bob"}, store.Person{Name: "Cid"})
+	ctx := context.Background()
+	for i, id := range []int32{1, 1, 3} {
+		if _, err := st.CreateAttachment(ctx, store.Attachment{PersonID: &id, ObjectKey: fmt.Sprintf("attachments/%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bob := int32(2)
+	if _, _, err := st.SetPhoto(ctx, store.Photo{PersonID: &bob, ObjectKey: "photos/2", Width: 4, Height: 4}); err != nil {
+		t.Fatal(err)
+	}
+	counting := &countingStore{MemoryStore: st}
+	app := newTestAppWithStore(st)
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.blobs, app.attachments, app.photos = blobs, counting, counting
+	router := withContractCheck(t, app.routes())
+
+	rr := testutil.Do(router, "GET", "/api/v1/persons?expand=attachments,photo", nil)
+	body := rr.Body.String()
+	var list []PersonResponse
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil || len(list) != 3 {
+		t.Fatalf("Expected three persons, got %d %v", rr.Code, err)
+	}
+	for i, want := range []int{2, 0, 1} {
+		if list[i].Attachments == nil || len(*list[i].Attachments) != want {
+			t.Errorf("Expected %d attachments for person %d, got %+v", want, list[i].ID, list[i].Attachments)
+		}
+	}
+	if !strings.Contains(body, `"attachments":[]`) {
+		t.Errorf("Expected an empty list for Bob, who has no attachments, got %s", body)
+	}
+	if list[0].Photo != nil || list[1].Photo == nil || list[1].Photo.PersonID != 2 {
+		t.Errorf("Expected Bob's photo only, got %+v", list)
+	}
+	if counting.attachmentLoads != 1 || counting.photoLoads != 1 {
+		t.Errorf("Expected one load per relation, got %d and %d", counting.attachmentLoads, counting.photoLoads)
+	}
+
+	rr = testutil.Do(router, "GET", "/api/v1/persons/1?expand=attachments", nil)
+	var ann PersonResponse
+	if err := json.NewDecoder(rr.Body).Decode(&ann); err != nil || ann.Attachments == nil || len(*ann.Attachments) != 2 || ann.Photo != nil {
+		t.Errorf("Expected Ann's attachments only, got %d %+v %v", rr.Code, ann, err)
+	}
+	rr = testutil.Do(router, "GET", "/api/v1/persons/1", nil)
+	if strings.Contains(rr.Body.String(), "attachments") {
+		t.Errorf("Expected no attachments unless expanded, got %s", rr.Body.String())
+	}
+
+	rr = testutil.Do(router, "GET", "/api/v1/persons?expand=employment", nil)
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), `"field":"expand"`) {
+		t.Errorf("Expected an unknown relation rejected, got %d: %s", rr.Code, rr.Body.String())
+	}
+	app.photos = nil
+	rr = testutil.Do(withContractCheck(t, app.routes()), "GET", "/api/v1/persons/1?expand=photo", nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected photo rejected with photos off, got %d", rr.Code)
+	}
+}
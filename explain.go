@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+)
+
+// ExplainRequest describes a list of persons by the parameters of
+// GET /api/v1/persons.
+type ExplainRequest struct {
+	Name   string `json:"name,omitempty"`
+	MinAge *int32 `json:"min_age,omitempty"`
+	MaxAge *int32 `json:"max_age,omitempty"`
+	Sort   string `json:"sort,omitempty"`
+	Limit  *int   `json:"limit,omitempty"`
+	Offset int    `json:"offset,omitempty"`
+}
+
+// query is req as a list query string, so it is validated and turned into a
+// filter exactly as the list would be.
+func (req ExplainRequest) query() url.Values {
+	q := url.Values{}
+	if req.Name != "" {
+		q.Set("name", req.Name)
+	}
+	if req.MinAge != nil {
+		q.Set("min_age", strconv.Itoa(int(*req.MinAge)))
+	}
+	if req.MaxAge != nil {
+		q.Set("max_age", strconv.Itoa(int(*req.MaxAge)))
+	}
+	if req.Sort != "" {
+		q.Set("sort", req.Sort)
+	}
+	if req.Limit != nil {
+		q.Set("limit", strconv.Itoa(*req.Limit))
+	}
+	if req.Offset != 0 {
+		q.Set("offset", strconv.Itoa(req.Offset))
+	}
+	return q
+}
+
+// explainList answers with the plan of the query listing persons would run
+// for the request, for operators looking into slow filters.
+func (app *application) explainList(w http.ResponseWriter, r *http.Request) {
+	var req ExplainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendValidationError(w, apierr.InvalidJSON, "Invalid json", []apierr.FieldError{
+			apierr.NewFieldError("body", apierr.KeyInvalidJSON, nil),
+		})
+		return
+	}
+	filter, errs := parseListQuery(req.query(), app.cfg.page)
+	if len(errs) > 0 {
+		sendValidationError(w, apierr.ValidationFailed, "Invalid list parameters", errs)
+		return
+	}
+	plan, err := app.explain.ExplainListPersons(r.Context(), filter)
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan)
+}
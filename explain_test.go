@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+type fakeExplainer struct {
+	filter store.ListFilter
+}
+
+func (e *fakeExplainer) ExplainListPersons(ctx context.Context, f store.ListFilter) (store.QueryPlan, error) {
+	e.filter = f
+	return store.QueryPlan{Query: "SELECT 1", Plan: []string{"Result  (cost=0.00..0.01 rows=1 width=4)"}}, nil
+}
+
+func explainRequest(t *testing.T, router http.Handler, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("POST", "/admin/explain", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestExplainList(t *testing.T) {
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	app.cfg.adminToken = "s3cret"
+	explainer := &fakeExplainer{}
+	app.explain = explainer
+	router := withContractCheck(t, app.routes())
+
+	rr := explainRequest(t, router, `{"name": "ann", "max_age": 30, "sort": "-age"}`)
+	var plan store.QueryPlan
+	if err := json.NewDecoder(rr.Body).Decode(&plan); err != nil || rr.Code != http.StatusOK || len(plan.Plan) != 1 {
+		t.Fatalf("Expected the plan, got %d %+v %v", rr.Code, plan, err)
+	}
+	f := explainer.filter
+	if f.Name != "ann" || f.MaxAge == nil || *f.MaxAge != 30 || len(f.Sort) != 1 || !f.Sort[0].Desc || f.Limit != app.cfg.page.defaultSize {
+		t.Errorf("Expected the filter the list would use, got %+v", f)
+	}
+
+	rr = explainRequest(t, router, `{"sort": "email", "limit": 0}`)
+	if body := rr.Body.String(); rr.Code != http.StatusBadRequest || !strings.Contains(body, `"field":"sort"`) || !strings.Contains(body, `"field":"limit"`) {
+		t.Errorf("Expected the list's validation errors, got %d: %s", rr.Code, body)
+	}
+}
+
+func TestExplainListWithDB(t *testing.T) {
+	db := setupTestDB(t)
+	app := newApplication(loadConfig(), db)
+	app.cfg.adminToken = "s3cret"
+	testutil.InsertPersons(t, db, store.Person{Name: "Ann"})
+
+	rr := explainRequest(t, withContractCheck(t, app.routes()), `{"name": "ann", "sort": "name"}`)
+	var plan store.QueryPlan
+	if err := json.NewDecoder(rr.Body).Decode(&plan); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("Expected the plan, got %d %v", rr.Code, err)
+	}
+	if !strings.Contains(plan.Query, "ILIKE") || !strings.Contains(strings.Join(plan.Plan, "\n"), "actual time") {
+		t.Errorf("Expected an analyzed plan of the list query, got %+v", plan)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+// checkWellFormedError fails the test unless every 4xx/5xx response carries a
+// JSON error body with a machine-readable code.
+func checkWellFormedError(t *testing.T, rr *httptest.ResponseRecorder) {
+	t.Helper()
+	if rr.Code < 400 {
+		return
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Fatalf("Status %d returned with content type %q", rr.Code, ct)
+	}
+	var body ErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Status %d returned malformed JSON %q: %v", rr.Code, rr.Body.String(), err)
+	}
+	if body.Code == "" {
+		t.Fatalf("Status %d returned error without code: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Code >= 500 {
+		t.Fatalf("Fake store never fails, got status %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func FuzzCreatePersonBody(f *testing.F) {
+	f.Add(`{"name":"Ann","age":30,"address":"Moscow","work":"Dev"}`)
+	f.Add(`{"name":""}`)
+	f.Add(`{"name":null,"age":"thirty"}`)
+	f.Add(`{"age":2147483648}`)
+	f.Add(`[]`)
+	f.Add(`{`)
+	f.Add("")
+
+	f.Fuzz(func(t *testing.T, body string) {
+		router := setupTestRouterWithStore(t, testutil.NewMemoryStore())
+		req := httptest.NewRequest("POST", "/api/v1/persons", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusCreated {
+			checkWellFormedError(t, rr)
+		}
+	})
+}
+
+func FuzzUpdatePersonBody(f *testing.F) {
+	f.Add(`{"name":"Bob"}`)
+	f.Add(`{"age":-1,"work":null}`)
+	f.Add(`{"name":"   "}`)
+	f.Add(`null`)
+	f.Add(`{"address":1}`)
+
+	f.Fuzz(func(t *testing.T, body string) {
+		st := testutil.NewMemoryStore()
+		router := setupTestRouterWithStore(t, st)
+		testutil.Do(router, "POST", "/api/v1/persons", PersonRequest{Name: stringPtr("Ann")})
+
+		req := httptest.NewRequest("PATCH", "/api/v1/persons/1", strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+
+		checkWellFormedError(t, rr)
+	})
+}
+
+func FuzzPersonID(f *testing.F) {
+	f.Add("1")
+	f.Add("-1")
+	f.Add("0x10")
+	f.Add("99999999999999999999")
+	f.Add("1.5")
+	f.Add("abc/def")
+	f.Add("%00")
+
+	f.Fuzz(func(t *testing.T, id string) {
+		router := setupTestRouterWithStore(t, testutil.NewMemoryStore())
+		for _, method := range []string{"GET", "PATCH", "DELETE"} {
+			req := httptest.NewRequest(method, "/api/v1/persons/"+url.PathEscape(id), strings.NewReader(`{"name":"Ann"}`))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			checkWellFormedError(t, rr)
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/geocode"
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+const (
+	geocodeQueueSize  = 1000
+	geocodeCacheSize  = 10000
+	geocoderNominatim = "nominatim"
+	geocoderGoogle    = "google"
+)
+
+type geocodeJob struct {
+	id      int32
+	address string
+}
+
+func newGeocoder(cfg config) geocode.Provider {
+	var p geocode.Provider
+	switch cfg.geocoder {
+	case geocoderNominatim:
+		p = geocode.NewNominatim(cmp.Or(cfg.geocoderURL, geocode.NominatimURL), "persons-service/"+buildVersion.Version)
+	case geocoderGoogle:
+		if cfg.geocoderAPIKey == "" {
+			slog.Warn("GEOCODER=google needs GEOCODER_API_KEY, geocoding disabled")
+			return nil
+		}
+		p = geocode.NewGoogle(cmp.Or(cfg.geocoderURL, geocode.GoogleURL), cfg.geocoderAPIKey)
+	default:
+		return nil
+	}
+	return geocode.NewCached(geocode.NewLimited(p, cfg.geocoderInterval), cfg.geocoderCacheTTL, geocodeCacheSize)
+}
+
+// geocodeAddress queues the address a request set for geocoding, unless the
+// client sent coordinates along with it. The queue is in memory: addresses
+// still queued when the process stops, or beyond a full queue, are not
+// geocoded.
+func (app *application) geocodeAddress(id int32, req PersonRequest) {
+	if app.geocoder == nil || req.Address == nil || req.Latitude != nil || strings.TrimSpace(*req.Address) == "" {
+		return
+	}
+	select {
+	case app.geocodeJobs <- geocodeJob{id: id, address: *req.Address}:
+	default:
+		slog.Warn("geocoding queue full, dropping address", "id", id)
+	}
+}
+
+// runGeocoder works through queued addresses one at a time until ctx is done.
+func (app *application) runGeocoder(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-app.geocodeJobs:
+			if err := app.geocodePerson(ctx, job); err != nil {
+				slog.WarnContext(ctx, "failed to geocode address", "id", job.id, "err", err)
+			}
+		}
+	}
+}
+
+// geocodePerson stores the coordinates of job's address, or clears stale ones
+// if the provider does not know it. Nothing is written when the address has
+// changed again in the meantime, since that change queued its own job, or
+// when the coordinates are already right.
+func (app *application) geocodePerson(ctx context.Context, job geocodeJob) error {
+	point, err := app.geocoder.Geocode(ctx, job.address)
+	if err != nil && !errors.Is(err, geocode.ErrNotFound) {
+		return err
+	}
+	var lat, lon *float64
+	if err == nil {
+		lat, lon = &point.Lat, &point.Lon
+	}
+	_, err = app.store.ModifyPerson(ctx, job.id, func(p *store.Person) error {
+		if p.Address == nil || *p.Address != job.address || equalPtr(p.Latitude, lat) && equalPtr(p.Longitude, lon) {
+			return store.ErrPreconditionFailed
+		}
+		p.Latitude, p.Longitude = lat, lon
+		return nil
+	})
+	if errors.Is(err, store.ErrPreconditionFailed) || errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err == nil {
+		slog.DebugContext(ctx, "geocoded address", "id", job.id, "found", lat != nil)
+	}
+	return err
+}
+
+func equalPtr[T comparable](a, b *T) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
+// unverifiedAddress returns the validator's verdict on the address req sets
+// when it rejects it, and nil when the address is fine, not being set, or
+// cannot be checked because the validator is unreachable: an outage there
+// should not stop all writes.
+func (app *application) unverifiedAddress(ctx context.Context, req PersonRequest) *geocode.Verdict {
+	if app.addresses == nil || req.Address == nil || strings.TrimSpace(*req.Address) == "" {
+		return nil
+	}
+	v, err := app.addresses.Validate(ctx, *req.Address)
+	if err != nil {
+		slog.WarnContext(ctx, "address validation failed, accepting address", "err", err)
+		return nil
+	}
+	if v.Verified {
+		return nil
+	}
+	return &v
+}
+
+func sendUnverifiedAddress(w http.ResponseWriter, v geocode.Verdict) {
+	suggestions := make([]AddressSuggestion, 0, len(v.Suggestions))
+	for _, s := range v.Suggestions {
+		suggestions = append(suggestions, AddressSuggestion{Address: s.Address, Latitude: s.Lat, Longitude: s.Lon})
+	}
+	fe := apierr.NewFieldError("address", apierr.KeyUnverified, nil)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apierr.AddressUnverified.Status())
+	json.NewEncoder(w).Encode(AddressErrorResponse{
+		ValidationErrorResponse: ValidationErrorResponse{
+			Code:    apierr.AddressUnverified,
+			Message: "Address could not be verified",
+			Errors:  map[string]string{fe.Field: fe.Message},
+			Details: []apierr.FieldError{fe},
+			TraceID: w.Header().Get(traceIDHeader),
+		},
+		Suggestions: suggestions,
+	})
+}
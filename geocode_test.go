@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/geocode"
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+type fakeGeocoder map[string]geocode.Point
+
+func (f fakeGeocoder) Geocode(ctx context.Context, address string) (geocode.Point, error) {
+	if p, ok := f[address]; ok {
+		return p, nil
+	}
+	return geocode.Point{}, geocode.ErrNotFound
+}
+
+func TestGeocodeAddress(t *testing.T) {
+	st := testutil.NewMemoryStore()
+	app := newTestAppWithStore(st)
+	app.geocoder = fakeGeocoder{"Red Square": {Lat: 55.7539, Lon: 37.6208}}
+	app.geocodeJobs = make(chan geocodeJob, 10)
+	router := app.routes()
+	ctx := context.Background()
+
+	rr := testutil.Do(router, "POST", "/api/v1/persons", PersonRequest{Name: stringPtr("Ann"), Address: stringPtr("Red Square")})
+	if rr.Code != http.StatusCreated || len(app.geocodeJobs) != 1 {
+		t.Fatalf("Expected a created person and a queued address, got %d and %d jobs", rr.Code, len(app.geocodeJobs))
+	}
+	if err := app.geocodePerson(ctx, <-app.geocodeJobs); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	p, _ := st.GetPerson(ctx, 1)
+	if p.Latitude == nil || *p.Latitude != 55.7539 || *p.Longitude != 37.6208 {
+		t.Errorf("Expected geocoded coordinates, got %+v", p)
+	}
+
+	testutil.Do(router, "POST", "/api/v1/persons", PersonRequest{Name: stringPtr("Bob"), Address: stringPtr("Red Square"), Latitude: float64Ptr(1), Longitude: float64Ptr(2)})
+	testutil.Do(router, "PATCH", "/api/v1/persons/1", PersonRequest{Age: int32Ptr(30)})
+	if len(app.geocodeJobs) != 0 {
+		t.Errorf("Expected nothing queued without a new address or with coordinates, got %d jobs", len(app.geocodeJobs))
+	}
+
+	// The address changes again before the first change is geocoded.
+	testutil.Do(router, "PATCH", "/api/v1/persons/1", PersonRequest{Address: stringPtr("Nowhere")})
+	testutil.Do(router, "PATCH", "/api/v1/persons/1", PersonRequest{Address: stringPtr("Red Square")})
+	app.geocodePerson(ctx, <-app.geocodeJobs)
+	if p, _ := st.GetPerson(ctx, 1); p.Latitude == nil {
+		t.Errorf("Expected a job for an outdated address to change nothing, got %+v", p)
+	}
+	app.geocodePerson(ctx, <-app.geocodeJobs)
+
+	// An address the provider does not know leaves no stale coordinates.
+	testutil.Do(router, "PATCH", "/api/v1/persons/1", PersonRequest{Address: stringPtr("Nowhere")})
+	app.geocodePerson(ctx, <-app.geocodeJobs)
+	if p, _ := st.GetPerson(ctx, 1); p.Latitude != nil || p.Longitude != nil {
+		t.Errorf("Expected coordinates cleared, got %+v", p)
+	}
+}
+
+func TestGeocodeQueueFull(t *testing.T) {
+	app := newTestAppWithStore(testutil.NewMemoryStore(store.Person{Name: "Ann"}))
+	app.geocoder = fakeGeocoder{}
+	app.geocodeJobs = make(chan geocodeJob, 1)
+	app.geocodeAddress(1, PersonRequest{Address: stringPtr("a")})
+	app.geocodeAddress(1, PersonRequest{Address: stringPtr("b")})
+	if job := <-app.geocodeJobs; job.address != "a" || len(app.geocodeJobs) != 0 {
+		t.Errorf("Expected the overflowing address dropped, got %+v", job)
+	}
+}
+
+type fakeValidator map[string]geocode.Verdict
+
+func (f fakeValidator) Validate(ctx context.Context, address string) (geocode.Verdict, error) {
+	if v, ok := f[address]; ok {
+		return v, nil
+	}
+	return geocode.Verdict{}, geocode.ErrUnavailable
+}
+
+func TestAddressValidation(t *testing.T) {
+	st := testutil.NewMemoryStore(store.Person{Name: "Ann"})
+	app := newTestAppWithStore(st)
+	app.addresses = fakeValidator{
+		"12 Main Street": {Verified: true},
+		"12 Mian Stret":  {Suggestions: []geocode.Suggestion{{Address: "12 Main Street", Lat: float64Ptr(1), Lon: float64Ptr(2)}}},
+	}
+	router := withContractCheck(t, app.routes())
+
+	testCases := []struct {
+		name     string
+		method   string
+		target   string
+		address  string
+		wantCode int
+	}{
+		{"Verified on create", "POST", "/api/v1/persons", "12 Main Street", http.StatusCreated},
+		{"Unverified on create", "POST", "/api/v1/persons", "12 Mian Stret", http.StatusUnprocessableEntity},
+		{"Unverified on replace", "PUT", "/api/v1/persons/1", "12 Mian Stret", http.StatusUnprocessableEntity},
+		{"Unverified on patch", "PATCH", "/api/v1/persons/1", "12 Mian Stret", http.StatusUnprocessableEntity},
+		{"Validator unavailable", "PATCH", "/api/v1/persons/1", "Somewhere", http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := testutil.Do(router, tc.method, tc.target, PersonRequest{Name: stringPtr("Ann"), Address: stringPtr(tc.address)})
+			if rr.Code != tc.wantCode {
+				t.Fatalf("Expected %d, got %d: %s", tc.wantCode, rr.Code, rr.Body.String())
+			}
+			if rr.Code != http.StatusUnprocessableEntity {
+				return
+			}
+			var body AddressErrorResponse
+			json.NewDecoder(rr.Body).Decode(&body)
+			if body.Code != apierr.AddressUnverified || len(body.Suggestions) != 1 || body.Suggestions[0].Address != "12 Main Street" || body.Errors["address"] == "" {
+				t.Errorf("Unexpected body %+v", body)
+			}
+		})
+	}
+	if p, _ := st.GetPerson(context.Background(), 1); p.Address == nil || *p.Address != "Somewhere" {
+		t.Errorf("Expected only accepted addresses stored, got %+v", p)
+	}
+}
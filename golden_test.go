@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata/golden")
+
+// goldenResponse is what gets recorded per endpoint: the status, the headers
+// clients rely on and the body as returned by the handler.
+type goldenResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    json.RawMessage   `json:"body,omitempty"`
+}
+
+var goldenTime = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func seedGoldenStore() *testutil.MemoryStore {
+	st := testutil.NewMemoryStore(
+		store.Person{
+			ID:        1,
+			Name:      "Ivan Ivanov",
+			Age:       testutil.Ptr[int32](42),
+			Address:   testutil.Ptr("Moscow, Red Square 1"),
+			Work:      testutil.Ptr("Engineer"),
+			UpdatedAt: goldenTime,
+		},
+		store.Person{ID: 2, Name: "Anna Petrova", UpdatedAt: goldenTime},
+	)
+	st.Now = func() time.Time { return goldenTime.Add(time.Hour) }
+	return st
+}
+
+func TestGoldenResponses(t *testing.T) {
+	testCases := []struct {
+		name   string
+		method string
+		target string
+		body   string
+	}{
+		{"list_persons", "GET", "/api/v1/persons", ""},
+		{"get_person", "GET", "/api/v1/persons/1", ""},
+		{"get_person_minimal", "GET", "/api/v1/persons/2", ""},
+		{"get_person_not_found", "GET", "/api/v1/persons/404", ""},
+		{"get_person_invalid_id", "GET", "/api/v1/persons/abc", ""},
+		{"create_person", "POST", "/api/v1/persons", `{"name":"New Person","age":20}`},
+		{"create_person_validation_error", "POST", "/api/v1/persons", `{"name":"","age":-1}`},
+		{"update_person", "PATCH", "/api/v1/persons/2", `{"work":"Designer"}`},
+		{"update_person_invalid_json", "PATCH", "/api/v1/persons/2", `{`},
+		{"delete_person", "DELETE", "/api/v1/persons/1", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setupTestRouterWithStore(t, seedGoldenStore())
+			rr := doRawRequest(router, tc.method, tc.target, tc.body)
+
+			got := goldenResponse{Status: rr.Code, Headers: map[string]string{}}
+			for _, h := range []string{"Content-Type", "Location", "Last-Modified"} {
+				if v := rr.Header().Get(h); v != "" {
+					got.Headers[h] = v
+				}
+			}
+			if b := bytes.TrimSpace(rr.Body.Bytes()); len(b) > 0 {
+				got.Body = b
+			}
+			actual, err := json.MarshalIndent(got, "", "  ")
+			if err != nil {
+				t.Fatalf("Failed to encode response: %v", err)
+			}
+			actual = append(actual, '\n')
+
+			path := filepath.Join("testdata", "golden", tc.name+".json")
+			if *updateGolden {
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, actual, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Missing golden file, run go test -run TestGoldenResponses -update: %v", err)
+			}
+			if !bytes.Equal(expected, actual) {
+				t.Errorf("Response differs from %s (run with -update if intended)\n--- expected\n%s\n--- actual\n%s", path, expected, actual)
+			}
+		})
+	}
+}
+
+func doRawRequest(h http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/logging"
+	"ci_cd/rsoi_lab_1/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+func toPersonResponse(p store.Person) PersonResponse {
+	resp := PersonResponse{
+		ID:          p.ID,
+		Name:        p.Name,
+		Age:         p.Age,
+		Address:     p.Address,
+		Work:        p.Work,
+		Latitude:    p.Latitude,
+		Longitude:   p.Longitude,
+		Phone:       p.Phone,
+		PhoneRegion: phoneRegion(p.Phone),
+		Email:       p.Email,
+		Custom:      p.Custom,
+	}
+	if !p.UpdatedAt.IsZero() {
+		updatedAt := p.UpdatedAt.UTC()
+		resp.UpdatedAt = &updatedAt
+	}
+	return resp
+}
+
+func setLastModified(w http.ResponseWriter, p store.Person) {
+	if !p.UpdatedAt.IsZero() {
+		w.Header().Set("Last-Modified", p.UpdatedAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// ifUnmodifiedSince returns a check for the If-Unmodified-Since header, or nil
+// when there is none. An unparsable date is ignored as RFC 9110 asks.
+func ifUnmodifiedSince(r *http.Request) func(p store.Person) error {
+	since, err := http.ParseTime(r.Header.Get("If-Unmodified-Since"))
+	if err != nil {
+		return nil
+	}
+	return func(p store.Person) error {
+		// HTTP dates have second precision, updated_at does not.
+		if p.UpdatedAt.Truncate(time.Second).After(since) {
+			return store.ErrPreconditionFailed
+		}
+		return nil
+	}
+}
+
+func parseID(r *http.Request) (int32, error) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 32)
+	return int32(id), err
+}
+
+func (app *application) listPersons(w http.ResponseWriter, r *http.Request) {
+	filter, errs := parseListFilter(r, app.cfg.page)
+	asOf, errs := parseTimeParam(r.URL.Query().Get("as_of"), "as_of", false, errs)
+	expand, errs := app.parseExpand(r.URL.Query(), errs)
+	if !asOf.IsZero() && app.history == nil {
+		errs = append(errs, apierr.NewFieldError("as_of", apierr.KeyRejected, map[string]any{"reason": "no history is recorded"}))
+	}
+	if len(errs) > 0 {
+		sendValidationError(w, apierr.ValidationFailed, "Invalid query parameters", errs)
+		return
+	}
+
+	slog.DebugContext(r.Context(), "listing persons",
+		"name_filter", logging.Redact(filter.Name), "limit", filter.Limit, "offset", filter.Offset)
+	var list []store.Person
+	var err error
+	if asOf.IsZero() {
+		list, err = app.store.ListPersons(r.Context(), filter)
+	} else {
+		list, err = app.history.ListPersonsAt(r.Context(), asOf, filter)
+	}
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+
+	persons := make([]PersonResponse, 0, len(list))
+	for _, p := range list {
+		persons = append(persons, toPersonResponse(p))
+	}
+	if err := app.expand(r.Context(), persons, expand); err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	// The body stays a bare array for existing clients, so paging info goes
+	// into headers. A full page may be followed by more.
+	if len(list) == filter.Limit {
+		w.Header().Set("Link", "<"+nextPageURL(r, filter.Limit, filter.Offset)+`>; rel="next"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(persons)
+	if err != nil {
+		sendError(w, apierr.Internal, "json encoding error")
+		return
+	}
+}
+
+func (app *application) createPerson(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var req PersonRequest
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		sendError(w, apierr.InvalidJSON, "json decoding error")
+		return
+	}
+	errs := validatePersonRequest(req, false)
+	req.Phone, errs = normalizePhone(req.Phone, app.cfg.phoneRegion, errs)
+	if errs, err = app.checkCustom(r.Context(), req.Custom, false, errs); err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	if len(errs) > 0 {
+		sendValidationError(w, apierr.ValidationFailed, "person validation error", errs)
+		return
+	}
+	if v := app.unverifiedAddress(r.Context(), req); v != nil {
+		sendUnverifiedAddress(w, *v)
+		return
+	}
+	id, err := app.store.CreatePerson(r.Context(), store.Person{
+		Name:      *req.Name,
+		Age:       req.Age,
+		Address:   req.Address,
+		Work:      req.Work,
+		Latitude:  req.Latitude,
+		Longitude: req.Longitude,
+		Phone:     req.Phone,
+		Email:     optionalEmail(req.Email),
+		Custom:    customValues(req.Custom),
+	})
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	slog.DebugContext(r.Context(), "person created", "id", id, "name", *req.Name)
+	app.geocodeAddress(id, req)
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/persons/%d", id))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (app *application) getPerson(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		sendError(w, apierr.InvalidID, "Invalid ID format")
+		return
+	}
+	expand, errs := app.parseExpand(r.URL.Query(), nil)
+	if len(errs) > 0 {
+		sendValidationError(w, apierr.ValidationFailed, "Invalid query parameters", errs)
+		return
+	}
+	person, err := app.store.GetPerson(r.Context(), id)
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	resp := []PersonResponse{toPersonResponse(person)}
+	if err := app.expand(r.Context(), resp, expand); err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	setLastModified(w, person)
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(resp[0])
+	if err != nil {
+		sendError(w, apierr.Internal, "Encoding error")
+		return
+	}
+}
+
+// getPersonSnapshot returns the person as it was at ?at=, from the event log or
+// the change feed, for audits and disputes.
+func (app *application) getPersonSnapshot(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		sendError(w, apierr.InvalidID, "Invalid ID format")
+		return
+	}
+	at, errs := parseTimeParam(r.URL.Query().Get("at"), "at", true, nil)
+	if len(errs) > 0 {
+		sendValidationError(w, apierr.ValidationFailed, "Invalid query parameters", errs)
+		return
+	}
+
+	person, err := app.history.PersonAt(r.Context(), id, at)
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(toPersonResponse(person)); err != nil {
+		sendError(w, apierr.Internal, "Encoding error")
+	}
+}
+
+// putPerson replaces the whole person. With cfg.putCreates an unknown ID is
+// created as given and answered like POST; otherwise it is a 404.
+func (app *application) putPerson(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil || id < 1 {
+		sendError(w, apierr.InvalidID, "Invalid ID format")
+		return
+	}
+
+	var req PersonRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendValidationError(w, apierr.InvalidJSON, "Invalid json", []apierr.FieldError{
+			apierr.NewFieldError("body", apierr.KeyInvalidJSON, nil),
+		})
+		return
+	}
+	errs := validatePersonRequest(req, false)
+	req.Phone, errs = normalizePhone(req.Phone, app.cfg.phoneRegion, errs)
+	if errs, err = app.checkCustom(r.Context(), req.Custom, false, errs); err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	if len(errs) > 0 {
+		sendValidationError(w, apierr.ValidationFailed, "person validation error", errs)
+		return
+	}
+	if v := app.unverifiedAddress(r.Context(), req); v != nil {
+		sendUnverifiedAddress(w, *v)
+		return
+	}
+	person := store.Person{
+		ID:        id,
+		Name:      *req.Name,
+		Age:       req.Age,
+		Address:   req.Address,
+		Work:      req.Work,
+		Latitude:  req.Latitude,
+		Longitude: req.Longitude,
+		Phone:     req.Phone,
+		Email:     optionalEmail(req.Email),
+		Custom:    customValues(req.Custom),
+	}
+
+	if app.cfg.putCreates {
+		var created bool
+		person, created, err = app.store.UpsertPerson(r.Context(), person)
+		if err != nil {
+			sendStoreError(w, r, err)
+			return
+		}
+		app.geocodeAddress(id, req)
+		if created {
+			w.Header().Set("Location", fmt.Sprintf("/api/v1/persons/%d", id))
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+	} else {
+		person, err = app.store.ModifyPerson(r.Context(), id, func(p *store.Person) error {
+			*p = person
+			return nil
+		})
+		if err != nil {
+			sendStoreError(w, r, err)
+			return
+		}
+		app.geocodeAddress(id, req)
+	}
+
+	setLastModified(w, person)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(toPersonResponse(person)); err != nil {
+		sendError(w, apierr.Internal, "Encoding error")
+	}
+}
+
+func (app *application) updatePerson(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		sendError(w, apierr.InvalidID, "Invalid id format")
+		return
+	}
+
+	var req PersonRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		sendValidationError(w, apierr.InvalidJSON, "Invalid json", []apierr.FieldError{
+			apierr.NewFieldError("body", apierr.KeyInvalidJSON, nil),
+		})
+		return
+	}
+	errs := validatePersonRequest(req, true)
+	req.Phone, errs = normalizePhone(req.Phone, app.cfg.phoneRegion, errs)
+	if errs, err = app.checkCustom(r.Context(), req.Custom, true, errs); err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	if len(errs) > 0 {
+		sendValidationError(w, apierr.ValidationFailed, "person validation error", errs)
+		return
+	}
+	if v := app.unverifiedAddress(r.Context(), req); v != nil {
+		sendUnverifiedAddress(w, *v)
+		return
+	}
+
+	patch := store.PersonPatch{
+		Name:      req.Name,
+		Age:       req.Age,
+		Address:   req.Address,
+		Work:      req.Work,
+		Latitude:  req.Latitude,
+		Longitude: req.Longitude,
+		Phone:     req.Phone,
+		Email:     optionalEmail(req.Email),
+		Custom:    req.Custom,
+	}
+	var person store.Person
+	if check := ifUnmodifiedSince(r); check != nil || app.cfg.rowLocking {
+		person, err = app.store.ModifyPerson(r.Context(), id, func(p *store.Person) error {
+			if check != nil {
+				if err := check(*p); err != nil {
+					return err
+				}
+			}
+			patch.Apply(p)
+			return nil
+		})
+	} else {
+		person, err = app.store.UpdatePerson(r.Context(), id, patch)
+	}
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	app.geocodeAddress(id, req)
+	setLastModified(w, person)
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(toPersonResponse(person))
+	if err != nil {
+		sendError(w, apierr.Internal, "Encoding error")
+		return
+	}
+}
+
+func (app *application) deletePerson(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		sendError(w, apierr.InvalidID, "Invalid ID format")
+		return
+	}
+
+	if check := ifUnmodifiedSince(r); check != nil {
+		err = app.store.DeletePersonIf(r.Context(), id, check)
+	} else {
+		err = app.store.DeletePerson(r.Context(), id)
+	}
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
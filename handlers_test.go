@@ -0,0 +1,568 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func newTestAppWithStore(st store.Store) *application {
+	app := newApplication(loadConfig(), nil)
+	app.store = st
+	return app
+}
+
+// setupTestRouterWithStore returns the full router over st, with every response
+// checked against the OpenAPI spec.
+func setupTestRouterWithStore(t testing.TB, st store.Store) http.Handler {
+	return withContractCheck(t, newTestAppWithStore(st).routes())
+}
+
+func decodeErrorCode(t *testing.T, rr *httptest.ResponseRecorder) apierr.Code {
+	t.Helper()
+	var body ErrorResponse
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode error response: %v", err)
+	}
+	return body.Code
+}
+
+func TestHandlers_CRUD(t *testing.T) {
+	st := testutil.NewMemoryStore()
+	router := setupTestRouterWithStore(t, st)
+
+	rr := testutil.Do(router, "POST", "/api/v1/persons", PersonRequest{Name: stringPtr("Ann"), Age: int32Ptr(30)})
+	if rr.Code != http.StatusCreated || rr.Header().Get("Location") != "/api/v1/persons/1" {
+		t.Fatalf("Expected 201 with Location, got %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	rr = testutil.Do(router, "PATCH", "/api/v1/persons/1", PersonRequest{Work: stringPtr("Dev")})
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var updated PersonResponse
+	json.NewDecoder(rr.Body).Decode(&updated)
+	if updated.Name != "Ann" || updated.Work == nil || *updated.Work != "Dev" || updated.Age == nil || *updated.Age != 30 {
+		t.Errorf("Unexpected updated person: %+v", updated)
+	}
+
+	rr = testutil.Do(router, "GET", "/api/v1/persons", nil)
+	var list []PersonResponse
+	json.NewDecoder(rr.Body).Decode(&list)
+	if rr.Code != http.StatusOK || len(list) != 1 {
+		t.Errorf("Expected one person in list, got %d %v", rr.Code, list)
+	}
+
+	rr = testutil.Do(router, "DELETE", "/api/v1/persons/1", nil)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", rr.Code)
+	}
+
+	rr = testutil.Do(router, "GET", "/api/v1/persons/1", nil)
+	if rr.Code != http.StatusNotFound || decodeErrorCode(t, rr) != apierr.PersonNotFound {
+		t.Errorf("Expected PERSON_NOT_FOUND after delete, got %d", rr.Code)
+	}
+}
+
+func TestHandlers_StoreErrors(t *testing.T) {
+	testCases := []struct {
+		name         string
+		err          error
+		expectedCode apierr.Code
+	}{
+		{"Scan error", fmt.Errorf("scan person: %w", errors.New("sql: Scan error on column index 2")), apierr.DBError},
+		{"Database down", fmt.Errorf("%w: dial tcp: connection refused", store.ErrUnavailable), apierr.DBUnavailable},
+		{"Unique violation", fmt.Errorf("%w: duplicate key", store.ErrConflict), apierr.Conflict},
+		{"Invalid filter", &store.ValidationError{Field: "sort", Message: "unknown sort field"}, apierr.ValidationFailed},
+		{"Check violation", fmt.Errorf("%w: %w", store.ErrConstraint, &store.ValidationError{Field: "age", Message: "violates check constraint"}), apierr.ConstraintViolation},
+		{"Value too long", fmt.Errorf("%w: %w", store.ErrConstraint, &store.ValidationError{Message: "value too long"}), apierr.ConstraintViolation},
+		{"Lock timeout", fmt.Errorf("%w: canceling statement due to lock timeout", store.ErrLockTimeout), apierr.LockTimeout},
+		{"Restricted relation", &store.DependentsError{Relation: "notes", Count: 2}, apierr.Conflict},
+	}
+	requests := []struct {
+		method, target string
+		body           interface{}
+	}{
+		{"GET", "/api/v1/persons", nil},
+		{"GET", "/api/v1/persons/1", nil},
+		{"POST", "/api/v1/persons", PersonRequest{Name: stringPtr("Ann")}},
+		{"PATCH", "/api/v1/persons/1", PersonRequest{Age: int32Ptr(1)}},
+		{"DELETE", "/api/v1/persons/1", nil},
+	}
+
+	for _, tc := range testCases {
+		for _, req := range requests {
+			t.Run(tc.name+"/"+req.method+" "+req.target, func(t *testing.T) {
+				st := testutil.NewMemoryStore()
+				st.Err = tc.err
+				rr := testutil.Do(setupTestRouterWithStore(t, st), req.method, req.target, req.body)
+
+				if rr.Code != tc.expectedCode.Status() {
+					t.Errorf("Expected status %d, got %d", tc.expectedCode.Status(), rr.Code)
+				}
+				if code := decodeErrorCode(t, rr); code != tc.expectedCode {
+					t.Errorf("Expected code %s, got %s", tc.expectedCode, code)
+				}
+			})
+		}
+	}
+}
+
+func TestHandlers_BadInput(t *testing.T) {
+	router := setupTestRouterWithStore(t, testutil.NewMemoryStore())
+
+	testCases := []struct {
+		name         string
+		method       string
+		target       string
+		body         string
+		expectedCode apierr.Code
+	}{
+		{"Non-numeric ID", "GET", "/api/v1/persons/abc", "", apierr.InvalidID},
+		{"ID overflows int32", "DELETE", "/api/v1/persons/4294967296", "", apierr.InvalidID},
+		{"Malformed create body", "POST", "/api/v1/persons", "{", apierr.InvalidJSON},
+		{"Malformed patch body", "PATCH", "/api/v1/persons/1", "{", apierr.InvalidJSON},
+		{"Invalid age", "POST", "/api/v1/persons", `{"name":"Ann","age":-5}`, apierr.ValidationFailed},
+		{"Unknown person", "PATCH", "/api/v1/persons/42", `{"name":"Ann"}`, apierr.PersonNotFound},
+		{"Non-numeric age filter", "GET", "/api/v1/persons?min_age=old", "", apierr.ValidationFailed},
+		{"Unknown sort field", "GET", "/api/v1/persons?sort=-address", "", apierr.ValidationFailed},
+		{"Page too large", "GET", "/api/v1/persons?limit=10000000", "", apierr.ValidationFailed},
+		{"Negative offset", "GET", "/api/v1/persons?offset=-1", "", apierr.ValidationFailed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedCode.Status() {
+				t.Errorf("Expected status %d, got %d", tc.expectedCode.Status(), rr.Code)
+			}
+			if code := decodeErrorCode(t, rr); code != tc.expectedCode {
+				t.Errorf("Expected code %s, got %s", tc.expectedCode, code)
+			}
+		})
+	}
+}
+
+func TestHandlers_ListFilter(t *testing.T) {
+	st := testutil.NewMemoryStore(
+		store.Person{Name: "Ann", Age: int32Ptr(30)},
+		store.Person{Name: "Joanna", Age: int32Ptr(45)},
+		store.Person{Name: "Bob", Age: int32Ptr(35)},
+		store.Person{Name: "Anton"},
+	)
+	router := setupTestRouterWithStore(t, st)
+
+	testCases := []struct {
+		query string
+		names []string
+	}{
+		{"", []string{"Ann", "Joanna", "Bob", "Anton"}},
+		{"?name=AN", []string{"Ann", "Joanna", "Anton"}},
+		{"?min_age=31&max_age=40", []string{"Bob"}},
+		{"?sort=-age", []string{"Anton", "Joanna", "Bob", "Ann"}},
+		{"?name=an&sort=name", []string{"Ann", "Anton", "Joanna"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			rr := testutil.Do(router, "GET", "/api/v1/persons"+tc.query, nil)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+			var list []PersonResponse
+			json.NewDecoder(rr.Body).Decode(&list)
+			var names []string
+			for _, p := range list {
+				names = append(names, p.Name)
+			}
+			if fmt.Sprint(names) != fmt.Sprint(tc.names) {
+				t.Errorf("Expected %v, got %v", tc.names, names)
+			}
+		})
+	}
+}
+
+func TestHandlers_PatchWithRowLocking(t *testing.T) {
+	st := testutil.NewMemoryStore(store.Person{Name: "Ann", Age: int32Ptr(30)})
+	app := newTestAppWithStore(st)
+	app.cfg.rowLocking = true
+	router := withContractCheck(t, app.routes())
+
+	rr := testutil.Do(router, "PATCH", "/api/v1/persons/1", PersonRequest{Work: stringPtr("Dev")})
+	var updated PersonResponse
+	json.NewDecoder(rr.Body).Decode(&updated)
+	if rr.Code != http.StatusOK || updated.Name != "Ann" || updated.Work == nil || *updated.Work != "Dev" {
+		t.Errorf("Unexpected response %d %+v", rr.Code, updated)
+	}
+
+	rr = testutil.Do(router, "PATCH", "/api/v1/persons/2", PersonRequest{Work: stringPtr("Dev")})
+	if rr.Code != http.StatusNotFound || decodeErrorCode(t, rr) != apierr.PersonNotFound {
+		t.Errorf("Expected PERSON_NOT_FOUND, got %d", rr.Code)
+	}
+}
+
+func TestHandlers_Phone(t *testing.T) {
+	st := testutil.NewMemoryStore()
+	app := newTestAppWithStore(st)
+	app.cfg.phoneRegion = "RU"
+	router := withContractCheck(t, app.routes())
+
+	rr := testutil.Do(router, "POST", "/api/v1/persons", PersonRequest{Name: stringPtr("Ann"), Phone: stringPtr("8 (495) 123-45-67")})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = testutil.Do(router, "GET", "/api/v1/persons/1", nil)
+	var got PersonResponse
+	json.NewDecoder(rr.Body).Decode(&got)
+	if got.Phone == nil || *got.Phone != "+74951234567" || got.PhoneRegion == nil || *got.PhoneRegion != "RU" {
+		t.Errorf("Expected the phone normalized with its region, got %+v", got)
+	}
+
+	rr = testutil.Do(router, "PATCH", "/api/v1/persons/1", PersonRequest{Phone: stringPtr("12345")})
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), apierr.KeyPhone) {
+		t.Errorf("Expected a phone validation error, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestHandlers_EmailConflict(t *testing.T) {
+	st := testutil.NewMemoryStore(
+		store.Person{Name: "Ann", Email: stringPtr("ann@example.com")},
+		store.Person{Name: "Bob"},
+	)
+	router := withContractCheck(t, newTestAppWithStore(st).routes())
+
+	rr := testutil.Do(router, "POST", "/api/v1/persons", PersonRequest{Name: stringPtr("Ann"), Email: stringPtr("Ann@Example.com")})
+	var got ConflictErrorResponse
+	json.NewDecoder(rr.Body).Decode(&got)
+	if rr.Code != http.StatusConflict || got.Field != "email" || got.ExistingID == nil || *got.ExistingID != 1 {
+		t.Errorf("Expected 409 naming email and person 1, got %d: %+v", rr.Code, got)
+	}
+
+	rr = testutil.Do(router, "PATCH", "/api/v1/persons/2", PersonRequest{Email: stringPtr("ann@example.com")})
+	if rr.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a taken email, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = testutil.Do(router, "PATCH", "/api/v1/persons/1", PersonRequest{Email: stringPtr("ANN@example.com")})
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected a person to keep their own email, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = testutil.Do(router, "PATCH", "/api/v1/persons/2", PersonRequest{Email: stringPtr("Bob <bob@example.com>")})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an address with a display name, got %d", rr.Code)
+	}
+}
+
+func TestHandlers_Put(t *testing.T) {
+	testCases := []struct {
+		name         string
+		putCreates   bool
+		target       string
+		expectedCode int
+	}{
+		{"Replace existing", false, "/api/v1/persons/1", http.StatusOK},
+		{"Unknown ID", false, "/api/v1/persons/7", http.StatusNotFound},
+		{"Replace existing with upsert", true, "/api/v1/persons/1", http.StatusOK},
+		{"Create with upsert", true, "/api/v1/persons/7", http.StatusCreated},
+		{"Non-positive ID", true, "/api/v1/persons/0", http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			st := testutil.NewMemoryStore(store.Person{Name: "Ann", Age: int32Ptr(30), Work: stringPtr("Dev")})
+			app := newTestAppWithStore(st)
+			app.cfg.putCreates = tc.putCreates
+			router := withContractCheck(t, app.routes())
+
+			rr := testutil.Do(router, "PUT", tc.target, PersonRequest{Name: stringPtr("Bea"), Age: int32Ptr(40)})
+			if rr.Code != tc.expectedCode {
+				t.Fatalf("Expected %d, got %d: %s", tc.expectedCode, rr.Code, rr.Body.String())
+			}
+			if rr.Code == http.StatusCreated && rr.Header().Get("Location") != tc.target {
+				t.Errorf("Expected Location %s, got %q", tc.target, rr.Header().Get("Location"))
+			}
+			if rr.Code >= 300 {
+				return
+			}
+			var resp PersonResponse
+			if rr.Code == http.StatusOK {
+				if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil || resp.UpdatedAt == nil || rr.Header().Get("Last-Modified") == "" {
+					t.Errorf("Expected the stored person with Last-Modified, got %+v %q (%v)", resp, rr.Header().Get("Last-Modified"), err)
+				}
+			}
+
+			id, _ := strconv.Atoi(tc.target[len("/api/v1/persons/"):])
+			p, err := st.GetPerson(context.Background(), int32(id))
+			if err != nil || p.Name != "Bea" || p.Age == nil || *p.Age != 40 || p.Work != nil {
+				t.Errorf("Expected person to be replaced, got %+v (%v)", p, err)
+			}
+		})
+	}
+}
+
+func TestHandlers_IfUnmodifiedSince(t *testing.T) {
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 500_000_000, time.UTC)
+	testCases := []struct {
+		name         string
+		method       string
+		header       string
+		expectedCode int
+	}{
+		{"Patch, unchanged since", "PATCH", "Fri, 01 Mar 2024 12:00:00 GMT", http.StatusOK},
+		{"Patch, changed since", "PATCH", "Fri, 01 Mar 2024 11:59:59 GMT", http.StatusPreconditionFailed},
+		{"Patch, unparsable date is ignored", "PATCH", "yesterday", http.StatusOK},
+		{"Delete, unchanged since", "DELETE", "Fri, 01 Mar 2024 13:00:00 GMT", http.StatusNoContent},
+		{"Delete, changed since", "DELETE", "Thu, 29 Feb 2024 12:00:00 GMT", http.StatusPreconditionFailed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			st := testutil.NewMemoryStore(store.Person{Name: "Ann", UpdatedAt: modified})
+			router := setupTestRouterWithStore(t, st)
+
+			req := httptest.NewRequest(tc.method, "/api/v1/persons/1", strings.NewReader(`{"age":31}`))
+			req.Header.Set("If-Unmodified-Since", tc.header)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tc.expectedCode {
+				t.Fatalf("Expected %d, got %d: %s", tc.expectedCode, rr.Code, rr.Body.String())
+			}
+			if rr.Code == http.StatusPreconditionFailed {
+				if code := decodeErrorCode(t, rr); code != apierr.PreconditionFail {
+					t.Errorf("Expected %s, got %s", apierr.PreconditionFail, code)
+				}
+				if p, _ := st.GetPerson(context.Background(), 1); p.Age != nil {
+					t.Errorf("Person was modified despite failed precondition: %+v", p)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlers_ListPagination(t *testing.T) {
+	st := testutil.NewMemoryStore(testutil.NewFactory(1).Persons(5)...)
+	app := newTestAppWithStore(st)
+	app.cfg.page = pageLimits{defaultSize: 2, maxSize: 3}
+	router := withContractCheck(t, app.routes())
+
+	testCases := []struct {
+		query string
+		ids   []int32
+		next  string
+	}{
+		{"", []int32{1, 2}, "/api/v1/persons?limit=2&offset=2"},
+		{"?limit=3&offset=3&sort=id", []int32{4, 5}, ""},
+		{"?offset=4", []int32{5}, ""},
+		{"?offset=10", nil, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			rr := testutil.Do(router, "GET", "/api/v1/persons"+tc.query, nil)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+			var list []PersonResponse
+			json.NewDecoder(rr.Body).Decode(&list)
+			var ids []int32
+			for _, p := range list {
+				ids = append(ids, p.ID)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(tc.ids) {
+				t.Errorf("Expected ids %v, got %v", tc.ids, ids)
+			}
+			wantLink := ""
+			if tc.next != "" {
+				wantLink = "<" + tc.next + `>; rel="next"`
+			}
+			if got := rr.Header().Get("Link"); got != wantLink {
+				t.Errorf("Expected Link %q, got %q", wantLink, got)
+			}
+		})
+	}
+
+	rr := testutil.Do(router, "GET", "/api/v1/persons?limit=4", nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 above the configured maximum, got %d", rr.Code)
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	router := app.routes()
+
+	if rr := testutil.Do(router, "GET", "/livez", nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected /livez to be 200, got %d", rr.Code)
+	}
+	if rr := testutil.Do(router, "GET", "/readyz", nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected /readyz with no checks to be 200, got %d", rr.Code)
+	}
+
+	app.health.Register("postgres", func(context.Context) error { return errors.New("connection refused") })
+	rr := testutil.Do(router, "GET", "/readyz", nil)
+	if rr.Code != http.StatusServiceUnavailable || !strings.Contains(rr.Body.String(), `"postgres":{"status":"fail"`) {
+		t.Errorf("Expected failing postgres check, got %d %s", rr.Code, rr.Body.String())
+	}
+	if rr := testutil.Do(router, "GET", "/livez", nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected /livez to ignore dependencies, got %d", rr.Code)
+	}
+}
+
+func TestHandlers_ChangeFeed(t *testing.T) {
+	st := testutil.NewMemoryStore()
+	app := newTestAppWithStore(st)
+	if rr := testutil.Do(withContractCheck(t, app.routes()), "GET", "/api/v1/changes", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 while the feed is disabled, got %d", rr.Code)
+	}
+	app.changes = st
+	router := withContractCheck(t, app.routes())
+
+	testutil.Do(router, "POST", "/api/v1/persons", PersonRequest{Name: stringPtr("Anna")})
+	testutil.Do(router, "PATCH", "/api/v1/persons/1", PersonRequest{Age: int32Ptr(30)})
+	testutil.Do(router, "DELETE", "/api/v1/persons/1", nil)
+
+	testCases := []struct {
+		query string
+		ops   []string
+		next  int64
+	}{
+		{"?limit=2", []string{"insert", "update"}, 2},
+		{"?since=2", []string{"delete"}, 3},
+		{"?since=3", nil, 3},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			rr := testutil.Do(router, "GET", "/api/v1/changes"+tc.query, nil)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+			var resp ChangesResponse
+			json.NewDecoder(rr.Body).Decode(&resp)
+			var ops []string
+			for _, c := range resp.Changes {
+				ops = append(ops, c.Op)
+			}
+			if fmt.Sprint(ops) != fmt.Sprint(tc.ops) || resp.Next != tc.next {
+				t.Errorf("Expected %v next %d, got %v next %d", tc.ops, tc.next, ops, resp.Next)
+			}
+		})
+	}
+
+	for _, query := range []string{"?since=4", "?since=-1", "?since=abc"} {
+		if rr := testutil.Do(router, "GET", "/api/v1/changes"+query, nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rr.Code)
+		}
+	}
+}
+
+func TestHandlers_PersonSnapshot(t *testing.T) {
+	st := testutil.NewMemoryStore()
+	clock := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	st.Now = func() time.Time { return clock }
+	app := newTestAppWithStore(st)
+	app.history = st
+	router := withContractCheck(t, app.routes())
+
+	testutil.Do(router, "POST", "/api/v1/persons", PersonRequest{Name: stringPtr("Anna")})
+	clock = clock.Add(time.Hour)
+	testutil.Do(router, "PATCH", "/api/v1/persons/1", PersonRequest{Name: stringPtr("Anna Petrova")})
+	clock = clock.Add(time.Hour)
+	testutil.Do(router, "DELETE", "/api/v1/persons/1", nil)
+
+	testCases := []struct {
+		at       string
+		wantCode int
+		wantName string
+	}{
+		{"2024-03-01T11:59:59Z", http.StatusNotFound, ""},
+		{"2024-03-01T12:30:00Z", http.StatusOK, "Anna"},
+		{"2024-03-01T13:00:00Z", http.StatusOK, "Anna Petrova"},
+		{"2024-03-01T15:00:00%2B02:00", http.StatusOK, "Anna Petrova"},
+		{"2024-03-01T14:00:00Z", http.StatusNotFound, ""},
+		{"yesterday", http.StatusBadRequest, ""},
+		{"", http.StatusBadRequest, ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.at, func(t *testing.T) {
+			rr := testutil.Do(router, "GET", "/api/v1/persons/1/snapshot?at="+tc.at, nil)
+			if rr.Code != tc.wantCode {
+				t.Fatalf("Expected %d, got %d: %s", tc.wantCode, rr.Code, rr.Body.String())
+			}
+			if tc.wantName != "" {
+				var p PersonResponse
+				json.NewDecoder(rr.Body).Decode(&p)
+				if p.Name != tc.wantName {
+					t.Errorf("Expected %q, got %q", tc.wantName, p.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlers_ListAsOf(t *testing.T) {
+	st := testutil.NewMemoryStore()
+	clock := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	st.Now = func() time.Time { return clock }
+	app := newTestAppWithStore(st)
+	router := withContractCheck(t, app.routes())
+
+	if rr := testutil.Do(router, "GET", "/api/v1/persons?as_of=2024-02-01T00:00:00Z", nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without history, got %d", rr.Code)
+	}
+	app.history = st
+	router = withContractCheck(t, app.routes())
+
+	testutil.Do(router, "POST", "/api/v1/persons", PersonRequest{Name: stringPtr("Anna")})
+	testutil.Do(router, "POST", "/api/v1/persons", PersonRequest{Name: stringPtr("Boris")})
+	clock = clock.Add(24 * time.Hour)
+	testutil.Do(router, "PATCH", "/api/v1/persons/1", PersonRequest{Name: stringPtr("Anna Petrova")})
+	testutil.Do(router, "DELETE", "/api/v1/persons/2", nil)
+	testutil.Do(router, "POST", "/api/v1/persons", PersonRequest{Name: stringPtr("Clara")})
+
+	testCases := []struct {
+		query string
+		names []string
+	}{
+		{"?as_of=2024-01-01T00:00:00Z", nil},
+		{"?as_of=2024-02-01T00:00:00Z", []string{"Anna", "Boris"}},
+		{"?as_of=2024-02-01T00:00:00Z&name=bor", []string{"Boris"}},
+		{"?as_of=2024-02-01T00:00:00Z&sort=-name&limit=1", []string{"Boris"}},
+		{"?as_of=2024-03-01T00:00:00Z", []string{"Anna Petrova", "Clara"}},
+		{"", []string{"Anna Petrova", "Clara"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			rr := testutil.Do(router, "GET", "/api/v1/persons"+tc.query, nil)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+			}
+			var list []PersonResponse
+			json.NewDecoder(rr.Body).Decode(&list)
+			var names []string
+			for _, p := range list {
+				names = append(names, p.Name)
+			}
+			if fmt.Sprint(names) != fmt.Sprint(tc.names) {
+				t.Errorf("Expected %v, got %v", tc.names, names)
+			}
+		})
+	}
+
+	if rr := testutil.Do(router, "GET", "/api/v1/persons?as_of=last-month", nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid as_of, got %d", rr.Code)
+	}
+}
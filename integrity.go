@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/blob"
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+const (
+	integrityBatch = 100
+	// integrityMaxProblems bounds the problems a report lists; the rest are
+	// only counted.
+	integrityMaxProblems = 1000
+
+	problemMissing          = "missing"
+	problemSizeMismatch     = "size_mismatch"
+	problemChecksumMismatch = "checksum_mismatch"
+)
+
+// IntegrityReport is the outcome of reading every attachment back from object
+// storage. Attachments stored before checksums were recorded get theirs from
+// what is read, and are counted in Backfilled.
+type IntegrityReport struct {
+	StartedAt    time.Time          `json:"started_at"`
+	FinishedAt   *time.Time         `json:"finished_at,omitempty"`
+	Checked      int                `json:"checked"`
+	Backfilled   int                `json:"backfilled"`
+	ProblemCount int                `json:"problem_count"`
+	Problems     []IntegrityProblem `json:"problems"`
+	// Error is why the run stopped before checking every attachment.
+	Error string `json:"error,omitempty"`
+}
+
+type IntegrityProblem struct {
+	AttachmentID int64  `json:"attachment_id"`
+	PersonID     int32  `json:"person_id"`
+	ObjectKey    string `json:"object_key"`
+	Problem      string `json:"problem"`
+}
+
+type IntegrityStatus struct {
+	Running bool `json:"running"`
+	// Report is that of the run in progress, or of the last one; null
+	// before the first.
+	Report *IntegrityReport `json:"report"`
+}
+
+// integrityChecker runs one verification at a time and keeps the report of
+// the latest.
+type integrityChecker struct {
+	mu      sync.Mutex
+	running bool
+	report  *IntegrityReport
+}
+
+// start claims the checker for a new run, unless one is in progress.
+func (c *integrityChecker) start() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.running {
+		return false
+	}
+	c.running = true
+	c.report = &IntegrityReport{StartedAt: time.Now().UTC(), Problems: []IntegrityProblem{}}
+	return true
+}
+
+// update changes the report of the run in progress.
+func (c *integrityChecker) update(fn func(*IntegrityReport)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fn(c.report)
+}
+
+func (c *integrityChecker) finish(err error) IntegrityReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now().UTC()
+	c.report.FinishedAt = &now
+	if err != nil {
+		c.report.Error = err.Error()
+	}
+	c.running = false
+	return *c.report
+}
+
+func (c *integrityChecker) status() IntegrityStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := IntegrityStatus{Running: c.running}
+	if c.report != nil {
+		r := *c.report
+		r.Problems = append([]IntegrityProblem{}, c.report.Problems...)
+		s.Report = &r
+	}
+	return s
+}
+
+// reprDigest is the Repr-Digest header (RFC 9530) of content with the hex
+// SHA-256 checksum, or "" without one.
+func reprDigest(checksum string) string {
+	b, err := hex.DecodeString(checksum)
+	if err != nil || len(b) != sha256.Size {
+		return ""
+	}
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(b) + ":"
+}
+
+// verifyAttachmentsEvery verifies the attachments every
+// cfg.integrityCheckInterval until ctx is done.
+func (app *application) verifyAttachmentsEvery(ctx context.Context) {
+	ticker := time.NewTicker(app.cfg.integrityCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !app.integrity.start() {
+			continue
+		}
+		app.verifyAttachments(ctx)
+	}
+}
+
+// verifyAttachments reads every attachment back and compares it with the
+// size and checksum it was stored with, to find objects that went missing
+// or rotted. It is started with app.integrity.start. Object storage or the
+// database failing ends the run, rather than reporting every attachment
+// after as missing.
+func (app *application) verifyAttachments(ctx context.Context) IntegrityReport {
+	slog.InfoContext(ctx, "verifying attachments")
+	var afterID int64
+	var err error
+	for err == nil {
+		var batch []store.Attachment
+		batch, err = app.attachments.AttachmentsAfter(ctx, afterID, integrityBatch)
+		if err != nil || len(batch) == 0 {
+			break
+		}
+		for _, a := range batch {
+			if err = app.verifyAttachment(ctx, a); err != nil {
+				break
+			}
+			afterID = a.ID
+		}
+	}
+	report := app.integrity.finish(err)
+	if err != nil {
+		slog.ErrorContext(ctx, "attachment verification stopped", "checked", report.Checked, "problems", report.ProblemCount, "err", err)
+	} else {
+		slog.InfoContext(ctx, "attachments verified", "checked", report.Checked, "backfilled", report.Backfilled, "problems", report.ProblemCount)
+	}
+	return report
+}
+
+func (app *application) verifyAttachment(ctx context.Context, a store.Attachment) error {
+	problem := ""
+	checksum := ""
+	body, err := app.blobs.Get(ctx, a.ObjectKey)
+	switch {
+	case errors.Is(err, blob.ErrNotFound):
+		problem = problemMissing
+	case err != nil:
+		return err
+	default:
+		h := sha256.New()
+		n, err := io.Copy(h, body)
+		body.Close()
+		if err != nil {
+			return fmt.Errorf("read %s: %w", a.ObjectKey, err)
+		}
+		checksum = hex.EncodeToString(h.Sum(nil))
+		switch {
+		case n != a.Size:
+			problem = problemSizeMismatch
+		case a.Checksum != "" && a.Checksum != checksum:
+			problem = problemChecksumMismatch
+		}
+	}
+
+	backfilled := false
+	if problem == "" && a.Checksum == "" {
+		err := app.attachments.SetAttachmentChecksum(ctx, a.ID, checksum)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		backfilled = err == nil
+	}
+	if problem != "" {
+		slog.ErrorContext(ctx, "attachment failed verification", "attachment_id", a.ID, "key", a.ObjectKey, "problem", problem)
+	}
+	app.integrity.update(func(r *IntegrityReport) {
+		r.Checked++
+		if backfilled {
+			r.Backfilled++
+		}
+		if problem == "" {
+			return
+		}
+		r.ProblemCount++
+		if len(r.Problems) < integrityMaxProblems {
+			r.Problems = append(r.Problems, IntegrityProblem{AttachmentID: a.ID, PersonID: *a.PersonID, ObjectKey: a.ObjectKey, Problem: problem})
+		}
+	})
+	return nil
+}
+
+// startVerification starts verifying the attachments in the background;
+// getVerification follows it.
+func (app *application) startVerification(w http.ResponseWriter, r *http.Request) {
+	if !app.integrity.start() {
+		sendError(w, apierr.Conflict, "A verification is already running")
+		return
+	}
+	slog.InfoContext(r.Context(), "attachment verification started by admin")
+	go app.verifyAttachments(context.WithoutCancel(r.Context()))
+	w.Header().Set("Location", "/admin/integrity")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(app.integrity.status())
+}
+
+func (app *application) getVerification(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(app.integrity.status())
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/blob"
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestVerifyAttachments(t *testing.T) {
+	ctx := context.Background()
+	st := testutil.NewMemoryStore(store.Person{Name: "Ann"})
+	app := newTestAppWithStore(st)
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.blobs, app.attachments = blobs, st
+	app.cfg.adminToken = "s3cret"
+	router := app.routes()
+
+	sum := func(s string) string {
+		h := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+	personID := int32(1)
+	add := func(key, stored, checksum string, size int64) {
+		if stored != "" {
+			if err := blobs.Put(ctx, key, strings.NewReader(stored), int64(len(stored)), ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := st.CreateAttachment(ctx, store.Attachment{PersonID: &personID, ObjectKey: key, Filename: "a.pdf", Size: size, Checksum: checksum}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add("attachments/1/intact", "%PDF-1", sum("%PDF-1"), 6)
+	add("attachments/1/missing", "", sum("%PDF-2"), 6)
+	add("attachments/1/rotted", "%PDF-X", sum("%PDF-3"), 6)
+	add("attachments/1/truncated", "%PDF", sum("%PDF-4"), 6)
+	add("attachments/1/legacy", "%PDF-5", "", 6)
+
+	do := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	var status IntegrityStatus
+	json.NewDecoder(do("GET", "/admin/integrity").Body).Decode(&status)
+	if status.Running || status.Report != nil {
+		t.Errorf("Expected no report before the first run, got %+v", status)
+	}
+
+	rr := do("POST", "/admin/integrity/verify")
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202, got %d: %s", rr.Code, rr.Body.String())
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		status = IntegrityStatus{}
+		json.NewDecoder(do("GET", "/admin/integrity").Body).Decode(&status)
+		if !status.Running || time.Now().After(deadline) {
+			break
+		}
+	}
+	report := status.Report
+	if status.Running || report == nil || report.FinishedAt == nil || report.Error != "" {
+		t.Fatalf("Expected the run to finish, got %+v", status)
+	}
+	want := []IntegrityProblem{
+		{AttachmentID: 2, PersonID: 1, ObjectKey: "attachments/1/missing", Problem: problemMissing},
+		{AttachmentID: 3, PersonID: 1, ObjectKey: "attachments/1/rotted", Problem: problemChecksumMismatch},
+		{AttachmentID: 4, PersonID: 1, ObjectKey: "attachments/1/truncated", Problem: problemSizeMismatch},
+	}
+	if report.Checked != 5 || report.Backfilled != 1 || report.ProblemCount != 3 || !reflect.DeepEqual(report.Problems, want) {
+		t.Errorf("Unexpected report %+v", report)
+	}
+	if a, _ := st.Attachment(ctx, 1, 5); a.Checksum != sum("%PDF-5") {
+		t.Errorf("Expected the legacy attachment to get its checksum, got %q", a.Checksum)
+	}
+
+	app.integrity.start()
+	if rr := do("POST", "/admin/integrity/verify"); rr.Code != http.StatusConflict || decodeErrorCode(t, rr) != apierr.Conflict {
+		t.Errorf("Expected a second run to be refused, got %d", rr.Code)
+	}
+}
+
+func TestReprDigest(t *testing.T) {
+	// The SHA-256 of "hello".
+	got := reprDigest("2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824")
+	if want := "sha-256=:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=:"; got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+	if got := reprDigest(""); got != "" {
+		t.Errorf("Expected no digest without a checksum, got %s", got)
+	}
+}
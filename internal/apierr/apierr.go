@@ -0,0 +1,105 @@
+// Package apierr is the catalog of machine-readable error codes returned in
+// the "code" field of every error response. Codes are part of the public API:
+// never rename or reuse one, only add new ones.
+package apierr
+
+import "net/http"
+
+type Code string
+
+const (
+	ValidationFailed Code = "VALIDATION_FAILED"
+	InvalidJSON      Code = "INVALID_JSON"
+	InvalidID        Code = "INVALID_ID"
+	PersonNotFound   Code = "PERSON_NOT_FOUND"
+	Conflict         Code = "CONFLICT"
+	RouteNotFound    Code = "ROUTE_NOT_FOUND"
+	MethodNotAllowed Code = "METHOD_NOT_ALLOWED"
+	DBUnavailable    Code = "DB_UNAVAILABLE"
+	DBError          Code = "DB_ERROR"
+	Internal         Code = "INTERNAL_ERROR"
+	Timeout          Code = "REQUEST_TIMEOUT"
+	Overloaded       Code = "SERVER_OVERLOADED"
+	TooManyRequests  Code = "TOO_MANY_REQUESTS"
+	LockTimeout      Code = "LOCK_TIMEOUT"
+	PreconditionFail Code = "PRECONDITION_FAILED"
+	Unauthorized     Code = "UNAUTHORIZED"
+	QuotaExceeded    Code = "QUOTA_EXCEEDED"
+	Forbidden        Code = "FORBIDDEN"
+	APIKeyNotFound   Code = "API_KEY_NOT_FOUND"
+	TOTPRequired     Code = "TOTP_REQUIRED"
+	// AddressUnverified comes with suggestions from the address validator.
+	AddressUnverified Code = "ADDRESS_UNVERIFIED"
+	// ConstraintViolation is a value the database refused although it passed
+	// validation, e.g. too long for its column.
+	ConstraintViolation Code = "CONSTRAINT_VIOLATION"
+	AttachmentNotFound  Code = "ATTACHMENT_NOT_FOUND"
+	LengthRequired      Code = "LENGTH_REQUIRED"
+	PayloadTooLarge     Code = "PAYLOAD_TOO_LARGE"
+	// StorageUnavailable means the object storage keeping attachments failed.
+	StorageUnavailable Code = "STORAGE_UNAVAILABLE"
+	// UploadNotFound means nothing was stored through a presigned upload URL.
+	UploadNotFound Code = "UPLOAD_NOT_FOUND"
+	// MalwareDetected refuses an infected upload, which is quarantined.
+	MalwareDetected    Code = "MALWARE_DETECTED"
+	ScannerUnavailable Code = "SCANNER_UNAVAILABLE"
+	PhotoNotFound      Code = "PHOTO_NOT_FOUND"
+	// UnsupportedImage refuses a photo that is not an image in a format
+	// photos are accepted in.
+	UnsupportedImage Code = "UNSUPPORTED_IMAGE"
+	// FileTypeNotAllowed and ContentTypeMismatch refuse an upload for what
+	// its content turned out to be.
+	FileTypeNotAllowed  Code = "FILE_TYPE_NOT_ALLOWED"
+	ContentTypeMismatch Code = "CONTENT_TYPE_MISMATCH"
+	// RangeNotSatisfiable refuses a download of a range beyond the file.
+	RangeNotSatisfiable Code = "RANGE_NOT_SATISFIABLE"
+	JobNotFound         Code = "JOB_NOT_FOUND"
+	FieldNotFound       Code = "FIELD_NOT_FOUND"
+)
+
+var statuses = map[Code]int{
+	ValidationFailed:    http.StatusBadRequest,
+	InvalidJSON:         http.StatusBadRequest,
+	InvalidID:           http.StatusBadRequest,
+	PersonNotFound:      http.StatusNotFound,
+	Conflict:            http.StatusConflict,
+	RouteNotFound:       http.StatusNotFound,
+	MethodNotAllowed:    http.StatusMethodNotAllowed,
+	DBUnavailable:       http.StatusServiceUnavailable,
+	DBError:             http.StatusInternalServerError,
+	Internal:            http.StatusInternalServerError,
+	Timeout:             http.StatusGatewayTimeout,
+	Overloaded:          http.StatusServiceUnavailable,
+	TooManyRequests:     http.StatusTooManyRequests,
+	LockTimeout:         http.StatusServiceUnavailable,
+	PreconditionFail:    http.StatusPreconditionFailed,
+	Unauthorized:        http.StatusUnauthorized,
+	QuotaExceeded:       http.StatusTooManyRequests,
+	Forbidden:           http.StatusForbidden,
+	APIKeyNotFound:      http.StatusNotFound,
+	TOTPRequired:        http.StatusUnauthorized,
+	AddressUnverified:   http.StatusUnprocessableEntity,
+	ConstraintViolation: http.StatusUnprocessableEntity,
+	AttachmentNotFound:  http.StatusNotFound,
+	LengthRequired:      http.StatusLengthRequired,
+	PayloadTooLarge:     http.StatusRequestEntityTooLarge,
+	StorageUnavailable:  http.StatusServiceUnavailable,
+	UploadNotFound:      http.StatusNotFound,
+	MalwareDetected:     http.StatusUnprocessableEntity,
+	ScannerUnavailable:  http.StatusServiceUnavailable,
+	PhotoNotFound:       http.StatusNotFound,
+	UnsupportedImage:    http.StatusUnsupportedMediaType,
+	FileTypeNotAllowed:  http.StatusUnsupportedMediaType,
+	ContentTypeMismatch: http.StatusUnsupportedMediaType,
+	RangeNotSatisfiable: http.StatusRequestedRangeNotSatisfiable,
+	JobNotFound:         http.StatusNotFound,
+	FieldNotFound:       http.StatusNotFound,
+}
+
+// Status is the HTTP status that accompanies the code. Unknown codes map to 500.
+func (c Code) Status() int {
+	if s, ok := statuses[c]; ok {
+		return s
+	}
+	return http.StatusInternalServerError
+}
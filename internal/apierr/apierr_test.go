@@ -0,0 +1,24 @@
+package apierr
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestEveryCodeHasStatus(t *testing.T) {
+	for _, c := range []Code{
+		ValidationFailed, InvalidJSON, InvalidID, PersonNotFound, Conflict, RouteNotFound, MethodNotAllowed, DBUnavailable,
+		DBError, Internal, Timeout, Overloaded, TooManyRequests, LockTimeout, PreconditionFail, Unauthorized,
+		QuotaExceeded, Forbidden, APIKeyNotFound, TOTPRequired, AddressUnverified,
+		ConstraintViolation, AttachmentNotFound, UploadNotFound, LengthRequired, PayloadTooLarge, StorageUnavailable,
+		MalwareDetected, ScannerUnavailable, PhotoNotFound, UnsupportedImage,
+		FileTypeNotAllowed, ContentTypeMismatch, RangeNotSatisfiable, JobNotFound, FieldNotFound,
+	} {
+		if _, ok := statuses[c]; !ok {
+			t.Errorf("Code %s is missing from the status catalog", c)
+		}
+	}
+	if got := Code("SOMETHING_NEW").Status(); got != http.StatusInternalServerError {
+		t.Errorf("Expected unknown code to map to 500, got %d", got)
+	}
+}
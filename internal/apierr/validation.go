@@ -0,0 +1,71 @@
+package apierr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Message keys for field validation failures. Like codes, keys are part of the
+// public API: clients and the i18n layer look them up to render their own text.
+const (
+	KeyRequired    = "validation.required"
+	KeyMaxLength   = "validation.max_length"
+	KeyMinLength   = "validation.min_length"
+	KeyMinValue    = "validation.min_value"
+	KeyMaxValue    = "validation.max_value"
+	KeyInvalidJSON = "validation.invalid_json"
+	KeyRejected    = "validation.rejected"
+	KeyNotInteger  = "validation.not_integer"
+	KeyOneOf       = "validation.one_of"
+	KeyNotTime     = "validation.not_time"
+	KeyNotNumber   = "validation.not_number"
+	KeyUnverified  = "validation.unverified"
+	KeyPhone       = "validation.phone"
+	KeyType        = "validation.type"
+	KeyPattern     = "validation.pattern"
+)
+
+var englishTemplates = map[string]string{
+	KeyRequired:    "{field} is required",
+	KeyMaxLength:   "{field} must be at most {limit} characters, got {actual}",
+	KeyMinLength:   "{field} must be at least {limit} characters, got {actual}",
+	KeyMinValue:    "{field} must be at least {limit}, got {actual}",
+	KeyMaxValue:    "{field} must be at most {limit}, got {actual}",
+	KeyInvalidJSON: "invalid json format",
+	KeyRejected:    "{field} was rejected: {reason}",
+	KeyNotInteger:  "{field} must be an integer, got {actual}",
+	KeyOneOf:       "{field} must be one of {allowed}, got {actual}",
+	KeyNotTime:     "{field} must be an RFC 3339 timestamp, got {actual}",
+	KeyNotNumber:   "{field} must be a number, got {actual}",
+	KeyUnverified:  "{field} could not be verified",
+	KeyPhone:       "{field} must be a valid phone number, got {actual}",
+	KeyType:        "{field} must be a {type}, got {actual}",
+	KeyPattern:     "{field} must match {pattern}, got {actual}",
+}
+
+// FieldError is a single validation failure. Key and Params are meant for
+// machines; Message is the English rendering kept for convenience.
+type FieldError struct {
+	Field   string         `json:"field"`
+	Key     string         `json:"key"`
+	Params  map[string]any `json:"params,omitempty"`
+	Message string         `json:"message"`
+}
+
+// NewFieldError builds a FieldError and renders its English message. The field
+// name is always available to templates as {field}.
+func NewFieldError(field, key string, params map[string]any) FieldError {
+	return FieldError{Field: field, Key: key, Params: params, Message: Render(key, field, params)}
+}
+
+func Render(key, field string, params map[string]any) string {
+	tmpl, ok := englishTemplates[key]
+	if !ok {
+		tmpl = key
+	}
+	replacements := []string{"{field}", field}
+	for name, v := range params {
+		replacements = append(replacements, "{"+name+"}", fmt.Sprint(v))
+	}
+	return strings.NewReplacer(replacements...).Replace(tmpl)
+}
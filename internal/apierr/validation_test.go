@@ -0,0 +1,20 @@
+package apierr
+
+import "testing"
+
+func TestNewFieldError(t *testing.T) {
+	fe := NewFieldError("name", KeyMaxLength, map[string]any{"limit": 255, "actual": 300})
+
+	if fe.Key != KeyMaxLength || fe.Params["limit"] != 255 || fe.Params["actual"] != 300 {
+		t.Errorf("Unexpected field error: %+v", fe)
+	}
+	if want := "name must be at most 255 characters, got 300"; fe.Message != want {
+		t.Errorf("Expected message %q, got %q", want, fe.Message)
+	}
+}
+
+func TestRenderUnknownKey(t *testing.T) {
+	if got := Render("validation.custom", "age", nil); got != "validation.custom" {
+		t.Errorf("Expected unknown key to render as itself, got %q", got)
+	}
+}
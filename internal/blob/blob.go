@@ -0,0 +1,61 @@
+// Package blob stores files such as attachments outside the database, on the
+// local disk or in an S3-compatible object store like AWS S3 or MinIO. Keys
+// are slash-separated paths; the database keeps the key of every object it
+// references, so objects nothing references can be found and deleted.
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+// ErrNotFound means no object has the key.
+var ErrNotFound = errors.New("object not found")
+
+// Object describes a stored object.
+type Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+type Store interface {
+	// Put stores the size bytes of r under key, replacing any object there.
+	// Readers never see a partly written object.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the object for reading; the caller closes it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetRange opens length bytes of the object starting at offset, which
+	// must lie within it.
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (Object, error)
+	// Copy stores a copy of the object at src under dst, replacing any
+	// object there.
+	Copy(ctx context.Context, src, dst string) error
+	// Delete removes the object. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+	// List calls fn for every object whose key starts with prefix, stopping
+	// at the first error fn returns.
+	List(ctx context.Context, prefix string, fn func(Object) error) error
+}
+
+// Presigner is a Store that can hand out URLs through which clients read or
+// write an object directly, without credentials, until the URLs expire.
+type Presigner interface {
+	PresignGet(key string, expires time.Duration, contentType, filename string) (string, error)
+	PresignPut(key string, expires time.Duration, size int64) (string, error)
+}
+
+// checkKey refuses keys that could escape the store's directory or prefix.
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, `\`) || path.Clean(key) != key ||
+		key == ".." || strings.HasPrefix(key, "../") {
+		return fmt.Errorf("invalid object key %q", key)
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+package blob
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Config locates a bucket of AWS S3 or a compatible store such as MinIO.
+type S3Config struct {
+	// Endpoint is the base URL of the service, e.g. http://minio:9000.
+	// Empty means AWS S3 in Region.
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	// Prefix is prepended to every key, so several deployments can share a
+	// bucket.
+	Prefix string
+	// PathStyle addresses the bucket as a path of the endpoint instead of a
+	// subdomain, which MinIO and most self-hosted stores need.
+	PathStyle bool
+	// PublicEndpoint replaces Endpoint in presigned URLs, for stores that
+	// clients reach under another name than the API does.
+	PublicEndpoint string
+}
+
+// S3 keeps objects in an S3 bucket. Requests are signed with the configured
+// credentials; payloads are left unsigned so uploads are streamed.
+type S3 struct {
+	base   *url.URL
+	public *url.URL
+	bucket string
+	prefix string
+	signer signer
+	client *http.Client
+	now    func() time.Time
+}
+
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3: bucket is not set")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	base, err := bucketURL(endpoint, cfg)
+	if err != nil {
+		return nil, err
+	}
+	public := base
+	if cfg.PublicEndpoint != "" {
+		if public, err = bucketURL(cfg.PublicEndpoint, cfg); err != nil {
+			return nil, err
+		}
+	}
+	return &S3{
+		base:   base,
+		public: public,
+		bucket: cfg.Bucket,
+		prefix: cfg.Prefix,
+		signer: signer{accessKey: cfg.AccessKey, secretKey: cfg.SecretKey, region: cfg.Region},
+		// No overall timeout: bodies of large objects take as long as they
+		// take, bounded by the caller's context.
+		client: &http.Client{},
+		now:    time.Now,
+	}, nil
+}
+
+func bucketURL(endpoint string, cfg S3Config) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("s3: invalid endpoint %q", endpoint)
+	}
+	if cfg.PathStyle {
+		u.Path += "/" + cfg.Bucket
+	} else {
+		u.Host = cfg.Bucket + "." + u.Host
+	}
+	return u, nil
+}
+
+// url addresses the object at key, or the bucket itself for an empty key.
+func (s *S3) url(key string, query url.Values) *url.URL {
+	return objectURL(s.base, s.prefix+key, query)
+}
+
+func objectURL(base *url.URL, key string, query url.Values) *url.URL {
+	u := *base
+	if key != "" {
+		u.Path += "/" + key
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.RawPath = awsEscape(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+func (s *S3) do(ctx context.Context, method string, u *url.URL) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.signer.sign(req, unsignedPayload, s.now())
+	return s.client.Do(req)
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.url(key, nil).String(), io.NopCloser(r))
+	if err != nil {
+		return fmt.Errorf("put %s: %w", key, err)
+	}
+	// S3 refuses chunked uploads, so the length must be known.
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.signer.sign(req, unsignedPayload, s.now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("put %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("put %s: %w", key, s3Error(resp))
+	}
+	return nil
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.get(ctx, key, "", http.StatusOK)
+}
+
+func (s *S3) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	return s.get(ctx, key, fmt.Sprintf("bytes=%d-%d", offset, offset+length-1), http.StatusPartialContent)
+}
+
+func (s *S3) get(ctx context.Context, key, byteRange string, want int) (io.ReadCloser, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url(key, nil).String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", key, err)
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+	s.signer.sign(req, unsignedPayload, s.now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("get %s: %w", key, err)
+	}
+	if resp.StatusCode != want {
+		defer resp.Body.Close()
+		return nil, fmt.Errorf("get %s: %w", key, s3Error(resp))
+	}
+	return resp.Body, nil
+}
+
+// Copy has S3 copy the object, so its content never passes through here.
+func (s *S3) Copy(ctx context.Context, src, dst string) error {
+	if err := checkKey(src); err != nil {
+		return err
+	}
+	if err := checkKey(dst); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.url(dst, nil).String(), http.NoBody)
+	if err != nil {
+		return fmt.Errorf("copy %s: %w", src, err)
+	}
+	req.Header.Set("X-Amz-Copy-Source", awsEscape("/"+s.bucket+"/"+s.prefix+src, false))
+	s.signer.sign(req, unsignedPayload, s.now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("copy %s: %w", src, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("copy %s: %w", src, s3Error(resp))
+	}
+	// A copy that fails once started is still answered with 200, the error
+	// being in the body.
+	var result struct{ XMLName xml.Name }
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("copy %s: decode result: %w", src, err)
+	}
+	if result.XMLName.Local != "CopyObjectResult" {
+		return fmt.Errorf("copy %s: s3 answered %s", src, result.XMLName.Local)
+	}
+	return nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, s.url(key, nil))
+	if err != nil {
+		return fmt.Errorf("delete %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("delete %s: %w", key, s3Error(resp))
+	}
+	return nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (Object, error) {
+	if err := checkKey(key); err != nil {
+		return Object{}, err
+	}
+	resp, err := s.do(ctx, http.MethodHead, s.url(key, nil))
+	if err != nil {
+		return Object{}, fmt.Errorf("stat %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Object{}, fmt.Errorf("stat %s: %w", key, s3Error(resp))
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return Object{Key: key, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+// PresignGet serves the object as contentType, as a download named filename.
+func (s *S3) PresignGet(key string, expires time.Duration, contentType, filename string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	q := url.Values{
+		"response-content-type":        {contentType},
+		"response-content-disposition": {mime.FormatMediaType("attachment", map[string]string{"filename": filename})},
+	}
+	return s.signer.presign(http.MethodGet, objectURL(s.public, s.prefix+key, q), nil, expires, s.now()).String(), nil
+}
+
+// PresignPut signs the Content-Length, so the upload must be exactly size
+// bytes.
+func (s *S3) PresignPut(key string, expires time.Duration, size int64) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	header := http.Header{"Content-Length": {strconv.FormatInt(size, 10)}}
+	return s.signer.presign(http.MethodPut, objectURL(s.public, s.prefix+key, nil), header, expires, s.now()).String(), nil
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// List pages through ListObjectsV2, a thousand keys at a time.
+func (s *S3) List(ctx context.Context, prefix string, fn func(Object) error) error {
+	q := url.Values{"list-type": {"2"}, "prefix": {s.prefix + prefix}}
+	for {
+		page, err := s.listPage(ctx, q)
+		if err != nil {
+			return fmt.Errorf("list %s: %w", prefix, err)
+		}
+		for _, c := range page.Contents {
+			if err := fn(Object{Key: strings.TrimPrefix(c.Key, s.prefix), Size: c.Size, ModTime: c.LastModified}); err != nil {
+				return fmt.Errorf("list %s: %w", prefix, err)
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		q.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+func (s *S3) listPage(ctx context.Context, q url.Values) (listBucketResult, error) {
+	var page listBucketResult
+	resp, err := s.do(ctx, http.MethodGet, objectURL(s.base, "", q))
+	if err != nil {
+		return page, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return page, s3Error(resp)
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return page, fmt.Errorf("decode listing: %w", err)
+	}
+	return page, nil
+}
+
+// s3Error turns an error response into an error, ErrNotFound for a missing
+// object.
+func s3Error(resp *http.Response) error {
+	var body struct {
+		Code    string
+		Message string
+	}
+	xml.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&body)
+	if resp.StatusCode == http.StatusNotFound && body.Code != "NoSuchBucket" {
+		return ErrNotFound
+	}
+	return fmt.Errorf("s3 answered %d %s: %s", resp.StatusCode, body.Code, body.Message)
+}
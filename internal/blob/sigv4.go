@@ -0,0 +1,145 @@
+package blob
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat = "20060102T150405Z"
+	// unsignedPayload lets bodies be streamed instead of hashed up front.
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// signer signs S3 requests with AWS Signature Version 4.
+type signer struct {
+	accessKey string
+	secretKey string
+	region    string
+}
+
+// sign adds the Authorization header to req. Host, Content-Type, Range and
+// every X-Amz header set on req are signed.
+func (s signer) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	req.Header.Set("X-Amz-Date", now.Format(sigV4TimeFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signed := http.Header{}
+	for name, values := range req.Header {
+		if lower := strings.ToLower(name); lower == "content-type" || lower == "range" || strings.HasPrefix(lower, "x-amz-") {
+			signed[name] = values
+		}
+	}
+	canonical, signedHeaders := canonicalHeaders(req.URL.Host, signed)
+	signature := s.signature(req.Method, req.URL, canonical, signedHeaders, payloadHash, now)
+	req.Header.Set("Authorization", sigV4Algorithm+" Credential="+s.accessKey+"/"+s.scope(now)+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// presign returns u with query parameters authorizing method on it for
+// expires after now, for clients without credentials. The headers in header
+// are signed, so the client must send them as given.
+func (s signer) presign(method string, u *url.URL, header http.Header, expires time.Duration, now time.Time) *url.URL {
+	now = now.UTC()
+	canonical, signedHeaders := canonicalHeaders(u.Host, header)
+	q := u.Query()
+	q.Set("X-Amz-Algorithm", sigV4Algorithm)
+	q.Set("X-Amz-Credential", s.accessKey+"/"+s.scope(now))
+	q.Set("X-Amz-Date", now.Format(sigV4TimeFormat))
+	q.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	q.Set("X-Amz-SignedHeaders", signedHeaders)
+	presigned := *u
+	presigned.RawQuery = canonicalQuery(q)
+	q.Set("X-Amz-Signature", s.signature(method, &presigned, canonical, signedHeaders, unsignedPayload, now))
+	presigned.RawQuery = canonicalQuery(q)
+	return &presigned
+}
+
+// canonicalHeaders lists host and header in the canonical form, along with
+// their names.
+func canonicalHeaders(host string, header http.Header) (canonical, signedHeaders string) {
+	headers := map[string]string{"host": host}
+	for name, values := range header {
+		headers[strings.ToLower(name)] = strings.Join(strings.Fields(strings.Join(values, ",")), " ")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + ":" + headers[name] + "\n")
+	}
+	return b.String(), strings.Join(names, ";")
+}
+
+func (s signer) scope(now time.Time) string {
+	return now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+}
+
+// signature signs the canonical form of a request.
+func (s signer) signature(method string, u *url.URL, canonicalHeaders, signedHeaders, payloadHash string, now time.Time) string {
+	canonicalRequest := strings.Join([]string{
+		method,
+		u.EscapedPath(),
+		canonicalQuery(u.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	stringToSign := strings.Join([]string{sigV4Algorithm, now.Format(sigV4TimeFormat), s.scope(now), hexSHA256(canonicalRequest)}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), now.Format("20060102"))
+	for _, part := range []string{s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func canonicalQuery(q url.Values) string {
+	pairs := make([]string, 0, len(q))
+	for k, vs := range q {
+		for _, v := range vs {
+			pairs = append(pairs, awsEscape(k, true)+"="+awsEscape(v, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// awsEscape percent-encodes everything but unreserved characters, and
+// slashes unless escapeSlash is set, as Signature Version 4 expects.
+func awsEscape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !escapeSlash {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,113 @@
+// Package discovery registers the service with a service registry, so that
+// clients find its instances without static configuration.
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Service is an instance as registered. Check is the URL the registry polls
+// every CheckInterval to tell whether it is healthy.
+type Service struct {
+	ID      string
+	Name    string
+	Address string
+	Port    int
+	Tags    []string
+
+	Check         string
+	CheckInterval time.Duration
+	// DeregisterAfter drops an instance that stayed unhealthy that long, for
+	// those that died without deregistering. Zero keeps them.
+	DeregisterAfter time.Duration
+}
+
+// Consul registers services with the local Consul agent over its HTTP API.
+type Consul struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+// NewConsul talks to the agent at addr, a base URL such as
+// http://127.0.0.1:8500, with an ACL token unless token is empty.
+func NewConsul(addr, token string) *Consul {
+	return &Consul{
+		addr:   strings.TrimSuffix(addr, "/"),
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type consulCheck struct {
+	HTTP                           string `json:"HTTP"`
+	Interval                       string `json:"Interval"`
+	DeregisterCriticalServiceAfter string `json:"DeregisterCriticalServiceAfter,omitempty"`
+}
+
+type consulService struct {
+	ID      string       `json:"ID"`
+	Name    string       `json:"Name"`
+	Address string       `json:"Address,omitempty"`
+	Port    int          `json:"Port"`
+	Tags    []string     `json:"Tags,omitempty"`
+	Check   *consulCheck `json:"Check,omitempty"`
+}
+
+// Register adds s to the agent's catalog, replacing any registration with
+// the same ID.
+func (c *Consul) Register(ctx context.Context, s Service) error {
+	body := consulService{ID: s.ID, Name: s.Name, Address: s.Address, Port: s.Port, Tags: s.Tags}
+	if s.Check != "" {
+		body.Check = &consulCheck{HTTP: s.Check, Interval: s.CheckInterval.String()}
+		if s.DeregisterAfter > 0 {
+			body.Check.DeregisterCriticalServiceAfter = s.DeregisterAfter.String()
+		}
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	if err := c.put(ctx, "/v1/agent/service/register", b); err != nil {
+		return fmt.Errorf("register %s: %w", s.ID, err)
+	}
+	return nil
+}
+
+func (c *Consul) Deregister(ctx context.Context, id string) error {
+	if err := c.put(ctx, "/v1/agent/service/deregister/"+url.PathEscape(id), nil); err != nil {
+		return fmt.Errorf("deregister %s: %w", id, err)
+	}
+	return nil
+}
+
+func (c *Consul) put(ctx context.Context, path string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.addr+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return fmt.Errorf("consul: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestConsul(t *testing.T) {
+	var registered map[string]any
+	var deregistered string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.Header.Get("X-Consul-Token") != "token" {
+			http.Error(w, "ACL not found", http.StatusForbidden)
+			return
+		}
+		switch {
+		case r.URL.Path == "/v1/agent/service/register":
+			json.NewDecoder(r.Body).Decode(&registered)
+		case len(r.URL.Path) > len("/v1/agent/service/deregister/"):
+			deregistered = r.URL.Path[len("/v1/agent/service/deregister/"):]
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	c := NewConsul(srv.URL+"/", "token")
+	err := c.Register(ctx, Service{
+		ID: "persons-1", Name: "persons", Address: "10.0.0.1", Port: 8080, Tags: []string{"v1"},
+		Check: "http://10.0.0.1:8080/readyz", CheckInterval: 10 * time.Second, DeregisterAfter: time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"ID": "persons-1", "Name": "persons", "Address": "10.0.0.1", "Port": float64(8080), "Tags": []any{"v1"},
+		"Check": map[string]any{"HTTP": "http://10.0.0.1:8080/readyz", "Interval": "10s", "DeregisterCriticalServiceAfter": "1m0s"},
+	}
+	if !reflect.DeepEqual(registered, want) {
+		t.Errorf("Expected registration\n%v, got\n%v", want, registered)
+	}
+
+	if err := c.Deregister(ctx, "persons-1"); err != nil || deregistered != "persons-1" {
+		t.Errorf("Expected persons-1 to be deregistered, got %q %v", deregistered, err)
+	}
+
+	if err := NewConsul(srv.URL, "").Register(ctx, Service{ID: "x"}); err == nil {
+		t.Error("Expected a refused registration to fail")
+	}
+}
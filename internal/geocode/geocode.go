@@ -0,0 +1,149 @@
+// Package geocode talks to external address services: providers that turn
+// addresses into coordinates and validators that check addresses exist.
+// Both are slow and often paid per request, so they are meant to be used
+// behind a cache, and providers also behind Limited.
+package geocode
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotFound means the provider has no location for the address.
+	ErrNotFound = errors.New("address not found")
+	// ErrUnavailable means the provider could not be asked or failed to
+	// answer; the same address may work later.
+	ErrUnavailable = errors.New("geocoder unavailable")
+)
+
+// Point is a WGS 84 location in degrees.
+type Point struct {
+	Lat, Lon float64
+}
+
+type Provider interface {
+	Geocode(ctx context.Context, address string) (Point, error)
+}
+
+// normalize makes addresses that differ only in case and spacing share a cache
+// entry.
+func normalize(address string) string {
+	return strings.ToLower(strings.Join(strings.Fields(address), " "))
+}
+
+// cache maps normalized addresses to answers for ttl. It holds at most
+// maxEntries; when full, expired entries are dropped first and everything if
+// that is not enough.
+type cache[V any] struct {
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry[V]
+}
+
+type cacheEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+func newCache[V any](ttl time.Duration, maxEntries int) *cache[V] {
+	return &cache[V]{ttl: ttl, maxEntries: maxEntries, now: time.Now, entries: make(map[string]cacheEntry[V])}
+}
+
+func (c *cache[V]) get(address string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[normalize(address)]
+	if !ok || !c.now().Before(e.expires) {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
+
+func (c *cache[V]) put(address string, v V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= c.maxEntries {
+		now := c.now()
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.maxEntries {
+			clear(c.entries)
+		}
+	}
+	c.entries[normalize(address)] = cacheEntry[V]{value: v, expires: c.now().Add(c.ttl)}
+}
+
+// Cached remembers answers, including ErrNotFound, by normalized address.
+type Cached struct {
+	Provider
+	cache *cache[geocodeAnswer]
+}
+
+type geocodeAnswer struct {
+	point Point
+	err   error
+}
+
+func NewCached(p Provider, ttl time.Duration, maxEntries int) *Cached {
+	return &Cached{Provider: p, cache: newCache[geocodeAnswer](ttl, maxEntries)}
+}
+
+func (c *Cached) Geocode(ctx context.Context, address string) (Point, error) {
+	if a, ok := c.cache.get(address); ok {
+		return a.point, a.err
+	}
+	point, err := c.Provider.Geocode(ctx, address)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return Point{}, err
+	}
+	c.cache.put(address, geocodeAnswer{point: point, err: err})
+	return point, err
+}
+
+// Limited spaces calls to the provider at least interval apart, as public
+// providers ask (Nominatim allows one request per second). Callers wait their
+// turn or give up when ctx is done.
+type Limited struct {
+	Provider
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func NewLimited(p Provider, interval time.Duration) *Limited {
+	return &Limited{Provider: p, interval: interval}
+}
+
+func (l *Limited) Geocode(ctx context.Context, address string) (Point, error) {
+	l.mu.Lock()
+	now := time.Now()
+	at := l.next
+	if at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(l.interval)
+	l.mu.Unlock()
+
+	if wait := time.Until(at); wait > 0 {
+		t := time.NewTimer(wait)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return Point{}, ctx.Err()
+		case <-t.C:
+		}
+	}
+	return l.Provider.Geocode(ctx, address)
+}
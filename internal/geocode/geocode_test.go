@@ -0,0 +1,180 @@
+package geocode
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type countingProvider struct {
+	mu    sync.Mutex
+	calls []string
+	err   error
+}
+
+func (p *countingProvider) Geocode(ctx context.Context, address string) (Point, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls = append(p.calls, address)
+	if p.err != nil {
+		return Point{}, p.err
+	}
+	return Point{Lat: 1, Lon: 2}, nil
+}
+
+func TestCached(t *testing.T) {
+	p := &countingProvider{}
+	c := NewCached(p, time.Hour, 10)
+	now := time.Now()
+	c.cache.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	c.Geocode(ctx, "1 Main Street")
+	if pt, err := c.Geocode(ctx, "  1 main   STREET"); err != nil || pt != (Point{1, 2}) {
+		t.Errorf("Expected the cached point, got %v %v", pt, err)
+	}
+	if len(p.calls) != 1 {
+		t.Errorf("Expected one provider call for the same address, got %v", p.calls)
+	}
+	now = now.Add(2 * time.Hour)
+	c.Geocode(ctx, "1 Main Street")
+	if len(p.calls) != 2 {
+		t.Errorf("Expected an expired entry to be asked again, got %v", p.calls)
+	}
+
+	p.err = ErrNotFound
+	c.Geocode(ctx, "Nowhere")
+	if _, err := c.Geocode(ctx, "Nowhere"); !errors.Is(err, ErrNotFound) || len(p.calls) != 3 {
+		t.Errorf("Expected a cached ErrNotFound, got %v after %v", err, p.calls)
+	}
+	p.err = ErrUnavailable
+	c.Geocode(ctx, "Elsewhere")
+	c.Geocode(ctx, "Elsewhere")
+	if len(p.calls) != 5 {
+		t.Errorf("Expected failures not to be cached, got %v", p.calls)
+	}
+}
+
+func TestCachedEviction(t *testing.T) {
+	p := &countingProvider{}
+	c := NewCached(p, time.Hour, 2)
+	ctx := context.Background()
+	for _, a := range []string{"a", "b", "c"} {
+		c.Geocode(ctx, a)
+	}
+	if len(c.cache.entries) > 2 {
+		t.Errorf("Expected at most 2 entries, got %d", len(c.cache.entries))
+	}
+}
+
+func TestLimited(t *testing.T) {
+	p := &countingProvider{}
+	l := NewLimited(p, 50*time.Millisecond)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		l.Geocode(ctx, "a")
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected three calls to take at least two intervals, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	l = NewLimited(p, time.Hour)
+	l.Geocode(context.Background(), "a")
+	if _, err := l.Geocode(ctx, "a"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a waiting call to give up with ctx, got %v", err)
+	}
+}
+
+func TestNominatim(t *testing.T) {
+	var gotQuery, gotAgent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotQuery, gotAgent = r.URL.Query().Get("q"), r.UserAgent()
+		if r.URL.Query().Get("q") == "Nowhere" {
+			io.WriteString(w, `[]`)
+			return
+		}
+		io.WriteString(w, `[{"lat":"55.7539","lon":"37.6208","display_name":"Red Square"}]`)
+	}))
+	defer srv.Close()
+
+	n := NewNominatim(srv.URL+"/", "persons-service/test")
+	pt, err := n.Geocode(context.Background(), "Red Square, Moscow")
+	if err != nil || pt != (Point{55.7539, 37.6208}) {
+		t.Fatalf("Unexpected result %v %v", pt, err)
+	}
+	if gotQuery != "Red Square, Moscow" || gotAgent != "persons-service/test" {
+		t.Errorf("Unexpected request q=%q User-Agent=%q", gotQuery, gotAgent)
+	}
+	if _, err := n.Geocode(context.Background(), "Nowhere"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestGoogle(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Query().Get("key") != "secret":
+			io.WriteString(w, `{"status":"REQUEST_DENIED","error_message":"bad key"}`)
+		case r.URL.Query().Get("address") == "Nowhere":
+			io.WriteString(w, `{"status":"ZERO_RESULTS","results":[]}`)
+		default:
+			io.WriteString(w, `{"status":"OK","results":[{"geometry":{"location":{"lat":55.7539,"lng":37.6208}}}]}`)
+		}
+	}))
+	defer srv.Close()
+
+	g := NewGoogle(srv.URL, "secret")
+	if pt, err := g.Geocode(context.Background(), "Red Square"); err != nil || pt != (Point{55.7539, 37.6208}) {
+		t.Errorf("Unexpected result %v %v", pt, err)
+	}
+	if _, err := g.Geocode(context.Background(), "Nowhere"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := NewGoogle(srv.URL, "wrong").Geocode(context.Background(), "Red Square"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable for a rejected key, got %v", err)
+	}
+}
+
+func TestWebhookValidator(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		var req struct{ Address string }
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Address == "12 Main Street" {
+			io.WriteString(w, `{"verified":true}`)
+			return
+		}
+		io.WriteString(w, `{"verified":false,"suggestions":[{"address":"12 Main Street","latitude":1.5}]}`)
+	}))
+	defer srv.Close()
+
+	v := NewCachedValidator(NewWebhookValidator(srv.URL), time.Hour, 10)
+	ctx := context.Background()
+	if got, err := v.Validate(ctx, "12 Main Street"); err != nil || !got.Verified {
+		t.Errorf("Expected a verified address, got %+v %v", got, err)
+	}
+	got, err := v.Validate(ctx, "12 Mian Stret")
+	if err != nil || got.Verified || len(got.Suggestions) != 1 || got.Suggestions[0].Address != "12 Main Street" || *got.Suggestions[0].Lat != 1.5 {
+		t.Errorf("Expected a suggestion, got %+v %v", got, err)
+	}
+	v.Validate(ctx, "12 MIAN stret ")
+	if calls != 2 {
+		t.Errorf("Expected verdicts cached by normalized address, got %d calls", calls)
+	}
+
+	srv.Close()
+	if _, err := v.Validate(ctx, "Elsewhere"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable, got %v", err)
+	}
+}
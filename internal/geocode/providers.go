@@ -0,0 +1,122 @@
+package geocode
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	NominatimURL = "https://nominatim.openstreetmap.org"
+	GoogleURL    = "https://maps.googleapis.com"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+func getJSON(ctx context.Context, target string, header http.Header, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return doJSON(req, out)
+}
+
+// doJSON decodes a successful response into out. Anything else is
+// ErrUnavailable, since services do not answer errors for bad addresses.
+func doJSON(req *http.Request, out any) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%w: answered %d: %s", ErrUnavailable, resp.StatusCode, msg)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return nil
+}
+
+// Nominatim geocodes with OpenStreetMap's Nominatim, the public instance or a
+// self-hosted one. The public one requires a User-Agent naming the
+// application.
+type Nominatim struct {
+	url       string
+	userAgent string
+}
+
+func NewNominatim(url, userAgent string) *Nominatim {
+	return &Nominatim{url: strings.TrimSuffix(url, "/"), userAgent: userAgent}
+}
+
+func (n *Nominatim) Geocode(ctx context.Context, address string) (Point, error) {
+	q := url.Values{"q": {address}, "format": {"jsonv2"}, "limit": {"1"}}
+	var places []struct {
+		Lat string `json:"lat"`
+		Lon string `json:"lon"`
+	}
+	if err := getJSON(ctx, n.url+"/search?"+q.Encode(), http.Header{"User-Agent": {n.userAgent}}, &places); err != nil {
+		return Point{}, err
+	}
+	if len(places) == 0 {
+		return Point{}, ErrNotFound
+	}
+	lat, err1 := strconv.ParseFloat(places[0].Lat, 64)
+	lon, err2 := strconv.ParseFloat(places[0].Lon, 64)
+	if err1 != nil || err2 != nil {
+		return Point{}, fmt.Errorf("%w: malformed coordinates %q, %q", ErrUnavailable, places[0].Lat, places[0].Lon)
+	}
+	return Point{Lat: lat, Lon: lon}, nil
+}
+
+// Google geocodes with the Google Maps Geocoding API.
+type Google struct {
+	url string
+	key string
+}
+
+func NewGoogle(url, key string) *Google {
+	return &Google{url: strings.TrimSuffix(url, "/"), key: key}
+}
+
+func (g *Google) Geocode(ctx context.Context, address string) (Point, error) {
+	q := url.Values{"address": {address}, "key": {g.key}}
+	var resp struct {
+		Status       string `json:"status"`
+		ErrorMessage string `json:"error_message"`
+		Results      []struct {
+			Geometry struct {
+				Location struct {
+					Lat float64 `json:"lat"`
+					Lng float64 `json:"lng"`
+				} `json:"location"`
+			} `json:"geometry"`
+		} `json:"results"`
+	}
+	if err := getJSON(ctx, g.url+"/maps/api/geocode/json?"+q.Encode(), nil, &resp); err != nil {
+		return Point{}, err
+	}
+	switch resp.Status {
+	case "OK":
+		if len(resp.Results) == 0 {
+			return Point{}, ErrNotFound
+		}
+		loc := resp.Results[0].Geometry.Location
+		return Point{Lat: loc.Lat, Lon: loc.Lng}, nil
+	case "ZERO_RESULTS":
+		return Point{}, ErrNotFound
+	default:
+		return Point{}, fmt.Errorf("%w: %s %s", ErrUnavailable, resp.Status, resp.ErrorMessage)
+	}
+}
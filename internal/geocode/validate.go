@@ -0,0 +1,79 @@
+package geocode
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Suggestion is an address the validator would accept instead, with its
+// location when the validator knows it.
+type Suggestion struct {
+	Address string   `json:"address"`
+	Lat     *float64 `json:"latitude,omitempty"`
+	Lon     *float64 `json:"longitude,omitempty"`
+}
+
+// Verdict is a validator's answer. Suggestions may come with verified
+// addresses too, e.g. a standardized spelling, but only matter otherwise.
+type Verdict struct {
+	Verified    bool         `json:"verified"`
+	Suggestions []Suggestion `json:"suggestions"`
+}
+
+type Validator interface {
+	Validate(ctx context.Context, address string) (Verdict, error)
+}
+
+// CachedValidator remembers verdicts by normalized address. Errors are not
+// cached.
+type CachedValidator struct {
+	Validator
+	cache *cache[Verdict]
+}
+
+func NewCachedValidator(v Validator, ttl time.Duration, maxEntries int) *CachedValidator {
+	return &CachedValidator{Validator: v, cache: newCache[Verdict](ttl, maxEntries)}
+}
+
+func (c *CachedValidator) Validate(ctx context.Context, address string) (Verdict, error) {
+	if v, ok := c.cache.get(address); ok {
+		return v, nil
+	}
+	v, err := c.Validator.Validate(ctx, address)
+	if err != nil {
+		return Verdict{}, err
+	}
+	c.cache.put(address, v)
+	return v, nil
+}
+
+// WebhookValidator asks a validation service of your own, which can wrap
+// whatever postal API is used. It POSTs {"address": "..."} to url and expects
+// a Verdict as JSON back.
+type WebhookValidator struct {
+	url string
+}
+
+func NewWebhookValidator(url string) *WebhookValidator {
+	return &WebhookValidator{url: url}
+}
+
+func (wv *WebhookValidator) Validate(ctx context.Context, address string) (Verdict, error) {
+	body, err := json.Marshal(map[string]string{"address": address})
+	if err != nil {
+		return Verdict{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wv.url, bytes.NewReader(body))
+	if err != nil {
+		return Verdict{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var v Verdict
+	if err := doJSON(req, &v); err != nil {
+		return Verdict{}, err
+	}
+	return v, nil
+}
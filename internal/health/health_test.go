@@ -0,0 +1,90 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRegistryHandler(t *testing.T) {
+	testCases := []struct {
+		name           string
+		checks         map[string]CheckFunc
+		expectedStatus int
+		failing        []string
+	}{
+		{"No checks", nil, http.StatusOK, nil},
+		{
+			"All healthy",
+			map[string]CheckFunc{
+				"postgres": func(context.Context) error { return nil },
+				"redis":    func(context.Context) error { return nil },
+			},
+			http.StatusOK,
+			nil,
+		},
+		{
+			"One failing",
+			map[string]CheckFunc{
+				"postgres": func(context.Context) error { return nil },
+				"redis":    func(context.Context) error { return errors.New("connection refused") },
+			},
+			http.StatusServiceUnavailable,
+			[]string{"redis"},
+		},
+		{
+			"Check exceeds timeout",
+			map[string]CheckFunc{
+				"slow": func(ctx context.Context) error {
+					<-ctx.Done()
+					return ctx.Err()
+				},
+			},
+			http.StatusServiceUnavailable,
+			[]string{"slow"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reg := NewRegistry(20 * time.Millisecond)
+			for name, c := range tc.checks {
+				reg.Register(name, c)
+			}
+
+			rr := httptest.NewRecorder()
+			reg.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+			if rr.Code != tc.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tc.expectedStatus, rr.Code)
+			}
+
+			var report Report
+			if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+				t.Fatalf("Failed to decode report: %v", err)
+			}
+			if len(report.Checks) != len(tc.checks) {
+				t.Errorf("Expected %d checks, got %v", len(tc.checks), report.Checks)
+			}
+			for _, name := range tc.failing {
+				if res := report.Checks[name]; res.Status != StatusFail || res.Error == "" {
+					t.Errorf("Expected %s to fail with an error, got %+v", name, res)
+				}
+			}
+		})
+	}
+}
+
+func TestRunMeasuresLatency(t *testing.T) {
+	reg := NewRegistry(time.Second)
+	reg.Register("sleepy", func(context.Context) error {
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	})
+	if res := reg.Run(context.Background()).Checks["sleepy"]; res.LatencyMS < 5 {
+		t.Errorf("Expected latency of at least 5ms, got %v", res.LatencyMS)
+	}
+}
@@ -0,0 +1,148 @@
+// Package imaging decodes uploaded photos and scales them down into the
+// variants that are served.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnsupported = errors.New("not a JPEG, PNG or GIF image")
+	// ErrTooLarge refuses an image with more pixels than allowed, before it
+	// is decoded into memory.
+	ErrTooLarge = errors.New("image is too large")
+)
+
+// Limits bound the images accepted. Zero means no bound.
+type Limits struct {
+	MaxPixels int
+	// MaxDimension bounds the longer side.
+	MaxDimension int
+}
+
+// Check fails with ErrTooLarge for an image of cfg's size beyond l.
+func (l Limits) Check(cfg image.Config) error {
+	if l.MaxDimension > 0 && max(cfg.Width, cfg.Height) > l.MaxDimension {
+		return ErrTooLarge
+	}
+	if l.MaxPixels > 0 && cfg.Height > 0 && cfg.Width > l.MaxPixels/cfg.Height {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+// Decode decodes a JPEG, PNG or GIF image within l and returns its media
+// type.
+func Decode(b []byte, l Limits) (image.Image, string, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", ErrUnsupported
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, "", ErrUnsupported
+	}
+	if err := l.Check(cfg); err != nil {
+		return nil, "", err
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	return img, "image/" + format, nil
+}
+
+// Flatten draws img onto white, so that transparent parts stay white in
+// formats without transparency, and into an RGBA image Fit can scale.
+func Flatten(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Over)
+	return dst
+}
+
+// Fit scales img down so that neither side is longer than size, keeping its
+// aspect ratio. Each pixel is the average of the pixels it covers. Images
+// that fit already are returned as they are, never scaled up.
+func Fit(img *image.RGBA, size int) *image.RGBA {
+	sw, sh := img.Bounds().Dx(), img.Bounds().Dy()
+	if sw <= size && sh <= size {
+		return img
+	}
+	dw, dh := size, max(1, sh*size/sw)
+	if sh > sw {
+		dw, dh = max(1, sw*size/sh), size
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, max(y*sh/dh+1, (y+1)*sh/dh)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, max(x*sw/dw+1, (x+1)*sw/dw)
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := img.Pix[sy*img.Stride+x0*4 : sy*img.Stride+x1*4]
+				for i := 0; i < len(row); i += 4 {
+					sum[0] += int(row[i])
+					sum[1] += int(row[i+1])
+					sum[2] += int(row[i+2])
+					sum[3] += int(row[i+3])
+				}
+			}
+			n := (y1 - y0) * (x1 - x0)
+			p := dst.Pix[y*dst.Stride+x*4:]
+			for i := range sum {
+				p[i] = uint8((sum[i] + n/2) / n)
+			}
+		}
+	}
+	return dst
+}
+
+// Variant is a size photos are served in: scaled to fit Size pixels.
+type Variant struct {
+	Name string
+	Size int
+}
+
+var variantName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// ParseVariants reads variants like "thumbnail=128,medium=512". Names in
+// reserved are refused.
+func ParseVariants(spec string, reserved ...string) ([]Variant, error) {
+	var variants []Variant
+	seen := map[string]bool{}
+	for _, r := range reserved {
+		seen[r] = true
+	}
+	for _, item := range strings.Split(spec, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		name, size, _ := strings.Cut(item, "=")
+		name, size = strings.TrimSpace(name), strings.TrimSpace(size)
+		if !variantName.MatchString(name) {
+			return nil, fmt.Errorf("invalid variant name %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("variant %s is defined twice or reserved", name)
+		}
+		n, err := strconv.Atoi(size)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("variant %s: invalid size %q", name, size)
+		}
+		seen[name] = true
+		variants = append(variants, Variant{Name: name, Size: n})
+	}
+	return variants, nil
+}
@@ -0,0 +1,76 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"reflect"
+	"testing"
+)
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecode(t *testing.T) {
+	b := encodePNG(t, image.NewNRGBA(image.Rect(0, 0, 40, 30)))
+	if img, mediaType, err := Decode(b, Limits{MaxPixels: 1200, MaxDimension: 40}); err != nil || img.Bounds().Dx() != 40 || mediaType != "image/png" {
+		t.Errorf("Expected the image decoded, got %s %v", mediaType, err)
+	}
+	for _, l := range []Limits{{MaxPixels: 1199}, {MaxDimension: 39}} {
+		if _, _, err := Decode(b, l); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("%+v: expected ErrTooLarge, got %v", l, err)
+		}
+	}
+	if _, _, err := Decode([]byte("%PDF-1.7"), Limits{}); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("Expected ErrUnsupported, got %v", err)
+	}
+}
+
+func TestFlattenAndFit(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 400, 100))
+	for x := 0; x < 200; x++ {
+		for y := 0; y < 100; y++ {
+			src.Set(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+	// The right half is transparent and becomes white.
+	img := Fit(Flatten(src), 40)
+	if b := img.Bounds(); b.Dx() != 40 || b.Dy() != 10 {
+		t.Fatalf("Expected 40x10, got %v", b)
+	}
+	if c := img.RGBAAt(5, 5); c != (color.RGBA{R: 255, A: 255}) {
+		t.Errorf("Expected red on the left, got %v", c)
+	}
+	if c := img.RGBAAt(35, 5); c != (color.RGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Errorf("Expected white on the right, got %v", c)
+	}
+
+	small := Flatten(image.NewNRGBA(image.Rect(0, 0, 10, 20)))
+	if Fit(small, 40) != small {
+		t.Error("Expected an image that fits to be kept as is")
+	}
+	if b := Fit(Flatten(image.NewNRGBA(image.Rect(0, 0, 10, 1000))), 100).Bounds(); b.Dx() != 1 || b.Dy() != 100 {
+		t.Errorf("Expected 1x100, got %v", b)
+	}
+}
+
+func TestParseVariants(t *testing.T) {
+	got, err := ParseVariants(" thumbnail=128, medium=512 ,", "original")
+	want := []Variant{{"thumbnail", 128}, {"medium", 512}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v %v", want, got, err)
+	}
+	for _, spec := range []string{"original=10", "a=1,a=2", "big=0", "Big=10", "thumb"} {
+		if _, err := ParseVariants(spec, "original"); err == nil {
+			t.Errorf("Expected %q to be refused", spec)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Package logging sets up the process-wide slog logger and enforces the PII
+// policy: names, addresses, workplaces, contact details and request bodies are
+// personal data and never reach the log store in clear text. Attributes with
+// those keys are masked by the handler at every level; anything else that may
+// carry personal data has to be wrapped with Redact before it is logged, which
+// is only meant for debug-level diagnostics.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+)
+
+// sensitiveKeys are attribute keys whose values are always masked, wherever
+// they appear in a group.
+var sensitiveKeys = map[string]bool{
+	"name":     true,
+	"address":  true,
+	"work":     true,
+	"email":    true,
+	"phone":    true,
+	"body":     true,
+	"password": true,
+	"token":    true,
+}
+
+// New returns a JSON logger writing to w that masks sensitive attributes.
+func New(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(NewRedactingHandler(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})))
+}
+
+// Redacted is personal data that keeps only its shape in logs, enough to tell
+// an empty value from a long one while debugging.
+type Redacted string
+
+// Redact marks s as personal data for logging.
+func Redact(s string) Redacted { return Redacted(s) }
+
+func (r Redacted) LogValue() slog.Value {
+	return slog.StringValue(mask(string(r)))
+}
+
+func (r Redacted) String() string { return mask(string(r)) }
+
+func mask(s string) string {
+	if s == "" {
+		return ""
+	}
+	return fmt.Sprintf("[redacted %d chars]", utf8.RuneCountInString(s))
+}
+
+type traceIDKey struct{}
+
+// WithTraceID returns a context whose records are logged with trace_id.
+func WithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, traceIDKey{}, id)
+}
+
+// TraceID returns the trace ID set with WithTraceID, or "".
+func TraceID(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey{}).(string)
+	return id
+}
+
+// RedactingHandler masks the values of sensitive attribute keys before
+// passing records on. Records logged with a context carrying a trace ID get
+// it as trace_id.
+type RedactingHandler struct {
+	next slog.Handler
+}
+
+func NewRedactingHandler(next slog.Handler) *RedactingHandler {
+	return &RedactingHandler{next: next}
+}
+
+func (h *RedactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *RedactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	clean := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		clean.AddAttrs(redactAttr(a))
+		return true
+	})
+	if id := TraceID(ctx); id != "" {
+		clean.AddAttrs(slog.String("trace_id", id))
+	}
+	return h.next.Handle(ctx, clean)
+}
+
+func (h *RedactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clean := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		clean[i] = redactAttr(a)
+	}
+	return &RedactingHandler{next: h.next.WithAttrs(clean)}
+}
+
+func (h *RedactingHandler) WithGroup(name string) slog.Handler {
+	return &RedactingHandler{next: h.next.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		group := v.Group()
+		clean := make([]any, len(group))
+		for i, ga := range group {
+			clean[i] = redactAttr(ga)
+		}
+		return slog.Group(a.Key, clean...)
+	}
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, mask(v.String()))
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
@@ -0,0 +1,59 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSensitiveKeysAreMasked(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelDebug).With("address", "Moscow, Red Square 1")
+
+	logger.Info("person created",
+		"id", 7,
+		"name", "Ivan Ivanov",
+		slog.Group("request", "body", `{"name":"Ivan Ivanov"}`, "method", "POST"),
+	)
+
+	out := buf.String()
+	for _, leak := range []string{"Ivan", "Moscow"} {
+		if strings.Contains(out, leak) {
+			t.Errorf("Log line leaks %q: %s", leak, out)
+		}
+	}
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("Invalid JSON log line: %v", err)
+	}
+	if line["id"] != float64(7) || line["name"] != "[redacted 11 chars]" {
+		t.Errorf("Unexpected attributes: %s", out)
+	}
+	if req := line["request"].(map[string]any); req["method"] != "POST" {
+		t.Errorf("Expected non-sensitive group attributes to survive: %s", out)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	var buf bytes.Buffer
+	New(&buf, slog.LevelDebug).Debug("lookup", "query", Redact("Анна"), "empty", Redact(""))
+
+	if !strings.Contains(buf.String(), `"query":"[redacted 4 chars]"`) || !strings.Contains(buf.String(), `"empty":""`) {
+		t.Errorf("Unexpected redaction: %s", buf.String())
+	}
+}
+
+func TestTraceID(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelDebug)
+	logger.InfoContext(WithTraceID(context.Background(), "4bf92f3577b34da6a3ce929d0e0e4736"), "traced")
+	logger.InfoContext(context.Background(), "untraced")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if !strings.Contains(lines[0], `"trace_id":"4bf92f3577b34da6a3ce929d0e0e4736"`) || strings.Contains(lines[1], "trace_id") {
+		t.Errorf("Expected trace_id only on the traced record: %s", buf.String())
+	}
+}
@@ -0,0 +1,279 @@
+// Package metrics is a small, dependency-free metrics registry that exposes
+// counters, gauges and histograms in the Prometheus text format, or in OpenMetrics
+// (with exemplars) when the scraper asks for it.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+	sinks    []Sink
+}
+
+// Sink is handed every observation as it is made, to push metrics to systems
+// that do not scrape /metrics.
+type Sink interface {
+	Count(name string, v float64, labels, values []string)
+	Observe(name string, v float64, labels, values []string)
+}
+
+type family interface {
+	write(w io.Writer, openMetrics bool)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families = append(r.families, f)
+}
+
+// AddSink forwards every later observation of the registry's metrics to s.
+func (r *Registry) AddSink(s Sink) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sinks = append(r.sinks, s)
+}
+
+func (r *Registry) currentSinks() []Sink {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sinks
+}
+
+// Exemplar links a single observation to the trace that produced it.
+type Exemplar struct {
+	TraceID string
+	Value   float64
+	Time    time.Time
+}
+
+type CounterVec struct {
+	reg        *Registry
+	name, help string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]*counter
+}
+
+type counter struct {
+	labelValues []string
+	value       float64
+}
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{reg: r, name: name, help: help, labels: labels, values: map[string]*counter{}}
+	r.register(c)
+	return c
+}
+
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	s, ok := c.values[key]
+	if !ok {
+		s = &counter{labelValues: labelValues}
+		c.values[key] = s
+	}
+	s.value += v
+	c.mu.Unlock()
+	for _, sink := range c.reg.currentSinks() {
+		sink.Count(c.name, v, c.labels, labelValues)
+	}
+}
+
+func (c *CounterVec) Inc(labelValues ...string) { c.Add(1, labelValues...) }
+
+func (c *CounterVec) write(w io.Writer, openMetrics bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	typeName := c.name
+	if openMetrics {
+		typeName = strings.TrimSuffix(c.name, "_total")
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", typeName, c.help, typeName)
+	for _, key := range sortedKeys(c.values) {
+		s := c.values[key]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, s.labelValues), formatFloat(s.value))
+	}
+}
+
+type HistogramVec struct {
+	reg        *Registry
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	values map[string]*histogram
+}
+
+type histogram struct {
+	labelValues []string
+	counts      []uint64
+	exemplars   []*Exemplar
+	count       uint64
+	sum         float64
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	h := &HistogramVec{reg: r, name: name, help: help, labels: labels, buckets: buckets, values: map[string]*histogram{}}
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.ObserveWithExemplar(v, "", labelValues...)
+}
+
+// ObserveWithExemplar records v and, when traceID is set, remembers it as the
+// latest exemplar of the bucket v falls into.
+func (h *HistogramVec) ObserveWithExemplar(v float64, traceID string, labelValues ...string) {
+	defer func() {
+		for _, sink := range h.reg.currentSinks() {
+			sink.Observe(h.name, v, h.labels, labelValues)
+		}
+	}()
+	key := strings.Join(labelValues, "\xff")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.values[key]
+	if !ok {
+		s = &histogram{
+			labelValues: labelValues,
+			counts:      make([]uint64, len(h.buckets)+1),
+			exemplars:   make([]*Exemplar, len(h.buckets)+1),
+		}
+		h.values[key] = s
+	}
+	i := sort.SearchFloat64s(h.buckets, v)
+	s.counts[i]++
+	s.count++
+	s.sum += v
+	if traceID != "" {
+		s.exemplars[i] = &Exemplar{TraceID: traceID, Value: v, Time: time.Now()}
+	}
+}
+
+func (h *HistogramVec) write(w io.Writer, openMetrics bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range sortedKeys(h.values) {
+		s := h.values[key]
+		var cumulative uint64
+		for i := range s.counts {
+			cumulative += s.counts[i]
+			le := math.Inf(1)
+			if i < len(h.buckets) {
+				le = h.buckets[i]
+			}
+			labels := formatLabels(append(h.labels[:len(h.labels):len(h.labels)], "le"), append(s.labelValues[:len(s.labelValues):len(s.labelValues)], formatFloat(le)))
+			fmt.Fprintf(w, "%s_bucket%s %d", h.name, labels, cumulative)
+			if e := s.exemplars[i]; openMetrics && e != nil {
+				fmt.Fprintf(w, " # {trace_id=%q} %s %s", e.TraceID, formatFloat(e.Value), strconv.FormatFloat(float64(e.Time.UnixMilli())/1000, 'f', 3, 64))
+			}
+			fmt.Fprintln(w)
+		}
+		labels := formatLabels(h.labels, s.labelValues)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labels, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, s.count)
+	}
+}
+
+// GaugeFunc is a gauge read from fn whenever metrics are written, for values
+// the application tracks anyway. It is only scraped; sinks do not get it.
+type GaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, fn: fn}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer, openMetrics bool) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatFloat(g.fn()))
+}
+
+// WriteTo writes every registered family. Exemplars are only part of the
+// OpenMetrics format, so they are omitted unless openMetrics is set.
+func (r *Registry) WriteTo(w io.Writer, openMetrics bool) {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	r.mu.Unlock()
+	for _, f := range families {
+		f.write(w, openMetrics)
+	}
+	if openMetrics {
+		fmt.Fprintln(w, "# EOF")
+	}
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		openMetrics := strings.Contains(req.Header.Get("Accept"), "application/openmetrics-text")
+		if openMetrics {
+			w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		}
+		r.WriteTo(w, openMetrics)
+	})
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(values[i]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"bytes"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogramExemplarsOnlyInOpenMetrics(t *testing.T) {
+	r := NewRegistry()
+	h := r.NewHistogramVec("request_seconds", "Request time.", []float64{0.1, 1}, "route")
+	h.ObserveWithExemplar(0.05, "4bf92f3577b34da6a3ce929d0e0e4736", "/persons")
+	h.Observe(0.5, "/persons")
+
+	var buf bytes.Buffer
+	r.WriteTo(&buf, true)
+	out := buf.String()
+	for _, want := range []string{
+		`request_seconds_bucket{route="/persons",le="0.1"} 1 # {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"} 0.05 `,
+		`request_seconds_bucket{route="/persons",le="1"} 2` + "\n",
+		`request_seconds_bucket{route="/persons",le="+Inf"} 2` + "\n",
+		`request_seconds_count{route="/persons"} 2`,
+		"# EOF",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("OpenMetrics output missing %q:\n%s", want, out)
+		}
+	}
+
+	buf.Reset()
+	r.WriteTo(&buf, false)
+	if strings.Contains(buf.String(), "trace_id") || strings.Contains(buf.String(), "# EOF") {
+		t.Errorf("Prometheus text output must not contain exemplars:\n%s", buf.String())
+	}
+}
+
+func TestCounterTypeName(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounterVec("requests_total", "Requests.", "code")
+	c.Inc("200")
+	c.Inc("200")
+
+	var buf bytes.Buffer
+	r.WriteTo(&buf, true)
+	if !strings.Contains(buf.String(), "# TYPE requests counter\n") || !strings.Contains(buf.String(), `requests_total{code="200"} 2`) {
+		t.Errorf("Unexpected OpenMetrics counter output:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	r.WriteTo(&buf, false)
+	if !strings.Contains(buf.String(), "# TYPE requests_total counter\n") {
+		t.Errorf("Unexpected Prometheus counter output:\n%s", buf.String())
+	}
+}
+
+func TestStatsDSink(t *testing.T) {
+	for _, tc := range []struct {
+		dogStatsD bool
+		want      string
+	}{
+		{false, "persons.http_requests.api_v1_persons_id.GET.200:1|c\npersons.http_request_duration.api_v1_persons_id:250.000|ms"},
+		{true, "persons.http_requests:1|c|#route:/api/v1/persons/{id},method:GET,code:200\npersons.http_request_duration:250.000|ms|#route:/api/v1/persons/{id}"},
+	} {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		defer pc.Close()
+		sink, err := NewStatsD(pc.LocalAddr().String(), "persons.", tc.dogStatsD)
+		if err != nil {
+			t.Fatalf("Failed to create sink: %v", err)
+		}
+
+		r := NewRegistry()
+		r.AddSink(sink)
+		r.NewCounterVec("http_requests_total", "Requests.", "route", "method", "code").Inc("/api/v1/persons/{id}", "GET", "200")
+		r.NewHistogramVec("http_request_duration_seconds", "Request time.", nil, "route").Observe(0.25, "/api/v1/persons/{id}")
+		sink.Flush()
+
+		buf := make([]byte, maxPacketSize)
+		pc.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil || string(buf[:n]) != tc.want {
+			t.Errorf("Unexpected datagram %q %v, want %q", buf[:n], err, tc.want)
+		}
+	}
+}
+
+func TestGaugeFunc(t *testing.T) {
+	r := NewRegistry()
+	v := 3.0
+	r.NewGaugeFunc("in_flight", "Requests in flight.", func() float64 { return v })
+	v = 5
+
+	var buf bytes.Buffer
+	r.WriteTo(&buf, false)
+	want := "# HELP in_flight Requests in flight.\n# TYPE in_flight gauge\nin_flight 5\n"
+	if buf.String() != want {
+		t.Errorf("Expected\n%s, got\n%s", want, buf.String())
+	}
+}
+
+func TestRuntimeMetrics(t *testing.T) {
+	r := NewRegistry()
+	r.RegisterRuntime()
+	runtime.GC()
+
+	var buf bytes.Buffer
+	r.WriteTo(&buf, true)
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE go_goroutines gauge\ngo_goroutines ",
+		"# TYPE go_gc_cycles counter\ngo_gc_cycles_total ",
+		"# TYPE go_gc_pause_seconds histogram\n",
+		`go_gc_pause_seconds_bucket{le="+Inf"} `,
+		"go_gc_pause_seconds_count ",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "go_gc_cycles_total 0\n") {
+		t.Error("Expected the forced GC cycle to be counted")
+	}
+}
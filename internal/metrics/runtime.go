@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"runtime/metrics"
+	"strings"
+)
+
+type runtimeMetric struct {
+	name, help string
+	// kind is the Prometheus type: gauge, counter or histogram.
+	kind   string
+	source string
+}
+
+var runtimeMetrics = []runtimeMetric{
+	{"go_goroutines", "Goroutines that currently exist.", "gauge", "/sched/goroutines:goroutines"},
+	{"go_gomaxprocs", "Threads that may run Go code at once.", "gauge", "/sched/gomaxprocs:threads"},
+	{"go_memory_total_bytes", "Memory mapped by the Go runtime.", "gauge", "/memory/classes/total:bytes"},
+	{"go_heap_objects_bytes", "Heap memory taken by objects, live or not yet swept.", "gauge", "/memory/classes/heap/objects:bytes"},
+	{"go_heap_objects", "Heap objects, live or not yet swept.", "gauge", "/gc/heap/objects:objects"},
+	{"go_heap_goal_bytes", "Heap size at which the next GC cycle ends.", "gauge", "/gc/heap/goal:bytes"},
+	{"go_heap_allocs_bytes_total", "Bytes allocated on the heap.", "counter", "/gc/heap/allocs:bytes"},
+	{"go_gc_cycles_total", "Completed GC cycles.", "counter", "/gc/cycles/total:gc-cycles"},
+	{"go_gc_pause_seconds", "Stop-the-world pauses for GC.", "histogram", "/sched/pauses/total/gc:seconds"},
+	{"go_sched_latency_seconds", "Time goroutines spent runnable before running.", "histogram", "/sched/latencies:seconds"},
+}
+
+// runtimeBuckets are the upper bounds runtime histograms are reported with;
+// the runtime's own are far too many to scrape.
+var runtimeBuckets = []float64{1e-6, 1e-5, 1e-4, 5e-4, .001, .005, .01, .05, .1, .5, 1}
+
+type runtimeCollector struct {
+	samples []metrics.Sample
+}
+
+// RegisterRuntime adds the Go runtime's goroutine, memory, GC and scheduler
+// metrics, read whenever metrics are written. Like GaugeFunc, they are only
+// scraped.
+func (r *Registry) RegisterRuntime() {
+	c := &runtimeCollector{}
+	for _, m := range runtimeMetrics {
+		c.samples = append(c.samples, metrics.Sample{Name: m.source})
+	}
+	r.register(c)
+}
+
+func (c *runtimeCollector) write(w io.Writer, openMetrics bool) {
+	samples := make([]metrics.Sample, len(c.samples))
+	copy(samples, c.samples)
+	metrics.Read(samples)
+	for i, m := range runtimeMetrics {
+		v := samples[i].Value
+		typeName := m.name
+		if openMetrics && m.kind == "counter" {
+			typeName = strings.TrimSuffix(m.name, "_total")
+		}
+		if v.Kind() == metrics.KindBad {
+			// Not supported by this Go version.
+			continue
+		}
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", typeName, m.help, typeName, m.kind)
+		switch v.Kind() {
+		case metrics.KindUint64:
+			fmt.Fprintf(w, "%s %d\n", m.name, v.Uint64())
+		case metrics.KindFloat64:
+			fmt.Fprintf(w, "%s %s\n", m.name, formatFloat(v.Float64()))
+		case metrics.KindFloat64Histogram:
+			writeRuntimeHistogram(w, m.name, v.Float64Histogram())
+		}
+	}
+}
+
+// writeRuntimeHistogram reports h with runtimeBuckets. A runtime bucket
+// counts towards a bound only when it ends at or below it, and the sum is
+// estimated from the buckets' midpoints, as the runtime keeps no sum.
+func writeRuntimeHistogram(w io.Writer, name string, h *metrics.Float64Histogram) {
+	var total uint64
+	var sum float64
+	cumulative := make([]uint64, len(runtimeBuckets))
+	for i, n := range h.Counts {
+		if n == 0 {
+			continue
+		}
+		lo, hi := h.Buckets[i], h.Buckets[i+1]
+		total += n
+		switch {
+		case math.IsInf(lo, -1):
+			sum += float64(n) * hi
+		case math.IsInf(hi, 1):
+			sum += float64(n) * lo
+		default:
+			sum += float64(n) * (lo + hi) / 2
+		}
+		for j, bound := range runtimeBuckets {
+			if hi <= bound {
+				cumulative[j] += n
+			}
+		}
+	}
+	for j, bound := range runtimeBuckets {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, formatFloat(bound), cumulative[j])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, total)
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, total)
+}
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPacketSize keeps datagrams under the usual MTU.
+const maxPacketSize = 1432
+
+// StatsD is a Sink pushing observations to a StatsD server over UDP.
+// Counters lose their _total suffix. Histograms of seconds become millisecond
+// timers without the _seconds suffix; other histograms use the h type.
+// Plain StatsD has no labels, so their values are appended to the name; with
+// DogStatsD they are sent as tags. Lines are batched into datagrams, which go
+// out when full or when Flush is called.
+type StatsD struct {
+	conn      net.Conn
+	prefix    string
+	dogStatsD bool
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// NewStatsD sends to addr, prefixing every metric name with prefix.
+func NewStatsD(addr, prefix string, dogStatsD bool) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open statsd connection: %w", err)
+	}
+	return &StatsD{conn: conn, prefix: prefix, dogStatsD: dogStatsD}, nil
+}
+
+func (s *StatsD) Count(name string, v float64, labels, values []string) {
+	s.send(strings.TrimSuffix(name, "_total"), strconv.FormatFloat(v, 'g', -1, 64), "c", labels, values)
+}
+
+func (s *StatsD) Observe(name string, v float64, labels, values []string) {
+	if base, ok := strings.CutSuffix(name, "_seconds"); ok {
+		s.send(base, strconv.FormatFloat(v*1000, 'f', 3, 64), "ms", labels, values)
+		return
+	}
+	s.send(name, strconv.FormatFloat(v, 'g', -1, 64), "h", labels, values)
+}
+
+func (s *StatsD) send(name, value, kind string, labels, values []string) {
+	var b strings.Builder
+	b.WriteString(s.prefix)
+	b.WriteString(name)
+	if !s.dogStatsD {
+		for _, v := range values {
+			b.WriteByte('.')
+			b.WriteString(sanitize(v))
+		}
+	}
+	fmt.Fprintf(&b, ":%s|%s", value, kind)
+	if s.dogStatsD && len(labels) > 0 {
+		b.WriteString("|#")
+		for i, l := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "%s:%s", l, strings.NewReplacer(",", "_", "|", "_").Replace(values[i]))
+		}
+	}
+	line := b.String()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.buf) > 0 && len(s.buf)+1+len(line) > maxPacketSize {
+		s.flushLocked()
+	}
+	if len(s.buf) > 0 {
+		s.buf = append(s.buf, '\n')
+	}
+	s.buf = append(s.buf, line...)
+}
+
+// sanitize turns a label value into a name segment, e.g. /api/v1/persons/{id}
+// into api_v1_persons_id.
+func sanitize(v string) string {
+	clean := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return '_'
+	}, v)
+	for strings.Contains(clean, "__") {
+		clean = strings.ReplaceAll(clean, "__", "_")
+	}
+	return strings.Trim(clean, "_")
+}
+
+// Flush sends the lines batched so far.
+func (s *StatsD) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushLocked()
+}
+
+func (s *StatsD) flushLocked() {
+	if len(s.buf) == 0 {
+		return
+	}
+	// UDP is fire and forget: a lost datagram is a gap in the graphs.
+	s.conn.Write(s.buf)
+	s.buf = s.buf[:0]
+}
+
+// Run flushes every interval until ctx is done.
+func (s *StatsD) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.Flush()
+			return
+		case <-ticker.C:
+			s.Flush()
+		}
+	}
+}
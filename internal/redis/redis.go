@@ -0,0 +1,256 @@
+// Package redis is a small Redis client speaking RESP2, enough to run
+// commands and Lua scripts.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNil is the reply to a command on a key that does not exist.
+var ErrNil = errors.New("redis: nil")
+
+// Error is an error reply from the server; the connection stays usable.
+type Error string
+
+func (e Error) Error() string { return "redis: " + string(e) }
+
+// Config says where the server is. DB is selected on every new connection.
+type Config struct {
+	Addr     string
+	Password string
+	DB       int
+	// Timeout bounds each command that has no earlier context deadline.
+	Timeout time.Duration
+}
+
+// maxIdle is how many connections are kept for reuse.
+const maxIdle = 8
+
+// Client runs commands over a pool of connections. It is safe for concurrent
+// use.
+type Client struct {
+	cfg Config
+
+	mu   sync.Mutex
+	idle []*conn
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func New(cfg Config) *Client {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = time.Second
+	}
+	return &Client{cfg: cfg}
+}
+
+// Close closes the idle connections; those in use are closed when returned.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cn := range c.idle {
+		cn.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.Do(ctx, "PING")
+	return err
+}
+
+// Do runs a command and returns its reply: a string for simple and bulk
+// strings, an int64 for integers and a []any for arrays, whose nil bulk
+// strings are nil. A nil bulk string as the whole reply is ErrNil. Arguments
+// may be strings, []byte and integers.
+func (c *Client) Do(ctx context.Context, args ...any) (any, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := cn.do(ctx, c.cfg.Timeout, args)
+	var redisErr Error
+	if err != nil && !errors.As(err, &redisErr) && !errors.Is(err, ErrNil) {
+		// The connection is in an unknown state.
+		cn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return reply, err
+}
+
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	dialCtx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+	nc, err := (&net.Dialer{}).DialContext(dialCtx, "tcp", c.cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	cn := &conn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	if c.cfg.Password != "" {
+		if _, err := cn.do(ctx, c.cfg.Timeout, []any{"AUTH", c.cfg.Password}); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	if c.cfg.DB != 0 {
+		if _, err := cn.do(ctx, c.cfg.Timeout, []any{"SELECT", c.cfg.DB}); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.idle) >= maxIdle {
+		cn.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+func (cn *conn) do(ctx context.Context, timeout time.Duration, args []any) (any, error) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	cn.SetDeadline(deadline)
+
+	fmt.Fprintf(cn.w, "*%d\r\n", len(args))
+	for _, a := range args {
+		var s string
+		switch a := a.(type) {
+		case string:
+			s = a
+		case []byte:
+			s = string(a)
+		case int:
+			s = strconv.Itoa(a)
+		case int64:
+			s = strconv.FormatInt(a, 10)
+		default:
+			return nil, fmt.Errorf("redis: unsupported argument type %T", a)
+		}
+		fmt.Fprintf(cn.w, "$%d\r\n%s\r\n", len(s), s)
+	}
+	if err := cn.w.Flush(); err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	reply, err := readReply(cn.r)
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, ErrNil
+	}
+	return reply, nil
+}
+
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad integer reply %q", line)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad bulk reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad array reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply %q", line)
+}
+
+// Script is a Lua script run by its SHA1, so that its source is only sent
+// when the server does not have it cached yet.
+type Script struct {
+	src  string
+	hash string
+}
+
+func NewScript(src string) *Script {
+	sum := sha1.Sum([]byte(src))
+	return &Script{src: src, hash: hex.EncodeToString(sum[:])}
+}
+
+// Run runs the script with keys and args; see Client.Do for the reply.
+func (s *Script) Run(ctx context.Context, c *Client, keys []string, args ...any) (any, error) {
+	cmd := make([]any, 0, 3+len(keys)+len(args))
+	cmd = append(cmd, "EVALSHA", s.hash, len(keys))
+	for _, k := range keys {
+		cmd = append(cmd, k)
+	}
+	cmd = append(cmd, args...)
+	reply, err := c.Do(ctx, cmd...)
+	var redisErr Error
+	if errors.As(err, &redisErr) && strings.HasPrefix(string(redisErr), "NOSCRIPT") {
+		cmd[0], cmd[1] = "EVAL", s.src
+		return c.Do(ctx, cmd...)
+	}
+	return reply, err
+}
@@ -0,0 +1,142 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis answers a few commands like Redis and records every command it
+// gets, one line per command.
+type fakeRedis struct {
+	addr string
+
+	mu       sync.Mutex
+	commands []string
+	values   map[string]int64
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f := &fakeRedis{addr: ln.Addr().String(), values: map[string]int64{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+		args := []string{}
+		for _, a := range reply.([]any) {
+			args = append(args, a.(string))
+		}
+		f.mu.Lock()
+		f.commands = append(f.commands, strings.Join(args, " "))
+		switch args[0] {
+		case "PING":
+			fmt.Fprint(conn, "+PONG\r\n")
+		case "AUTH":
+			if args[1] == "secret" {
+				fmt.Fprint(conn, "+OK\r\n")
+			} else {
+				fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+			}
+		case "SELECT":
+			fmt.Fprint(conn, "+OK\r\n")
+		case "INCR":
+			f.values[args[1]]++
+			fmt.Fprintf(conn, ":%d\r\n", f.values[args[1]])
+		case "GET":
+			fmt.Fprint(conn, "$-1\r\n")
+		case "EVALSHA":
+			fmt.Fprint(conn, "-NOSCRIPT No matching script\r\n")
+		case "EVAL":
+			fmt.Fprintf(conn, "*3\r\n:1\r\n$%d\r\n%s\r\n$-1\r\n", len(args[3]), args[3])
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+		f.mu.Unlock()
+	}
+}
+
+func (f *fakeRedis) log() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.commands...)
+}
+
+func TestClient(t *testing.T) {
+	f := newFakeRedis(t)
+	c := New(Config{Addr: f.addr, Password: "secret", DB: 2, Timeout: time.Second})
+	defer c.Close()
+	ctx := context.Background()
+
+	if err := c.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for want := int64(1); want <= 2; want++ {
+		if n, err := c.Do(ctx, "INCR", "hits"); err != nil || n != want {
+			t.Errorf("Expected INCR to return %d, got %v %v", want, n, err)
+		}
+	}
+	if _, err := c.Do(ctx, "GET", "missing"); !errors.Is(err, ErrNil) {
+		t.Errorf("Expected ErrNil for a missing key, got %v", err)
+	}
+	var redisErr Error
+	if _, err := c.Do(ctx, "FLUSHALL"); !errors.As(err, &redisErr) {
+		t.Errorf("Expected an error reply, got %v", err)
+	}
+	want := []string{"AUTH secret", "SELECT 2", "PING", "INCR hits", "INCR hits", "GET missing", "FLUSHALL"}
+	if got := f.log(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the commands on one connection\n%q, got\n%q", want, got)
+	}
+
+	bad := New(Config{Addr: f.addr, Password: "wrong"})
+	if err := bad.Ping(ctx); !errors.As(err, &redisErr) {
+		t.Errorf("Expected a wrong password to fail, got %v", err)
+	}
+}
+
+func TestScript(t *testing.T) {
+	f := newFakeRedis(t)
+	c := New(Config{Addr: f.addr})
+	defer c.Close()
+
+	s := NewScript("return {1, ARGV[1], false}")
+	reply, err := s.Run(context.Background(), c, []string{"k"}, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []any{int64(1), "k", nil}; !reflect.DeepEqual(reply, want) {
+		t.Errorf("Expected %v, got %v", want, reply)
+	}
+	log := f.log()
+	if len(log) != 2 || !strings.HasPrefix(log[0], "EVALSHA "+s.hash+" 1 k a") || !strings.HasPrefix(log[1], "EVAL return") {
+		t.Errorf("Expected EVALSHA, then EVAL for an uncached script, got %q", log)
+	}
+}
@@ -0,0 +1,131 @@
+// Package scan checks uploaded files for malware before they are accepted.
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// ErrUnavailable means the scanner could not be asked or failed to answer;
+// the file is neither known to be clean nor infected.
+var ErrUnavailable = errors.New("malware scanner unavailable")
+
+// Verdict is what a scan found. Signature names the malware when Infected.
+type Verdict struct {
+	Infected  bool
+	Signature string
+}
+
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (Verdict, error)
+}
+
+// chunkSize is how much of the file goes into one INSTREAM chunk.
+const chunkSize = 64 << 10
+
+// ClamAV scans with a clamd daemon over TCP, streaming files with the
+// INSTREAM command. Files larger than clamd's StreamMaxLength are refused by
+// clamd and reported as ErrUnavailable.
+type ClamAV struct {
+	addr    string
+	timeout time.Duration
+}
+
+func NewClamAV(addr string, timeout time.Duration) *ClamAV {
+	return &ClamAV{addr: addr, timeout: timeout}
+}
+
+func (c *ClamAV) dial(ctx context.Context) (net.Conn, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	return conn, nil
+}
+
+func (c *ClamAV) Scan(ctx context.Context, r io.Reader) (Verdict, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return Verdict{}, err
+	}
+	defer conn.Close()
+
+	w := bufio.NewWriterSize(conn, chunkSize+4)
+	w.WriteString("zINSTREAM\x00")
+	buf := make([]byte, chunkSize)
+	for {
+		n, rerr := io.ReadFull(r, buf)
+		if n > 0 {
+			binary.Write(w, binary.BigEndian, uint32(n))
+			if _, err := w.Write(buf[:n]); err != nil {
+				return Verdict{}, fmt.Errorf("%w: %v", ErrUnavailable, err)
+			}
+		}
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rerr != nil {
+			return Verdict{}, fmt.Errorf("read file to scan: %w", rerr)
+		}
+	}
+	binary.Write(w, binary.BigEndian, uint32(0))
+	if err := w.Flush(); err != nil {
+		return Verdict{}, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	reply, err := readReply(conn)
+	if err != nil {
+		return Verdict{}, err
+	}
+	// Replies are "stream: OK", "stream: <signature> FOUND" or
+	// "<reason> ERROR".
+	result := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case result == "OK":
+		return Verdict{}, nil
+	case strings.HasSuffix(result, " FOUND"):
+		return Verdict{Infected: true, Signature: strings.TrimSuffix(result, " FOUND")}, nil
+	}
+	return Verdict{}, fmt.Errorf("%w: clamd answered %q", ErrUnavailable, reply)
+}
+
+// Ping checks that clamd answers, for health checks.
+func (c *ClamAV) Ping(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "zPING\x00"); err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("%w: clamd answered %q", ErrUnavailable, reply)
+	}
+	return nil
+}
+
+// readReply reads a NUL-terminated reply, as answered to z-prefixed commands.
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(io.LimitReader(conn, 4096)).ReadString(0)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	return strings.TrimSuffix(reply, "\x00"), nil
+}
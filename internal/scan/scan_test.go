@@ -0,0 +1,79 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClamd answers INSTREAM like clamd, finding the EICAR marker, and PING.
+func fakeClamd(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				cmd, _ := r.ReadString(0)
+				if cmd == "zPING\x00" {
+					io.WriteString(conn, "PONG\x00")
+					return
+				}
+				var file bytes.Buffer
+				for {
+					var n uint32
+					if binary.Read(r, binary.BigEndian, &n) != nil || n == 0 {
+						break
+					}
+					io.CopyN(&file, r, int64(n))
+				}
+				if strings.Contains(file.String(), "EICAR-STANDARD-ANTIVIRUS-TEST-FILE") {
+					io.WriteString(conn, "stream: Eicar-Test-Signature FOUND\x00")
+				} else {
+					io.WriteString(conn, "stream: OK\x00")
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestClamAV(t *testing.T) {
+	c := NewClamAV(fakeClamd(t), time.Second)
+	ctx := context.Background()
+
+	clean := strings.Repeat("x", 3*chunkSize+1)
+	if v, err := c.Scan(ctx, strings.NewReader(clean)); err != nil || v.Infected {
+		t.Errorf("Expected a clean verdict, got %+v %v", v, err)
+	}
+	v, err := c.Scan(ctx, strings.NewReader(clean+"EICAR-STANDARD-ANTIVIRUS-TEST-FILE"))
+	if err != nil || !v.Infected || v.Signature != "Eicar-Test-Signature" {
+		t.Errorf("Expected the EICAR signature, got %+v %v", v, err)
+	}
+	if err := c.Ping(ctx); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := ln.Addr().String()
+	ln.Close()
+	if _, err := NewClamAV(addr, time.Second).Scan(ctx, strings.NewReader("x")); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable without clamd, got %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/store/db"
+)
+
+func (s *Postgres) APIKeyByHash(ctx context.Context, hash []byte) (APIKey, error) {
+	return retry(ctx, s, func() (APIKey, error) { return s.apiKeyByHash(ctx, hash) })
+}
+
+func (s *Postgres) apiKeyByHash(ctx context.Context, hash []byte) (APIKey, error) {
+	defer s.observe(ctx, "get_api_key")()
+	row, err := s.q.GetAPIKeyByHash(ctx, hash)
+	if err != nil {
+		return APIKey{}, fmt.Errorf("get api key: %w", translate(err))
+	}
+	return fromKeyRow(row), nil
+}
+
+func fromKeyRow(row db.ApiKey) APIKey {
+	return APIKey{
+		ID:              row.ID,
+		Name:            row.Name,
+		MonthlyRequests: row.MonthlyRequests,
+		MonthlyWrites:   row.MonthlyWrites,
+		CreatedAt:       row.CreatedAt,
+		Prefix:          row.Prefix,
+		Scopes:          row.Scopes,
+		ExpiresAt:       row.ExpiresAt,
+		RevokedAt:       row.RevokedAt,
+	}
+}
+
+// CreateAPIKey is not retried: a lost connection may have created it already.
+func (s *Postgres) CreateAPIKey(ctx context.Context, k APIKey, hash []byte) (APIKey, error) {
+	defer s.observe(ctx, "create_api_key")()
+	row, err := s.q.CreateAPIKey(ctx, db.CreateAPIKeyParams{
+		Name:            k.Name,
+		KeyHash:         hash,
+		Prefix:          k.Prefix,
+		Scopes:          k.Scopes,
+		MonthlyRequests: k.MonthlyRequests,
+		MonthlyWrites:   k.MonthlyWrites,
+		ExpiresAt:       k.ExpiresAt,
+	})
+	if err != nil {
+		return APIKey{}, fmt.Errorf("create api key: %w", translate(err))
+	}
+	return fromKeyRow(row), nil
+}
+
+func (s *Postgres) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	return retry(ctx, s, func() ([]APIKey, error) { return s.listAPIKeys(ctx) })
+}
+
+func (s *Postgres) listAPIKeys(ctx context.Context) ([]APIKey, error) {
+	defer s.observe(ctx, "list_api_keys")()
+	rows, err := s.q.ListAPIKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list api keys: %w", translate(err))
+	}
+	keys := make([]APIKey, 0, len(rows))
+	for _, row := range rows {
+		keys = append(keys, fromKeyRow(row))
+	}
+	return keys, nil
+}
+
+func (s *Postgres) RotateAPIKey(ctx context.Context, id int32, hash []byte, prefix string) (APIKey, error) {
+	return retry(ctx, s, func() (APIKey, error) { return s.rotateAPIKey(ctx, id, hash, prefix) })
+}
+
+func (s *Postgres) rotateAPIKey(ctx context.Context, id int32, hash []byte, prefix string) (APIKey, error) {
+	defer s.observe(ctx, "rotate_api_key")()
+	row, err := s.q.RotateAPIKey(ctx, db.RotateAPIKeyParams{ID: id, KeyHash: hash, Prefix: prefix})
+	if err != nil {
+		return APIKey{}, fmt.Errorf("rotate api key %d: %w", id, translate(err))
+	}
+	return fromKeyRow(row), nil
+}
+
+func (s *Postgres) RevokeAPIKey(ctx context.Context, id int32) error {
+	_, err := retry(ctx, s, func() (struct{}, error) { return struct{}{}, s.revokeAPIKey(ctx, id) })
+	return err
+}
+
+func (s *Postgres) revokeAPIKey(ctx context.Context, id int32) error {
+	defer s.observe(ctx, "revoke_api_key")()
+	n, err := s.q.RevokeAPIKey(ctx, id)
+	if err != nil {
+		return fmt.Errorf("revoke api key %d: %w", id, translate(err))
+	}
+	if n == 0 {
+		return fmt.Errorf("revoke api key %d: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+func fromUsageRow(row db.ApiKeyUsage) Usage {
+	return Usage{Month: row.Month, Requests: row.Requests, RowsWritten: row.RowsWritten}
+}
+
+// RecordRequest is not retried: a lost connection may have counted it already.
+func (s *Postgres) RecordRequest(ctx context.Context, keyID int32, month time.Time) (Usage, error) {
+	defer s.observe(ctx, "record_api_key_request")()
+	row, err := s.q.RecordAPIKeyRequest(ctx, db.RecordAPIKeyRequestParams{KeyID: keyID, Month: month})
+	if err != nil {
+		return Usage{}, fmt.Errorf("record request for key %d: %w", keyID, translate(err))
+	}
+	return fromUsageRow(row), nil
+}
+
+func (s *Postgres) RecordWrites(ctx context.Context, keyID int32, month time.Time, rows int64) error {
+	defer s.observe(ctx, "record_api_key_writes")()
+	err := s.q.RecordAPIKeyWrites(ctx, db.RecordAPIKeyWritesParams{KeyID: keyID, Month: month, RowsWritten: rows})
+	if err != nil {
+		return fmt.Errorf("record writes for key %d: %w", keyID, translate(err))
+	}
+	return nil
+}
+
+func (s *Postgres) KeyUsage(ctx context.Context, keyID int32, month time.Time) (Usage, error) {
+	return retry(ctx, s, func() (Usage, error) { return s.keyUsage(ctx, keyID, month) })
+}
+
+func (s *Postgres) keyUsage(ctx context.Context, keyID int32, month time.Time) (Usage, error) {
+	defer s.observe(ctx, "get_api_key_usage")()
+	row, err := s.q.GetAPIKeyUsage(ctx, db.GetAPIKeyUsageParams{KeyID: keyID, Month: month})
+	if err = translate(err); errors.Is(err, ErrNotFound) {
+		return Usage{Month: month}, nil
+	}
+	if err != nil {
+		return Usage{}, fmt.Errorf("get usage for key %d: %w", keyID, err)
+	}
+	return fromUsageRow(row), nil
+}
@@ -0,0 +1,205 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"ci_cd/rsoi_lab_1/internal/store/sqlb"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var attachmentColumnList = []string{"id", "person_id", "object_key", "filename", "content_type", "size", "checksum", "uploaded_by", "created_at"}
+
+var attachmentColumns = strings.Join(attachmentColumnList, ", ")
+
+// CreateAttachment is not retried: a lost connection may have created it
+// already.
+func (s *Postgres) CreateAttachment(ctx context.Context, a Attachment) (Attachment, error) {
+	defer s.observe(ctx, "create_attachment")()
+	// Inserting only if the person exists tells a missing person apart from
+	// other foreign key violations.
+	row := s.pool.QueryRow(ctx, "INSERT INTO attachments (person_id, object_key, filename, content_type, size, checksum, uploaded_by) "+
+		"SELECT $1, $2, $3, $4, $5, $6, $7 WHERE EXISTS (SELECT 1 FROM persons WHERE id = $1) RETURNING "+attachmentColumns,
+		a.PersonID, a.ObjectKey, a.Filename, a.ContentType, a.Size, a.Checksum, a.UploadedBy)
+	created, err := scanAttachment(row)
+	if err != nil {
+		return Attachment{}, fmt.Errorf("create attachment: %w", translate(err))
+	}
+	return created, nil
+}
+
+func (s *Postgres) Attachment(ctx context.Context, personID int32, id int64) (Attachment, error) {
+	return retry(ctx, s, func() (Attachment, error) { return s.attachment(ctx, personID, id) })
+}
+
+func (s *Postgres) attachment(ctx context.Context, personID int32, id int64) (Attachment, error) {
+	defer s.observe(ctx, "get_attachment")()
+	row := s.pool.QueryRow(ctx, "SELECT "+attachmentColumns+" FROM attachments WHERE id = $1 AND person_id = $2", id, personID)
+	a, err := scanAttachment(row)
+	if err != nil {
+		return Attachment{}, fmt.Errorf("get attachment %d: %w", id, translate(err))
+	}
+	return a, nil
+}
+
+func attachmentsQuery(personID int32, f AttachmentFilter) (string, []any, error) {
+	q := sqlb.Select(attachmentColumnList...).From("attachments").Where(sqlb.Eq("person_id", personID))
+	if top, ok := strings.CutSuffix(f.MediaType, "/*"); ok {
+		q = q.Where(sqlb.Expr("starts_with(content_type, ?)", top+"/"))
+	} else if f.MediaType != "" {
+		q = q.Where(sqlb.Expr("split_part(content_type, ';', 1) = ?", f.MediaType))
+	}
+	q = q.OrderBy("id", false)
+	if f.Limit > 0 {
+		q = q.Limit(uint64(f.Limit))
+	}
+	if f.Offset > 0 {
+		q = q.Offset(uint64(f.Offset))
+	}
+	return q.ToSQL()
+}
+
+func (s *Postgres) ListAttachments(ctx context.Context, personID int32, f AttachmentFilter) ([]Attachment, error) {
+	return retry(ctx, s, func() ([]Attachment, error) { return s.listAttachments(ctx, personID, f) })
+}
+
+func (s *Postgres) listAttachments(ctx context.Context, personID int32, f AttachmentFilter) ([]Attachment, error) {
+	query, args, err := attachmentsQuery(personID, f)
+	if err != nil {
+		return nil, err
+	}
+	defer s.observe(ctx, "list_attachments")()
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list attachments of person %d: %w", personID, translate(err))
+	}
+	defer rows.Close()
+
+	list := []Attachment{}
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan attachment: %w", translate(err))
+		}
+		list = append(list, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate attachments: %w", translate(err))
+	}
+	return list, nil
+}
+
+func (s *Postgres) AttachmentsOf(ctx context.Context, persons []int32) (map[int32][]Attachment, error) {
+	return retry(ctx, s, func() (map[int32][]Attachment, error) { return s.attachmentsOf(ctx, persons) })
+}
+
+func (s *Postgres) attachmentsOf(ctx context.Context, persons []int32) (map[int32][]Attachment, error) {
+	defer s.observe(ctx, "attachments_of")()
+	rows, err := s.pool.Query(ctx, "SELECT "+attachmentColumns+" FROM attachments WHERE person_id = ANY($1) ORDER BY id", persons)
+	if err != nil {
+		return nil, fmt.Errorf("list attachments of persons: %w", translate(err))
+	}
+	defer rows.Close()
+
+	byPerson := map[int32][]Attachment{}
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan attachment: %w", translate(err))
+		}
+		byPerson[*a.PersonID] = append(byPerson[*a.PersonID], a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate attachments: %w", translate(err))
+	}
+	return byPerson, nil
+}
+
+// DeleteAttachment is not retried: a retry after a lost connection would not
+// find the row and fail although it was deleted.
+func (s *Postgres) DeleteAttachment(ctx context.Context, personID int32, id int64) (Attachment, error) {
+	defer s.observe(ctx, "delete_attachment")()
+	row := s.pool.QueryRow(ctx, "DELETE FROM attachments WHERE id = $1 AND person_id = $2 RETURNING "+attachmentColumns, id, personID)
+	a, err := scanAttachment(row)
+	if err != nil {
+		return Attachment{}, fmt.Errorf("delete attachment %d: %w", id, translate(err))
+	}
+	return a, nil
+}
+
+func (s *Postgres) UnreferencedKeys(ctx context.Context, keys []string) ([]string, error) {
+	return retry(ctx, s, func() ([]string, error) { return s.unreferencedKeys(ctx, keys) })
+}
+
+func (s *Postgres) unreferencedKeys(ctx context.Context, keys []string) ([]string, error) {
+	defer s.observe(ctx, "unreferenced_keys")()
+	rows, err := s.pool.Query(ctx, "SELECT k FROM unnest($1::text[]) AS k WHERE NOT EXISTS (SELECT 1 FROM attachments WHERE object_key = k)", keys)
+	if err != nil {
+		return nil, fmt.Errorf("find unreferenced keys: %w", translate(err))
+	}
+	defer rows.Close()
+
+	unreferenced := []string{}
+	for rows.Next() {
+		var k string
+		if err := rows.Scan(&k); err != nil {
+			return nil, fmt.Errorf("scan key: %w", translate(err))
+		}
+		unreferenced = append(unreferenced, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate keys: %w", translate(err))
+	}
+	return unreferenced, nil
+}
+
+func (s *Postgres) AttachmentsAfter(ctx context.Context, afterID int64, limit int) ([]Attachment, error) {
+	return retry(ctx, s, func() ([]Attachment, error) { return s.attachmentsAfter(ctx, afterID, limit) })
+}
+
+func (s *Postgres) attachmentsAfter(ctx context.Context, afterID int64, limit int) ([]Attachment, error) {
+	defer s.observe(ctx, "attachments_after")()
+	rows, err := s.pool.Query(ctx, "SELECT "+attachmentColumns+" FROM attachments WHERE id > $1 ORDER BY id LIMIT $2", afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list attachments after %d: %w", afterID, translate(err))
+	}
+	defer rows.Close()
+
+	list := []Attachment{}
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan attachment: %w", translate(err))
+		}
+		list = append(list, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate attachments: %w", translate(err))
+	}
+	return list, nil
+}
+
+func (s *Postgres) SetAttachmentChecksum(ctx context.Context, id int64, checksum string) error {
+	_, err := retry(ctx, s, func() (struct{}, error) { return struct{}{}, s.setAttachmentChecksum(ctx, id, checksum) })
+	return err
+}
+
+func (s *Postgres) setAttachmentChecksum(ctx context.Context, id int64, checksum string) error {
+	defer s.observe(ctx, "set_attachment_checksum")()
+	tag, err := s.pool.Exec(ctx, "UPDATE attachments SET checksum = $2 WHERE id = $1 AND checksum = ''", id, checksum)
+	if err != nil {
+		return fmt.Errorf("set checksum of attachment %d: %w", id, translate(err))
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("set checksum of attachment %d: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+func scanAttachment(row pgx.Row) (Attachment, error) {
+	var a Attachment
+	err := row.Scan(&a.ID, &a.PersonID, &a.ObjectKey, &a.Filename, &a.ContentType, &a.Size, &a.Checksum, &a.UploadedBy, &a.CreatedAt)
+	return a, err
+}
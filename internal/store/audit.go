@@ -0,0 +1,83 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// RecordAudit is not retried: a lost connection may have recorded it already.
+func (s *Postgres) RecordAudit(ctx context.Context, e AuditEntry) error {
+	defer s.observe(ctx, "record_audit")()
+	_, err := s.pool.Exec(ctx, "INSERT INTO audit_log (actor, method, path, status, request_id) VALUES ($1, $2, $3, $4, $5)",
+		e.Actor, e.Method, e.Path, e.Status, e.RequestID)
+	if err != nil {
+		return fmt.Errorf("record audit: %w", translate(err))
+	}
+	return nil
+}
+
+func auditQuery(f AuditFilter) (string, []any) {
+	args := []any{f.After}
+	where := []string{"id > $1"}
+	if !f.From.IsZero() {
+		args = append(args, f.From)
+		where = append(where, fmt.Sprintf("at >= $%d", len(args)))
+	}
+	if !f.To.IsZero() {
+		args = append(args, f.To)
+		where = append(where, fmt.Sprintf("at < $%d", len(args)))
+	}
+	if f.Actor != "" {
+		args = append(args, f.Actor)
+		where = append(where, fmt.Sprintf("actor = $%d", len(args)))
+	}
+	query := "SELECT id, at, actor, method, path, status, request_id FROM audit_log WHERE " +
+		strings.Join(where, " AND ") + " ORDER BY id"
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	return query, args
+}
+
+func (s *Postgres) AuditEntries(ctx context.Context, f AuditFilter) ([]AuditEntry, error) {
+	return retry(ctx, s, func() ([]AuditEntry, error) { return s.auditEntries(ctx, f) })
+}
+
+func (s *Postgres) auditEntries(ctx context.Context, f AuditFilter) ([]AuditEntry, error) {
+	defer s.observe(ctx, "list_audit")()
+	query, args := auditQuery(f)
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list audit: %w", translate(err))
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(&e.ID, &e.At, &e.Actor, &e.Method, &e.Path, &e.Status, &e.RequestID); err != nil {
+			return nil, fmt.Errorf("scan audit entry: %w", translate(err))
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate audit entries: %w", translate(err))
+	}
+	return entries, nil
+}
+
+func (s *Postgres) PruneAudit(ctx context.Context, before time.Time) (int64, error) {
+	return retry(ctx, s, func() (int64, error) { return s.pruneAudit(ctx, before) })
+}
+
+func (s *Postgres) pruneAudit(ctx context.Context, before time.Time) (int64, error) {
+	defer s.observe(ctx, "prune_audit")()
+	tag, err := s.pool.Exec(ctx, "DELETE FROM audit_log WHERE at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("prune audit: %w", translate(err))
+	}
+	return tag.RowsAffected(), nil
+}
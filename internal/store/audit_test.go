@@ -0,0 +1,18 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuditQuery(t *testing.T) {
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	query, args := auditQuery(AuditFilter{From: from, Actor: "admin", After: 10, Limit: 50})
+	want := "SELECT id, at, actor, method, path, status, request_id FROM audit_log WHERE id > $1 AND at >= $2 AND actor = $3 ORDER BY id LIMIT $4"
+	if query != want || len(args) != 4 || args[1] != from || args[2] != "admin" {
+		t.Errorf("Unexpected query %q %v", query, args)
+	}
+	if query, _ := auditQuery(AuditFilter{}); query != "SELECT id, at, actor, method, path, status, request_id FROM audit_log WHERE id > $1 ORDER BY id" {
+		t.Errorf("Unexpected unfiltered query %q", query)
+	}
+}
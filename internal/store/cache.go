@@ -0,0 +1,160 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Cache keeps persons read through GetPerson in memory. Writes made through it
+// drop the entry right away; writes made by other instances are only seen
+// once Invalidate is called for them, which is what Postgres.Listen is for.
+// TTL bounds staleness if a notification is ever lost.
+//
+// Replicas may instead partition the cache between them; see Partition.
+type Cache struct {
+	Store
+	ttl time.Duration
+	now func() time.Time
+
+	// self, ring and peers are set by Partition.
+	self  string
+	ring  *HashRing
+	peers CachePeers
+
+	mu      sync.Mutex
+	entries map[int32]cacheEntry
+	// gen changes on every invalidation, so a read that raced with one does
+	// not put its possibly stale result back.
+	gen uint64
+}
+
+type cacheEntry struct {
+	person  Person
+	expires time.Time
+}
+
+func NewCache(s Store, ttl time.Duration) *Cache {
+	return &Cache{Store: s, ttl: ttl, now: time.Now, entries: make(map[int32]cacheEntry)}
+}
+
+// CachePeers reaches the caches of other instances, named as in the ring.
+type CachePeers interface {
+	// GetPerson calls GetCachedPerson on peer.
+	GetPerson(ctx context.Context, peer string, id int32) (Person, error)
+	// Invalidate calls Invalidate on peer.
+	Invalidate(ctx context.Context, peer string, id int32) error
+}
+
+// Partition makes c cache only the persons ring assigns to self, the name of
+// this instance, and ask their owners through peers for the others, so every
+// person is cached once across replicas rather than by each. A peer that
+// cannot be reached is bypassed by reading from the store.
+func (c *Cache) Partition(self string, ring *HashRing, peers CachePeers) {
+	c.self, c.ring, c.peers = self, ring, peers
+}
+
+// owner is the peer caching the person with id, or "" for this instance.
+func (c *Cache) owner(id int32) string {
+	if c.ring == nil {
+		return ""
+	}
+	if node := c.ring.Node(strconv.Itoa(int(id))); node != c.self {
+		return node
+	}
+	return ""
+}
+
+func (c *Cache) GetPerson(ctx context.Context, id int32) (Person, error) {
+	owner := c.owner(id)
+	if owner == "" {
+		return c.GetCachedPerson(ctx, id)
+	}
+	p, err := c.peers.GetPerson(ctx, owner, id)
+	if err == nil || errors.Is(err, ErrNotFound) {
+		return p, err
+	}
+	slog.WarnContext(ctx, "cache peer unavailable, reading from the store", "peer", owner, "err", err)
+	return c.Store.GetPerson(ctx, id)
+}
+
+// GetCachedPerson reads through this instance's cache whoever owns the
+// person, for peers asking the owner.
+func (c *Cache) GetCachedPerson(ctx context.Context, id int32) (Person, error) {
+	c.mu.Lock()
+	e, ok := c.entries[id]
+	gen := c.gen
+	c.mu.Unlock()
+	if ok && c.now().Before(e.expires) {
+		return e.person, nil
+	}
+
+	p, err := c.Store.GetPerson(ctx, id)
+	if err != nil {
+		return Person{}, err
+	}
+	c.mu.Lock()
+	if c.gen == gen {
+		c.entries[id] = cacheEntry{person: p, expires: c.now().Add(c.ttl)}
+	}
+	c.mu.Unlock()
+	return p, nil
+}
+
+// Invalidate drops a single person from this instance's cache.
+func (c *Cache) Invalidate(id int32) {
+	c.mu.Lock()
+	delete(c.entries, id)
+	c.gen++
+	c.mu.Unlock()
+}
+
+// Purge drops everything, for when invalidations may have been missed.
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	clear(c.entries)
+	c.gen++
+	c.mu.Unlock()
+}
+
+// written drops a person this instance just wrote, also from its owner's
+// cache, so that reads through any instance see the write at once rather
+// than once the owner gets the change notification.
+func (c *Cache) written(ctx context.Context, id int32) {
+	c.Invalidate(id)
+	owner := c.owner(id)
+	if owner == "" {
+		return
+	}
+	if err := c.peers.Invalidate(ctx, owner, id); err != nil {
+		slog.WarnContext(ctx, "failed to invalidate person on cache peer", "peer", owner, "id", id, "err", err)
+	}
+}
+
+func (c *Cache) UpsertPerson(ctx context.Context, p Person) (Person, bool, error) {
+	defer c.written(ctx, p.ID)
+	return c.Store.UpsertPerson(ctx, p)
+}
+
+func (c *Cache) UpdatePerson(ctx context.Context, id int32, patch PersonPatch) (Person, error) {
+	defer c.written(ctx, id)
+	return c.Store.UpdatePerson(ctx, id, patch)
+}
+
+func (c *Cache) ModifyPerson(ctx context.Context, id int32, fn func(p *Person) error) (Person, error) {
+	defer c.written(ctx, id)
+	return c.Store.ModifyPerson(ctx, id, fn)
+}
+
+func (c *Cache) DeletePersonIf(ctx context.Context, id int32, check func(p Person) error) error {
+	defer c.written(ctx, id)
+	return c.Store.DeletePersonIf(ctx, id, check)
+}
+
+func (c *Cache) DeletePerson(ctx context.Context, id int32) error {
+	defer c.written(ctx, id)
+	return c.Store.DeletePerson(ctx, id)
+}
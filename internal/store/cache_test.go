@@ -0,0 +1,154 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestCache(t *testing.T) {
+	ctx := context.Background()
+	backend := testutil.NewMemoryStore(store.Person{Name: "Anna"})
+	c := store.NewCache(backend, time.Hour)
+
+	get := func() string {
+		t.Helper()
+		p, err := c.GetPerson(ctx, 1)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return p.Name
+	}
+
+	get()
+	backend.Put(store.Person{ID: 1, Name: "Changed elsewhere"})
+	if got := get(); got != "Anna" {
+		t.Errorf("Expected the cached name, got %q", got)
+	}
+
+	c.Invalidate(1)
+	if got := get(); got != "Changed elsewhere" {
+		t.Errorf("Expected a fresh read after Invalidate, got %q", got)
+	}
+
+	name := "Patched"
+	if _, err := c.UpdatePerson(ctx, 1, store.PersonPatch{Name: &name}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := get(); got != name {
+		t.Errorf("Expected own writes to be visible, got %q", got)
+	}
+
+	backend.Put(store.Person{ID: 1, Name: "Restored"})
+	c.Purge()
+	if got := get(); got != "Restored" {
+		t.Errorf("Expected a fresh read after Purge, got %q", got)
+	}
+
+	if err := c.DeletePerson(ctx, 1); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := c.GetPerson(ctx, 1); err == nil {
+		t.Error("Expected deleted person to be gone")
+	}
+}
+
+func TestCache_TTL(t *testing.T) {
+	backend := testutil.NewMemoryStore(store.Person{Name: "Anna"})
+	c := store.NewCache(backend, time.Nanosecond)
+
+	c.GetPerson(context.Background(), 1)
+	backend.Put(store.Person{ID: 1, Name: "Boris"})
+	time.Sleep(time.Millisecond)
+	if p, _ := c.GetPerson(context.Background(), 1); p.Name != "Boris" {
+		t.Errorf("Expected expired entry to be refetched, got %q", p.Name)
+	}
+}
+
+// cachePeers reaches the caches of a group of instances directly.
+type cachePeers map[string]*store.Cache
+
+func (p cachePeers) GetPerson(ctx context.Context, peer string, id int32) (store.Person, error) {
+	c, ok := p[peer]
+	if !ok {
+		return store.Person{}, errors.New("connection refused")
+	}
+	return c.GetCachedPerson(ctx, id)
+}
+
+func (p cachePeers) Invalidate(ctx context.Context, peer string, id int32) error {
+	c, ok := p[peer]
+	if !ok {
+		return errors.New("connection refused")
+	}
+	c.Invalidate(id)
+	return nil
+}
+
+func TestCachePartition(t *testing.T) {
+	ctx := context.Background()
+	backend := testutil.NewMemoryStore(store.Person{Name: "Anna"})
+	ring := store.NewHashRing(50, "a", "b")
+	peers := cachePeers{}
+	for _, name := range []string{"a", "b"} {
+		peers[name] = store.NewCache(backend, time.Hour)
+		peers[name].Partition(name, ring, peers)
+	}
+	owner, other := peers[ring.Node("1")], peers["a"]
+	if other == owner {
+		other = peers["b"]
+	}
+
+	other.GetPerson(ctx, 1)
+	backend.Put(store.Person{ID: 1, Name: "Changed elsewhere"})
+	if p, _ := owner.GetPerson(ctx, 1); p.Name != "Anna" {
+		t.Errorf("Expected the read through the other instance to be cached by the owner, got %q", p.Name)
+	}
+
+	name := "Patched"
+	if _, err := other.UpdatePerson(ctx, 1, store.PersonPatch{Name: &name}); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := owner.GetPerson(ctx, 1); p.Name != name {
+		t.Errorf("Expected a write through the other instance to invalidate the owner, got %q", p.Name)
+	}
+
+	delete(peers, ring.Node("1"))
+	backend.Put(store.Person{ID: 1, Name: "Owner down"})
+	if p, err := other.GetPerson(ctx, 1); err != nil || p.Name != "Owner down" {
+		t.Errorf("Expected a read from the store while the owner is down, got %q %v", p.Name, err)
+	}
+}
+
+func TestHashRing(t *testing.T) {
+	before := store.NewHashRing(50, "a", "b", "c")
+	after := store.NewHashRing(50, "a", "b", "c", "d")
+	owned := map[string]int{}
+	moved := 0
+	for id := range 1000 {
+		key := strconv.Itoa(id)
+		owned[after.Node(key)]++
+		if b, a := before.Node(key), after.Node(key); b != a {
+			if a != "d" {
+				t.Fatalf("Expected key %s to move only to the new node, it moved from %s to %s", key, b, a)
+			}
+			moved++
+		}
+	}
+	for node, n := range owned {
+		if n < 100 {
+			t.Errorf("Expected keys spread over the nodes, %s owns %d of 1000", node, n)
+		}
+	}
+	if moved == 0 {
+		t.Error("Expected the new node to take over some keys")
+	}
+	if got := store.NewHashRing(50).Node("1"); got != "" {
+		t.Errorf("Expected no node on an empty ring, got %q", got)
+	}
+}
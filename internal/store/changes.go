@@ -0,0 +1,199 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/store/db"
+)
+
+// SetChangeFeed installs or removes the trigger that records every change to
+// persons in person_changes. Recorded changes are kept when it is removed.
+func (s *Postgres) SetChangeFeed(ctx context.Context, enabled bool) error {
+	stmt := "DROP TRIGGER IF EXISTS persons_record_change ON persons;"
+	if enabled {
+		stmt += `CREATE TRIGGER persons_record_change AFTER INSERT OR UPDATE OR DELETE ON persons
+			FOR EACH ROW EXECUTE FUNCTION record_person_change();`
+	}
+	if _, err := s.pool.Exec(ctx, stmt); err != nil {
+		return fmt.Errorf("set change feed: %w", translate(err))
+	}
+	return nil
+}
+
+// Changes only returns changes of transactions older than every one still in
+// progress, so a later call can never turn up something before its cursor.
+func (s *Postgres) Changes(ctx context.Context, since int64, limit int) ([]Change, error) {
+	return retry(ctx, s, func() ([]Change, error) { return s.changes(ctx, since, limit) })
+}
+
+func (s *Postgres) changes(ctx context.Context, since int64, limit int) ([]Change, error) {
+	defer s.observe(ctx, "list_changes")()
+	var afterTxid int64
+	if since > 0 {
+		var err error
+		afterTxid, err = s.q.GetChangeTxid(ctx, since)
+		if err != nil {
+			if err = translate(err); errors.Is(err, ErrNotFound) {
+				return nil, &ValidationError{Field: "since", Message: fmt.Sprintf("unknown change %d", since)}
+			}
+			return nil, fmt.Errorf("list changes: %w", err)
+		}
+	}
+	rows, err := s.q.ListChanges(ctx, db.ListChangesParams{
+		AfterTxid: afterTxid,
+		AfterSeq:  since,
+		MaxRows:   int32(limit),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list changes: %w", translate(err))
+	}
+
+	changes := make([]Change, 0, len(rows))
+	for _, row := range rows {
+		c, err := decodeChange(row)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, nil
+}
+
+// decodeChange turns a person_changes row, whose data is to_jsonb of the
+// persons row, into a Change.
+func decodeChange(row db.PersonChange) (Change, error) {
+	var data struct {
+		ID        int32          `json:"id"`
+		Name      string         `json:"name"`
+		Age       *int32         `json:"age"`
+		Address   *string        `json:"address"`
+		Work      *string        `json:"work"`
+		UpdatedAt time.Time      `json:"updated_at"`
+		Latitude  *float64       `json:"latitude"`
+		Longitude *float64       `json:"longitude"`
+		Phone     *string        `json:"phone"`
+		Email     *string        `json:"email"`
+		Metadata  map[string]any `json:"metadata"`
+	}
+	if err := json.Unmarshal(row.Data, &data); err != nil {
+		return Change{}, fmt.Errorf("decode change %d: %w", row.Seq, err)
+	}
+	return Change{
+		Seq: row.Seq,
+		Op:  row.Op,
+		Person: Person{
+			ID:        data.ID,
+			Name:      data.Name,
+			Age:       data.Age,
+			Address:   data.Address,
+			Work:      data.Work,
+			UpdatedAt: data.UpdatedAt,
+			Latitude:  data.Latitude,
+			Longitude: data.Longitude,
+			Phone:     data.Phone,
+			Email:     data.Email,
+			Custom:    data.Metadata,
+		},
+		ChangedAt: row.ChangedAt,
+	}, nil
+}
+
+const changesAtCTE = `WITH persons_at AS (
+	SELECT id, name, age, address, work, updated_at, latitude, longitude, phone, email, metadata FROM (
+		SELECT DISTINCT ON (person_id) person_id AS id, op,
+			data->>'name' AS name, (data->>'age')::int AS age, data->>'address' AS address,
+			data->>'work' AS work, (data->>'updated_at')::timestamptz AS updated_at,
+			(data->>'latitude')::float8 AS latitude, (data->>'longitude')::float8 AS longitude, data->>'phone' AS phone, data->>'email' AS email,
+			COALESCE(data->'metadata', '{}') AS metadata
+		FROM person_changes WHERE changed_at <= $%d
+		ORDER BY person_id, txid DESC, seq DESC
+	) latest WHERE op <> 'delete'
+)`
+
+// ListPersonsAt lists persons from the change feed. Persons that were not
+// changed while the feed was enabled are missing.
+func (s *Postgres) ListPersonsAt(ctx context.Context, at time.Time, f ListFilter) ([]Person, error) {
+	return retry(ctx, s, func() ([]Person, error) { return s.listPersonsAt(ctx, changesAtCTE, at, f) })
+}
+
+// PersonAt reconstructs the person from the change feed. Only changes made
+// while the feed was enabled are known.
+func (s *Postgres) PersonAt(ctx context.Context, id int32, at time.Time) (Person, error) {
+	return retry(ctx, s, func() (Person, error) { return s.personAt(ctx, id, at) })
+}
+
+func (s *Postgres) personAt(ctx context.Context, id int32, at time.Time) (Person, error) {
+	defer s.observe(ctx, "get_person_at")()
+	row, err := s.q.GetPersonChangeAt(ctx, db.GetPersonChangeAtParams{PersonID: id, ChangedAt: at})
+	if err != nil {
+		return Person{}, fmt.Errorf("get person %d at %s: %w", id, at, translate(err))
+	}
+	c, err := decodeChange(row)
+	if err != nil {
+		return Person{}, err
+	}
+	if c.Op == "delete" {
+		return Person{}, fmt.Errorf("get person %d at %s: %w", id, at, ErrNotFound)
+	}
+	return c.Person, nil
+}
+
+// purgeHistoryQuery counts the history of the persons selected by the query
+// given as its third argument, deleting it when formatted with "DELETE FROM"
+// and "RETURNING 1" rather than "SELECT 1 FROM" and "".
+const purgeHistoryQuery = `WITH purged AS (
+	%[3]s
+), changes AS (
+	%[1]s person_changes WHERE person_id IN (SELECT person_id FROM purged) %[2]s
+), events AS (
+	%[1]s person_events WHERE person_id IN (SELECT person_id FROM purged) %[2]s
+)
+SELECT (SELECT count(*) FROM changes) + (SELECT count(*) FROM events)`
+
+// deletedBeforeQuery selects the persons whose last deletion is older than
+// $1.
+const deletedBeforeQuery = `SELECT person_id FROM (
+		SELECT person_id, changed_at AS deleted_at FROM person_changes WHERE op = 'delete'
+		UNION ALL
+		SELECT person_id, recorded_at FROM person_events WHERE type = '` + EventDeleted + `'
+	) deletions
+	WHERE NOT EXISTS (SELECT 1 FROM persons p WHERE p.id = deletions.person_id)
+	GROUP BY person_id HAVING max(deleted_at) < $1`
+
+// PurgeHistory covers both the change feed and the event log, whichever
+// has entries. Consumers of the change feed whose cursor was purged have to
+// start over.
+func (s *Postgres) PurgeHistory(ctx context.Context, before time.Time, dryRun bool) (int64, error) {
+	return retry(ctx, s, func() (int64, error) { return s.purgeHistory(ctx, before, dryRun) })
+}
+
+func (s *Postgres) purgeHistory(ctx context.Context, before time.Time, dryRun bool) (int64, error) {
+	defer s.observe(ctx, "purge_history")()
+	query := fmt.Sprintf(purgeHistoryQuery, "DELETE FROM", "RETURNING 1", deletedBeforeQuery)
+	if dryRun {
+		query = fmt.Sprintf(purgeHistoryQuery, "SELECT 1 FROM", "", deletedBeforeQuery)
+	}
+	var n int64
+	if err := s.pool.QueryRow(ctx, query, before).Scan(&n); err != nil {
+		return 0, fmt.Errorf("purge history: %w", translate(err))
+	}
+	return n, nil
+}
+
+func (s *Postgres) PurgePersonHistory(ctx context.Context, id int32) (int64, error) {
+	return retry(ctx, s, func() (int64, error) { return s.purgePersonHistory(ctx, id) })
+}
+
+func (s *Postgres) purgePersonHistory(ctx context.Context, id int32) (int64, error) {
+	defer s.observe(ctx, "purge_person_history")()
+	query := fmt.Sprintf(purgeHistoryQuery, "DELETE FROM", "RETURNING 1", "SELECT $1::integer AS person_id")
+	var n int64
+	if err := s.pool.QueryRow(ctx, query, id).Scan(&n); err != nil {
+		return 0, fmt.Errorf("purge history of person %d: %w", id, translate(err))
+	}
+	return n, nil
+}
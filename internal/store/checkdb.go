@@ -0,0 +1,87 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// anomalySample bounds the IDs an Anomaly lists.
+const anomalySample = 20
+
+// Anomaly is data breaking a rule the API keeps but the schema does not
+// enforce, found by CheckData.
+type Anomaly struct {
+	Check       string `json:"check"`
+	Description string `json:"description"`
+	Count       int64  `json:"count"`
+	// IDs are the first of the rows found, or of the persons for checks on
+	// their history.
+	IDs []int64 `json:"ids"`
+}
+
+type DataReport struct {
+	CheckedAt time.Time `json:"checked_at"`
+	// Checks names every check run, anomalies found or not.
+	Checks    []string  `json:"checks"`
+	Anomalies []Anomaly `json:"anomalies"`
+}
+
+type DataChecker interface {
+	CheckData(ctx context.Context) (DataReport, error)
+}
+
+type dataCheck struct {
+	name, description string
+	// query selects the ID of every offending row.
+	query string
+}
+
+func (s *Postgres) dataChecks() []dataCheck {
+	checks := []dataCheck{
+		{"blank_name", "persons whose name is empty or only spaces",
+			"SELECT id FROM persons WHERE btrim(name) = ''"},
+		{"negative_age", "persons with a negative age",
+			"SELECT id FROM persons WHERE age < 0"},
+		{"half_location", "persons with only one of latitude and longitude",
+			"SELECT id FROM persons WHERE (latitude IS NULL) <> (longitude IS NULL)"},
+		{"location_out_of_range", "persons whose coordinates are not on Earth",
+			"SELECT id FROM persons WHERE latitude NOT BETWEEN -90 AND 90 OR longitude NOT BETWEEN -180 AND 180"},
+		{"orphaned_changes", "persons with change feed entries who neither exist nor were deleted",
+			`SELECT DISTINCT c.person_id FROM person_changes c
+			 WHERE NOT EXISTS (SELECT 1 FROM persons p WHERE p.id = c.person_id)
+			   AND NOT EXISTS (SELECT 1 FROM person_changes d WHERE d.person_id = c.person_id AND d.op = 'delete')`},
+		{"orphaned_events", "persons with events who neither exist nor were deleted",
+			`SELECT DISTINCT e.person_id FROM person_events e
+			 WHERE NOT EXISTS (SELECT 1 FROM persons p WHERE p.id = e.person_id)
+			   AND NOT EXISTS (SELECT 1 FROM person_events d WHERE d.person_id = e.person_id AND d.type = '` + EventDeleted + `')`},
+	}
+	for _, r := range Relations {
+		// Orphan leaves such rows on purpose.
+		if s.deletePolicy(r) == Orphan {
+			continue
+		}
+		checks = append(checks, dataCheck{"orphaned_" + r.Name, r.Name + " of no person",
+			fmt.Sprintf("SELECT id FROM %s WHERE %s IS NULL", r.Table, r.Column)})
+	}
+	return checks
+}
+
+// CheckData looks for anomalies in every table; finding some is not an
+// error. Each check reads a whole table.
+func (s *Postgres) CheckData(ctx context.Context) (DataReport, error) {
+	defer s.observe(ctx, "check_data")()
+	report := DataReport{CheckedAt: time.Now().UTC(), Checks: []string{}, Anomalies: []Anomaly{}}
+	for _, c := range s.dataChecks() {
+		a := Anomaly{Check: c.name, Description: c.description}
+		query := fmt.Sprintf("SELECT count(*), COALESCE((array_agg(id::bigint ORDER BY id))[1:%d], '{}') FROM (%s) AS q(id)", anomalySample, c.query)
+		if err := s.pool.QueryRow(ctx, query).Scan(&a.Count, &a.IDs); err != nil {
+			return DataReport{}, fmt.Errorf("check %s: %w", c.name, translate(err))
+		}
+		report.Checks = append(report.Checks, c.name)
+		if a.Count > 0 {
+			report.Anomalies = append(report.Anomalies, a)
+		}
+	}
+	return report, nil
+}
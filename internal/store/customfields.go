@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Custom field types.
+const (
+	FieldString  = "string"
+	FieldInteger = "integer"
+	FieldNumber  = "number"
+	FieldBoolean = "boolean"
+	// FieldDate values are RFC 3339 full dates, e.g. 2024-05-31.
+	FieldDate = "date"
+	// FieldEnum values are one of the field's Constraints.Values.
+	FieldEnum = "enum"
+)
+
+// CustomField is a field a deployment added to persons. Its values are kept
+// in Person.Custom under Name.
+type CustomField struct {
+	Name string
+	Type string
+	// Required fields must be given whenever a person is written in full.
+	Required    bool
+	Constraints FieldConstraints
+	CreatedAt   time.Time
+}
+
+// FieldConstraints restrict the values of a custom field beyond its type.
+// Lengths and Pattern apply to strings, Min and Max to numbers.
+type FieldConstraints struct {
+	MinLength *int     `json:"min_length,omitempty"`
+	MaxLength *int     `json:"max_length,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+	Min       *float64 `json:"min,omitempty"`
+	Max       *float64 `json:"max,omitempty"`
+	Values    []string `json:"values,omitempty"`
+}
+
+type CustomFieldStore interface {
+	// DefineCustomField fails with a ConflictError on Field "name" when a
+	// field by that name exists.
+	DefineCustomField(ctx context.Context, f CustomField) (CustomField, error)
+	// CustomFields lists the fields by name.
+	CustomFields(ctx context.Context) ([]CustomField, error)
+	// DeleteCustomField fails with ErrNotFound for unknown names. Values
+	// persons already have are kept.
+	DeleteCustomField(ctx context.Context, name string) error
+}
+
+const customFieldColumns = "name, type, required, constraints, created_at"
+
+func scanCustomField(row pgx.Row) (CustomField, error) {
+	var f CustomField
+	err := row.Scan(&f.Name, &f.Type, &f.Required, &f.Constraints, &f.CreatedAt)
+	return f, err
+}
+
+// DefineCustomField is not retried: a lost connection may have defined it
+// already.
+func (s *Postgres) DefineCustomField(ctx context.Context, f CustomField) (CustomField, error) {
+	defer s.observe(ctx, "define_custom_field")()
+	f, err := scanCustomField(s.pool.QueryRow(ctx, "INSERT INTO custom_fields (name, type, required, constraints) VALUES ($1, $2, $3, $4) RETURNING "+customFieldColumns,
+		f.Name, f.Type, f.Required, f.Constraints))
+	if err != nil {
+		return CustomField{}, fmt.Errorf("define custom field %s: %w", f.Name, translate(err))
+	}
+	return f, nil
+}
+
+func (s *Postgres) CustomFields(ctx context.Context) ([]CustomField, error) {
+	return retry(ctx, s, func() ([]CustomField, error) {
+		defer s.observe(ctx, "list_custom_fields")()
+		rows, err := s.pool.Query(ctx, "SELECT "+customFieldColumns+" FROM custom_fields ORDER BY name")
+		if err != nil {
+			return nil, fmt.Errorf("list custom fields: %w", translate(err))
+		}
+		defer rows.Close()
+		fields := []CustomField{}
+		for rows.Next() {
+			f, err := scanCustomField(rows)
+			if err != nil {
+				return nil, fmt.Errorf("list custom fields: %w", translate(err))
+			}
+			fields = append(fields, f)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("list custom fields: %w", translate(err))
+		}
+		return fields, nil
+	})
+}
+
+func (s *Postgres) DeleteCustomField(ctx context.Context, name string) error {
+	defer s.observe(ctx, "delete_custom_field")()
+	tag, err := s.pool.Exec(ctx, "DELETE FROM custom_fields WHERE name = $1", name)
+	if err != nil {
+		return fmt.Errorf("delete custom field %s: %w", name, translate(err))
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("delete custom field %s: %w", name, ErrNotFound)
+	}
+	return nil
+}
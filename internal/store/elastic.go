@@ -0,0 +1,302 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Elastic mirrors persons into an Elasticsearch (or OpenSearch) index and
+// serves Search from it, keeping search load off Postgres. The mirror is fed
+// by Sync and lags writes slightly.
+type Elastic struct {
+	url    string
+	index  string
+	client *http.Client
+	now    func() time.Time
+}
+
+func NewElastic(url, index string) *Elastic {
+	return &Elastic{
+		url:    strings.TrimSuffix(url, "/"),
+		index:  index,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+	}
+}
+
+// elasticDoc is a person as indexed. SyncedAt tells documents touched by a
+// reindex from ones it did not see, which are then deleted.
+type elasticDoc struct {
+	ID        int32          `json:"id"`
+	Name      string         `json:"name"`
+	Age       *int32         `json:"age,omitempty"`
+	Address   *string        `json:"address,omitempty"`
+	Work      *string        `json:"work,omitempty"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	Latitude  *float64       `json:"latitude,omitempty"`
+	Longitude *float64       `json:"longitude,omitempty"`
+	Phone     *string        `json:"phone,omitempty"`
+	Email     *string        `json:"email,omitempty"`
+	Custom    map[string]any `json:"custom,omitempty"`
+	SyncedAt  time.Time      `json:"synced_at"`
+}
+
+func toElasticDoc(p Person, syncedAt time.Time) elasticDoc {
+	return elasticDoc{ID: p.ID, Name: p.Name, Age: p.Age, Address: p.Address, Work: p.Work, UpdatedAt: p.UpdatedAt, Latitude: p.Latitude, Longitude: p.Longitude, Phone: p.Phone, Email: p.Email, Custom: p.Custom, SyncedAt: syncedAt}
+}
+
+var elasticMapping = map[string]any{
+	"mappings": map[string]any{
+		"properties": map[string]any{
+			"id":         map[string]any{"type": "integer"},
+			"name":       map[string]any{"type": "text"},
+			"age":        map[string]any{"type": "integer"},
+			"address":    map[string]any{"type": "text"},
+			"work":       map[string]any{"type": "text"},
+			"updated_at": map[string]any{"type": "date"},
+			"latitude":   map[string]any{"type": "double"},
+			"longitude":  map[string]any{"type": "double"},
+			"phone":      map[string]any{"type": "keyword"},
+			"email":      map[string]any{"type": "keyword"},
+			"custom":     map[string]any{"type": "object", "enabled": false},
+			"synced_at":  map[string]any{"type": "date"},
+		},
+	},
+}
+
+type elasticError struct {
+	status int
+	body   string
+}
+
+func (e *elasticError) Error() string {
+	return fmt.Sprintf("elasticsearch answered %d: %s", e.status, e.body)
+}
+
+func elasticStatus(err error) int {
+	var ee *elasticError
+	if errors.As(err, &ee) {
+		return ee.status
+	}
+	return 0
+}
+
+// do sends body, JSON encoded unless it is already []byte, and decodes a
+// successful response into out. Transport failures are ErrUnavailable.
+func (e *Elastic) do(ctx context.Context, method, path string, body, out any) error {
+	var r io.Reader
+	contentType := "application/json"
+	switch b := body.(type) {
+	case nil:
+	case []byte:
+		r = bytes.NewReader(b)
+		contentType = "application/x-ndjson"
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, e.url+path, r)
+	if err != nil {
+		return err
+	}
+	if r != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &elasticError{status: resp.StatusCode, body: string(msg)}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// EnsureIndex creates the index unless it exists.
+func (e *Elastic) EnsureIndex(ctx context.Context) error {
+	err := e.do(ctx, http.MethodPut, "/"+e.index, elasticMapping, nil)
+	if elasticStatus(err) == http.StatusBadRequest && strings.Contains(err.Error(), "resource_already_exists_exception") {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("create index %s: %w", e.index, err)
+	}
+	return nil
+}
+
+func (e *Elastic) indexPerson(ctx context.Context, p Person) error {
+	if err := e.do(ctx, http.MethodPut, fmt.Sprintf("/%s/_doc/%d", e.index, p.ID), toElasticDoc(p, e.now()), nil); err != nil {
+		return fmt.Errorf("index person %d: %w", p.ID, err)
+	}
+	return nil
+}
+
+func (e *Elastic) deletePerson(ctx context.Context, id int32) error {
+	err := e.do(ctx, http.MethodDelete, fmt.Sprintf("/%s/_doc/%d", e.index, id), nil, nil)
+	if err != nil && elasticStatus(err) != http.StatusNotFound {
+		return fmt.Errorf("delete person %d: %w", id, err)
+	}
+	return nil
+}
+
+// Sync keeps the index in step with persons until ctx is done. It follows the
+// persons_changed notifications, so writes by every instance are indexed, and
+// reindexes everything after each (re)connect since changes announced in
+// between were missed.
+func (e *Elastic) Sync(ctx context.Context, pg *Postgres) {
+	if err := e.EnsureIndex(ctx); err != nil {
+		slog.ErrorContext(ctx, "search index unavailable, continuing to sync", "err", err)
+	}
+	pg.Listen(ctx, func(id int32) {
+		if err := e.syncPerson(ctx, pg, id); err != nil {
+			slog.WarnContext(ctx, "failed to sync person to search index", "id", id, "err", err)
+		}
+	}, func() {
+		if err := e.Reindex(ctx, pg); err != nil {
+			slog.ErrorContext(ctx, "failed to reindex persons", "err", err)
+		}
+	})
+}
+
+func (e *Elastic) syncPerson(ctx context.Context, s Store, id int32) error {
+	p, err := s.GetPerson(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return e.deletePerson(ctx, id)
+	}
+	if err != nil {
+		return err
+	}
+	return e.indexPerson(ctx, p)
+}
+
+const reindexBatch = 500
+
+// Reindex indexes every person and then deletes documents of persons that no
+// longer exist.
+func (e *Elastic) Reindex(ctx context.Context, s Store) error {
+	start := e.now()
+	indexed := 0
+	for offset := 0; ; offset += reindexBatch {
+		persons, err := s.ListPersons(ctx, ListFilter{Limit: reindexBatch, Offset: offset})
+		if err != nil {
+			return fmt.Errorf("reindex: %w", err)
+		}
+		if len(persons) > 0 {
+			if err := e.bulkIndex(ctx, persons, start); err != nil {
+				return err
+			}
+		}
+		indexed += len(persons)
+		if len(persons) < reindexBatch {
+			break
+		}
+	}
+	stale := map[string]any{"query": map[string]any{"range": map[string]any{"synced_at": map[string]any{"lt": start}}}}
+	if err := e.do(ctx, http.MethodPost, "/"+e.index+"/_delete_by_query?conflicts=proceed", stale, nil); err != nil {
+		return fmt.Errorf("delete stale documents: %w", err)
+	}
+	slog.InfoContext(ctx, "reindexed persons", "index", e.index, "count", indexed)
+	return nil
+}
+
+func (e *Elastic) bulkIndex(ctx context.Context, persons []Person, syncedAt time.Time) error {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, p := range persons {
+		enc.Encode(map[string]any{"index": map[string]any{"_index": e.index, "_id": strconv.Itoa(int(p.ID))}})
+		enc.Encode(toElasticDoc(p, syncedAt))
+	}
+	var resp struct {
+		Errors bool `json:"errors"`
+	}
+	if err := e.do(ctx, http.MethodPost, "/_bulk", b.Bytes(), &resp); err != nil {
+		return fmt.Errorf("bulk index: %w", err)
+	}
+	if resp.Errors {
+		return errors.New("bulk index: some documents were rejected")
+	}
+	return nil
+}
+
+type elasticHits struct {
+	Hits struct {
+		Total struct {
+			Value int `json:"value"`
+		} `json:"total"`
+		Hits []struct {
+			Score     *float32            `json:"_score"`
+			Source    elasticDoc          `json:"_source"`
+			Highlight map[string][]string `json:"highlight"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// SearchPersons takes the query in simple_query_string syntax, which shares
+// words, "phrases" and -exclusion with Postgres but spells or as |.
+func (e *Elastic) SearchPersons(ctx context.Context, q SearchQuery) (SearchResult, error) {
+	sort := []any{map[string]any{"id": "asc"}}
+	if q.ByRelevance {
+		sort = append([]any{"_score"}, sort...)
+	}
+	highlight := map[string]any{}
+	for _, f := range searchFields {
+		highlight[f] = map[string]any{}
+	}
+	body := map[string]any{
+		"query": map[string]any{"simple_query_string": map[string]any{
+			"query":            q.Text,
+			"fields":           searchFields,
+			"default_operator": "and",
+		}},
+		"highlight": map[string]any{
+			"pre_tags":  []string{HighlightStart},
+			"post_tags": []string{HighlightStop},
+			"fields":    highlight,
+		},
+		"sort":             sort,
+		"track_scores":     true,
+		"track_total_hits": true,
+		"from":             q.Offset,
+	}
+	if q.Limit > 0 {
+		body["size"] = q.Limit
+	}
+	var resp elasticHits
+	if err := e.do(ctx, http.MethodPost, "/"+e.index+"/_search", body, &resp); err != nil {
+		return SearchResult{}, fmt.Errorf("search persons: %w", err)
+	}
+
+	res := SearchResult{Hits: []SearchHit{}, Total: resp.Hits.Total.Value}
+	for _, h := range resp.Hits.Hits {
+		d := h.Source
+		hit := SearchHit{Person: Person{ID: d.ID, Name: d.Name, Age: d.Age, Address: d.Address, Work: d.Work, UpdatedAt: d.UpdatedAt, Latitude: d.Latitude, Longitude: d.Longitude, Phone: d.Phone, Email: d.Email, Custom: d.Custom}}
+		if h.Score != nil {
+			hit.Score = *h.Score
+		}
+		for field, fragments := range h.Highlight {
+			if hit.Highlights == nil {
+				hit.Highlights = map[string]string{}
+			}
+			hit.Highlights[field] = strings.Join(fragments, " … ")
+		}
+		res.Hits = append(res.Hits, hit)
+	}
+	return res, nil
+}
@@ -0,0 +1,91 @@
+package store_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+// fakeElastic records requests and answers searches with a canned response.
+type fakeElastic struct {
+	mu       sync.Mutex
+	requests []string
+	bodies   []string
+	search   string
+}
+
+func (f *fakeElastic) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	f.bodies = append(f.bodies, string(body))
+	f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/_search"):
+		io.WriteString(w, f.search)
+	case r.URL.Path == "/_bulk":
+		io.WriteString(w, `{"errors":false}`)
+	default:
+		io.WriteString(w, `{}`)
+	}
+}
+
+func TestElasticReindex(t *testing.T) {
+	fake := &fakeElastic{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	backend := testutil.NewMemoryStore(store.Person{Name: "Ann"}, store.Person{Name: "Bob"})
+	es := store.NewElastic(srv.URL+"/", "persons")
+	if err := es.Reindex(context.Background(), backend); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := []string{"POST /_bulk", "POST /persons/_delete_by_query"}
+	if strings.Join(fake.requests, ",") != strings.Join(want, ",") {
+		t.Fatalf("Expected %v, got %v", want, fake.requests)
+	}
+	if lines := strings.Split(strings.TrimSpace(fake.bodies[0]), "\n"); len(lines) != 4 || !strings.Contains(lines[1], `"name":"Ann"`) {
+		t.Errorf("Expected an action and a document per person, got %q", fake.bodies[0])
+	}
+	if !strings.Contains(fake.bodies[1], `"synced_at"`) {
+		t.Errorf("Expected stale documents selected by synced_at, got %s", fake.bodies[1])
+	}
+}
+
+func TestElasticSearch(t *testing.T) {
+	fake := &fakeElastic{search: `{"hits":{"total":{"value":7},"hits":[
+		{"_score":1.5,"_source":{"id":3,"name":"Ann","address":"Main St","updated_at":"2024-03-01T12:00:00Z"},
+		 "highlight":{"address":["` + store.HighlightStart + `Main` + store.HighlightStop + ` St"]}}
+	]}}`}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	es := store.NewElastic(srv.URL, "persons")
+	res, err := es.SearchPersons(context.Background(), store.SearchQuery{Text: "main", ByRelevance: true, Limit: 10, Offset: 20})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if res.Total != 7 || len(res.Hits) != 1 || res.Hits[0].Person.ID != 3 || res.Hits[0].Score != 1.5 ||
+		res.Hits[0].Highlights["address"] != store.HighlightStart+"Main"+store.HighlightStop+" St" {
+		t.Errorf("Unexpected result %+v", res)
+	}
+
+	var sent struct {
+		Sort []any `json:"sort"`
+		From int   `json:"from"`
+		Size int   `json:"size"`
+	}
+	json.Unmarshal([]byte(fake.bodies[0]), &sent)
+	if fake.requests[0] != "POST /persons/_search" || sent.From != 20 || sent.Size != 10 || len(sent.Sort) != 2 || sent.Sort[0] != "_score" {
+		t.Errorf("Unexpected search request %s %s", fake.requests[0], fake.bodies[0])
+	}
+}
@@ -0,0 +1,407 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/store/db"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Event types in person_events.
+const (
+	EventCreated = "created"
+	EventUpdated = "updated"
+	EventDeleted = "deleted"
+)
+
+// eventData is the payload of created and updated events: the full person
+// after the change, so replaying never depends on earlier events' contents.
+type eventData struct {
+	Name      string         `json:"name"`
+	Age       *int32         `json:"age"`
+	Address   *string        `json:"address"`
+	Work      *string        `json:"work"`
+	Latitude  *float64       `json:"latitude,omitempty"`
+	Longitude *float64       `json:"longitude,omitempty"`
+	Phone     *string        `json:"phone,omitempty"`
+	Email     *string        `json:"email,omitempty"`
+	Custom    map[string]any `json:"custom,omitempty"`
+}
+
+// EventStore records every mutation as an event in person_events and keeps
+// persons as the read projection, updated in the same transaction. Reads are
+// served by the embedded Postgres store from that projection.
+type EventStore struct {
+	*Postgres
+}
+
+func NewEventStore(pg *Postgres) *EventStore {
+	return &EventStore{Postgres: pg}
+}
+
+// Backfill records a created event for persons that have none, which is the
+// case for rows written before switching to the event store.
+func (s *EventStore) Backfill(ctx context.Context) error {
+	n, err := s.q.BackfillPersonEvents(ctx)
+	if err != nil {
+		return fmt.Errorf("backfill person events: %w", translate(err))
+	}
+	if n > 0 {
+		slog.InfoContext(ctx, "recorded events for existing persons", "count", n)
+	}
+	return nil
+}
+
+// inTx runs fn in a transaction. fn must record its event through q.
+func (s *EventStore) inTx(ctx context.Context, fn func(q *db.Queries) error) error {
+	return s.inRawTx(ctx, func(tx pgx.Tx) error { return fn(s.q.WithTx(tx)) })
+}
+
+// inRawTx is inTx for fns that also run statements of their own on tx.
+func (s *EventStore) inRawTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return translate(err)
+	}
+	defer tx.Rollback(ctx)
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return translate(tx.Commit(ctx))
+}
+
+func appendEvent(ctx context.Context, q *db.Queries, typ string, p Person) error {
+	data := []byte("{}")
+	if typ != EventDeleted {
+		var err error
+		data, err = json.Marshal(eventData{Name: p.Name, Age: p.Age, Address: p.Address, Work: p.Work, Latitude: p.Latitude, Longitude: p.Longitude, Phone: p.Phone, Email: p.Email, Custom: p.Custom})
+		if err != nil {
+			return err
+		}
+	}
+	_, err := q.AppendPersonEvent(ctx, db.AppendPersonEventParams{PersonID: p.ID, Type: typ, Data: data})
+	if err != nil {
+		return fmt.Errorf("append %s event for person %d: %w", typ, p.ID, translate(err))
+	}
+	return nil
+}
+
+func (s *EventStore) CreatePerson(ctx context.Context, p Person) (int32, error) {
+	return retryAborted(ctx, func() (int32, error) { return s.createPerson(ctx, p) })
+}
+
+func (s *EventStore) createPerson(ctx context.Context, p Person) (int32, error) {
+	defer s.observe(ctx, "create_person")()
+	err := s.inTx(ctx, func(q *db.Queries) error {
+		id, err := q.CreatePerson(ctx, db.CreatePersonParams{
+			Name:      p.Name,
+			Age:       p.Age,
+			Address:   p.Address,
+			Work:      p.Work,
+			Latitude:  p.Latitude,
+			Longitude: p.Longitude,
+			Phone:     p.Phone,
+			Email:     p.Email,
+			Metadata:  encodeMetadata(p.Custom),
+		})
+		if err != nil {
+			return fmt.Errorf("create person: %w", explainConflict(ctx, s.q, translate(err), p.Email))
+		}
+		p.ID = id
+		return appendEvent(ctx, q, EventCreated, p)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return p.ID, nil
+}
+
+func (s *EventStore) UpsertPerson(ctx context.Context, p Person) (Person, bool, error) {
+	var created bool
+	stored, err := retry(ctx, s.Postgres, func() (stored Person, err error) {
+		stored, created, err = s.upsertPerson(ctx, p)
+		return stored, err
+	})
+	return stored, created, err
+}
+
+func (s *EventStore) upsertPerson(ctx context.Context, p Person) (Person, bool, error) {
+	defer s.observe(ctx, "upsert_person")()
+	var created bool
+	err := s.inTx(ctx, func(q *db.Queries) error {
+		row, err := q.UpsertPerson(ctx, db.UpsertPersonParams{
+			ID:        p.ID,
+			Name:      p.Name,
+			Age:       p.Age,
+			Address:   p.Address,
+			Work:      p.Work,
+			Latitude:  p.Latitude,
+			Longitude: p.Longitude,
+			Phone:     p.Phone,
+			Email:     p.Email,
+			Metadata:  encodeMetadata(p.Custom),
+		})
+		if err != nil {
+			return fmt.Errorf("upsert person %d: %w", p.ID, explainConflict(ctx, s.q, translate(err), p.Email))
+		}
+		created, p.UpdatedAt = row.Inserted, row.UpdatedAt
+		if !created {
+			return appendEvent(ctx, q, EventUpdated, p)
+		}
+		if err := q.SyncPersonIDSequence(ctx); err != nil {
+			return fmt.Errorf("sync person id sequence: %w", translate(err))
+		}
+		return appendEvent(ctx, q, EventCreated, p)
+	})
+	if err != nil {
+		return Person{}, false, err
+	}
+	return p, created, nil
+}
+
+func (s *EventStore) UpdatePerson(ctx context.Context, id int32, patch PersonPatch) (Person, error) {
+	return retry(ctx, s.Postgres, func() (Person, error) { return s.updatePerson(ctx, id, patch) })
+}
+
+func (s *EventStore) updatePerson(ctx context.Context, id int32, patch PersonPatch) (Person, error) {
+	defer s.observe(ctx, "update_person")()
+	var p Person
+	err := s.inTx(ctx, func(q *db.Queries) error {
+		row, err := q.UpdatePerson(ctx, db.UpdatePersonParams{
+			Name:      patch.Name,
+			Age:       patch.Age,
+			Address:   patch.Address,
+			Work:      patch.Work,
+			Latitude:  patch.Latitude,
+			Longitude: patch.Longitude,
+			Phone:     patch.Phone,
+			Email:     patch.Email,
+			Metadata:  encodePatchMetadata(patch.Custom),
+			ID:        id,
+		})
+		if err != nil {
+			return fmt.Errorf("update person %d: %w", id, explainConflict(ctx, s.q, translate(err), patch.Email))
+		}
+		p = fromRow(row)
+		return appendEvent(ctx, q, EventUpdated, p)
+	})
+	return p, err
+}
+
+func (s *EventStore) ModifyPerson(ctx context.Context, id int32, fn func(p *Person) error) (Person, error) {
+	return retry(ctx, s.Postgres, func() (Person, error) { return s.modifyPerson(ctx, id, fn) })
+}
+
+func (s *EventStore) modifyPerson(ctx context.Context, id int32, fn func(p *Person) error) (Person, error) {
+	defer s.observe(ctx, "modify_person")()
+	var p Person
+	err := s.inTx(ctx, func(q *db.Queries) error {
+		var err error
+		if p, err = s.lockPerson(ctx, q, id); err != nil {
+			return err
+		}
+		if err := fn(&p); err != nil {
+			return err
+		}
+		p.ID = id
+		p.UpdatedAt, err = q.ReplacePerson(ctx, db.ReplacePersonParams{
+			Name:      p.Name,
+			Age:       p.Age,
+			Address:   p.Address,
+			Work:      p.Work,
+			Latitude:  p.Latitude,
+			Longitude: p.Longitude,
+			Phone:     p.Phone,
+			Email:     p.Email,
+			Metadata:  encodeMetadata(p.Custom),
+			ID:        id,
+		})
+		if err != nil {
+			return fmt.Errorf("modify person %d: %w", id, explainConflict(ctx, s.q, translate(err), p.Email))
+		}
+		return appendEvent(ctx, q, EventUpdated, p)
+	})
+	return p, err
+}
+
+func (s *EventStore) DeletePersonIf(ctx context.Context, id int32, check func(p Person) error) error {
+	return retryDelete(ctx, s.Postgres, func() error { return s.deletePersonIf(ctx, id, check) })
+}
+
+func (s *EventStore) deletePersonIf(ctx context.Context, id int32, check func(p Person) error) error {
+	defer s.observe(ctx, "delete_person")()
+	return s.inRawTx(ctx, func(tx pgx.Tx) error {
+		q := s.q.WithTx(tx)
+		p, err := s.lockPerson(ctx, q, id)
+		if err != nil {
+			return err
+		}
+		if err := check(p); err != nil {
+			return err
+		}
+		if err := s.deleteDependents(ctx, tx, id); err != nil {
+			return err
+		}
+		if _, err := q.DeletePerson(ctx, id); err != nil {
+			return fmt.Errorf("delete person %d: %w", id, translate(err))
+		}
+		return appendEvent(ctx, q, EventDeleted, p)
+	})
+}
+
+func (s *EventStore) DeletePerson(ctx context.Context, id int32) error {
+	return retryDelete(ctx, s.Postgres, func() error { return s.deletePerson(ctx, id) })
+}
+
+func (s *EventStore) deletePerson(ctx context.Context, id int32) error {
+	defer s.observe(ctx, "delete_person")()
+	return s.inRawTx(ctx, func(tx pgx.Tx) error {
+		if err := s.deleteDependents(ctx, tx, id); err != nil {
+			return err
+		}
+		q := s.q.WithTx(tx)
+		n, err := q.DeletePerson(ctx, id)
+		if err != nil {
+			return fmt.Errorf("delete person %d: %w", id, translate(err))
+		}
+		if n == 0 {
+			return fmt.Errorf("delete person %d: %w", id, ErrNotFound)
+		}
+		return appendEvent(ctx, q, EventDeleted, Person{ID: id})
+	})
+}
+
+// PersonAt returns the state from the last event recorded by at. Events carry
+// the full person, so nothing needs replaying.
+func (s *EventStore) PersonAt(ctx context.Context, id int32, at time.Time) (Person, error) {
+	return retry(ctx, s.Postgres, func() (Person, error) { return s.personAt(ctx, id, at) })
+}
+
+func (s *EventStore) personAt(ctx context.Context, id int32, at time.Time) (Person, error) {
+	defer s.observe(ctx, "get_person_at")()
+	e, err := s.q.GetPersonEventAt(ctx, db.GetPersonEventAtParams{PersonID: id, RecordedAt: at})
+	if err != nil {
+		return Person{}, fmt.Errorf("get person %d at %s: %w", id, at, translate(err))
+	}
+	if e.Type == EventDeleted {
+		return Person{}, fmt.Errorf("get person %d at %s: %w", id, at, ErrNotFound)
+	}
+	var data eventData
+	if err := json.Unmarshal(e.Data, &data); err != nil {
+		return Person{}, fmt.Errorf("decode event %d: %w", e.Seq, err)
+	}
+	return Person{
+		ID:        id,
+		Name:      data.Name,
+		Age:       data.Age,
+		Address:   data.Address,
+		Work:      data.Work,
+		UpdatedAt: e.RecordedAt,
+		Latitude:  data.Latitude,
+		Longitude: data.Longitude,
+		Phone:     data.Phone,
+		Email:     data.Email,
+		Custom:    data.Custom,
+	}, nil
+}
+
+const eventsAtCTE = `WITH persons_at AS (
+	SELECT id, name, age, address, work, updated_at, latitude, longitude, phone, email, metadata FROM (
+		SELECT DISTINCT ON (person_id) person_id AS id, type,
+			data->>'name' AS name, (data->>'age')::int AS age, data->>'address' AS address,
+			data->>'work' AS work, recorded_at AS updated_at,
+			(data->>'latitude')::float8 AS latitude, (data->>'longitude')::float8 AS longitude, data->>'phone' AS phone, data->>'email' AS email,
+			COALESCE(data->'custom', '{}') AS metadata
+		FROM person_events WHERE recorded_at <= $%d
+		ORDER BY person_id, version DESC
+	) latest WHERE type <> 'deleted'
+)`
+
+func (s *EventStore) ListPersonsAt(ctx context.Context, at time.Time, f ListFilter) ([]Person, error) {
+	return retry(ctx, s.Postgres, func() ([]Person, error) { return s.listPersonsAt(ctx, eventsAtCTE, at, f) })
+}
+
+const replayBatchSize = 1000
+
+// Rebuild brings the persons projection back in line with the event log in a
+// single transaction, so readers see either the old or the rebuilt table.
+// Rows are rewritten in place from each person's last event rather than
+// dropped and replayed, which attachments and photos referencing them would
+// not allow; persons whose last event is a delete go through the delete
+// policies. Persons without events are backfilled first rather than lost.
+// The change feed does not record the rewrite.
+func (s *EventStore) Rebuild(ctx context.Context) error {
+	return s.inRawTx(ctx, func(tx pgx.Tx) error {
+		q := s.q.WithTx(tx)
+		if err := q.SkipChangeFeed(ctx); err != nil {
+			return fmt.Errorf("rebuild: %w", translate(err))
+		}
+		if _, err := q.BackfillPersonEvents(ctx); err != nil {
+			return fmt.Errorf("rebuild: %w", translate(err))
+		}
+		var after int32
+		for {
+			events, err := q.ListLatestPersonEvents(ctx, db.ListLatestPersonEventsParams{PersonID: after, Limit: replayBatchSize})
+			if err != nil {
+				return fmt.Errorf("rebuild: %w", translate(err))
+			}
+			for _, e := range events {
+				if err := s.project(ctx, tx, e); err != nil {
+					return fmt.Errorf("rebuild: person %d: %w", e.PersonID, err)
+				}
+				after = e.PersonID
+			}
+			if len(events) < replayBatchSize {
+				break
+			}
+		}
+		if err := q.SyncPersonIDSequence(ctx); err != nil {
+			return fmt.Errorf("rebuild: %w", translate(err))
+		}
+		return nil
+	})
+}
+
+// project makes the person's row what its last event e says.
+func (s *EventStore) project(ctx context.Context, tx pgx.Tx, e db.PersonEvent) error {
+	q := s.q.WithTx(tx)
+	switch e.Type {
+	case EventCreated, EventUpdated:
+		var data eventData
+		if err := json.Unmarshal(e.Data, &data); err != nil {
+			return err
+		}
+		return translate(q.ProjectPerson(ctx, db.ProjectPersonParams{
+			ID:        e.PersonID,
+			Name:      data.Name,
+			Age:       data.Age,
+			Address:   data.Address,
+			Work:      data.Work,
+			UpdatedAt: e.RecordedAt,
+			Latitude:  data.Latitude,
+			Longitude: data.Longitude,
+			Phone:     data.Phone,
+			Email:     data.Email,
+			Metadata:  encodeMetadata(data.Custom),
+		}))
+	case EventDeleted:
+		if _, err := q.GetPerson(ctx, e.PersonID); err != nil {
+			if err = translate(err); errors.Is(err, ErrNotFound) {
+				return nil
+			}
+			return err
+		}
+		if err := s.deleteDependents(ctx, tx, e.PersonID); err != nil {
+			return err
+		}
+		_, err := q.DeletePerson(ctx, e.PersonID)
+		return translate(err)
+	}
+	return fmt.Errorf("unknown event type %q", e.Type)
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// QueryPlan is how Postgres ran a query, as EXPLAIN ANALYZE tells it.
+type QueryPlan struct {
+	Query string `json:"query"`
+	// Plan holds the lines of the text output, with actual row counts,
+	// timings and buffer usage.
+	Plan []string `json:"plan"`
+}
+
+type QueryExplainer interface {
+	// ExplainListPersons runs the query ListPersons runs for f under EXPLAIN
+	// ANALYZE.
+	ExplainListPersons(ctx context.Context, f ListFilter) (QueryPlan, error)
+}
+
+// ExplainListPersons runs in a read-only transaction, as EXPLAIN ANALYZE
+// really executes the query.
+func (s *Postgres) ExplainListPersons(ctx context.Context, f ListFilter) (QueryPlan, error) {
+	query, args, err := listQuery(f)
+	if err != nil {
+		return QueryPlan{}, err
+	}
+	defer s.observe(ctx, "explain_list_persons")()
+	tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return QueryPlan{}, fmt.Errorf("explain list persons: %w", translate(err))
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, "EXPLAIN (ANALYZE, BUFFERS) "+query, args...)
+	if err != nil {
+		return QueryPlan{}, fmt.Errorf("explain list persons: %w", translate(err))
+	}
+	plan, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return QueryPlan{}, fmt.Errorf("explain list persons: %w", translate(err))
+	}
+	return QueryPlan{Query: query, Plan: plan}, nil
+}
@@ -0,0 +1,269 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Job statuses. A failed job is retried once RunAt comes; a dead one used up
+// its attempts.
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+	JobFailed  = "failed"
+	JobDone    = "done"
+	JobDead    = "dead"
+)
+
+// Job is background work of a Type, described by its Payload, queued until
+// a worker claims it.
+type Job struct {
+	ID          string
+	Type        string
+	Payload     json.RawMessage
+	Status      string
+	Attempts    int
+	MaxAttempts int
+	// Progress is the percentage of the work a running job got through.
+	Progress int
+	// RunAt is when a pending or failed job is due.
+	RunAt time.Time
+	// LockedUntil is when the lease of a running job ends.
+	LockedUntil *time.Time
+	Result      json.RawMessage
+	// File is the file the job produced in object storage, if any.
+	File       *JobFile
+	Error      string
+	CreatedAt  time.Time
+	StartedAt  *time.Time
+	FinishedAt *time.Time
+}
+
+// JobLogLine is a line a job logged, numbered in order by ID.
+type JobLogLine struct {
+	ID      int64
+	At      time.Time
+	Message string
+}
+
+type JobFile struct {
+	Key         string `json:"key"`
+	ContentType string `json:"content_type"`
+	Filename    string `json:"filename"`
+}
+
+// JobQueue keeps jobs for any number of workers, in any number of
+// processes, each job claimed by one worker at a time.
+type JobQueue interface {
+	// EnqueueJob queues j, which needs an ID, a Type, a Payload and
+	// MaxAttempts.
+	EnqueueJob(ctx context.Context, j Job) (Job, error)
+	// Job fails with ErrNotFound for unknown IDs.
+	Job(ctx context.Context, id string) (Job, error)
+	// Jobs lists the jobs with status, newest first.
+	Jobs(ctx context.Context, status string, limit int) ([]Job, error)
+	// ClaimJob takes the job due first among those of types, pending or
+	// failed with RunAt passed or running with its lease ended, and runs it
+	// for lease, counting an attempt. ok is false when no job is due.
+	ClaimJob(ctx context.Context, types []string, lease time.Duration) (j Job, ok bool, err error)
+	// RenewJob extends the lease of a running job. attempt is the Attempts
+	// of the claim, as for the methods below: once another worker claimed
+	// the job, they fail with ErrNotFound.
+	RenewJob(ctx context.Context, id string, attempt int, lease time.Duration) error
+	// ReportJobProgress sets the progress of a running job and adds lines to
+	// its log.
+	ReportJobProgress(ctx context.Context, id string, attempt int, progress int, lines ...string) error
+	// JobLog lists the lines job id logged after the line with ID after.
+	JobLog(ctx context.Context, id string, after int64) ([]JobLogLine, error)
+	CompleteJob(ctx context.Context, id string, attempt int, result json.RawMessage, file *JobFile) error
+	// FailJob records why an attempt failed. The job is retried after
+	// backoff, unless that was its last attempt, which leaves it dead.
+	FailJob(ctx context.Context, id string, attempt int, reason string, backoff time.Duration) error
+	// RetryJob queues a dead job again with all its attempts; ErrNotFound
+	// when no dead job has the ID.
+	RetryJob(ctx context.Context, id string) (Job, error)
+	// PruneJobs deletes the jobs done before before and returns them.
+	PruneJobs(ctx context.Context, before time.Time) ([]Job, error)
+}
+
+const jobColumns = "id, type, payload, status, attempts, max_attempts, progress, run_at, locked_until, result, file, error, created_at, started_at, finished_at"
+
+func scanJob(row pgx.Row) (Job, error) {
+	var j Job
+	err := row.Scan(&j.ID, &j.Type, &j.Payload, &j.Status, &j.Attempts, &j.MaxAttempts, &j.Progress, &j.RunAt, &j.LockedUntil,
+		&j.Result, &j.File, &j.Error, &j.CreatedAt, &j.StartedAt, &j.FinishedAt)
+	return j, err
+}
+
+func (s *Postgres) queryJobs(ctx context.Context, query string, args ...any) ([]Job, error) {
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, translate(err)
+	}
+	defer rows.Close()
+	jobs := []Job{}
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, translate(err)
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, translate(rows.Err())
+}
+
+// EnqueueJob is not retried: a lost connection may have queued it already.
+func (s *Postgres) EnqueueJob(ctx context.Context, j Job) (Job, error) {
+	defer s.observe(ctx, "enqueue_job")()
+	row := s.pool.QueryRow(ctx, "INSERT INTO jobs (id, type, payload, max_attempts) VALUES ($1, $2, $3, $4) RETURNING "+jobColumns,
+		j.ID, j.Type, j.Payload, j.MaxAttempts)
+	j, err := scanJob(row)
+	if err != nil {
+		return Job{}, fmt.Errorf("enqueue job: %w", translate(err))
+	}
+	return j, nil
+}
+
+func (s *Postgres) Job(ctx context.Context, id string) (Job, error) {
+	return retry(ctx, s, func() (Job, error) {
+		defer s.observe(ctx, "get_job")()
+		j, err := scanJob(s.pool.QueryRow(ctx, "SELECT "+jobColumns+" FROM jobs WHERE id = $1", id))
+		if err != nil {
+			return Job{}, fmt.Errorf("get job %s: %w", id, translate(err))
+		}
+		return j, nil
+	})
+}
+
+func (s *Postgres) Jobs(ctx context.Context, status string, limit int) ([]Job, error) {
+	return retry(ctx, s, func() ([]Job, error) {
+		defer s.observe(ctx, "list_jobs")()
+		jobs, err := s.queryJobs(ctx, "SELECT "+jobColumns+" FROM jobs WHERE status = $1 ORDER BY created_at DESC LIMIT $2", status, limit)
+		if err != nil {
+			return nil, fmt.Errorf("list jobs: %w", err)
+		}
+		return jobs, nil
+	})
+}
+
+// ClaimJob skips the jobs other workers are claiming rather than waiting for
+// them.
+func (s *Postgres) ClaimJob(ctx context.Context, types []string, lease time.Duration) (Job, bool, error) {
+	defer s.observe(ctx, "claim_job")()
+	j, err := scanJob(s.pool.QueryRow(ctx, `UPDATE jobs SET status = 'running', attempts = attempts + 1, progress = 0,
+			started_at = now(), locked_until = now() + make_interval(secs => $2)
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE type = ANY($1) AND (status IN ('pending', 'failed') AND run_at <= now() OR status = 'running' AND locked_until < now())
+			ORDER BY run_at, created_at
+			LIMIT 1 FOR UPDATE SKIP LOCKED
+		) RETURNING `+jobColumns, types, lease.Seconds()))
+	if err = translate(err); errors.Is(err, ErrNotFound) {
+		return Job{}, false, nil
+	}
+	if err != nil {
+		return Job{}, false, fmt.Errorf("claim job: %w", err)
+	}
+	return j, true, nil
+}
+
+func (s *Postgres) RenewJob(ctx context.Context, id string, attempt int, lease time.Duration) error {
+	defer s.observe(ctx, "renew_job")()
+	return s.updateJob(ctx, "renew", "UPDATE jobs SET locked_until = now() + make_interval(secs => $3) WHERE id = $1 AND status = 'running' AND attempts = $2",
+		id, attempt, lease.Seconds())
+}
+
+func (s *Postgres) ReportJobProgress(ctx context.Context, id string, attempt int, progress int, lines ...string) error {
+	defer s.observe(ctx, "report_job_progress")()
+	if lines == nil {
+		lines = []string{}
+	}
+	var found bool
+	err := s.pool.QueryRow(ctx, `WITH job AS (
+			UPDATE jobs SET progress = $3 WHERE id = $1 AND status = 'running' AND attempts = $2 RETURNING id
+		), logged AS (
+			INSERT INTO job_log (job_id, message) SELECT job.id, line FROM job, unnest($4::text[]) WITH ORDINALITY AS l(line, n) ORDER BY n
+		)
+		SELECT EXISTS (SELECT 1 FROM job)`, id, attempt, progress, lines).Scan(&found)
+	if err != nil {
+		return fmt.Errorf("report job %s progress: %w", id, translate(err))
+	}
+	if !found {
+		return fmt.Errorf("report job %s progress: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+func (s *Postgres) JobLog(ctx context.Context, id string, after int64) ([]JobLogLine, error) {
+	return retry(ctx, s, func() ([]JobLogLine, error) {
+		defer s.observe(ctx, "job_log")()
+		rows, err := s.pool.Query(ctx, "SELECT id, at, message FROM job_log WHERE job_id = $1 AND id > $2 ORDER BY id", id, after)
+		if err != nil {
+			return nil, fmt.Errorf("job %s log: %w", id, translate(err))
+		}
+		defer rows.Close()
+		lines := []JobLogLine{}
+		for rows.Next() {
+			var l JobLogLine
+			if err := rows.Scan(&l.ID, &l.At, &l.Message); err != nil {
+				return nil, fmt.Errorf("job %s log: %w", id, translate(err))
+			}
+			lines = append(lines, l)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("job %s log: %w", id, translate(err))
+		}
+		return lines, nil
+	})
+}
+
+func (s *Postgres) CompleteJob(ctx context.Context, id string, attempt int, result json.RawMessage, file *JobFile) error {
+	defer s.observe(ctx, "complete_job")()
+	return s.updateJob(ctx, "complete", `UPDATE jobs SET status = 'done', progress = 100, result = $3, file = $4, error = '', locked_until = NULL, finished_at = now()
+		WHERE id = $1 AND status = 'running' AND attempts = $2`, id, attempt, result, file)
+}
+
+func (s *Postgres) FailJob(ctx context.Context, id string, attempt int, reason string, backoff time.Duration) error {
+	defer s.observe(ctx, "fail_job")()
+	return s.updateJob(ctx, "fail", `UPDATE jobs SET error = $3, run_at = now() + make_interval(secs => $4), locked_until = NULL,
+			status = CASE WHEN attempts >= max_attempts THEN 'dead' ELSE 'failed' END,
+			finished_at = CASE WHEN attempts >= max_attempts THEN now() END
+		WHERE id = $1 AND status = 'running' AND attempts = $2`, id, attempt, reason, backoff.Seconds())
+}
+
+// updateJob runs an update of the job with ID args[0], failing with
+// ErrNotFound when it matches no row.
+func (s *Postgres) updateJob(ctx context.Context, op, query string, args ...any) error {
+	tag, err := s.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("%s job %s: %w", op, args[0], translate(err))
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s job %s: %w", op, args[0], ErrNotFound)
+	}
+	return nil
+}
+
+func (s *Postgres) RetryJob(ctx context.Context, id string) (Job, error) {
+	defer s.observe(ctx, "retry_job")()
+	j, err := scanJob(s.pool.QueryRow(ctx, `UPDATE jobs SET status = 'pending', attempts = 0, run_at = now(), finished_at = NULL
+		WHERE id = $1 AND status = 'dead' RETURNING `+jobColumns, id))
+	if err != nil {
+		return Job{}, fmt.Errorf("retry job %s: %w", id, translate(err))
+	}
+	return j, nil
+}
+
+func (s *Postgres) PruneJobs(ctx context.Context, before time.Time) ([]Job, error) {
+	defer s.observe(ctx, "prune_jobs")()
+	jobs, err := s.queryJobs(ctx, "DELETE FROM jobs WHERE status = 'done' AND finished_at < $1 RETURNING "+jobColumns, before)
+	if err != nil {
+		return nil, fmt.Errorf("prune jobs: %w", err)
+	}
+	return jobs, nil
+}
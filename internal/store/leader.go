@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+const (
+	// leaderRetryDelay is how often instances that do not lead try to.
+	leaderRetryDelay = 10 * time.Second
+	// leaderCheckEvery is how often the leader checks it still holds the
+	// lock. A leader whose connection broke may run on for that long after
+	// another took over.
+	leaderCheckEvery = 5 * time.Second
+)
+
+// Lead runs fn on one instance at a time: the one holding the session-level
+// advisory lock named name. The others retry until it stops or loses its
+// connection, which releases the lock. fn's context is cancelled when
+// leadership is lost, and Lead returns when ctx is done or fn returns.
+func (s *Postgres) Lead(ctx context.Context, name string, fn func(ctx context.Context)) {
+	for ctx.Err() == nil {
+		led, err := s.lead(ctx, name, fn)
+		if ctx.Err() != nil || (led && err == nil) {
+			return
+		}
+		if led {
+			slog.WarnContext(ctx, "lost leadership", "name", name, "err", err)
+		} else if err != nil {
+			slog.WarnContext(ctx, "failed to contest leadership", "name", name, "err", err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(leaderRetryDelay):
+		}
+	}
+}
+
+// lead runs fn if the lock is free; led reports whether it was.
+func (s *Postgres) lead(ctx context.Context, name string, fn func(ctx context.Context)) (led bool, err error) {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return false, translate(err)
+	}
+	var locked bool
+	err = conn.QueryRow(ctx, "SELECT pg_try_advisory_lock(hashtextextended($1, 0))", name).Scan(&locked)
+	if err != nil || !locked {
+		conn.Release()
+		return false, translate(err)
+	}
+	// Ending the session releases the lock, whatever state it is in.
+	defer func() {
+		conn.Conn().Close(context.Background())
+		conn.Release()
+	}()
+
+	slog.InfoContext(ctx, "leading", "name", name)
+	leadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(leadCtx)
+	}()
+	ticker := time.NewTicker(leaderCheckEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return true, nil
+		case <-ticker.C:
+			if err := conn.Ping(leadCtx); err != nil {
+				cancel()
+				<-done
+				return true, translate(err)
+			}
+		}
+	}
+}
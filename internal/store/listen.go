@@ -0,0 +1,62 @@
+package store
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// PersonsChannel is where the persons_notify trigger announces changed IDs.
+const PersonsChannel = "persons_changed"
+
+const listenRetryDelay = time.Second
+
+// Listen calls onChange with the ID of every person changed by any instance
+// until ctx is done. It holds one pooled connection for LISTEN and reconnects
+// when that is lost; onReset runs after every (re)connect because whatever was
+// announced in between is gone.
+func (s *Postgres) Listen(ctx context.Context, onChange func(id int32), onReset func()) {
+	for ctx.Err() == nil {
+		err := s.listen(ctx, onChange, onReset)
+		if ctx.Err() != nil {
+			return
+		}
+		slog.WarnContext(ctx, "lost change notifications, reconnecting", "err", err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(listenRetryDelay):
+		}
+	}
+}
+
+func (s *Postgres) listen(ctx context.Context, onChange func(id int32), onReset func()) error {
+	conn, err := s.pool.Acquire(ctx)
+	if err != nil {
+		return translate(err)
+	}
+	// A connection in LISTEN mode must not go back to the pool for others.
+	defer func() {
+		conn.Conn().Close(context.Background())
+		conn.Release()
+	}()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{PersonsChannel}.Sanitize()); err != nil {
+		return translate(err)
+	}
+	onReset()
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return translate(err)
+		}
+		id, err := strconv.ParseInt(n.Payload, 10, 32)
+		if err != nil {
+			slog.WarnContext(ctx, "ignoring malformed change notification", "payload", n.Payload)
+			continue
+		}
+		onChange(int32(id))
+	}
+}
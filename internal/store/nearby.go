@@ -0,0 +1,94 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const earthRadiusKm = 6371.0
+
+// nearbyDistance is the haversine distance in km from ($1, $2). least() keeps
+// rounding from pushing asin out of its domain for antipodal points.
+const nearbyDistance = `2 * 6371.0 * asin(least(1, sqrt(
+	power(sin(radians(latitude - $1) / 2), 2) +
+	cos(radians($1)) * cos(radians(latitude)) * power(sin(radians(longitude - $2) / 2), 2))))`
+
+// nearbyBox returns the latitude and longitude ranges that contain every point
+// within radiusKm of lat, lon. lonOK is false when the circle reaches a pole or
+// crosses the antimeridian, where longitude cannot narrow the search.
+func nearbyBox(lat, lon, radiusKm float64) (minLat, maxLat, minLon, maxLon float64, lonOK bool) {
+	angle := radiusKm / earthRadiusKm
+	dLat := angle * 180 / math.Pi
+	minLat, maxLat = lat-dLat, lat+dLat
+	if minLat <= -90 || maxLat >= 90 {
+		return math.Max(minLat, -90), math.Min(maxLat, 90), 0, 0, false
+	}
+	dLon := math.Asin(math.Sin(angle)/math.Cos(lat*math.Pi/180)) * 180 / math.Pi
+	minLon, maxLon = lon-dLon, lon+dLon
+	if minLon < -180 || maxLon > 180 {
+		return minLat, maxLat, 0, 0, false
+	}
+	return minLat, maxLat, minLon, maxLon, true
+}
+
+// nearbyQuery narrows to the bounding box on the persons_location index before
+// computing exact distances, which avoids needing earthdistance or PostGIS.
+func nearbyQuery(q NearbyQuery) (string, []any) {
+	minLat, maxLat, minLon, maxLon, lonOK := nearbyBox(q.Lat, q.Lon, q.RadiusKm)
+	args := []any{q.Lat, q.Lon, q.RadiusKm, minLat, maxLat}
+	var b strings.Builder
+	fmt.Fprintf(&b, "SELECT %s, distance FROM (SELECT %s, %s AS distance FROM persons WHERE latitude BETWEEN $4 AND $5",
+		strings.Join(personColumns, ", "), strings.Join(personColumns, ", "), nearbyDistance)
+	if lonOK {
+		args = append(args, minLon, maxLon)
+		b.WriteString(" AND longitude BETWEEN $6 AND $7")
+	}
+	b.WriteString(") nearby WHERE distance <= $3 ORDER BY distance, id")
+	if q.Limit > 0 {
+		args = append(args, q.Limit)
+		fmt.Fprintf(&b, " LIMIT $%d", len(args))
+	}
+	if q.Offset > 0 {
+		args = append(args, q.Offset)
+		fmt.Fprintf(&b, " OFFSET $%d", len(args))
+	}
+	return b.String(), args
+}
+
+func (s *Postgres) NearbyPersons(ctx context.Context, q NearbyQuery) ([]NearbyHit, error) {
+	return retry(ctx, s, func() ([]NearbyHit, error) { return s.nearbyPersons(ctx, q) })
+}
+
+func (s *Postgres) nearbyPersons(ctx context.Context, q NearbyQuery) ([]NearbyHit, error) {
+	defer s.observe(ctx, "nearby_persons")()
+	query, args := nearbyQuery(q)
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("nearby persons: %w", translate(err))
+	}
+	defer rows.Close()
+
+	hits := []NearbyHit{}
+	for rows.Next() {
+		var h NearbyHit
+		if err := rows.Scan(append(personFields(&h.Person), &h.DistanceKm)...); err != nil {
+			return nil, fmt.Errorf("scan nearby person: %w", translate(err))
+		}
+		hits = append(hits, h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate nearby persons: %w", translate(err))
+	}
+	return hits, nil
+}
+
+// Distance returns the great-circle distance in km between two points, as
+// NearbyPersons computes it.
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	h := math.Pow(math.Sin((lat2-lat1)*rad/2), 2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Pow(math.Sin((lon2-lon1)*rad/2), 2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
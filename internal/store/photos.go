@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+const photoColumns = "person_id, object_key, hash, width, height, created_at"
+
+// SetPhoto is not retried: a retry after a lost connection could return the
+// photo it had just set as the one replaced.
+func (s *Postgres) SetPhoto(ctx context.Context, p Photo) (Photo, string, error) {
+	defer s.observe(ctx, "set_photo")()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return Photo{}, "", fmt.Errorf("set photo: %w", translate(err))
+	}
+	defer tx.Rollback(ctx)
+
+	// Locking the person serializes replacing their photo, so every replaced
+	// photo is returned once.
+	if err := tx.QueryRow(ctx, "SELECT id FROM persons WHERE id = $1 FOR UPDATE", p.PersonID).Scan(new(int32)); err != nil {
+		return Photo{}, "", fmt.Errorf("set photo: %w", translate(err))
+	}
+	var previous string
+	err = tx.QueryRow(ctx, "DELETE FROM photos WHERE person_id = $1 RETURNING object_key", p.PersonID).Scan(&previous)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return Photo{}, "", fmt.Errorf("set photo: %w", translate(err))
+	}
+	row := tx.QueryRow(ctx, "INSERT INTO photos (person_id, object_key, hash, width, height) VALUES ($1, $2, $3, $4, $5) RETURNING "+photoColumns,
+		p.PersonID, p.ObjectKey, p.Hash, p.Width, p.Height)
+	created, err := scanPhoto(row)
+	if err != nil {
+		return Photo{}, "", fmt.Errorf("set photo: %w", translate(err))
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Photo{}, "", fmt.Errorf("set photo: %w", translate(err))
+	}
+	return created, previous, nil
+}
+
+func (s *Postgres) Photo(ctx context.Context, personID int32) (Photo, error) {
+	return retry(ctx, s, func() (Photo, error) { return s.photo(ctx, personID) })
+}
+
+func (s *Postgres) photo(ctx context.Context, personID int32) (Photo, error) {
+	defer s.observe(ctx, "get_photo")()
+	p, err := scanPhoto(s.pool.QueryRow(ctx, "SELECT "+photoColumns+" FROM photos WHERE person_id = $1", personID))
+	if err != nil {
+		return Photo{}, fmt.Errorf("get photo of person %d: %w", personID, translate(err))
+	}
+	return p, nil
+}
+
+func (s *Postgres) PhotosOf(ctx context.Context, persons []int32) (map[int32]Photo, error) {
+	return retry(ctx, s, func() (map[int32]Photo, error) { return s.photosOf(ctx, persons) })
+}
+
+func (s *Postgres) photosOf(ctx context.Context, persons []int32) (map[int32]Photo, error) {
+	defer s.observe(ctx, "photos_of")()
+	rows, err := s.pool.Query(ctx, "SELECT "+photoColumns+" FROM photos WHERE person_id = ANY($1)", persons)
+	if err != nil {
+		return nil, fmt.Errorf("get photos of persons: %w", translate(err))
+	}
+	defer rows.Close()
+
+	photos := map[int32]Photo{}
+	for rows.Next() {
+		p, err := scanPhoto(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan photo: %w", translate(err))
+		}
+		photos[*p.PersonID] = p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate photos: %w", translate(err))
+	}
+	return photos, nil
+}
+
+// DeletePhoto is not retried: a retry after a lost connection would not find
+// the row and fail although it was deleted.
+func (s *Postgres) DeletePhoto(ctx context.Context, personID int32) (Photo, error) {
+	defer s.observe(ctx, "delete_photo")()
+	p, err := scanPhoto(s.pool.QueryRow(ctx, "DELETE FROM photos WHERE person_id = $1 RETURNING "+photoColumns, personID))
+	if err != nil {
+		return Photo{}, fmt.Errorf("delete photo of person %d: %w", personID, translate(err))
+	}
+	return p, nil
+}
+
+func (s *Postgres) UnreferencedPhotoKeys(ctx context.Context, keys []string) ([]string, error) {
+	return retry(ctx, s, func() ([]string, error) { return s.unreferencedPhotoKeys(ctx, keys) })
+}
+
+func (s *Postgres) unreferencedPhotoKeys(ctx context.Context, keys []string) ([]string, error) {
+	defer s.observe(ctx, "unreferenced_photo_keys")()
+	rows, err := s.pool.Query(ctx, "SELECT k FROM unnest($1::text[]) AS k WHERE NOT EXISTS (SELECT 1 FROM photos WHERE object_key = k)", keys)
+	if err != nil {
+		return nil, fmt.Errorf("find unreferenced photo keys: %w", translate(err))
+	}
+	defer rows.Close()
+
+	unreferenced := []string{}
+	for rows.Next() {
+		var k string
+		if err := rows.Scan(&k); err != nil {
+			return nil, fmt.Errorf("scan photo key: %w", translate(err))
+		}
+		unreferenced = append(unreferenced, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate photo keys: %w", translate(err))
+	}
+	return unreferenced, nil
+}
+
+func scanPhoto(row pgx.Row) (Photo, error) {
+	var p Photo
+	err := row.Scan(&p.PersonID, &p.ObjectKey, &p.Hash, &p.Width, &p.Height, &p.CreatedAt)
+	return p, err
+}
@@ -0,0 +1,494 @@
+package store
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/store/db"
+	"ci_cd/rsoi_lab_1/internal/store/sqlb"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// QueryObserver is called when a named query starts; the returned func is
+// called once it has finished.
+type QueryObserver func(ctx context.Context, query string) func()
+
+//go:embed sql/schema.sql
+var schema string
+
+// Postgres implements Store on top of the sqlc generated queries in the db
+// package. Regenerate them with `sqlc generate` after editing sql/*.sql.
+// Listing takes optional filters and is built with sqlb instead. Statements
+// are prepared once per pooled connection and cached by pgx.
+type Postgres struct {
+	pool    *pgxpool.Pool
+	q       *db.Queries
+	observe QueryObserver
+
+	// LockTimeout bounds how long ModifyPerson waits for a row lock held by
+	// another transaction. Zero waits indefinitely.
+	LockTimeout time.Duration
+	// DeletePolicies overrides the default DeletePolicy of Relations by name.
+	DeletePolicies map[string]DeletePolicy
+}
+
+func NewPostgres(pool *pgxpool.Pool, observe QueryObserver) *Postgres {
+	if observe == nil {
+		observe = func(context.Context, string) func() { return func() {} }
+	}
+	return &Postgres{pool: pool, q: db.New(pool), observe: observe}
+}
+
+func (s *Postgres) Migrate(ctx context.Context) error {
+	if _, err := s.pool.Exec(ctx, schema); err != nil {
+		return fmt.Errorf("failed to create table: %w", translate(err))
+	}
+	return nil
+}
+
+func fromRow(row db.Person) Person {
+	return Person{
+		ID:        row.ID,
+		Name:      row.Name,
+		Age:       row.Age,
+		Address:   row.Address,
+		Work:      row.Work,
+		UpdatedAt: row.UpdatedAt,
+		Latitude:  row.Latitude,
+		Longitude: row.Longitude,
+		Phone:     row.Phone,
+		Email:     row.Email,
+		Custom:    decodeMetadata(row.Metadata),
+	}
+}
+
+// encodeMetadata turns custom field values into the metadata column, which
+// holds an object even when there are none. The values were decoded from
+// JSON, so they always encode.
+func encodeMetadata(custom map[string]any) []byte {
+	if custom == nil {
+		return []byte("{}")
+	}
+	b, _ := json.Marshal(custom)
+	return b
+}
+
+// encodePatchMetadata is encodeMetadata for a patch, where nil leaves the
+// column alone.
+func encodePatchMetadata(custom map[string]any) []byte {
+	if custom == nil {
+		return nil
+	}
+	return encodeMetadata(custom)
+}
+
+func decodeMetadata(b []byte) map[string]any {
+	var custom map[string]any
+	_ = json.Unmarshal(b, &custom)
+	return custom
+}
+
+var sortColumns = map[string]string{
+	"id":   "id",
+	"name": "name",
+	"age":  "age",
+}
+
+// personColumns are selected wherever persons are scanned with personFields.
+var personColumns = []string{"id", "name", "age", "address", "work", "updated_at", "latitude", "longitude", "phone", "email", "metadata"}
+
+// personFields returns the scan destinations for personColumns.
+func personFields(p *Person) []any {
+	return []any{&p.ID, &p.Name, &p.Age, &p.Address, &p.Work, &p.UpdatedAt, &p.Latitude, &p.Longitude, &p.Phone, &p.Email, &p.Custom}
+}
+
+func listQuery(f ListFilter) (string, []any, error) {
+	return listQueryFrom("persons", f)
+}
+
+// listQueryFrom lists from any relation with the columns of persons, such as
+// a CTE reconstructing it from history.
+func listQueryFrom(relation string, f ListFilter) (string, []any, error) {
+	q := sqlb.Select(personColumns...).From(relation)
+	if f.Name != "" {
+		q = q.Where(sqlb.Contains("name", f.Name))
+	}
+	if f.MinAge != nil {
+		q = q.Where(sqlb.Gte("age", *f.MinAge))
+	}
+	if f.MaxAge != nil {
+		q = q.Where(sqlb.Lte("age", *f.MaxAge))
+	}
+	if !f.UpdatedBefore.IsZero() {
+		q = q.Where(sqlb.Expr("updated_at < ?", f.UpdatedBefore))
+	}
+	for _, k := range f.Sort {
+		col, ok := sortColumns[k.Field]
+		if !ok {
+			return "", nil, &ValidationError{Field: "sort", Message: fmt.Sprintf("unknown sort field %q", k.Field)}
+		}
+		q = q.OrderBy(col, k.Desc)
+	}
+	q = q.OrderBy("id", false)
+	if f.Limit > 0 {
+		q = q.Limit(uint64(f.Limit))
+	}
+	if f.Offset > 0 {
+		q = q.Offset(uint64(f.Offset))
+	}
+	return q.ToSQL()
+}
+
+func (s *Postgres) ListPersons(ctx context.Context, f ListFilter) ([]Person, error) {
+	return retry(ctx, s, func() ([]Person, error) { return s.listPersons(ctx, f) })
+}
+
+func (s *Postgres) listPersons(ctx context.Context, f ListFilter) ([]Person, error) {
+	query, args, err := listQuery(f)
+	if err != nil {
+		return nil, err
+	}
+	defer s.observe(ctx, "list_persons")()
+	return s.queryPersons(ctx, query, args)
+}
+
+// listAtQuery lists from persons_at, which cte defines from history. The
+// cutoff time goes last, so cte refers to it as $%d.
+func listAtQuery(cte string, at time.Time, f ListFilter) (string, []any, error) {
+	query, args, err := listQueryFrom("persons_at", f)
+	if err != nil {
+		return "", nil, err
+	}
+	args = append(args, at)
+	return fmt.Sprintf(cte, len(args)) + " " + query, args, nil
+}
+
+func (s *Postgres) listPersonsAt(ctx context.Context, cte string, at time.Time, f ListFilter) ([]Person, error) {
+	query, args, err := listAtQuery(cte, at, f)
+	if err != nil {
+		return nil, err
+	}
+	defer s.observe(ctx, "list_persons_at")()
+	return s.queryPersons(ctx, query, args)
+}
+
+func (s *Postgres) queryPersons(ctx context.Context, query string, args []any) ([]Person, error) {
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list persons: %w", translate(err))
+	}
+	defer rows.Close()
+
+	persons := []Person{}
+	for rows.Next() {
+		var p Person
+		if err := rows.Scan(personFields(&p)...); err != nil {
+			return nil, fmt.Errorf("scan person: %w", translate(err))
+		}
+		persons = append(persons, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate persons: %w", translate(err))
+	}
+	return persons, nil
+}
+
+func (s *Postgres) GetPerson(ctx context.Context, id int32) (Person, error) {
+	return retry(ctx, s, func() (Person, error) { return s.getPerson(ctx, id) })
+}
+
+func (s *Postgres) getPerson(ctx context.Context, id int32) (Person, error) {
+	defer s.observe(ctx, "get_person")()
+	row, err := s.q.GetPerson(ctx, id)
+	if err != nil {
+		return Person{}, fmt.Errorf("get person %d: %w", id, translate(err))
+	}
+	return fromRow(row), nil
+}
+
+func (s *Postgres) CreatePerson(ctx context.Context, p Person) (int32, error) {
+	return retryAborted(ctx, func() (int32, error) { return s.createPerson(ctx, p) })
+}
+
+func (s *Postgres) createPerson(ctx context.Context, p Person) (int32, error) {
+	defer s.observe(ctx, "create_person")()
+	id, err := s.q.CreatePerson(ctx, db.CreatePersonParams{
+		Name:      p.Name,
+		Age:       p.Age,
+		Address:   p.Address,
+		Work:      p.Work,
+		Latitude:  p.Latitude,
+		Longitude: p.Longitude,
+		Phone:     p.Phone,
+		Email:     p.Email,
+		Metadata:  encodeMetadata(p.Custom),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("create person: %w", explainConflict(ctx, s.q, translate(err), p.Email))
+	}
+	return id, nil
+}
+
+// UpsertPerson inserts or replaces the person with an explicit ID. When a row
+// is created the serial sequence is moved past it, so later CreatePerson calls
+// don't collide with IDs brought in from outside.
+func (s *Postgres) UpsertPerson(ctx context.Context, p Person) (Person, bool, error) {
+	var created bool
+	stored, err := retry(ctx, s, func() (stored Person, err error) {
+		stored, created, err = s.upsertPerson(ctx, p)
+		return stored, err
+	})
+	return stored, created, err
+}
+
+func (s *Postgres) upsertPerson(ctx context.Context, p Person) (Person, bool, error) {
+	defer s.observe(ctx, "upsert_person")()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return Person{}, false, fmt.Errorf("upsert person %d: %w", p.ID, translate(err))
+	}
+	defer tx.Rollback(ctx)
+
+	q := s.q.WithTx(tx)
+	row, err := q.UpsertPerson(ctx, db.UpsertPersonParams{
+		ID:        p.ID,
+		Name:      p.Name,
+		Age:       p.Age,
+		Address:   p.Address,
+		Work:      p.Work,
+		Latitude:  p.Latitude,
+		Longitude: p.Longitude,
+		Phone:     p.Phone,
+		Email:     p.Email,
+		Metadata:  encodeMetadata(p.Custom),
+	})
+	if err != nil {
+		return Person{}, false, fmt.Errorf("upsert person %d: %w", p.ID, explainConflict(ctx, s.q, translate(err), p.Email))
+	}
+	if row.Inserted {
+		if err := q.SyncPersonIDSequence(ctx); err != nil {
+			return Person{}, false, fmt.Errorf("sync person id sequence: %w", translate(err))
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Person{}, false, fmt.Errorf("upsert person %d: %w", p.ID, translate(err))
+	}
+	p.UpdatedAt = row.UpdatedAt
+	return p, row.Inserted, nil
+}
+
+// UpdatePerson applies the patch in a single UPDATE ... RETURNING, so there is
+// no window between reading the row and writing it back for a concurrent
+// PATCH to slip into: nil fields keep whatever value the row has at write time.
+func (s *Postgres) UpdatePerson(ctx context.Context, id int32, patch PersonPatch) (Person, error) {
+	return retry(ctx, s, func() (Person, error) { return s.updatePerson(ctx, id, patch) })
+}
+
+func (s *Postgres) updatePerson(ctx context.Context, id int32, patch PersonPatch) (Person, error) {
+	defer s.observe(ctx, "update_person")()
+	row, err := s.q.UpdatePerson(ctx, db.UpdatePersonParams{
+		Name:      patch.Name,
+		Age:       patch.Age,
+		Address:   patch.Address,
+		Work:      patch.Work,
+		Latitude:  patch.Latitude,
+		Longitude: patch.Longitude,
+		Phone:     patch.Phone,
+		Email:     patch.Email,
+		Metadata:  encodePatchMetadata(patch.Custom),
+		ID:        id,
+	})
+	if err != nil {
+		return Person{}, fmt.Errorf("update person %d: %w", id, explainConflict(ctx, s.q, translate(err), patch.Email))
+	}
+	return fromRow(row), nil
+}
+
+func (s *Postgres) ModifyPerson(ctx context.Context, id int32, fn func(p *Person) error) (Person, error) {
+	return retry(ctx, s, func() (Person, error) { return s.modifyPerson(ctx, id, fn) })
+}
+
+func (s *Postgres) modifyPerson(ctx context.Context, id int32, fn func(p *Person) error) (Person, error) {
+	defer s.observe(ctx, "modify_person")()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return Person{}, fmt.Errorf("modify person %d: %w", id, translate(err))
+	}
+	defer tx.Rollback(ctx)
+
+	q := s.q.WithTx(tx)
+	p, err := s.lockPerson(ctx, q, id)
+	if err != nil {
+		return Person{}, err
+	}
+	if err := fn(&p); err != nil {
+		return Person{}, err
+	}
+	p.ID = id
+	p.UpdatedAt, err = q.ReplacePerson(ctx, db.ReplacePersonParams{
+		Name:      p.Name,
+		Age:       p.Age,
+		Address:   p.Address,
+		Work:      p.Work,
+		Latitude:  p.Latitude,
+		Longitude: p.Longitude,
+		Phone:     p.Phone,
+		Email:     p.Email,
+		Metadata:  encodeMetadata(p.Custom),
+		ID:        id,
+	})
+	if err != nil {
+		return Person{}, fmt.Errorf("modify person %d: %w", id, explainConflict(ctx, s.q, translate(err), p.Email))
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return Person{}, fmt.Errorf("modify person %d: %w", id, translate(err))
+	}
+	return p, nil
+}
+
+func (s *Postgres) DeletePersonIf(ctx context.Context, id int32, check func(p Person) error) error {
+	return retryDelete(ctx, s, func() error { return s.deletePersonIf(ctx, id, check) })
+}
+
+func (s *Postgres) deletePersonIf(ctx context.Context, id int32, check func(p Person) error) error {
+	defer s.observe(ctx, "delete_person")()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("delete person %d: %w", id, translate(err))
+	}
+	defer tx.Rollback(ctx)
+
+	q := s.q.WithTx(tx)
+	p, err := s.lockPerson(ctx, q, id)
+	if err != nil {
+		return err
+	}
+	if err := check(p); err != nil {
+		return err
+	}
+	if err := s.deleteDependents(ctx, tx, id); err != nil {
+		return err
+	}
+	if _, err := q.DeletePerson(ctx, id); err != nil {
+		return fmt.Errorf("delete person %d: %w", id, translate(err))
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("delete person %d: %w", id, translate(err))
+	}
+	return nil
+}
+
+// lockPerson applies LockTimeout to the transaction q runs in and reads the
+// person with FOR UPDATE.
+func (s *Postgres) lockPerson(ctx context.Context, q *db.Queries, id int32) (Person, error) {
+	if s.LockTimeout > 0 {
+		err := q.SetLockTimeout(ctx, strconv.FormatInt(s.LockTimeout.Milliseconds(), 10)+"ms")
+		if err != nil {
+			return Person{}, fmt.Errorf("lock person %d: %w", id, translate(err))
+		}
+	}
+	row, err := q.GetPersonForUpdate(ctx, id)
+	if err != nil {
+		return Person{}, fmt.Errorf("lock person %d: %w", id, translate(err))
+	}
+	return fromRow(row), nil
+}
+
+func (s *Postgres) DeletePerson(ctx context.Context, id int32) error {
+	return retryDelete(ctx, s, func() error { return s.deletePerson(ctx, id) })
+}
+
+func (s *Postgres) deletePerson(ctx context.Context, id int32) error {
+	defer s.observe(ctx, "delete_person")()
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("delete person %d: %w", id, translate(err))
+	}
+	defer tx.Rollback(ctx)
+
+	if err := s.deleteDependents(ctx, tx, id); err != nil {
+		return err
+	}
+	n, err := s.q.WithTx(tx).DeletePerson(ctx, id)
+	if err != nil {
+		return fmt.Errorf("delete person %d: %w", id, translate(err))
+	}
+	if n == 0 {
+		return fmt.Errorf("delete person %d: %w", id, ErrNotFound)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("delete person %d: %w", id, translate(err))
+	}
+	return nil
+}
+
+// uniqueFields names the field each unique constraint is about.
+var uniqueFields = map[string]string{
+	"persons_email":      "email",
+	"custom_fields_pkey": "name",
+}
+
+// translate wraps driver errors into the package sentinels while keeping the
+// original error in the chain for logging.
+func translate(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		class := pgErr.Code[:2]
+		switch {
+		case pgErr.Code == "23505" && uniqueFields[pgErr.ConstraintName] != "":
+			return fmt.Errorf("%w: %w", &ConflictError{Field: uniqueFields[pgErr.ConstraintName]}, err)
+		case pgErr.Code == "23505" || pgErr.Code == "23503" || class == "40":
+			return fmt.Errorf("%w: %w", ErrConflict, err)
+		case pgErr.Code == "55P03":
+			return fmt.Errorf("%w: %w", ErrLockTimeout, err)
+		case class == "22" || class == "23":
+			// Detail often quotes the whole failing row, so it stays in the
+			// server log and the client only learns which rule refused it.
+			slog.Warn("value rejected by database", "code", pgErr.Code, "constraint", pgErr.ConstraintName, "column", pgErr.ColumnName, "message", pgErr.Message, "detail", pgErr.Detail)
+			reason := pgErr.ConstraintName
+			if reason == "" {
+				reason = "SQLSTATE " + pgErr.Code
+			}
+			return fmt.Errorf("%w: %w", ErrConstraint, &ValidationError{Field: pgErr.ColumnName, Message: reason})
+		case class == "08" || class == "53" || class == "57":
+			return fmt.Errorf("%w: %w", ErrUnavailable, err)
+		}
+		return err
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || pgconn.SafeToRetry(err) || strings.Contains(err.Error(), "closed pool") {
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+	return err
+}
+
+// explainConflict fills in who holds the email a ConflictError is about, which
+// the violation itself does not say. q must not be the failed transaction's,
+// since the violation aborted it.
+func explainConflict(ctx context.Context, q *db.Queries, err error, email *string) error {
+	var cerr *ConflictError
+	if email == nil || !errors.As(err, &cerr) || cerr.Field != "email" {
+		return err
+	}
+	if id, lerr := q.GetPersonIDByEmail(ctx, *email); lerr == nil {
+		cerr.ExistingID = id
+	}
+	return err
+}
@@ -0,0 +1,255 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestTranslate(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"No rows", pgx.ErrNoRows, ErrNotFound},
+		{"Unique violation", &pgconn.PgError{Code: "23505"}, ErrConflict},
+		{"Email taken", &pgconn.PgError{Code: "23505", ConstraintName: "persons_email"}, ErrConflict},
+		{"Foreign key violation", &pgconn.PgError{Code: "23503"}, ErrConflict},
+		{"Not null violation", &pgconn.PgError{Code: "23502", ColumnName: "name"}, ErrConstraint},
+		{"Check violation", &pgconn.PgError{Code: "23514", ColumnName: "age"}, ErrConstraint},
+		{"Value too long", &pgconn.PgError{Code: "22001"}, ErrConstraint},
+		{"Too many connections", &pgconn.PgError{Code: "53300"}, ErrUnavailable},
+		{"Connection failure", &pgconn.PgError{Code: "08006"}, ErrUnavailable},
+		{"Deadlock", &pgconn.PgError{Code: "40P01"}, ErrConflict},
+		{"Lock timeout", &pgconn.PgError{Code: "55P03"}, ErrLockTimeout},
+		{"Admin shutdown", &pgconn.PgError{Code: "57P01"}, ErrUnavailable},
+		{"Closed pool", errors.New("closed pool"), ErrUnavailable},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := translate(tc.err); !errors.Is(got, tc.want) {
+				t.Errorf("translate(%v) = %v, want %v", tc.err, got, tc.want)
+			}
+		})
+	}
+
+	err := translate(&pgconn.PgError{Code: "23514", ColumnName: "age", ConstraintName: "persons_age_check", Message: "new row violates check constraint", Detail: "Failing row contains (1, Ann, -1, ann@example.com)."})
+	var verr *ValidationError
+	if !errors.As(err, &verr) || verr.Field != "age" || verr.Message != "persons_age_check" || strings.Contains(err.Error(), "Ann") {
+		t.Errorf("Expected only the column and constraint, got %v", err)
+	}
+}
+
+func TestTranslateUniqueField(t *testing.T) {
+	var cerr *ConflictError
+	if err := translate(&pgconn.PgError{Code: "23505", ConstraintName: "persons_email"}); !errors.As(err, &cerr) || cerr.Field != "email" {
+		t.Errorf("Expected a ConflictError on email, got %v", err)
+	}
+	if err := translate(&pgconn.PgError{Code: "23505", ConstraintName: "persons_pkey"}); errors.As(err, &cerr) {
+		t.Errorf("Expected other unique violations to name no field, got %v", err)
+	}
+}
+
+func TestListQuery(t *testing.T) {
+	age := int32(30)
+	query, args, err := listQuery(ListFilter{
+		Name:   "ann",
+		MaxAge: &age,
+		Sort:   []SortKey{{Field: "age", Desc: true}},
+		Limit:  50,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := "SELECT id, name, age, address, work, updated_at, latitude, longitude, phone, email, metadata FROM persons WHERE (name ILIKE $1) AND (age <= $2) ORDER BY age DESC, id LIMIT $3"
+	if query != want || len(args) != 3 || args[0] != "%ann%" || args[1] != age || args[2] != uint64(50) {
+		t.Errorf("Got %q %v, want %q", query, args, want)
+	}
+
+	before := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	query, args, err = listQuery(ListFilter{UpdatedBefore: before, Limit: 100})
+	want = "SELECT id, name, age, address, work, updated_at, latitude, longitude, phone, email, metadata FROM persons WHERE updated_at < $1 ORDER BY id LIMIT $2"
+	if err != nil || query != want || len(args) != 2 || args[0] != before {
+		t.Errorf("Got %q %v %v, want %q", query, args, err, want)
+	}
+
+	_, _, err = listQuery(ListFilter{Sort: []SortKey{{Field: "address"}}})
+	if !errors.Is(err, ErrValidation) {
+		t.Errorf("Expected validation error for unknown sort field, got %v", err)
+	}
+}
+
+func TestAttachmentsQuery(t *testing.T) {
+	query, args, err := attachmentsQuery(3, AttachmentFilter{MediaType: "image/*", Limit: 10, Offset: 20})
+	want := "SELECT " + attachmentColumns + " FROM attachments WHERE (person_id = $1) AND (starts_with(content_type, $2)) ORDER BY id LIMIT $3 OFFSET $4"
+	if err != nil || query != want || args[0] != int32(3) || args[1] != "image/" {
+		t.Errorf("Got %q %v %v, want %q", query, args, err, want)
+	}
+	query, args, _ = attachmentsQuery(3, AttachmentFilter{MediaType: "application/pdf"})
+	if !strings.HasSuffix(query, "AND (split_part(content_type, ';', 1) = $2) ORDER BY id") || args[1] != "application/pdf" {
+		t.Errorf("Unexpected exact type query %q %v", query, args)
+	}
+}
+
+func TestSearchQuery(t *testing.T) {
+	query, args := searchQuery(SearchQuery{Text: "ann", ByRelevance: true, Limit: 10})
+	if !strings.HasSuffix(query, "ORDER BY score DESC, id LIMIT $3") || len(args) != 3 || args[0] != "ann" {
+		t.Errorf("Unexpected relevance query %q %v", query, args)
+	}
+	if !strings.Contains(query, "WHERE "+searchDocument+" @@ q") {
+		t.Errorf("Expected the query to match the indexed expression, got %q", query)
+	}
+	query, args = searchQuery(SearchQuery{Text: "ann", Offset: 5})
+	if !strings.HasSuffix(query, "ORDER BY id OFFSET $3") || len(args) != 3 {
+		t.Errorf("Unexpected query %q %v", query, args)
+	}
+}
+
+func TestNearbyQuery(t *testing.T) {
+	query, args := nearbyQuery(NearbyQuery{Lat: 55.75, Lon: 37.62, RadiusKm: 10, Limit: 20})
+	if !strings.Contains(query, "latitude BETWEEN $4 AND $5 AND longitude BETWEEN $6 AND $7") ||
+		!strings.HasSuffix(query, "WHERE distance <= $3 ORDER BY distance, id LIMIT $8") || len(args) != 8 {
+		t.Errorf("Unexpected query %q %v", query, args)
+	}
+	minLat, maxLat := args[3].(float64), args[4].(float64)
+	if math.Abs(minLat-55.66) > 0.001 || math.Abs(maxLat-55.84) > 0.001 {
+		t.Errorf("Unexpected latitude range %v..%v", minLat, maxLat)
+	}
+
+	// Near the antimeridian longitude cannot bound the search.
+	query, args = nearbyQuery(NearbyQuery{Lat: 0, Lon: 179.99, RadiusKm: 10})
+	if strings.Contains(query, "longitude BETWEEN") || len(args) != 5 {
+		t.Errorf("Unexpected query %q %v", query, args)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	// Moscow to Saint Petersburg.
+	if d := Distance(55.7558, 37.6173, 59.9343, 30.3351); d < 630 || d > 636 {
+		t.Errorf("Got %v km, want about 633", d)
+	}
+	if d := Distance(10, 20, 10, 20); d != 0 {
+		t.Errorf("Got %v km between the same point, want 0", d)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	pool, err := pgxpool.New(context.Background(), "postgres://localhost/unused")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	s := NewPostgres(pool, nil)
+
+	testCases := []struct {
+		name      string
+		err       error
+		wantCalls int
+	}{
+		{"Success", nil, 1},
+		{"Admin shutdown", &pgconn.PgError{Code: "57P01"}, 2},
+		{"Connection reset", fmt.Errorf("%w: %w", ErrUnavailable, io.ErrUnexpectedEOF), 2},
+		{"Not found", ErrNotFound, 1},
+		{"Unique violation", &pgconn.PgError{Code: "23505"}, 1},
+		{"Serialization failure", fmt.Errorf("%w: %w", ErrConflict, &pgconn.PgError{Code: "40001"}), 2},
+		{"Deadlock", &pgconn.PgError{Code: "40P01"}, 2},
+		{"Event version taken", fmt.Errorf("%w: %w", ErrConflict, &pgconn.PgError{Code: "23505", ConstraintName: eventVersionKey}), 2},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			got, err := retry(context.Background(), s, func() (int, error) {
+				calls++
+				if calls == 1 && tc.err != nil {
+					return 0, tc.err
+				}
+				return 42, nil
+			})
+			if calls != tc.wantCalls {
+				t.Errorf("Expected %d calls, got %d", tc.wantCalls, calls)
+			}
+			if tc.wantCalls == 2 && (err != nil || got != 42) {
+				t.Errorf("Expected retry to succeed, got %d, %v", got, err)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	retry(ctx, s, func() (int, error) {
+		calls++
+		return 0, io.ErrUnexpectedEOF
+	})
+	if calls != 1 {
+		t.Errorf("Expected no retry after cancellation, got %d calls", calls)
+	}
+}
+
+func TestRetryDelete(t *testing.T) {
+	pool, err := pgxpool.New(context.Background(), "postgres://localhost/unused")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	s := NewPostgres(pool, nil)
+
+	calls := 0
+	err = retryDelete(context.Background(), s, func() error {
+		calls++
+		if calls == 1 {
+			return fmt.Errorf("%w: %w", ErrUnavailable, io.ErrUnexpectedEOF)
+		}
+		return ErrNotFound
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("Expected a replay finding nothing to succeed, got %v after %d calls", err, calls)
+	}
+	if err := retryDelete(context.Background(), s, func() error { return ErrNotFound }); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a first attempt finding nothing to be ErrNotFound, got %v", err)
+	}
+}
+
+func TestListAtQuery(t *testing.T) {
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	query, args, err := listAtQuery("WITH persons_at AS (SELECT * FROM history WHERE at <= $%d)", at, ListFilter{Name: "ann", Limit: 10})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	want := "WITH persons_at AS (SELECT * FROM history WHERE at <= $3) SELECT id, name, age, address, work, updated_at, latitude, longitude, phone, email, metadata FROM persons_at WHERE name ILIKE $1 ORDER BY id LIMIT $2"
+	if query != want || len(args) != 3 || args[2] != at {
+		t.Errorf("Got %q %v, want %q", query, args, want)
+	}
+}
+
+func TestNextShardID(t *testing.T) {
+	testCases := []struct {
+		last     int64
+		index, n int
+		want     int64
+	}{
+		{0, 0, 3, 3},
+		{0, 1, 3, 1},
+		{0, 2, 3, 2},
+		{7, 1, 3, 10},
+		{7, 2, 3, 8},
+		{9, 0, 3, 12},
+	}
+	for _, tc := range testCases {
+		if got := nextShardID(tc.last, tc.index, tc.n); got != tc.want {
+			t.Errorf("After %d on shard %d of %d: expected %d, got %d", tc.last, tc.index, tc.n, tc.want, got)
+		}
+	}
+}
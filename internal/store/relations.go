@@ -0,0 +1,125 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// DeletePolicy decides what deleting a person does to the rows of a relation
+// that reference them.
+type DeletePolicy string
+
+const (
+	// Cascade deletes the rows along with the person.
+	Cascade DeletePolicy = "cascade"
+	// Restrict refuses to delete a person that rows still reference, with a
+	// DependentsError.
+	Restrict DeletePolicy = "restrict"
+	// Orphan keeps the rows and sets their reference to NULL.
+	Orphan DeletePolicy = "orphan"
+)
+
+// Relation is a table referencing persons. Its foreign key must be declared
+// without an ON DELETE action: the store applies Policy, or the configured
+// override, before deleting the person, so the behaviour is the same for
+// every store and can change without a migration. Orphan needs a nullable
+// column.
+type Relation struct {
+	Name   string
+	Table  string
+	Column string
+	Policy DeletePolicy
+}
+
+// Relations lists the tables referencing persons. Features storing rows per
+// person add theirs here along with their schema.
+var Relations = []Relation{
+	{Name: "attachments", Table: "attachments", Column: "person_id", Policy: Cascade},
+	{Name: "photos", Table: "photos", Column: "person_id", Policy: Cascade},
+}
+
+// DependentsError refuses to delete a person that rows of a Restrict relation
+// still reference. It matches ErrConflict with errors.Is.
+type DependentsError struct {
+	Relation string
+	Count    int64
+}
+
+func (e *DependentsError) Error() string {
+	return fmt.Sprintf("person still has %d %s", e.Count, e.Relation)
+}
+
+func (e *DependentsError) Unwrap() error { return ErrConflict }
+
+// ParseDeletePolicies reads overrides like "attachments=restrict,notes=orphan".
+func ParseDeletePolicies(spec string) (map[string]DeletePolicy, error) {
+	policies := map[string]DeletePolicy{}
+	for _, item := range strings.Split(spec, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		name, policy, _ := strings.Cut(item, "=")
+		name, policy = strings.TrimSpace(name), strings.TrimSpace(policy)
+		if !slices.ContainsFunc(Relations, func(r Relation) bool { return r.Name == name }) {
+			return nil, fmt.Errorf("unknown relation %q", name)
+		}
+		switch p := DeletePolicy(policy); p {
+		case Cascade, Restrict, Orphan:
+			policies[name] = p
+		default:
+			return nil, fmt.Errorf("relation %s: unknown delete policy %q", name, policy)
+		}
+	}
+	return policies, nil
+}
+
+func (s *Postgres) deletePolicy(r Relation) DeletePolicy {
+	if p, ok := s.DeletePolicies[r.Name]; ok {
+		return p
+	}
+	return r.Policy
+}
+
+// dependentsStatement is what applying policy to r runs for a person ID in $1.
+func dependentsStatement(r Relation, policy DeletePolicy) string {
+	switch policy {
+	case Restrict:
+		return fmt.Sprintf("SELECT count(*) FROM %s WHERE %s = $1", r.Table, r.Column)
+	case Orphan:
+		return fmt.Sprintf("UPDATE %s SET %s = NULL WHERE %s = $1", r.Table, r.Column, r.Column)
+	default:
+		return fmt.Sprintf("DELETE FROM %s WHERE %s = $1", r.Table, r.Column)
+	}
+}
+
+// deleteDependents applies every relation's delete policy for person id, in
+// the transaction that then deletes the person. Restrict relations are
+// checked first so that nothing is changed when the delete is refused.
+func (s *Postgres) deleteDependents(ctx context.Context, tx pgx.Tx, id int32) error {
+	for _, r := range Relations {
+		if s.deletePolicy(r) != Restrict {
+			continue
+		}
+		var n int64
+		if err := tx.QueryRow(ctx, dependentsStatement(r, Restrict), id).Scan(&n); err != nil {
+			return fmt.Errorf("count %s of person %d: %w", r.Name, id, translate(err))
+		}
+		if n > 0 {
+			return &DependentsError{Relation: r.Name, Count: n}
+		}
+	}
+	for _, r := range Relations {
+		policy := s.deletePolicy(r)
+		if policy == Restrict {
+			continue
+		}
+		if _, err := tx.Exec(ctx, dependentsStatement(r, policy), id); err != nil {
+			return fmt.Errorf("%s %s of person %d: %w", policy, r.Name, id, translate(err))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package store
+
+import "testing"
+
+func TestParseDeletePolicies(t *testing.T) {
+	defer func(old []Relation) { Relations = old }(Relations)
+	Relations = []Relation{{Name: "notes", Table: "notes", Column: "person_id", Policy: Cascade}}
+
+	got, err := ParseDeletePolicies(" notes = orphan ,")
+	if err != nil || len(got) != 1 || got["notes"] != Orphan {
+		t.Errorf("Unexpected policies %v %v", got, err)
+	}
+	if got, err := ParseDeletePolicies(""); err != nil || len(got) != 0 {
+		t.Errorf("Expected no overrides, got %v %v", got, err)
+	}
+	for _, spec := range []string{"photos=cascade", "notes=archive", "notes"} {
+		if _, err := ParseDeletePolicies(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestDependentsStatement(t *testing.T) {
+	r := Relation{Name: "notes", Table: "notes", Column: "person_id"}
+	testCases := []struct {
+		policy DeletePolicy
+		want   string
+	}{
+		{Cascade, "DELETE FROM notes WHERE person_id = $1"},
+		{Restrict, "SELECT count(*) FROM notes WHERE person_id = $1"},
+		{Orphan, "UPDATE notes SET person_id = NULL WHERE person_id = $1"},
+	}
+	for _, tc := range testCases {
+		if got := dependentsStatement(r, tc.policy); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.policy, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// retry runs op and, if it failed because the connection was lost (typically
+// a Postgres restart or failover), resets the pool, which holds more
+// connections to the same dead server, and runs op once more on a fresh one.
+// Only use it for operations that are safe to repeat.
+func retry[T any](ctx context.Context, s *Postgres, op func() (T, error)) (T, error) {
+	v, err := retryAborted(ctx, op)
+	if err == nil || !isConnLost(err) || ctx.Err() != nil {
+		return v, err
+	}
+	slog.WarnContext(ctx, "database connection lost, retrying on a fresh connection", "err", err)
+	s.resetPool()
+	return retryAborted(ctx, op)
+}
+
+// retryDelete is retry for deletes. A delete whose connection was lost may
+// have committed before the error reached us, so a replay that finds nothing
+// left to delete counts as done rather than as ErrNotFound.
+func retryDelete(ctx context.Context, s *Postgres, op func() error) error {
+	attempts := 0
+	_, err := retry(ctx, s, func() (struct{}, error) {
+		attempts++
+		err := op()
+		if attempts > 1 && errors.Is(err, ErrNotFound) {
+			return struct{}{}, nil
+		}
+		return struct{}{}, err
+	})
+	return err
+}
+
+// abortedRetries is how many more times an op whose transaction Postgres
+// aborted is run before its ErrConflict is returned.
+const abortedRetries = 3
+
+// retryAborted runs op again, after a short random pause, while it fails with
+// a serialization failure, a deadlock or a lost race for an event version.
+// The transaction was rolled back in favour of a concurrent one, so unlike a
+// lost connection this is safe to retry for any op, inserts included.
+func retryAborted[T any](ctx context.Context, op func() (T, error)) (T, error) {
+	v, err := op()
+	for i := 0; i < abortedRetries && isAborted(err); i++ {
+		t := time.NewTimer(rand.N(10 * time.Millisecond << i))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return v, err
+		case <-t.C:
+		}
+		v, err = op()
+	}
+	return v, err
+}
+
+// eventVersionKey is the unique constraint on person_events (person_id,
+// version). appendEvent numbers events with MAX(version)+1, which two writers
+// to the same person may both compute.
+const eventVersionKey = "person_events_person_id_version_key"
+
+func isAborted(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	switch pgErr.Code {
+	case "40001", "40P01": // serialization_failure, deadlock_detected
+		return true
+	case "23505": // unique_violation
+		return pgErr.ConstraintName == eventVersionKey
+	}
+	return false
+}
+
+func (s *Postgres) resetPool() {
+	s.pool.Reset()
+}
+
+// isConnLost reports whether err means the connection it ran on is gone, as
+// opposed to the statement itself being wrong.
+func isConnLost(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "57P01", "57P02": // admin_shutdown, crash_shutdown
+			return true
+		}
+		return pgErr.Code[:2] == "08"
+	}
+	var opErr *net.OpError
+	return pgconn.SafeToRetry(err) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.As(err, &opErr)
+}
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"hash/crc32"
+	"slices"
+	"strconv"
+)
+
+// HashRing assigns keys to nodes by consistent hashing: each node owns the
+// arcs of the ring before its points, so adding or removing one moves only
+// the keys it gains or loses.
+type HashRing struct {
+	points []uint32
+	nodes  map[uint32]string
+}
+
+// NewHashRing places every node at points places on the ring; more points
+// spread keys more evenly.
+func NewHashRing(points int, nodes ...string) *HashRing {
+	r := &HashRing{nodes: make(map[uint32]string)}
+	for _, node := range nodes {
+		for i := range points {
+			h := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + node))
+			r.points = append(r.points, h)
+			r.nodes[h] = node
+		}
+	}
+	slices.Sort(r.points)
+	return r
+}
+
+// Node is the node owning key, or "" on an empty ring.
+func (r *HashRing) Node(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	i, _ := slices.BinarySearch(r.points, h)
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.nodes[r.points[i]]
+}
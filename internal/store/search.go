@@ -0,0 +1,87 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// searchDocument is what full-text search matches against. It must stay the
+// same expression as the persons_search index.
+const searchDocument = `to_tsvector('simple', name || ' ' || coalesce(address, '') || ' ' || coalesce(work, ''))`
+
+// searchHeadline is passed to ts_headline. Fields that did not match come back
+// without markers and are dropped.
+const searchHeadline = `StartSel="` + HighlightStart + `", StopSel="` + HighlightStop + `", MaxWords=20, MinWords=5, MaxFragments=2`
+
+var searchFields = []string{"name", "address", "work"}
+
+func searchQuery(q SearchQuery) (string, []any) {
+	args := []any{q.Text, searchHeadline}
+	var b strings.Builder
+	fmt.Fprintf(&b, "SELECT id, name, age, address, work, updated_at, latitude, longitude, phone, email, metadata, count(*) OVER (), ts_rank(%s, q) AS score", searchDocument)
+	for _, f := range searchFields {
+		fmt.Fprintf(&b, ", ts_headline('simple', %s, q, $2)", f)
+	}
+	fmt.Fprintf(&b, " FROM persons, websearch_to_tsquery('simple', $1) AS q WHERE %s @@ q ORDER BY ", searchDocument)
+	if q.ByRelevance {
+		b.WriteString("score DESC, ")
+	}
+	b.WriteString("id")
+	if q.Limit > 0 {
+		args = append(args, q.Limit)
+		fmt.Fprintf(&b, " LIMIT $%d", len(args))
+	}
+	if q.Offset > 0 {
+		args = append(args, q.Offset)
+		fmt.Fprintf(&b, " OFFSET $%d", len(args))
+	}
+	return b.String(), args
+}
+
+func (s *Postgres) SearchPersons(ctx context.Context, q SearchQuery) (SearchResult, error) {
+	return retry(ctx, s, func() (SearchResult, error) { return s.searchPersons(ctx, q) })
+}
+
+// searchPersons counts the matches with a window function in the same query,
+// which costs little next to finding them.
+func (s *Postgres) searchPersons(ctx context.Context, q SearchQuery) (SearchResult, error) {
+	defer s.observe(ctx, "search_persons")()
+	query, args := searchQuery(q)
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return SearchResult{}, fmt.Errorf("search persons: %w", translate(err))
+	}
+	defer rows.Close()
+
+	res := SearchResult{Hits: []SearchHit{}, Total: -1}
+	for rows.Next() {
+		var h SearchHit
+		var total int64
+		headlines := make([]*string, len(searchFields))
+		dest := append(personFields(&h.Person), &total, &h.Score)
+		for i := range headlines {
+			dest = append(dest, &headlines[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return SearchResult{}, fmt.Errorf("scan search hit: %w", translate(err))
+		}
+		res.Total = int(total)
+		for i, hl := range headlines {
+			if hl != nil && strings.Contains(*hl, HighlightStart) {
+				if h.Highlights == nil {
+					h.Highlights = map[string]string{}
+				}
+				h.Highlights[searchFields[i]] = *hl
+			}
+		}
+		res.Hits = append(res.Hits, h)
+	}
+	if err := rows.Err(); err != nil {
+		return SearchResult{}, fmt.Errorf("iterate search hits: %w", translate(err))
+	}
+	if len(res.Hits) == 0 && q.Offset == 0 {
+		res.Total = 0
+	}
+	return res, nil
+}
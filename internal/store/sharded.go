@@ -0,0 +1,258 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Sharded spreads persons over several stores: shard i holds the persons
+// whose ID leaves i when divided by the number of shards. Each shard hands
+// out IDs itself from a sequence stepping by the number of shards (see
+// Postgres.ConfigureShard), so creating a person needs no central allocator.
+// Lists and searches ask every shard and merge the results.
+//
+// Emails are only unique within a shard, and names are sorted bytewise when
+// merging, which may differ from the databases' collation. The number of
+// shards cannot change once persons are stored.
+type Sharded struct {
+	shards []Store
+	// created counts creations, to pick the shard of the next one.
+	created atomic.Uint32
+}
+
+func NewSharded(shards ...Store) *Sharded {
+	return &Sharded{shards: shards}
+}
+
+// ShardOf is the index of the shard holding the person with id.
+func (s *Sharded) ShardOf(id int32) int {
+	return int(id % int32(len(s.shards)))
+}
+
+func (s *Sharded) shard(id int32) Store {
+	if id <= 0 {
+		// No shard has it; the first answers ErrNotFound as for any other
+		// missing person.
+		return s.shards[0]
+	}
+	return s.shards[s.ShardOf(id)]
+}
+
+func (s *Sharded) GetPerson(ctx context.Context, id int32) (Person, error) {
+	return s.shard(id).GetPerson(ctx, id)
+}
+
+// CreatePerson creates the person on the shard after the one that got the
+// previous, so that persons spread evenly.
+func (s *Sharded) CreatePerson(ctx context.Context, p Person) (int32, error) {
+	i := int(s.created.Add(1) % uint32(len(s.shards)))
+	id, err := s.shards[i].CreatePerson(ctx, p)
+	if err != nil {
+		return 0, err
+	}
+	if s.ShardOf(id) != i {
+		return 0, fmt.Errorf("shard %d created person %d, which belongs to shard %d: its ID sequence is not configured for sharding", i, id, s.ShardOf(id))
+	}
+	return id, nil
+}
+
+func (s *Sharded) UpdatePerson(ctx context.Context, id int32, patch PersonPatch) (Person, error) {
+	return s.shard(id).UpdatePerson(ctx, id, patch)
+}
+
+func (s *Sharded) DeletePerson(ctx context.Context, id int32) error {
+	return s.shard(id).DeletePerson(ctx, id)
+}
+
+func (s *Sharded) UpsertPerson(ctx context.Context, p Person) (Person, bool, error) {
+	return s.shard(p.ID).UpsertPerson(ctx, p)
+}
+
+func (s *Sharded) ModifyPerson(ctx context.Context, id int32, fn func(p *Person) error) (Person, error) {
+	return s.shard(id).ModifyPerson(ctx, id, fn)
+}
+
+func (s *Sharded) DeletePersonIf(ctx context.Context, id int32, check func(p Person) error) error {
+	return s.shard(id).DeletePersonIf(ctx, id, check)
+}
+
+// ListPersons asks every shard for the first Offset+Limit matches and merges
+// them, so deep pages cost every shard as much as the whole prefix.
+func (s *Sharded) ListPersons(ctx context.Context, f ListFilter) ([]Person, error) {
+	perShard := f
+	perShard.Offset = 0
+	if f.Limit > 0 {
+		perShard.Limit = f.Offset + f.Limit
+	}
+	lists, err := gather(ctx, s.shards, func(ctx context.Context, shard Store) ([]Person, error) {
+		return shard.ListPersons(ctx, perShard)
+	})
+	if err != nil {
+		return nil, err
+	}
+	keys := append(f.Sort[:len(f.Sort):len(f.Sort)], SortKey{Field: "id"})
+	merged := []Person{}
+	for _, list := range lists {
+		merged = append(merged, list...)
+	}
+	slices.SortFunc(merged, func(a, b Person) int {
+		for _, k := range keys {
+			if c := comparePersons(a, b, k.Field); c != 0 {
+				if k.Desc {
+					return -c
+				}
+				return c
+			}
+		}
+		return 0
+	})
+	return page(merged, f.Limit, f.Offset), nil
+}
+
+// SearchPersons searches every shard, which must all implement Search, and
+// merges the hits. Relevance scores are comparable across shards as long as
+// they rank with the same settings.
+func (s *Sharded) SearchPersons(ctx context.Context, q SearchQuery) (SearchResult, error) {
+	perShard := q
+	perShard.Offset = 0
+	if q.Limit > 0 {
+		perShard.Limit = q.Offset + q.Limit
+	}
+	results, err := gather(ctx, s.shards, func(ctx context.Context, shard Store) (SearchResult, error) {
+		search, ok := shard.(Search)
+		if !ok {
+			return SearchResult{}, fmt.Errorf("shard %T cannot search", shard)
+		}
+		return search.SearchPersons(ctx, perShard)
+	})
+	if err != nil {
+		return SearchResult{}, err
+	}
+	merged := SearchResult{Hits: []SearchHit{}}
+	for _, r := range results {
+		merged.Hits = append(merged.Hits, r.Hits...)
+		merged.Total += max(r.Total, 0)
+	}
+	slices.SortFunc(merged.Hits, func(a, b SearchHit) int {
+		if q.ByRelevance {
+			if c := cmp.Compare(b.Score, a.Score); c != 0 {
+				return c
+			}
+		}
+		return cmp.Compare(a.Person.ID, b.Person.ID)
+	})
+	merged.Hits = page(merged.Hits, q.Limit, q.Offset)
+	return merged, nil
+}
+
+// ConfigureShard makes the database shard index of n: its persons ID
+// sequence steps by n through the IDs that leave index when divided by n. It
+// fails when the database holds persons of other shards.
+func (s *Postgres) ConfigureShard(ctx context.Context, index, n int) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("configure shard: %w", translate(err))
+	}
+	defer tx.Rollback(ctx)
+
+	// Inserts take their IDs from the sequence; they wait while it changes.
+	if _, err := tx.Exec(ctx, "LOCK TABLE persons IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+		return fmt.Errorf("configure shard: %w", translate(err))
+	}
+	var misplaced int
+	if err := tx.QueryRow(ctx, "SELECT count(*) FROM persons WHERE id % $1 <> $2", n, index).Scan(&misplaced); err != nil {
+		return fmt.Errorf("configure shard: %w", translate(err))
+	}
+	if misplaced > 0 {
+		return fmt.Errorf("shard %d of %d holds %d persons of other shards", index, n, misplaced)
+	}
+	var last int64
+	err = tx.QueryRow(ctx, "SELECT GREATEST((SELECT last_value FROM persons_id_seq), (SELECT COALESCE(MAX(id), 0) FROM persons))").Scan(&last)
+	if err != nil {
+		return fmt.Errorf("configure shard: %w", translate(err))
+	}
+	if _, err := tx.Exec(ctx, fmt.Sprintf("ALTER SEQUENCE persons_id_seq INCREMENT BY %d", n)); err != nil {
+		return fmt.Errorf("configure shard: %w", translate(err))
+	}
+	if _, err := tx.Exec(ctx, "SELECT setval('persons_id_seq', $1, false)", nextShardID(last, index, n)); err != nil {
+		return fmt.Errorf("configure shard: %w", translate(err))
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("configure shard: %w", translate(err))
+	}
+	return nil
+}
+
+// nextShardID is the first ID above last of shard index of n.
+func nextShardID(last int64, index, n int) int64 {
+	id := last + 1
+	return id + (int64(index)-id%int64(n)+int64(n))%int64(n)
+}
+
+// gather calls fn for every shard at once and returns the results in shard
+// order. The first error cancels the other calls and is returned.
+func gather[T any](ctx context.Context, shards []Store, fn func(ctx context.Context, shard Store) (T, error)) ([]T, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make([]T, len(shards))
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for i, shard := range shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := fn(ctx, shard)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("shard %d: %w", i, err)
+					cancel()
+				}
+				mu.Unlock()
+				return
+			}
+			results[i] = r
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return results, nil
+}
+
+func page[T any](list []T, limit, offset int) []T {
+	list = list[min(offset, len(list)):]
+	if limit > 0 && limit < len(list) {
+		list = list[:limit]
+	}
+	return list
+}
+
+// comparePersons orders by field as Postgres does, with a missing age after
+// any other.
+func comparePersons(a, b Person, field string) int {
+	switch field {
+	case "name":
+		return strings.Compare(a.Name, b.Name)
+	case "age":
+		switch {
+		case a.Age == nil && b.Age == nil:
+			return 0
+		case a.Age == nil:
+			return 1
+		case b.Age == nil:
+			return -1
+		}
+		return cmp.Compare(*a.Age, *b.Age)
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
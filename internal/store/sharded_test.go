@@ -0,0 +1,125 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func newShards(n int) (*store.Sharded, []*testutil.MemoryStore) {
+	mems := make([]*testutil.MemoryStore, n)
+	shards := make([]store.Store, n)
+	for i := range mems {
+		// IDs start at 1, so shard 0 starts at n.
+		next := int32(i)
+		if i == 0 {
+			next = int32(n)
+		}
+		mems[i] = testutil.NewMemoryStore()
+		mems[i].SetIDSequence(next, int32(n))
+		shards[i] = mems[i]
+	}
+	return store.NewSharded(shards...), mems
+}
+
+func TestShardedRouting(t *testing.T) {
+	ctx := context.Background()
+	s, mems := newShards(3)
+	for i := 0; i < 9; i++ {
+		id, err := s.CreatePerson(ctx, store.Person{Name: fmt.Sprint("p", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := mems[s.ShardOf(id)].GetPerson(ctx, id); err != nil {
+			t.Errorf("Expected person %d on shard %d: %v", id, s.ShardOf(id), err)
+		}
+	}
+	for i, m := range mems {
+		if list, _ := m.ListPersons(ctx, store.ListFilter{}); len(list) != 3 {
+			t.Errorf("Expected 3 persons on shard %d, got %d", i, len(list))
+		}
+	}
+
+	if _, created, err := s.UpsertPerson(ctx, store.Person{ID: 100, Name: "imported"}); err != nil || !created {
+		t.Fatalf("Expected the person to be created, got %v %v", created, err)
+	}
+	if _, err := mems[1].GetPerson(ctx, 100); err != nil {
+		t.Errorf("Expected person 100 on shard 1: %v", err)
+	}
+	name := "renamed"
+	if p, err := s.UpdatePerson(ctx, 100, store.PersonPatch{Name: &name}); err != nil || p.Name != name {
+		t.Errorf("Unexpected update %+v %v", p, err)
+	}
+	if err := s.DeletePerson(ctx, 100); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int32{100, 0, -4} {
+		if _, err := s.GetPerson(ctx, id); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound for %d, got %v", id, err)
+		}
+	}
+
+	// Shard 0 hands out 1, which belongs to shard 1.
+	unconfigured := store.NewSharded(testutil.NewMemoryStore(), testutil.NewMemoryStore())
+	unconfigured.CreatePerson(ctx, store.Person{Name: "x"})
+	if _, err := unconfigured.CreatePerson(ctx, store.Person{Name: "y"}); err == nil {
+		t.Error("Expected a shard handing out IDs of another to be refused")
+	}
+}
+
+func TestShardedList(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newShards(2)
+	ages := []int32{30, 20, 40, 10}
+	for i, name := range []string{"Dan", "Bob", "Cid", "Ann", "Eve"} {
+		p := store.Person{Name: name}
+		if i < len(ages) {
+			p.Age = &ages[i]
+		}
+		if name == "Ann" || name == "Eve" {
+			work := "nurse"
+			p.Work = &work
+		}
+		if _, err := s.CreatePerson(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := func(f store.ListFilter) string {
+		list, err := s.ListPersons(ctx, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out string
+		for _, p := range list {
+			out += p.Name + " "
+		}
+		return out
+	}
+	testCases := []struct {
+		filter store.ListFilter
+		want   string
+	}{
+		{store.ListFilter{}, "Dan Bob Cid Ann Eve "},
+		{store.ListFilter{Sort: []store.SortKey{{Field: "name"}}}, "Ann Bob Cid Dan Eve "},
+		{store.ListFilter{Sort: []store.SortKey{{Field: "age", Desc: true}}}, "Eve Cid Dan Bob Ann "},
+		{store.ListFilter{Sort: []store.SortKey{{Field: "name"}}, Limit: 2, Offset: 1}, "Bob Cid "},
+		{store.ListFilter{Offset: 10}, ""},
+	}
+	for _, tc := range testCases {
+		if got := names(tc.filter); got != tc.want {
+			t.Errorf("%+v: expected %q, got %q", tc.filter, tc.want, got)
+		}
+	}
+	if list, _ := s.ListPersons(ctx, store.ListFilter{Offset: 10}); list == nil {
+		t.Error("Expected an empty list rather than nil")
+	}
+
+	res, err := s.SearchPersons(ctx, store.SearchQuery{Text: "nurse", Limit: 1, Offset: 1})
+	if err != nil || res.Total != 2 || len(res.Hits) != 1 || res.Hits[0].Person.Name != "Eve" {
+		t.Errorf("Unexpected search result %+v %v", res, err)
+	}
+}
@@ -0,0 +1,194 @@
+-- name: GetPerson :one
+SELECT id, name, age, address, work, updated_at, latitude, longitude, phone, email, metadata FROM persons WHERE id = $1;
+
+-- name: GetPersonForUpdate :one
+SELECT id, name, age, address, work, updated_at, latitude, longitude, phone, email, metadata FROM persons WHERE id = $1 FOR UPDATE;
+
+-- name: GetPersonIDByEmail :one
+SELECT id FROM persons WHERE lower(email) = lower($1);
+
+-- name: CreatePerson :one
+INSERT INTO persons (name, age, address, work, latitude, longitude, phone, email, metadata)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+RETURNING id;
+
+-- name: UpdatePerson :one
+UPDATE persons SET
+    name = COALESCE(sqlc.narg('name'), name),
+    age = COALESCE(sqlc.narg('age'), age),
+    address = COALESCE(sqlc.narg('address'), address),
+    work = COALESCE(sqlc.narg('work'), work),
+    latitude = COALESCE(sqlc.narg('latitude'), latitude),
+    longitude = COALESCE(sqlc.narg('longitude'), longitude),
+    phone = COALESCE(sqlc.narg('phone'), phone),
+    email = COALESCE(sqlc.narg('email'), email),
+    metadata = jsonb_strip_nulls(metadata || COALESCE(sqlc.narg('metadata')::jsonb, '{}')),
+    updated_at = now()
+WHERE id = sqlc.arg('id')
+RETURNING id, name, age, address, work, updated_at, latitude, longitude, phone, email, metadata;
+
+-- name: UpsertPerson :one
+INSERT INTO persons (id, name, age, address, work, latitude, longitude, phone, email, metadata)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+ON CONFLICT (id) DO UPDATE SET
+    name = EXCLUDED.name,
+    age = EXCLUDED.age,
+    address = EXCLUDED.address,
+    work = EXCLUDED.work,
+    latitude = EXCLUDED.latitude,
+    longitude = EXCLUDED.longitude,
+    phone = EXCLUDED.phone,
+    email = EXCLUDED.email,
+    metadata = EXCLUDED.metadata,
+    updated_at = now()
+RETURNING (xmax = 0)::boolean AS inserted, updated_at;
+
+-- name: SyncPersonIDSequence :exec
+SELECT setval(pg_get_serial_sequence('persons', 'id'), (SELECT MAX(id) FROM persons));
+
+-- name: ReplacePerson :one
+UPDATE persons SET name = $1, age = $2, address = $3, work = $4, latitude = $5, longitude = $6, phone = $7, email = $8, metadata = $9, updated_at = now()
+WHERE id = $10
+RETURNING updated_at;
+
+-- name: DeletePerson :execrows
+DELETE FROM persons WHERE id = $1;
+
+-- name: GetChangeTxid :one
+SELECT txid FROM person_changes WHERE seq = $1;
+
+-- name: ListChanges :many
+SELECT seq, txid, person_id, op, data, changed_at FROM person_changes
+WHERE txid < txid_snapshot_xmin(txid_current_snapshot())
+  AND (txid, seq) > (sqlc.arg('after_txid')::bigint, sqlc.arg('after_seq')::bigint)
+ORDER BY txid, seq
+LIMIT sqlc.arg('max_rows');
+
+-- name: AppendPersonEvent :one
+INSERT INTO person_events (person_id, version, type, data)
+VALUES (
+    sqlc.arg('person_id'),
+    COALESCE((SELECT MAX(version) FROM person_events WHERE person_id = sqlc.arg('person_id')), 0) + 1,
+    sqlc.arg('type'),
+    sqlc.arg('data')
+)
+RETURNING version;
+
+-- name: ListLatestPersonEvents :many
+SELECT DISTINCT ON (person_id) seq, person_id, version, type, data, recorded_at FROM person_events
+WHERE person_id > $1
+ORDER BY person_id, version DESC
+LIMIT $2;
+
+-- name: ProjectPerson :exec
+INSERT INTO persons (id, name, age, address, work, updated_at, latitude, longitude, phone, email, metadata)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+ON CONFLICT (id) DO UPDATE SET
+    name = EXCLUDED.name,
+    age = EXCLUDED.age,
+    address = EXCLUDED.address,
+    work = EXCLUDED.work,
+    updated_at = EXCLUDED.updated_at,
+    latitude = EXCLUDED.latitude,
+    longitude = EXCLUDED.longitude,
+    phone = EXCLUDED.phone,
+    email = EXCLUDED.email,
+    metadata = EXCLUDED.metadata;
+
+-- name: BackfillPersonEvents :execrows
+INSERT INTO person_events (person_id, version, type, data, recorded_at)
+SELECT p.id, 1, 'created', jsonb_build_object('name', p.name, 'age', p.age, 'address', p.address, 'work', p.work, 'latitude', p.latitude, 'longitude', p.longitude, 'phone', p.phone, 'email', p.email, 'custom', p.metadata), p.updated_at
+FROM persons p
+WHERE NOT EXISTS (SELECT 1 FROM person_events e WHERE e.person_id = p.id);
+
+-- name: SetLockTimeout :exec
+SELECT set_config('lock_timeout', sqlc.arg('timeout')::text, true);
+
+-- name: SkipChangeFeed :exec
+SELECT set_config('persons.skip_change_feed', 'on', true);
+
+-- name: GetPersonChangeAt :one
+SELECT seq, txid, person_id, op, data, changed_at FROM person_changes
+WHERE person_id = $1 AND changed_at <= $2
+ORDER BY txid DESC, seq DESC
+LIMIT 1;
+
+-- name: GetPersonEventAt :one
+SELECT seq, person_id, version, type, data, recorded_at FROM person_events
+WHERE person_id = $1 AND recorded_at <= $2
+ORDER BY version DESC
+LIMIT 1;
+
+-- name: GetAPIKeyByHash :one
+SELECT id, name, key_hash, monthly_requests, monthly_writes, created_at, prefix, scopes, expires_at, revoked_at FROM api_keys WHERE key_hash = $1;
+
+-- name: CreateAPIKey :one
+INSERT INTO api_keys (name, key_hash, prefix, scopes, monthly_requests, monthly_writes, expires_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, name, key_hash, monthly_requests, monthly_writes, created_at, prefix, scopes, expires_at, revoked_at;
+
+-- name: ListAPIKeys :many
+SELECT id, name, key_hash, monthly_requests, monthly_writes, created_at, prefix, scopes, expires_at, revoked_at FROM api_keys ORDER BY id;
+
+-- name: RotateAPIKey :one
+UPDATE api_keys SET key_hash = $2, prefix = $3
+WHERE id = $1 AND revoked_at IS NULL
+RETURNING id, name, key_hash, monthly_requests, monthly_writes, created_at, prefix, scopes, expires_at, revoked_at;
+
+-- name: RevokeAPIKey :execrows
+UPDATE api_keys SET revoked_at = COALESCE(revoked_at, now()) WHERE id = $1;
+
+-- name: RecordAPIKeyRequest :one
+INSERT INTO api_key_usage (key_id, month, requests)
+VALUES ($1, $2, 1)
+ON CONFLICT (key_id, month) DO UPDATE SET requests = api_key_usage.requests + 1
+RETURNING key_id, month, requests, rows_written;
+
+-- name: RecordAPIKeyWrites :exec
+INSERT INTO api_key_usage (key_id, month, rows_written)
+VALUES ($1, $2, $3)
+ON CONFLICT (key_id, month) DO UPDATE SET rows_written = api_key_usage.rows_written + EXCLUDED.rows_written;
+
+-- name: GetAPIKeyUsage :one
+SELECT key_id, month, requests, rows_written FROM api_key_usage WHERE key_id = $1 AND month = $2;
+
+-- name: CreateUser :one
+INSERT INTO users (email, password_hash) VALUES ($1, $2)
+RETURNING id, email, password_hash, created_at;
+
+-- name: GetUserByEmail :one
+SELECT id, email, password_hash, created_at FROM users WHERE email = $1;
+
+-- name: CreateSession :one
+INSERT INTO sessions (user_id, refresh_hash, expires_at) VALUES ($1, $2, $3)
+RETURNING id, user_id, refresh_hash, previous_hash, created_at, expires_at, revoked_at;
+
+-- name: RotateSession :one
+UPDATE sessions SET previous_hash = refresh_hash, refresh_hash = @new_hash
+WHERE refresh_hash = @old_hash AND revoked_at IS NULL AND expires_at > now()
+RETURNING id, user_id, refresh_hash, previous_hash, created_at, expires_at, revoked_at;
+
+-- name: RevokeReusedSession :execrows
+UPDATE sessions SET revoked_at = now() WHERE previous_hash = $1 AND revoked_at IS NULL;
+
+-- name: RevokeSession :one
+UPDATE sessions SET revoked_at = COALESCE(revoked_at, now()) WHERE refresh_hash = $1
+RETURNING user_id;
+
+-- name: RevokeUserSessions :execrows
+UPDATE sessions SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL;
+
+-- name: GetAdminTOTP :one
+SELECT id, secret, confirmed_at, last_step, created_at FROM admin_totp;
+
+-- name: SetAdminTOTP :exec
+INSERT INTO admin_totp (secret) VALUES ($1)
+ON CONFLICT (id) DO UPDATE SET secret = EXCLUDED.secret, confirmed_at = NULL, last_step = NULL, created_at = now();
+
+-- name: ConfirmAdminTOTP :execrows
+UPDATE admin_totp SET confirmed_at = now(), last_step = $1
+WHERE confirmed_at IS NULL AND (last_step IS NULL OR last_step < $1);
+
+-- name: UseAdminTOTPStep :execrows
+UPDATE admin_totp SET last_step = $1
+WHERE confirmed_at IS NOT NULL AND (last_step IS NULL OR last_step < $1);
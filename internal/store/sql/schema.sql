@@ -0,0 +1,233 @@
+CREATE TABLE IF NOT EXISTS persons (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    age INT,
+    address TEXT,
+    work TEXT
+);
+
+ALTER TABLE persons ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE persons ADD COLUMN IF NOT EXISTS latitude DOUBLE PRECISION;
+ALTER TABLE persons ADD COLUMN IF NOT EXISTS longitude DOUBLE PRECISION;
+-- E.164, normalized by the API.
+ALTER TABLE persons ADD COLUMN IF NOT EXISTS phone TEXT;
+ALTER TABLE persons ADD COLUMN IF NOT EXISTS email TEXT;
+-- Violations are reported as a ConflictError on email, see uniqueFields.
+CREATE UNIQUE INDEX IF NOT EXISTS persons_email ON persons (lower(email));
+
+-- Nearby search narrows to a bounding box on this index before computing
+-- distances, see nearbyQuery.
+CREATE INDEX IF NOT EXISTS persons_location ON persons (latitude, longitude) WHERE latitude IS NOT NULL;
+
+-- Full-text search. The expression must match searchDocument in search.go for
+-- the index to be used.
+CREATE INDEX IF NOT EXISTS persons_search ON persons USING GIN
+    (to_tsvector('simple', name || ' ' || coalesce(address, '') || ' ' || coalesce(work, '')));
+
+-- Every change is announced on persons_changed with the row ID, so replicas
+-- can drop it from their caches.
+CREATE OR REPLACE FUNCTION notify_person_changed() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('persons_changed', COALESCE(NEW.id, OLD.id)::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS persons_notify ON persons;
+CREATE TRIGGER persons_notify AFTER INSERT OR UPDATE OR DELETE ON persons
+    FOR EACH ROW EXECUTE FUNCTION notify_person_changed();
+
+-- Change feed (CDC). The trigger is only installed while the feed is enabled,
+-- see Postgres.SetChangeFeed. txid orders changes by transaction so readers
+-- never skip one that commits after a later sequence number became visible.
+CREATE TABLE IF NOT EXISTS person_changes (
+    seq BIGSERIAL PRIMARY KEY,
+    txid BIGINT NOT NULL DEFAULT txid_current(),
+    person_id INT NOT NULL,
+    op TEXT NOT NULL,
+    data JSONB NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS person_changes_txid_seq ON person_changes (txid, seq);
+
+CREATE OR REPLACE FUNCTION record_person_change() RETURNS trigger AS $$
+BEGIN
+    -- EventStore.Rebuild rewrites rows to what they already were.
+    IF current_setting('persons.skip_change_feed', true) = 'on' THEN
+        RETURN NULL;
+    END IF;
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO person_changes (person_id, op, data) VALUES (OLD.id, 'delete', to_jsonb(OLD));
+    ELSE
+        INSERT INTO person_changes (person_id, op, data) VALUES (NEW.id, lower(TG_OP), to_jsonb(NEW));
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Event log for the event-sourced store (STORE_MODE=events). persons is then
+-- only a projection of it and can be rebuilt with EventStore.Rebuild.
+CREATE TABLE IF NOT EXISTS person_events (
+    seq BIGSERIAL PRIMARY KEY,
+    person_id INT NOT NULL,
+    version INT NOT NULL,
+    type TEXT NOT NULL,
+    data JSONB NOT NULL,
+    recorded_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (person_id, version)
+);
+
+-- API keys are stored as SHA-256 hashes. The monthly quotas are NULL when
+-- unlimited.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    key_hash BYTEA NOT NULL UNIQUE,
+    monthly_requests BIGINT,
+    monthly_writes BIGINT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS api_key_usage (
+    key_id INT NOT NULL REFERENCES api_keys (id) ON DELETE CASCADE,
+    month DATE NOT NULL,
+    requests BIGINT NOT NULL DEFAULT 0,
+    rows_written BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (key_id, month)
+);
+
+-- prefix is the start of the plain key, shown in listings to tell keys apart.
+-- Revoked keys are kept so their usage history stays.
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS prefix TEXT NOT NULL DEFAULT '';
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS scopes TEXT[] NOT NULL DEFAULT '{read,write}';
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMPTZ;
+
+-- Emails are stored lowercased, so the unique constraint is case-insensitive.
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    email TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Refresh tokens are stored hashed and rotate on every use; previous_hash
+-- catches a replayed old token, which revokes the whole session.
+CREATE TABLE IF NOT EXISTS sessions (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    refresh_hash BYTEA NOT NULL UNIQUE,
+    previous_hash BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS sessions_previous_hash ON sessions (previous_hash);
+
+-- The TOTP secret guarding destructive admin endpoints. There is a single
+-- admin identity, so at most one row. last_step is the time step of the last
+-- accepted code, so a code cannot be replayed.
+CREATE TABLE IF NOT EXISTS admin_totp (
+    id BOOLEAN PRIMARY KEY DEFAULT true CHECK (id),
+    secret TEXT NOT NULL,
+    confirmed_at TIMESTAMPTZ,
+    last_step BIGINT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- The audit trail of write requests. Rows older than the retention window
+-- (AUDIT_RETENTION) are pruned.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    actor TEXT NOT NULL,
+    method TEXT NOT NULL,
+    path TEXT NOT NULL,
+    status INT NOT NULL,
+    request_id TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_at ON audit_log (at);
+
+-- Files stored for persons. The content is in object storage under
+-- object_key; objects no row references are deleted by the orphan sweeper.
+-- person_id is nullable and has no ON DELETE action, see store.Relations.
+CREATE TABLE IF NOT EXISTS attachments (
+    id BIGSERIAL PRIMARY KEY,
+    person_id INT REFERENCES persons (id),
+    object_key TEXT NOT NULL UNIQUE,
+    filename TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS attachments_person_id ON attachments (person_id);
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS checksum TEXT NOT NULL DEFAULT '';
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS uploaded_by TEXT NOT NULL DEFAULT '';
+
+-- A person's photo, kept in object storage as one object per size under the
+-- object_key prefix. person_id is nullable and has no ON DELETE action, see
+-- store.Relations.
+CREATE TABLE IF NOT EXISTS photos (
+    id BIGSERIAL PRIMARY KEY,
+    person_id INT UNIQUE REFERENCES persons (id),
+    object_key TEXT NOT NULL UNIQUE,
+    width INT NOT NULL,
+    height INT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- hash identifies the content of a photo in the URLs it is served under.
+ALTER TABLE photos ADD COLUMN IF NOT EXISTS hash TEXT NOT NULL DEFAULT '';
+
+-- Background jobs, run by whichever instance claims them first. Failed jobs
+-- are retried at run_at until max_attempts, then left dead for an admin to
+-- look at. A running job whose lease (locked_until) ran out was lost with its
+-- worker and is claimed again.
+CREATE TABLE IF NOT EXISTS jobs (
+    id TEXT PRIMARY KEY,
+    type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL,
+    run_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    locked_until TIMESTAMPTZ,
+    result JSONB,
+    file JSONB,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    started_at TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS jobs_due ON jobs (run_at) WHERE status IN ('pending', 'running', 'failed');
+
+-- progress is the percentage a running job reports it got through.
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS progress SMALLINT NOT NULL DEFAULT 0;
+
+-- Log lines of jobs, for those following them; deleted with their job.
+CREATE TABLE IF NOT EXISTS job_log (
+    id BIGSERIAL PRIMARY KEY,
+    job_id TEXT NOT NULL REFERENCES jobs (id) ON DELETE CASCADE,
+    at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    message TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS job_log_job_id ON job_log (job_id, id);
+
+-- Values of custom fields by name, as defined in custom_fields.
+ALTER TABLE persons ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
+
+-- Fields a deployment adds to persons. constraints holds what a value must
+-- satisfy besides its type: lengths, a pattern, bounds or allowed values.
+CREATE TABLE IF NOT EXISTS custom_fields (
+    name TEXT PRIMARY KEY,
+    type TEXT NOT NULL,
+    required BOOLEAN NOT NULL DEFAULT false,
+    constraints JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
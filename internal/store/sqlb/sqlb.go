@@ -0,0 +1,183 @@
+// Package sqlb composes SELECT statements from optional filters and sort keys
+// without concatenating user input into SQL. Values always travel as $n
+// parameters; identifiers must be plain column names and are checked before
+// they reach the statement.
+package sqlb
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var identRe = regexp.MustCompile(`^[a-z_][a-z0-9_]*(\.[a-z_][a-z0-9_]*)?$`)
+
+// Cond is a boolean expression written with ? placeholders, one per arg.
+type Cond struct {
+	sql  string
+	args []any
+}
+
+// Expr wraps a hand-written condition. Use ? for every argument.
+func Expr(sql string, args ...any) Cond {
+	return Cond{sql: sql, args: args}
+}
+
+func Eq(col string, v any) Cond    { return Cond{sql: col + " = ?", args: []any{v}} }
+func Gte(col string, v any) Cond   { return Cond{sql: col + " >= ?", args: []any{v}} }
+func Lte(col string, v any) Cond   { return Cond{sql: col + " <= ?", args: []any{v}} }
+func ILike(col string, v any) Cond { return Cond{sql: col + " ILIKE ?", args: []any{v}} }
+
+func (c Cond) empty() bool { return c.sql == "" }
+
+// Contains matches col case-insensitively against s anywhere in the value.
+// LIKE wildcards in s are escaped so they match literally.
+func Contains(col, s string) Cond {
+	return ILike(col, "%"+escapeLike(s)+"%")
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+type order struct {
+	col  string
+	desc bool
+}
+
+// SelectBuilder is immutable: every method returns a modified copy, so a base
+// query can be shared and extended per request.
+type SelectBuilder struct {
+	cols   []string
+	from   string
+	where  []Cond
+	orders []order
+	limit  *uint64
+	offset *uint64
+}
+
+func Select(cols ...string) SelectBuilder {
+	return SelectBuilder{cols: cols}
+}
+
+func (b SelectBuilder) From(table string) SelectBuilder {
+	b.from = table
+	return b
+}
+
+// Where adds a condition; multiple conditions are joined with AND.
+func (b SelectBuilder) Where(c Cond) SelectBuilder {
+	if c.empty() {
+		return b
+	}
+	b.where = append(b.where[:len(b.where):len(b.where)], c)
+	return b
+}
+
+func (b SelectBuilder) OrderBy(col string, desc bool) SelectBuilder {
+	b.orders = append(b.orders[:len(b.orders):len(b.orders)], order{col: col, desc: desc})
+	return b
+}
+
+func (b SelectBuilder) Limit(n uint64) SelectBuilder {
+	b.limit = &n
+	return b
+}
+
+func (b SelectBuilder) Offset(n uint64) SelectBuilder {
+	b.offset = &n
+	return b
+}
+
+// ToSQL renders the statement with $1..$n placeholders.
+func (b SelectBuilder) ToSQL() (string, []any, error) {
+	if len(b.cols) == 0 {
+		return "", nil, fmt.Errorf("sqlb: select without columns")
+	}
+	if b.from == "" {
+		return "", nil, fmt.Errorf("sqlb: select without table")
+	}
+	for _, id := range append(append([]string{}, b.cols...), b.from) {
+		if err := checkIdent(id); err != nil {
+			return "", nil, err
+		}
+	}
+
+	var sb strings.Builder
+	var args []any
+	sb.WriteString("SELECT ")
+	sb.WriteString(strings.Join(b.cols, ", "))
+	sb.WriteString(" FROM ")
+	sb.WriteString(b.from)
+
+	for i, c := range b.where {
+		if i == 0 {
+			sb.WriteString(" WHERE ")
+		} else {
+			sb.WriteString(" AND ")
+		}
+		if len(b.where) > 1 {
+			sb.WriteString("(" + c.sql + ")")
+		} else {
+			sb.WriteString(c.sql)
+		}
+		args = append(args, c.args...)
+	}
+
+	for i, o := range b.orders {
+		if err := checkIdent(o.col); err != nil {
+			return "", nil, err
+		}
+		if i == 0 {
+			sb.WriteString(" ORDER BY ")
+		} else {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(o.col)
+		if o.desc {
+			sb.WriteString(" DESC")
+		}
+	}
+
+	if b.limit != nil {
+		sb.WriteString(" LIMIT ?")
+		args = append(args, *b.limit)
+	}
+	if b.offset != nil {
+		sb.WriteString(" OFFSET ?")
+		args = append(args, *b.offset)
+	}
+
+	query, err := dollar(sb.String(), len(args))
+	if err != nil {
+		return "", nil, err
+	}
+	return query, args, nil
+}
+
+func checkIdent(id string) error {
+	if !identRe.MatchString(id) {
+		return fmt.Errorf("sqlb: invalid identifier %q", id)
+	}
+	return nil
+}
+
+// dollar replaces ? placeholders with $1..$n and checks that their number
+// matches the number of args.
+func dollar(query string, nargs int) (string, error) {
+	var sb strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	if n != nargs {
+		return "", fmt.Errorf("sqlb: %d placeholders for %d args", n, nargs)
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,68 @@
+package sqlb
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSelectToSQL(t *testing.T) {
+	base := Select("id", "name").From("persons")
+	testCases := []struct {
+		name      string
+		b         SelectBuilder
+		wantSQL   string
+		wantArgs  []any
+		wantError bool
+	}{
+		{"Plain", base, "SELECT id, name FROM persons", nil, false},
+		{"Single condition", base.Where(Eq("id", 1)), "SELECT id, name FROM persons WHERE id = $1", []any{1}, false},
+		{
+			"Conditions, order and paging",
+			base.Where(Gte("age", 18)).Where(Lte("age", 30)).OrderBy("name", true).OrderBy("id", false).Limit(10).Offset(20),
+			"SELECT id, name FROM persons WHERE (age >= $1) AND (age <= $2) ORDER BY name DESC, id LIMIT $3 OFFSET $4",
+			[]any{18, 30, uint64(10), uint64(20)},
+			false,
+		},
+		{"Empty condition is ignored", base.Where(Cond{}), "SELECT id, name FROM persons", nil, false},
+		{"Injected sort column", base.OrderBy("name; DROP TABLE persons", false), "", nil, true},
+		{"Injected column", Select("id, (SELECT 1)").From("persons"), "", nil, true},
+		{"Placeholder mismatch", base.Where(Expr("age BETWEEN ? AND ?", 1)), "", nil, true},
+		{"No table", Select("id"), "", nil, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sql, args, err := tc.b.ToSQL()
+			if tc.wantError {
+				if err == nil {
+					t.Fatalf("Expected error, got %q", sql)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if sql != tc.wantSQL || !reflect.DeepEqual(args, tc.wantArgs) {
+				t.Errorf("Got %q %v, want %q %v", sql, args, tc.wantSQL, tc.wantArgs)
+			}
+		})
+	}
+}
+
+func TestBuilderIsImmutable(t *testing.T) {
+	base := Select("id").From("persons").Where(Eq("id", 1))
+	_ = base.Where(Eq("name", "a"))
+	_ = base.Where(Eq("name", "b"))
+
+	sql, args, _ := base.ToSQL()
+	if sql != "SELECT id FROM persons WHERE id = $1" || len(args) != 1 {
+		t.Errorf("Base builder was modified: %q %v", sql, args)
+	}
+}
+
+func TestContainsEscapesWildcards(t *testing.T) {
+	_, args, _ := Select("id").From("persons").Where(Contains("name", `50%_off\`)).ToSQL()
+	if want := `%50\%\_off\\%`; args[0] != want {
+		t.Errorf("Expected pattern %q, got %q", want, args[0])
+	}
+}
@@ -0,0 +1,492 @@
+// Package store holds the persistence layer for persons. Handlers only talk to
+// the Store interface and branch on the sentinel errors below, never on driver
+// specific errors.
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrNotFound    = errors.New("person not found")
+	ErrConflict    = errors.New("conflict")
+	ErrValidation  = errors.New("validation failed")
+	ErrUnavailable = errors.New("database unavailable")
+	// ErrLockTimeout means the row stayed locked by another transaction for
+	// longer than the configured lock timeout.
+	ErrLockTimeout = errors.New("lock timeout")
+	// ErrPreconditionFailed is returned by callers' ModifyPerson and
+	// DeletePersonIf callbacks when the row no longer matches what the client
+	// expected.
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrTokenReused means a refresh token was presented after it had been
+	// rotated, so it has probably been stolen.
+	ErrTokenReused = errors.New("refresh token reused")
+	// ErrConstraint means the database refused a value the API let through,
+	// e.g. one too long for its column or failing a CHECK. It wraps a
+	// ValidationError, whose Field is empty when Postgres names no column.
+	ErrConstraint = errors.New("rejected by database constraint")
+)
+
+// ValidationError reports which field was rejected. It matches ErrValidation
+// with errors.Is.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+func (e *ValidationError) Unwrap() error { return ErrValidation }
+
+// ConflictError reports a unique field whose value another person already
+// has. It matches ErrConflict with errors.Is. ExistingID is zero when the
+// other person could not be looked up.
+type ConflictError struct {
+	Field      string
+	ExistingID int32
+}
+
+func (e *ConflictError) Error() string {
+	if e.ExistingID != 0 {
+		return fmt.Sprintf("%s is taken by person %d", e.Field, e.ExistingID)
+	}
+	return fmt.Sprintf("%s is taken", e.Field)
+}
+
+func (e *ConflictError) Unwrap() error { return ErrConflict }
+
+type Person struct {
+	ID        int32
+	Name      string
+	Age       *int32
+	Address   *string
+	Work      *string
+	UpdatedAt time.Time
+	// Latitude and Longitude are WGS 84 degrees, both set or both nil.
+	Latitude  *float64
+	Longitude *float64
+	// Phone is in E.164 form.
+	Phone *string
+	// Email is unique among persons, ignoring case.
+	Email *string
+	// Custom holds the values of the deployment's custom fields by name.
+	Custom map[string]any
+}
+
+// PersonPatch describes a partial update: nil fields are left untouched.
+type PersonPatch struct {
+	Name      *string
+	Age       *int32
+	Address   *string
+	Work      *string
+	Latitude  *float64
+	Longitude *float64
+	Phone     *string
+	Email     *string
+	// Custom sets the custom fields it names; a nil value removes one.
+	Custom map[string]any
+}
+
+// ListFilter narrows, orders and pages ListPersons. The zero value lists
+// everyone by ID.
+type ListFilter struct {
+	Name   string // case-insensitive substring of the name
+	MinAge *int32
+	MaxAge *int32
+	Sort   []SortKey
+	Limit  int // zero means no limit
+	Offset int
+	// UpdatedBefore, unless zero, keeps persons last updated before it.
+	UpdatedBefore time.Time
+}
+
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// SortFields are the values accepted in SortKey.Field.
+var SortFields = []string{"id", "name", "age"}
+
+// Apply copies the non-nil fields of the patch onto p.
+func (patch PersonPatch) Apply(p *Person) {
+	if patch.Name != nil {
+		p.Name = *patch.Name
+	}
+	if patch.Age != nil {
+		p.Age = patch.Age
+	}
+	if patch.Address != nil {
+		p.Address = patch.Address
+	}
+	if patch.Work != nil {
+		p.Work = patch.Work
+	}
+	if patch.Latitude != nil {
+		p.Latitude = patch.Latitude
+	}
+	if patch.Longitude != nil {
+		p.Longitude = patch.Longitude
+	}
+	if patch.Phone != nil {
+		p.Phone = patch.Phone
+	}
+	if patch.Email != nil {
+		p.Email = patch.Email
+	}
+	if patch.Custom != nil {
+		custom := make(map[string]any, len(p.Custom)+len(patch.Custom))
+		for k, v := range p.Custom {
+			custom[k] = v
+		}
+		for k, v := range patch.Custom {
+			if v == nil {
+				delete(custom, k)
+			} else {
+				custom[k] = v
+			}
+		}
+		p.Custom = custom
+	}
+}
+
+type Store interface {
+	ListPersons(ctx context.Context, f ListFilter) ([]Person, error)
+	GetPerson(ctx context.Context, id int32) (Person, error)
+	CreatePerson(ctx context.Context, p Person) (int32, error)
+	UpdatePerson(ctx context.Context, id int32, patch PersonPatch) (Person, error)
+	DeletePerson(ctx context.Context, id int32) error
+	// UpsertPerson stores p under p.ID, creating it if no person has that ID
+	// yet. It returns the stored person and reports whether it was created.
+	UpsertPerson(ctx context.Context, p Person) (stored Person, created bool, err error)
+	// ModifyPerson locks the person for the rest of the transaction, lets fn
+	// change it and writes the result back. Returning an error from fn aborts
+	// without writing anything.
+	ModifyPerson(ctx context.Context, id int32, fn func(p *Person) error) (Person, error)
+	// DeletePersonIf locks the person and deletes it only if check accepts the
+	// current row.
+	DeletePersonIf(ctx context.Context, id int32, check func(p Person) error) error
+}
+
+// Change is one entry of the change feed: the row as it was right after the
+// operation, or right before it for deletes.
+type Change struct {
+	Seq       int64
+	Op        string // "insert", "update" or "delete"
+	Person    Person
+	ChangedAt time.Time
+}
+
+// ChangeFeed lists committed changes after the one with sequence number since,
+// zero meaning from the start. The order is stable across calls, so the Seq of
+// the last change returned is the position to continue from.
+type ChangeFeed interface {
+	Changes(ctx context.Context, since int64, limit int) ([]Change, error)
+}
+
+// History reconstructs persons as they were at a past instant. ErrNotFound
+// means the person did not exist then, or no history was recorded for it.
+type History interface {
+	PersonAt(ctx context.Context, id int32, at time.Time) (Person, error)
+	// ListPersonsAt lists persons that existed at at, with their state then,
+	// filtered and paged like ListPersons.
+	ListPersonsAt(ctx context.Context, at time.Time, f ListFilter) ([]Person, error)
+}
+
+// HistoryPurger removes what history keeps of persons.
+type HistoryPurger interface {
+	// PurgeHistory deletes the change feed entries and events of persons
+	// deleted before before, and not created again since, and counts them.
+	// With dryRun it only counts them.
+	PurgeHistory(ctx context.Context, before time.Time, dryRun bool) (int64, error)
+	// PurgePersonHistory deletes the change feed entries and events of the
+	// person, deleted or not, and counts them.
+	PurgePersonHistory(ctx context.Context, id int32) (int64, error)
+}
+
+// SearchQuery is full-text search in web search syntax: words, "quoted
+// phrases", or, and -excluded words. Words match whole, case-insensitively,
+// in any of name, address and work.
+type SearchQuery struct {
+	Text string
+	// ByRelevance orders the best matches first instead of by ID.
+	ByRelevance bool
+	Limit       int // zero means no limit
+	Offset      int
+}
+
+// SearchHit is a person matching a search. Highlights holds an excerpt of
+// each field the query matched in, with the matches between HighlightStart
+// and HighlightStop.
+type SearchHit struct {
+	Person     Person
+	Highlights map[string]string
+	// Score grows with how well the person matches. It only compares hits of
+	// the same query.
+	Score float32
+}
+
+// Highlight markers are private use characters, so callers can escape the
+// excerpt for their output format before turning them into markup.
+const (
+	HighlightStart = "\ue000"
+	HighlightStop  = "\ue001"
+)
+
+// SearchResult is one page of hits. Total counts every match, or is -1 when
+// the page is past the end and nothing was counted.
+type SearchResult struct {
+	Hits  []SearchHit
+	Total int
+}
+
+type Search interface {
+	SearchPersons(ctx context.Context, q SearchQuery) (SearchResult, error)
+}
+
+// NearbyQuery finds persons with coordinates within RadiusKm of Lat, Lon.
+type NearbyQuery struct {
+	Lat, Lon float64
+	RadiusKm float64
+	Limit    int // zero means no limit
+	Offset   int
+}
+
+// NearbyHit is a person found by NearbyPersons and its great-circle distance
+// from the query point.
+type NearbyHit struct {
+	Person     Person
+	DistanceKm float64
+}
+
+// Nearby returns hits nearest first, then by ID.
+type Nearby interface {
+	NearbyPersons(ctx context.Context, q NearbyQuery) ([]NearbyHit, error)
+}
+
+// APIKey identifies an API consumer. The key itself is never stored, only its
+// hash.
+type APIKey struct {
+	ID   int32
+	Name string
+	// MonthlyRequests and MonthlyWrites are quotas per calendar month (UTC);
+	// nil means unlimited.
+	MonthlyRequests *int64
+	MonthlyWrites   *int64
+	CreatedAt       time.Time
+	// Prefix is the start of the plain key, enough to recognize it.
+	Prefix    string
+	Scopes    []string
+	ExpiresAt *time.Time
+	RevokedAt *time.Time
+}
+
+// API key scopes. Keys without ScopeWrite may only read.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// Scopes lists every scope; new keys get all of them unless told otherwise.
+var Scopes = []string{ScopeRead, ScopeWrite}
+
+// Active reports whether the key may be used at now.
+func (k APIKey) Active(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// Usage is what a key consumed in one month.
+type Usage struct {
+	Month       time.Time
+	Requests    int64
+	RowsWritten int64
+}
+
+// KeyStore looks up API keys and accounts their usage. Months are identified
+// by their first day.
+type KeyStore interface {
+	APIKeyByHash(ctx context.Context, hash []byte) (APIKey, error)
+	// RecordRequest counts one request and returns the usage including it.
+	RecordRequest(ctx context.Context, keyID int32, month time.Time) (Usage, error)
+	RecordWrites(ctx context.Context, keyID int32, month time.Time, rows int64) error
+	KeyUsage(ctx context.Context, keyID int32, month time.Time) (Usage, error)
+
+	CreateAPIKey(ctx context.Context, k APIKey, hash []byte) (APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+	// RotateAPIKey replaces the key of an unrevoked API key, keeping its
+	// settings and usage.
+	RotateAPIKey(ctx context.Context, id int32, hash []byte, prefix string) (APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int32) error
+}
+
+// User is an account that signs in with email and password. Only the bcrypt
+// hash of the password is stored.
+type User struct {
+	ID           int32
+	Email        string
+	PasswordHash string
+	CreatedAt    time.Time
+}
+
+// UserStore keeps user accounts. Emails are expected lowercased; creating a
+// user with a taken email fails with ErrConflict.
+type UserStore interface {
+	CreateUser(ctx context.Context, email, passwordHash string) (User, error)
+	UserByEmail(ctx context.Context, email string) (User, error)
+
+	CreateSession(ctx context.Context, userID int32, refreshHash []byte, expiresAt time.Time) (Session, error)
+	// RotateSession replaces the refresh token of a live session. A token
+	// that was already rotated away revokes its session and fails with
+	// ErrTokenReused; any other unknown, expired or revoked token fails with
+	// ErrNotFound.
+	RotateSession(ctx context.Context, oldHash, newHash []byte) (Session, error)
+	// RevokeSession ends the session of a refresh token, or with all set
+	// every session of its user.
+	RevokeSession(ctx context.Context, refreshHash []byte, all bool) error
+}
+
+// Session is a login that can be extended with its refresh token until it
+// expires or is revoked.
+type Session struct {
+	ID        int64
+	UserID    int32
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// AdminTOTP is the TOTP secret of the admin. Codes are only enforced once
+// Confirmed, i.e. after the admin proved their authenticator has it.
+type AdminTOTP struct {
+	Secret    string
+	Confirmed bool
+}
+
+// TOTPStore keeps the admin TOTP secret. Steps are TOTP time steps; each may
+// be used once, and only after every earlier one.
+type TOTPStore interface {
+	// AdminTOTP fails with ErrNotFound until a secret is enrolled.
+	AdminTOTP(ctx context.Context) (AdminTOTP, error)
+	// EnrollAdminTOTP replaces the secret with an unconfirmed one.
+	EnrollAdminTOTP(ctx context.Context, secret string) error
+	// ConfirmAdminTOTP confirms the enrolled secret with a code of step.
+	ConfirmAdminTOTP(ctx context.Context, step int64) (bool, error)
+	// UseAdminTOTPStep reports false when a code of step, or a later one,
+	// was already used.
+	UseAdminTOTPStep(ctx context.Context, step int64) (bool, error)
+}
+
+// AuditEntry records a write request: who sent it, to what, and how it ended.
+// Request bodies are not recorded.
+type AuditEntry struct {
+	ID        int64
+	At        time.Time
+	Actor     string
+	Method    string
+	Path      string
+	Status    int
+	RequestID string
+}
+
+// AuditFilter selects audit entries at or after From and before To, by Actor
+// when set, with IDs above After. Zero values do not filter; zero Limit means
+// no limit.
+type AuditFilter struct {
+	From  time.Time
+	To    time.Time
+	Actor string
+	After int64
+	Limit int
+}
+
+// AuditLog keeps the audit trail of write requests.
+type AuditLog interface {
+	RecordAudit(ctx context.Context, e AuditEntry) error
+	// AuditEntries lists the entries matching f in ID order.
+	AuditEntries(ctx context.Context, f AuditFilter) ([]AuditEntry, error)
+	// PruneAudit deletes the entries from before before and counts them.
+	PruneAudit(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Attachment is a file stored for a person. The content is kept in object
+// storage under ObjectKey, only its description in the database. PersonID is
+// nil once the person was deleted with the orphan delete policy.
+type Attachment struct {
+	ID          int64
+	PersonID    *int32
+	ObjectKey   string
+	Filename    string
+	ContentType string
+	Size        int64
+	// Checksum is the hex SHA-256 of the content. UploadedBy names who
+	// uploaded it, like the actor of audit entries.
+	Checksum   string
+	UploadedBy string
+	CreatedAt  time.Time
+}
+
+// AttachmentFilter narrows ListAttachments. MediaType matches the type
+// without parameters, either exactly or, as in "image/*", by its top level
+// type.
+type AttachmentFilter struct {
+	MediaType string
+	Limit     int // zero means no limit
+	Offset    int
+}
+
+// AttachmentStore keeps the descriptions of attachments. Attachments are
+// looked up by person and ID, so one person's cannot be reached through
+// another.
+type AttachmentStore interface {
+	// CreateAttachment fails with ErrNotFound when the person does not exist.
+	CreateAttachment(ctx context.Context, a Attachment) (Attachment, error)
+	Attachment(ctx context.Context, personID int32, id int64) (Attachment, error)
+	// ListAttachments returns the person's attachments oldest first.
+	ListAttachments(ctx context.Context, personID int32, f AttachmentFilter) ([]Attachment, error)
+	// AttachmentsOf returns the attachments of each of persons, oldest first,
+	// leaving out the persons without any.
+	AttachmentsOf(ctx context.Context, persons []int32) (map[int32][]Attachment, error)
+	// DeleteAttachment returns what it deleted, so the caller can delete the
+	// object too.
+	DeleteAttachment(ctx context.Context, personID int32, id int64) (Attachment, error)
+	// UnreferencedKeys returns those of keys no attachment references.
+	UnreferencedKeys(ctx context.Context, keys []string) ([]string, error)
+	// AttachmentsAfter returns up to limit attachments of any person with IDs
+	// above afterID, in ID order, for jobs that go through all of them.
+	AttachmentsAfter(ctx context.Context, afterID int64, limit int) ([]Attachment, error)
+	// SetAttachmentChecksum records the checksum of an attachment stored
+	// without one. It fails with ErrNotFound when the attachment is gone or
+	// has a checksum already.
+	SetAttachmentChecksum(ctx context.Context, id int64, checksum string) error
+}
+
+// Photo is a person's photo. It is kept in object storage in several sizes,
+// all under the ObjectKey prefix; Width and Height are those of the original.
+// Hash identifies its content, empty for photos stored before it was.
+type Photo struct {
+	PersonID  *int32
+	ObjectKey string
+	Hash      string
+	Width     int
+	Height    int
+	CreatedAt time.Time
+}
+
+// PhotoStore keeps the descriptions of photos, at most one per person.
+type PhotoStore interface {
+	// SetPhoto replaces the person's photo and returns the ObjectKey of the
+	// one it replaced, if any, so the caller can delete its objects. It fails
+	// with ErrNotFound when the person does not exist.
+	SetPhoto(ctx context.Context, p Photo) (Photo, string, error)
+	Photo(ctx context.Context, personID int32) (Photo, error)
+	// PhotosOf returns the photos of those of persons that have one.
+	PhotosOf(ctx context.Context, persons []int32) (map[int32]Photo, error)
+	DeletePhoto(ctx context.Context, personID int32) (Photo, error)
+	// UnreferencedPhotoKeys returns those of keys no photo references.
+	UnreferencedPhotoKeys(ctx context.Context, keys []string) ([]string, error)
+}
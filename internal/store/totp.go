@@ -0,0 +1,52 @@
+package store
+
+import (
+	"context"
+	"fmt"
+)
+
+func (s *Postgres) AdminTOTP(ctx context.Context) (AdminTOTP, error) {
+	return retry(ctx, s, func() (AdminTOTP, error) { return s.adminTOTP(ctx) })
+}
+
+func (s *Postgres) adminTOTP(ctx context.Context) (AdminTOTP, error) {
+	defer s.observe(ctx, "get_admin_totp")()
+	row, err := s.q.GetAdminTOTP(ctx)
+	if err != nil {
+		return AdminTOTP{}, fmt.Errorf("get admin totp: %w", translate(err))
+	}
+	return AdminTOTP{Secret: row.Secret, Confirmed: row.ConfirmedAt != nil}, nil
+}
+
+func (s *Postgres) EnrollAdminTOTP(ctx context.Context, secret string) error {
+	_, err := retry(ctx, s, func() (struct{}, error) { return struct{}{}, s.enrollAdminTOTP(ctx, secret) })
+	return err
+}
+
+func (s *Postgres) enrollAdminTOTP(ctx context.Context, secret string) error {
+	defer s.observe(ctx, "enroll_admin_totp")()
+	if err := s.q.SetAdminTOTP(ctx, secret); err != nil {
+		return fmt.Errorf("enroll admin totp: %w", translate(err))
+	}
+	return nil
+}
+
+// ConfirmAdminTOTP and UseAdminTOTPStep are not retried: the first attempt
+// may have used the step, and the retry would then report a replay.
+func (s *Postgres) ConfirmAdminTOTP(ctx context.Context, step int64) (bool, error) {
+	defer s.observe(ctx, "confirm_admin_totp")()
+	n, err := s.q.ConfirmAdminTOTP(ctx, &step)
+	if err != nil {
+		return false, fmt.Errorf("confirm admin totp: %w", translate(err))
+	}
+	return n > 0, nil
+}
+
+func (s *Postgres) UseAdminTOTPStep(ctx context.Context, step int64) (bool, error) {
+	defer s.observe(ctx, "use_admin_totp_step")()
+	n, err := s.q.UseAdminTOTPStep(ctx, &step)
+	if err != nil {
+		return false, fmt.Errorf("use admin totp step: %w", translate(err))
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/store/db"
+)
+
+func fromUserRow(row db.User) User {
+	return User{ID: row.ID, Email: row.Email, PasswordHash: row.PasswordHash, CreatedAt: row.CreatedAt}
+}
+
+// CreateUser is not retried: a lost connection may have created it already,
+// and the retry would then report the email as taken.
+func (s *Postgres) CreateUser(ctx context.Context, email, passwordHash string) (User, error) {
+	defer s.observe(ctx, "create_user")()
+	row, err := s.q.CreateUser(ctx, db.CreateUserParams{Email: email, PasswordHash: passwordHash})
+	if err != nil {
+		return User{}, fmt.Errorf("create user: %w", translate(err))
+	}
+	return fromUserRow(row), nil
+}
+
+func (s *Postgres) UserByEmail(ctx context.Context, email string) (User, error) {
+	return retry(ctx, s, func() (User, error) { return s.userByEmail(ctx, email) })
+}
+
+func (s *Postgres) userByEmail(ctx context.Context, email string) (User, error) {
+	defer s.observe(ctx, "get_user")()
+	row, err := s.q.GetUserByEmail(ctx, email)
+	if err != nil {
+		return User{}, fmt.Errorf("get user: %w", translate(err))
+	}
+	return fromUserRow(row), nil
+}
+
+func fromSessionRow(row db.Session) Session {
+	return Session{ID: row.ID, UserID: row.UserID, CreatedAt: row.CreatedAt, ExpiresAt: row.ExpiresAt}
+}
+
+func (s *Postgres) CreateSession(ctx context.Context, userID int32, refreshHash []byte, expiresAt time.Time) (Session, error) {
+	defer s.observe(ctx, "create_session")()
+	row, err := s.q.CreateSession(ctx, db.CreateSessionParams{UserID: userID, RefreshHash: refreshHash, ExpiresAt: expiresAt})
+	if err != nil {
+		return Session{}, fmt.Errorf("create session: %w", translate(err))
+	}
+	return fromSessionRow(row), nil
+}
+
+// RotateSession is not retried: if the first attempt went through, the retry
+// would present a rotated token and revoke the session.
+func (s *Postgres) RotateSession(ctx context.Context, oldHash, newHash []byte) (Session, error) {
+	defer s.observe(ctx, "rotate_session")()
+	row, err := s.q.RotateSession(ctx, db.RotateSessionParams{NewHash: newHash, OldHash: oldHash})
+	if err = translate(err); !errors.Is(err, ErrNotFound) {
+		if err != nil {
+			return Session{}, fmt.Errorf("rotate session: %w", err)
+		}
+		return fromSessionRow(row), nil
+	}
+	n, err := s.q.RevokeReusedSession(ctx, oldHash)
+	if err != nil {
+		return Session{}, fmt.Errorf("revoke reused session: %w", translate(err))
+	}
+	if n > 0 {
+		return Session{}, fmt.Errorf("rotate session: %w", ErrTokenReused)
+	}
+	return Session{}, fmt.Errorf("rotate session: %w", ErrNotFound)
+}
+
+func (s *Postgres) RevokeSession(ctx context.Context, refreshHash []byte, all bool) error {
+	_, err := retry(ctx, s, func() (struct{}, error) { return struct{}{}, s.revokeSession(ctx, refreshHash, all) })
+	return err
+}
+
+func (s *Postgres) revokeSession(ctx context.Context, refreshHash []byte, all bool) error {
+	defer s.observe(ctx, "revoke_session")()
+	userID, err := s.q.RevokeSession(ctx, refreshHash)
+	if err != nil {
+		return fmt.Errorf("revoke session: %w", translate(err))
+	}
+	if !all {
+		return nil
+	}
+	if _, err := s.q.RevokeUserSessions(ctx, userID); err != nil {
+		return fmt.Errorf("revoke sessions of user %d: %w", userID, translate(err))
+	}
+	return nil
+}
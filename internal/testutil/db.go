@@ -0,0 +1,163 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// StartPostgres runs a throwaway postgres:13 container on a random host port
+// and returns its DSN together with a func that removes it.
+func StartPostgres() (string, func(), error) {
+	if _, err := exec.LookPath("docker"); err != nil {
+		return "", nil, fmt.Errorf("docker not found: %w", err)
+	}
+	out, err := exec.Command("docker", "run", "-d", "--rm",
+		"-e", "POSTGRES_USER=program",
+		"-e", "POSTGRES_PASSWORD=test",
+		"-e", "POSTGRES_DB=persons",
+		"-p", "127.0.0.1::5432",
+		"postgres:13",
+	).Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("docker run: %w", err)
+	}
+	id := strings.TrimSpace(string(out))
+	stop := func() { exec.Command("docker", "rm", "-f", id).Run() }
+
+	out, err = exec.Command("docker", "port", id, "5432/tcp").Output()
+	if err != nil {
+		stop()
+		return "", nil, fmt.Errorf("docker port: %w", err)
+	}
+	hostPort := strings.TrimSpace(strings.Split(string(out), "\n")[0])
+	dsn := fmt.Sprintf("postgres://program:test@%s/persons?sslmode=disable", hostPort)
+
+	if err := WaitForPostgres(dsn, time.Minute); err != nil {
+		stop()
+		return "", nil, err
+	}
+	return dsn, stop, nil
+}
+
+func WaitForPostgres(dsn string, timeout time.Duration) error {
+	db, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err = db.Ping(context.Background())
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("postgres not ready after %s: %w", timeout, err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// OpenDB returns a connection whose search_path points at a fresh schema
+// private to the test, with the persons table already created. Tests using it
+// can run with t.Parallel(). The schema is dropped on cleanup and the test is
+// skipped when dsn is empty.
+func OpenDB(t testing.TB, dsn string) *pgxpool.Pool {
+	t.Helper()
+	if dsn == "" {
+		t.Skip("No Postgres available: set TEST_DB_URL or install Docker")
+	}
+	ctx := context.Background()
+	admin, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+
+	schema := schemaName(t)
+	quoted := pgx.Identifier{schema}.Sanitize()
+	if _, err := admin.Exec(ctx, "CREATE SCHEMA "+quoted); err != nil {
+		admin.Close(ctx)
+		t.Fatalf("Failed to create schema %s: %v", schema, err)
+	}
+	t.Cleanup(func() {
+		admin.Exec(ctx, "DROP SCHEMA "+quoted+" CASCADE")
+		admin.Close(ctx)
+	})
+
+	db, err := pgxpool.New(ctx, withSearchPath(dsn, schema))
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	t.Cleanup(db.Close)
+	if err := store.NewPostgres(db, nil).Migrate(ctx); err != nil {
+		t.Fatalf("Failed to create test tables: %v", err)
+	}
+	return db
+}
+
+var schemaSeq atomic.Int64
+
+// schemaName derives a valid, unique schema name from the test name.
+func schemaName(t testing.TB) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return '_'
+	}, t.Name())
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	return fmt.Sprintf("test_%s_%d_%d", name, os.Getpid(), schemaSeq.Add(1))
+}
+
+// withSearchPath adds a search_path run-time parameter to a URL or key=value DSN.
+func withSearchPath(dsn, schema string) string {
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	return dsn + " search_path=" + schema
+}
+
+// Truncate empties the given tables and resets their id sequences.
+func Truncate(t testing.TB, db *pgxpool.Pool, tables ...string) {
+	t.Helper()
+	_, err := db.Exec(context.Background(), "TRUNCATE "+strings.Join(tables, ", ")+" RESTART IDENTITY CASCADE")
+	if err != nil {
+		t.Fatalf("Failed to truncate %v: %v", tables, err)
+	}
+}
+
+// InsertPersons stores persons directly through the Postgres store and returns
+// them with their new IDs.
+func InsertPersons(t testing.TB, db *pgxpool.Pool, persons ...store.Person) []store.Person {
+	t.Helper()
+	pg := store.NewPostgres(db, nil)
+	for i := range persons {
+		id, err := pg.CreatePerson(context.Background(), persons[i])
+		if err != nil {
+			t.Fatalf("Failed to insert person: %v", err)
+		}
+		persons[i].ID = id
+	}
+	return persons
+}
@@ -0,0 +1,63 @@
+// Package testutil holds helpers shared by the test suites: deterministic
+// person factories, Postgres setup and cleanup, an in-memory store and small
+// HTTP helpers.
+package testutil
+
+import (
+	"fmt"
+	"math/rand"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+var (
+	firstNames = []string{"Ivan", "Anna", "Petr", "Olga", "Sergey", "Maria", "Dmitry", "Elena"}
+	lastNames  = []string{"Ivanov", "Petrova", "Sidorov", "Smirnova", "Kuznetsov", "Popova"}
+	cities     = []string{"Moscow", "Kazan", "Tver", "Samara", "Omsk", "Perm"}
+	jobs       = []string{"Engineer", "Teacher", "Doctor", "Designer", "Accountant", "Driver"}
+)
+
+// Factory builds random but reproducible persons: two factories created with
+// the same seed produce the same sequence.
+type Factory struct {
+	rnd *rand.Rand
+	seq int
+}
+
+func NewFactory(seed int64) *Factory {
+	return &Factory{rnd: rand.New(rand.NewSource(seed))}
+}
+
+// Person returns a fully populated person without an ID. Options run last and
+// can override or clear any field.
+func (f *Factory) Person(opts ...func(*store.Person)) store.Person {
+	f.seq++
+	age := int32(18 + f.rnd.Intn(60))
+	address := fmt.Sprintf("%s, %d %s St.", pick(f.rnd, cities), 1+f.rnd.Intn(200), pick(f.rnd, lastNames))
+	work := pick(f.rnd, jobs)
+	p := store.Person{
+		Name:    fmt.Sprintf("%s %s %d", pick(f.rnd, firstNames), pick(f.rnd, lastNames), f.seq),
+		Age:     &age,
+		Address: &address,
+		Work:    &work,
+	}
+	for _, opt := range opts {
+		opt(&p)
+	}
+	return p
+}
+
+// Persons returns n persons from the factory.
+func (f *Factory) Persons(n int) []store.Person {
+	persons := make([]store.Person, n)
+	for i := range persons {
+		persons[i] = f.Person()
+	}
+	return persons
+}
+
+func pick(rnd *rand.Rand, values []string) string {
+	return values[rnd.Intn(len(values))]
+}
+
+func Ptr[T any](v T) *T { return &v }
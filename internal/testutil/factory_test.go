@@ -0,0 +1,29 @@
+package testutil
+
+import (
+	"reflect"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+func TestFactoryIsDeterministic(t *testing.T) {
+	a := NewFactory(42).Persons(5)
+	b := NewFactory(42).Persons(5)
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("Expected same seed to produce the same persons:\n%+v\n%+v", a, b)
+	}
+	if reflect.DeepEqual(a, NewFactory(43).Persons(5)) {
+		t.Error("Expected different seeds to produce different persons")
+	}
+}
+
+func TestFactoryOptions(t *testing.T) {
+	p := NewFactory(1).Person(func(p *store.Person) {
+		p.Name = "Fixed"
+		p.Work = nil
+	})
+	if p.Name != "Fixed" || p.Work != nil || p.Age == nil {
+		t.Errorf("Unexpected person: %+v", p)
+	}
+}
@@ -0,0 +1,36 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+// JSONBody encodes data as a request body. Strings are sent verbatim, which
+// is how tests send malformed JSON.
+func JSONBody(data any) io.Reader {
+	if s, ok := data.(string); ok {
+		return strings.NewReader(s)
+	}
+	var buf bytes.Buffer
+	json.NewEncoder(&buf).Encode(data)
+	return &buf
+}
+
+// Do sends a request to h and returns the recorded response. A nil body sends
+// no body and no Content-Type.
+func Do(h http.Handler, method, target string, body any) *httptest.ResponseRecorder {
+	var req *http.Request
+	if body != nil {
+		req = httptest.NewRequest(method, target, JSONBody(body))
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		req = httptest.NewRequest(method, target, nil)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
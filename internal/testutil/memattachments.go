@@ -0,0 +1,164 @@
+package testutil
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+func (m *MemoryStore) CreateAttachment(ctx context.Context, a store.Attachment) (store.Attachment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.Attachment{}, m.Err
+	}
+	if a.PersonID == nil {
+		return store.Attachment{}, store.ErrNotFound
+	}
+	if _, ok := m.persons[*a.PersonID]; !ok {
+		return store.Attachment{}, store.ErrNotFound
+	}
+	for _, other := range m.attachments {
+		if other.ObjectKey == a.ObjectKey {
+			return store.Attachment{}, store.ErrConflict
+		}
+	}
+	m.lastAttachmentID++
+	a.ID = m.lastAttachmentID
+	a.CreatedAt = m.Now()
+	m.attachments[a.ID] = a
+	return a, nil
+}
+
+func (m *MemoryStore) Attachment(ctx context.Context, personID int32, id int64) (store.Attachment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.Attachment{}, m.Err
+	}
+	a, ok := m.attachments[id]
+	if !ok || a.PersonID == nil || *a.PersonID != personID {
+		return store.Attachment{}, store.ErrNotFound
+	}
+	return a, nil
+}
+
+func (m *MemoryStore) ListAttachments(ctx context.Context, personID int32, f store.AttachmentFilter) ([]store.Attachment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	list := []store.Attachment{}
+	for _, a := range m.attachments {
+		if a.PersonID == nil || *a.PersonID != personID {
+			continue
+		}
+		mediaType, _, _ := strings.Cut(a.ContentType, ";")
+		if top, ok := strings.CutSuffix(f.MediaType, "/*"); ok {
+			if !strings.HasPrefix(mediaType, top+"/") {
+				continue
+			}
+		} else if f.MediaType != "" && mediaType != f.MediaType {
+			continue
+		}
+		list = append(list, a)
+	}
+	slices.SortFunc(list, func(a, b store.Attachment) int { return cmp.Compare(a.ID, b.ID) })
+	if f.Offset >= len(list) {
+		return []store.Attachment{}, nil
+	}
+	list = list[f.Offset:]
+	if f.Limit > 0 && f.Limit < len(list) {
+		list = list[:f.Limit]
+	}
+	return list, nil
+}
+
+func (m *MemoryStore) AttachmentsOf(ctx context.Context, persons []int32) (map[int32][]store.Attachment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	byPerson := map[int32][]store.Attachment{}
+	for _, a := range m.attachments {
+		if a.PersonID != nil && slices.Contains(persons, *a.PersonID) {
+			byPerson[*a.PersonID] = append(byPerson[*a.PersonID], a)
+		}
+	}
+	for _, list := range byPerson {
+		slices.SortFunc(list, func(a, b store.Attachment) int { return cmp.Compare(a.ID, b.ID) })
+	}
+	return byPerson, nil
+}
+
+func (m *MemoryStore) DeleteAttachment(ctx context.Context, personID int32, id int64) (store.Attachment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.Attachment{}, m.Err
+	}
+	a, ok := m.attachments[id]
+	if !ok || a.PersonID == nil || *a.PersonID != personID {
+		return store.Attachment{}, store.ErrNotFound
+	}
+	delete(m.attachments, id)
+	return a, nil
+}
+
+func (m *MemoryStore) UnreferencedKeys(ctx context.Context, keys []string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	referenced := map[string]bool{}
+	for _, a := range m.attachments {
+		referenced[a.ObjectKey] = true
+	}
+	unreferenced := []string{}
+	for _, k := range keys {
+		if !referenced[k] {
+			unreferenced = append(unreferenced, k)
+		}
+	}
+	return unreferenced, nil
+}
+
+func (m *MemoryStore) AttachmentsAfter(ctx context.Context, afterID int64, limit int) ([]store.Attachment, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	list := []store.Attachment{}
+	for _, a := range m.attachments {
+		if a.ID > afterID {
+			list = append(list, a)
+		}
+	}
+	slices.SortFunc(list, func(a, b store.Attachment) int { return cmp.Compare(a.ID, b.ID) })
+	if limit < len(list) {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+func (m *MemoryStore) SetAttachmentChecksum(ctx context.Context, id int64, checksum string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	a, ok := m.attachments[id]
+	if !ok || a.Checksum != "" {
+		return store.ErrNotFound
+	}
+	a.Checksum = checksum
+	m.attachments[id] = a
+	return nil
+}
@@ -0,0 +1,74 @@
+package testutil
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+// MemoryAuditLog is an in-memory store.AuditLog for handler tests. Entries
+// are stamped with Now.
+type MemoryAuditLog struct {
+	mu      sync.Mutex
+	entries []store.AuditEntry
+	lastID  int64
+	Now     func() time.Time
+	Err     error
+}
+
+func NewMemoryAuditLog() *MemoryAuditLog {
+	return &MemoryAuditLog{Now: time.Now}
+}
+
+func (m *MemoryAuditLog) RecordAudit(ctx context.Context, e store.AuditEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	m.lastID++
+	e.ID = m.lastID
+	e.At = m.Now()
+	m.entries = append(m.entries, e)
+	return nil
+}
+
+func (m *MemoryAuditLog) AuditEntries(ctx context.Context, f store.AuditFilter) ([]store.AuditEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	entries := []store.AuditEntry{}
+	for _, e := range m.entries {
+		if e.ID <= f.After || e.At.Before(f.From) || !f.To.IsZero() && !e.At.Before(f.To) || f.Actor != "" && e.Actor != f.Actor {
+			continue
+		}
+		if f.Limit > 0 && len(entries) == f.Limit {
+			break
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func (m *MemoryAuditLog) PruneAudit(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return 0, m.Err
+	}
+	var n int64
+	kept := m.entries[:0]
+	for _, e := range m.entries {
+		if e.At.Before(before) {
+			n++
+			continue
+		}
+		kept = append(kept, e)
+	}
+	m.entries = kept
+	return n, nil
+}
@@ -0,0 +1,66 @@
+package testutil
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+// MemoryFieldStore is an in-memory store.CustomFieldStore for handler tests.
+type MemoryFieldStore struct {
+	mu     sync.Mutex
+	fields map[string]store.CustomField
+	Err    error
+}
+
+func NewMemoryFieldStore(fields ...store.CustomField) *MemoryFieldStore {
+	m := &MemoryFieldStore{fields: map[string]store.CustomField{}}
+	for _, f := range fields {
+		m.fields[f.Name] = f
+	}
+	return m
+}
+
+func (m *MemoryFieldStore) DefineCustomField(ctx context.Context, f store.CustomField) (store.CustomField, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.CustomField{}, m.Err
+	}
+	if _, ok := m.fields[f.Name]; ok {
+		return store.CustomField{}, &store.ConflictError{Field: "name"}
+	}
+	f.CreatedAt = time.Now()
+	m.fields[f.Name] = f
+	return f, nil
+}
+
+func (m *MemoryFieldStore) CustomFields(ctx context.Context) ([]store.CustomField, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	fields := make([]store.CustomField, 0, len(m.fields))
+	for _, f := range m.fields {
+		fields = append(fields, f)
+	}
+	sort.Slice(fields, func(i, k int) bool { return fields[i].Name < fields[k].Name })
+	return fields, nil
+}
+
+func (m *MemoryFieldStore) DeleteCustomField(ctx context.Context, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	if _, ok := m.fields[name]; !ok {
+		return store.ErrNotFound
+	}
+	delete(m.fields, name)
+	return nil
+}
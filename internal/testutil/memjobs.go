@@ -0,0 +1,211 @@
+package testutil
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+// MemoryJobQueue is an in-memory store.JobQueue for handler tests. Leases and
+// due times are measured against Now.
+type MemoryJobQueue struct {
+	mu      sync.Mutex
+	jobs    map[string]*store.Job
+	log     map[string][]store.JobLogLine
+	lastLog int64
+	Now     func() time.Time
+	Err     error
+}
+
+func NewMemoryJobQueue() *MemoryJobQueue {
+	return &MemoryJobQueue{jobs: map[string]*store.Job{}, log: map[string][]store.JobLogLine{}, Now: time.Now}
+}
+
+func (m *MemoryJobQueue) EnqueueJob(ctx context.Context, j store.Job) (store.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.Job{}, m.Err
+	}
+	now := m.Now()
+	j = store.Job{ID: j.ID, Type: j.Type, Payload: j.Payload, Status: store.JobPending, MaxAttempts: j.MaxAttempts, RunAt: now, CreatedAt: now}
+	m.jobs[j.ID] = &j
+	return j, nil
+}
+
+func (m *MemoryJobQueue) Job(ctx context.Context, id string) (store.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.Job{}, m.Err
+	}
+	j, ok := m.jobs[id]
+	if !ok {
+		return store.Job{}, store.ErrNotFound
+	}
+	return *j, nil
+}
+
+func (m *MemoryJobQueue) Jobs(ctx context.Context, status string, limit int) ([]store.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	jobs := []store.Job{}
+	for _, j := range m.jobs {
+		if j.Status == status {
+			jobs = append(jobs, *j)
+		}
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].CreatedAt.After(jobs[k].CreatedAt) })
+	return jobs[:min(limit, len(jobs))], nil
+}
+
+func (m *MemoryJobQueue) ClaimJob(ctx context.Context, types []string, lease time.Duration) (store.Job, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.Job{}, false, m.Err
+	}
+	now := m.Now()
+	var due *store.Job
+	for _, j := range m.jobs {
+		if !slices.Contains(types, j.Type) {
+			continue
+		}
+		waiting := (j.Status == store.JobPending || j.Status == store.JobFailed) && !j.RunAt.After(now)
+		abandoned := j.Status == store.JobRunning && j.LockedUntil.Before(now)
+		if !waiting && !abandoned {
+			continue
+		}
+		if due == nil || j.RunAt.Before(due.RunAt) || j.RunAt.Equal(due.RunAt) && j.CreatedAt.Before(due.CreatedAt) {
+			due = j
+		}
+	}
+	if due == nil {
+		return store.Job{}, false, nil
+	}
+	lockedUntil := now.Add(lease)
+	due.Status, due.LockedUntil, due.StartedAt, due.Progress = store.JobRunning, &lockedUntil, &now, 0
+	due.Attempts++
+	return *due, true, nil
+}
+
+// running returns the running job with id, if still in attempt. m.mu is
+// held.
+func (m *MemoryJobQueue) running(id string, attempt int) (*store.Job, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	j, ok := m.jobs[id]
+	if !ok || j.Status != store.JobRunning || j.Attempts != attempt {
+		return nil, store.ErrNotFound
+	}
+	return j, nil
+}
+
+func (m *MemoryJobQueue) RenewJob(ctx context.Context, id string, attempt int, lease time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, err := m.running(id, attempt)
+	if err != nil {
+		return err
+	}
+	lockedUntil := m.Now().Add(lease)
+	j.LockedUntil = &lockedUntil
+	return nil
+}
+
+func (m *MemoryJobQueue) ReportJobProgress(ctx context.Context, id string, attempt int, progress int, lines ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, err := m.running(id, attempt)
+	if err != nil {
+		return err
+	}
+	j.Progress = progress
+	for _, line := range lines {
+		m.lastLog++
+		m.log[id] = append(m.log[id], store.JobLogLine{ID: m.lastLog, At: m.Now(), Message: line})
+	}
+	return nil
+}
+
+func (m *MemoryJobQueue) JobLog(ctx context.Context, id string, after int64) ([]store.JobLogLine, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	lines := []store.JobLogLine{}
+	for _, l := range m.log[id] {
+		if l.ID > after {
+			lines = append(lines, l)
+		}
+	}
+	return lines, nil
+}
+
+func (m *MemoryJobQueue) CompleteJob(ctx context.Context, id string, attempt int, result json.RawMessage, file *store.JobFile) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, err := m.running(id, attempt)
+	if err != nil {
+		return err
+	}
+	now := m.Now()
+	j.Status, j.Result, j.File, j.Error, j.LockedUntil, j.FinishedAt, j.Progress = store.JobDone, result, file, "", nil, &now, 100
+	return nil
+}
+
+func (m *MemoryJobQueue) FailJob(ctx context.Context, id string, attempt int, reason string, backoff time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, err := m.running(id, attempt)
+	if err != nil {
+		return err
+	}
+	j.Status, j.Error, j.RunAt, j.LockedUntil = store.JobFailed, reason, m.Now().Add(backoff), nil
+	if j.Attempts >= j.MaxAttempts {
+		now := m.Now()
+		j.Status, j.FinishedAt = store.JobDead, &now
+	}
+	return nil
+}
+
+func (m *MemoryJobQueue) RetryJob(ctx context.Context, id string) (store.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.Job{}, m.Err
+	}
+	j, ok := m.jobs[id]
+	if !ok || j.Status != store.JobDead {
+		return store.Job{}, store.ErrNotFound
+	}
+	j.Status, j.Attempts, j.RunAt, j.FinishedAt = store.JobPending, 0, m.Now(), nil
+	return *j, nil
+}
+
+func (m *MemoryJobQueue) PruneJobs(ctx context.Context, before time.Time) ([]store.Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	pruned := []store.Job{}
+	for id, j := range m.jobs {
+		if j.Status == store.JobDone && j.FinishedAt.Before(before) {
+			pruned = append(pruned, *j)
+			delete(m.jobs, id)
+			delete(m.log, id)
+		}
+	}
+	return pruned, nil
+}
@@ -0,0 +1,172 @@
+package testutil
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+// MemoryKeyStore is an in-memory store.KeyStore for handler tests.
+type MemoryKeyStore struct {
+	mu     sync.Mutex
+	keys   map[int32]store.APIKey
+	hashes map[string]int32
+	usage  map[usageKey]store.Usage
+	nextID int32
+	Err    error
+}
+
+type usageKey struct {
+	keyID int32
+	month time.Time
+}
+
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{
+		keys:   map[int32]store.APIKey{},
+		hashes: map[string]int32{},
+		usage:  map[usageKey]store.Usage{},
+		nextID: 1,
+	}
+}
+
+// Add stores k under hash, assigning it the next ID and, like the database,
+// every scope when k has none.
+func (m *MemoryKeyStore) Add(hash []byte, k store.APIKey) store.APIKey {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.add(hash, k)
+}
+
+func (m *MemoryKeyStore) add(hash []byte, k store.APIKey) store.APIKey {
+	k.ID = m.nextID
+	m.nextID++
+	if k.Scopes == nil {
+		k.Scopes = slices.Clone(store.Scopes)
+	}
+	if k.CreatedAt.IsZero() {
+		k.CreatedAt = time.Now()
+	}
+	m.keys[k.ID] = k
+	m.hashes[string(hash)] = k.ID
+	return k
+}
+
+func (m *MemoryKeyStore) APIKeyByHash(ctx context.Context, hash []byte) (store.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.APIKey{}, m.Err
+	}
+	id, ok := m.hashes[string(hash)]
+	if !ok {
+		return store.APIKey{}, store.ErrNotFound
+	}
+	return m.keys[id], nil
+}
+
+func (m *MemoryKeyStore) RecordRequest(ctx context.Context, keyID int32, month time.Time) (store.Usage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.Usage{}, m.Err
+	}
+	u := m.usage[usageKey{keyID, month}]
+	u.Month = month
+	u.Requests++
+	m.usage[usageKey{keyID, month}] = u
+	return u, nil
+}
+
+func (m *MemoryKeyStore) RecordWrites(ctx context.Context, keyID int32, month time.Time, rows int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	u := m.usage[usageKey{keyID, month}]
+	u.Month = month
+	u.RowsWritten += rows
+	m.usage[usageKey{keyID, month}] = u
+	return nil
+}
+
+func (m *MemoryKeyStore) KeyUsage(ctx context.Context, keyID int32, month time.Time) (store.Usage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.Usage{}, m.Err
+	}
+	u := m.usage[usageKey{keyID, month}]
+	u.Month = month
+	return u, nil
+}
+
+func (m *MemoryKeyStore) CreateAPIKey(ctx context.Context, k store.APIKey, hash []byte) (store.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.APIKey{}, m.Err
+	}
+	if _, ok := m.hashes[string(hash)]; ok {
+		return store.APIKey{}, store.ErrConflict
+	}
+	return m.add(hash, k), nil
+}
+
+func (m *MemoryKeyStore) ListAPIKeys(ctx context.Context) ([]store.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	keys := make([]store.APIKey, 0, len(m.keys))
+	for _, k := range m.keys {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b store.APIKey) int { return cmp.Compare(a.ID, b.ID) })
+	return keys, nil
+}
+
+func (m *MemoryKeyStore) RotateAPIKey(ctx context.Context, id int32, hash []byte, prefix string) (store.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.APIKey{}, m.Err
+	}
+	k, ok := m.keys[id]
+	if !ok || k.RevokedAt != nil {
+		return store.APIKey{}, store.ErrNotFound
+	}
+	for h, kid := range m.hashes {
+		if kid == id {
+			delete(m.hashes, h)
+		}
+	}
+	m.hashes[string(hash)] = id
+	k.Prefix = prefix
+	m.keys[id] = k
+	return k, nil
+}
+
+func (m *MemoryKeyStore) RevokeAPIKey(ctx context.Context, id int32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	k, ok := m.keys[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	if k.RevokedAt == nil {
+		now := time.Now()
+		k.RevokedAt = &now
+		m.keys[id] = k
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package testutil
+
+import (
+	"context"
+	"sort"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+func (m *MemoryStore) NearbyPersons(ctx context.Context, q store.NearbyQuery) ([]store.NearbyHit, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	all, _ := list(m.persons, store.ListFilter{})
+	hits := []store.NearbyHit{}
+	for _, p := range all {
+		if p.Latitude == nil || p.Longitude == nil {
+			continue
+		}
+		if d := store.Distance(q.Lat, q.Lon, *p.Latitude, *p.Longitude); d <= q.RadiusKm {
+			hits = append(hits, store.NearbyHit{Person: p, DistanceKm: d})
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool { return hits[i].DistanceKm < hits[j].DistanceKm })
+	if q.Offset >= len(hits) {
+		return []store.NearbyHit{}, nil
+	}
+	hits = hits[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(hits) {
+		hits = hits[:q.Limit]
+	}
+	return hits, nil
+}
@@ -0,0 +1,86 @@
+package testutil
+
+import (
+	"context"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+func (m *MemoryStore) SetPhoto(ctx context.Context, p store.Photo) (store.Photo, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.Photo{}, "", m.Err
+	}
+	if p.PersonID == nil {
+		return store.Photo{}, "", store.ErrNotFound
+	}
+	if _, ok := m.persons[*p.PersonID]; !ok {
+		return store.Photo{}, "", store.ErrNotFound
+	}
+	previous := m.photos[*p.PersonID].ObjectKey
+	p.CreatedAt = m.Now()
+	m.photos[*p.PersonID] = p
+	return p, previous, nil
+}
+
+func (m *MemoryStore) Photo(ctx context.Context, personID int32) (store.Photo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.Photo{}, m.Err
+	}
+	p, ok := m.photos[personID]
+	if !ok {
+		return store.Photo{}, store.ErrNotFound
+	}
+	return p, nil
+}
+
+func (m *MemoryStore) PhotosOf(ctx context.Context, persons []int32) (map[int32]store.Photo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	photos := map[int32]store.Photo{}
+	for _, id := range persons {
+		if p, ok := m.photos[id]; ok {
+			photos[id] = p
+		}
+	}
+	return photos, nil
+}
+
+func (m *MemoryStore) DeletePhoto(ctx context.Context, personID int32) (store.Photo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.Photo{}, m.Err
+	}
+	p, ok := m.photos[personID]
+	if !ok {
+		return store.Photo{}, store.ErrNotFound
+	}
+	delete(m.photos, personID)
+	return p, nil
+}
+
+func (m *MemoryStore) UnreferencedPhotoKeys(ctx context.Context, keys []string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	referenced := map[string]bool{}
+	for _, p := range m.photos {
+		referenced[p.ObjectKey] = true
+	}
+	unreferenced := []string{}
+	for _, k := range keys {
+		if !referenced[k] {
+			unreferenced = append(unreferenced, k)
+		}
+	}
+	return unreferenced, nil
+}
@@ -0,0 +1,98 @@
+package testutil
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+// SearchPersons approximates Postgres full-text search with the simple
+// configuration: every word of the query has to appear as a whole word in one
+// of the fields. Quotes, or and - are not supported. The score is the number
+// of matching words.
+func (m *MemoryStore) SearchPersons(ctx context.Context, q store.SearchQuery) (store.SearchResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.SearchResult{}, m.Err
+	}
+	terms := map[string]bool{}
+	for _, w := range strings.FieldsFunc(strings.ToLower(q.Text), notWordRune) {
+		terms[w] = true
+	}
+	all, _ := list(m.persons, store.ListFilter{})
+	hits := []store.SearchHit{}
+	for _, p := range all {
+		fields := map[string]string{"name": p.Name}
+		if p.Address != nil {
+			fields["address"] = *p.Address
+		}
+		if p.Work != nil {
+			fields["work"] = *p.Work
+		}
+		found := map[string]bool{}
+		h := store.SearchHit{Person: p}
+		for field, text := range fields {
+			if hl, n := highlight(text, terms, found); n > 0 {
+				h.Score += float32(n)
+				if h.Highlights == nil {
+					h.Highlights = map[string]string{}
+				}
+				h.Highlights[field] = hl
+			}
+		}
+		if len(terms) > 0 && len(found) == len(terms) {
+			hits = append(hits, h)
+		}
+	}
+	if q.ByRelevance {
+		sort.SliceStable(hits, func(i, j int) bool { return hits[i].Score > hits[j].Score })
+	}
+	res := store.SearchResult{Hits: []store.SearchHit{}, Total: len(hits)}
+	if q.Offset >= len(hits) {
+		if q.Offset > 0 {
+			res.Total = -1
+		}
+		return res, nil
+	}
+	hits = hits[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(hits) {
+		hits = hits[:q.Limit]
+	}
+	res.Hits = hits
+	return res, nil
+}
+
+func notWordRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// highlight marks the words of text that are terms, records them in found
+// and counts them.
+func highlight(text string, terms, found map[string]bool) (string, int) {
+	var b strings.Builder
+	matched := 0
+	for len(text) > 0 {
+		i := strings.IndexFunc(text, notWordRune)
+		if i == 0 {
+			b.WriteByte(text[0])
+			text = text[1:]
+			continue
+		}
+		if i < 0 {
+			i = len(text)
+		}
+		word := text[:i]
+		if terms[strings.ToLower(word)] {
+			found[strings.ToLower(word)] = true
+			matched++
+			word = store.HighlightStart + word + store.HighlightStop
+		}
+		b.WriteString(word)
+		text = text[i:]
+	}
+	return b.String(), matched
+}
@@ -0,0 +1,389 @@
+package testutil
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+// MemoryStore is an in-memory store.Store for handler tests. Setting Err makes
+// every call fail with it, which is how tests reach error paths that need a
+// broken database. Writes stamp UpdatedAt with Now, which tests can pin, and
+// are recorded for the store.ChangeFeed; Put is not.
+type MemoryStore struct {
+	mu      sync.Mutex
+	persons map[int32]store.Person
+	nextID  int32
+	idStep  int32
+	changes []store.Change
+	lastSeq int64
+	Err     error
+	Now     func() time.Time
+
+	// attachments and photos are kept apart from persons and survive their
+	// deletion.
+	attachments      map[int64]store.Attachment
+	lastAttachmentID int64
+	photos           map[int32]store.Photo
+}
+
+func NewMemoryStore(persons ...store.Person) *MemoryStore {
+	m := &MemoryStore{persons: map[int32]store.Person{}, attachments: map[int64]store.Attachment{}, photos: map[int32]store.Photo{}, nextID: 1, idStep: 1, Now: time.Now}
+	for _, p := range persons {
+		m.Put(p)
+	}
+	return m
+}
+
+// Put stores p as is, assigning the next free ID when p.ID is zero.
+func (m *MemoryStore) Put(p store.Person) store.Person {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.put(p)
+}
+
+func (m *MemoryStore) put(p store.Person) store.Person {
+	if p.ID == 0 {
+		p.ID = m.nextID
+	}
+	if p.ID >= m.nextID {
+		m.nextID = p.ID + m.idStep
+	}
+	m.persons[p.ID] = p
+	return p
+}
+
+// SetIDSequence makes CreatePerson assign next, then every step-th ID after
+// it, like the sequence of a sharded database.
+func (m *MemoryStore) SetIDSequence(next, step int32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID, m.idStep = next, step
+}
+
+// ListPersons mirrors the Postgres filter semantics, including NULL ages
+// never matching an age bound and sorting last (first when descending).
+func (m *MemoryStore) ListPersons(ctx context.Context, f store.ListFilter) ([]store.Person, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return list(m.persons, f)
+}
+
+func list(persons map[int32]store.Person, f store.ListFilter) ([]store.Person, error) {
+	for _, k := range f.Sort {
+		if !slices.Contains(store.SortFields, k.Field) {
+			return nil, &store.ValidationError{Field: "sort", Message: fmt.Sprintf("unknown sort field %q", k.Field)}
+		}
+	}
+
+	list := make([]store.Person, 0, len(persons))
+	for _, p := range persons {
+		if matches(p, f) {
+			list = append(list, p)
+		}
+	}
+	keys := append(f.Sort[:len(f.Sort):len(f.Sort)], store.SortKey{Field: "id"})
+	sort.Slice(list, func(i, j int) bool {
+		for _, k := range keys {
+			if c := compareField(list[i], list[j], k.Field); c != 0 {
+				if k.Desc {
+					return c > 0
+				}
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	list = list[min(f.Offset, len(list)):]
+	if f.Limit > 0 && f.Limit < len(list) {
+		list = list[:f.Limit]
+	}
+	return list, nil
+}
+
+func matches(p store.Person, f store.ListFilter) bool {
+	if f.Name != "" && !strings.Contains(strings.ToLower(p.Name), strings.ToLower(f.Name)) {
+		return false
+	}
+	if f.MinAge != nil && (p.Age == nil || *p.Age < *f.MinAge) {
+		return false
+	}
+	if f.MaxAge != nil && (p.Age == nil || *p.Age > *f.MaxAge) {
+		return false
+	}
+	if !f.UpdatedBefore.IsZero() && !p.UpdatedAt.Before(f.UpdatedBefore) {
+		return false
+	}
+	return true
+}
+
+func compareField(a, b store.Person, field string) int {
+	switch field {
+	case "name":
+		return strings.Compare(a.Name, b.Name)
+	case "age":
+		switch {
+		case a.Age == nil && b.Age == nil:
+			return 0
+		case a.Age == nil:
+			return 1
+		case b.Age == nil:
+			return -1
+		}
+		return cmp.Compare(*a.Age, *b.Age)
+	}
+	return cmp.Compare(a.ID, b.ID)
+}
+
+func (m *MemoryStore) GetPerson(ctx context.Context, id int32) (store.Person, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.Person{}, m.Err
+	}
+	p, ok := m.persons[id]
+	if !ok {
+		return store.Person{}, store.ErrNotFound
+	}
+	return p, nil
+}
+
+func (m *MemoryStore) CreatePerson(ctx context.Context, p store.Person) (int32, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return 0, m.Err
+	}
+	p.ID = m.nextID
+	if err := m.checkUnique(p); err != nil {
+		return 0, err
+	}
+	p.UpdatedAt = m.Now()
+	m.nextID += m.idStep
+	m.persons[p.ID] = p
+	m.record("insert", p)
+	return p.ID, nil
+}
+
+func (m *MemoryStore) UpdatePerson(ctx context.Context, id int32, patch store.PersonPatch) (store.Person, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.Person{}, m.Err
+	}
+	p, ok := m.persons[id]
+	if !ok {
+		return store.Person{}, store.ErrNotFound
+	}
+	patch.Apply(&p)
+	if err := m.checkUnique(p); err != nil {
+		return store.Person{}, err
+	}
+	p.UpdatedAt = m.Now()
+	m.persons[id] = p
+	m.record("update", p)
+	return p, nil
+}
+
+func (m *MemoryStore) UpsertPerson(ctx context.Context, p store.Person) (store.Person, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.Person{}, false, m.Err
+	}
+	if err := m.checkUnique(p); err != nil {
+		return store.Person{}, false, err
+	}
+	_, exists := m.persons[p.ID]
+	p.UpdatedAt = m.Now()
+	m.put(p)
+	if exists {
+		m.record("update", p)
+	} else {
+		m.record("insert", p)
+	}
+	return p, !exists, nil
+}
+
+func (m *MemoryStore) ModifyPerson(ctx context.Context, id int32, fn func(p *store.Person) error) (store.Person, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.Person{}, m.Err
+	}
+	p, ok := m.persons[id]
+	if !ok {
+		return store.Person{}, store.ErrNotFound
+	}
+	if err := fn(&p); err != nil {
+		return store.Person{}, err
+	}
+	p.ID = id
+	if err := m.checkUnique(p); err != nil {
+		return store.Person{}, err
+	}
+	p.UpdatedAt = m.Now()
+	m.persons[id] = p
+	m.record("update", p)
+	return p, nil
+}
+
+// checkUnique mirrors the persons_email unique index.
+func (m *MemoryStore) checkUnique(p store.Person) error {
+	if p.Email == nil {
+		return nil
+	}
+	for _, other := range m.persons {
+		if other.ID != p.ID && other.Email != nil && strings.EqualFold(*other.Email, *p.Email) {
+			return &store.ConflictError{Field: "email", ExistingID: other.ID}
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeletePersonIf(ctx context.Context, id int32, check func(p store.Person) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	p, ok := m.persons[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	if err := check(p); err != nil {
+		return err
+	}
+	delete(m.persons, id)
+	m.record("delete", p)
+	return nil
+}
+
+func (m *MemoryStore) DeletePerson(ctx context.Context, id int32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	p, ok := m.persons[id]
+	if !ok {
+		return store.ErrNotFound
+	}
+	delete(m.persons, id)
+	m.record("delete", p)
+	return nil
+}
+
+func (m *MemoryStore) record(op string, p store.Person) {
+	m.lastSeq++
+	m.changes = append(m.changes, store.Change{
+		Seq:       m.lastSeq,
+		Op:        op,
+		Person:    p,
+		ChangedAt: m.Now(),
+	})
+}
+
+func (m *MemoryStore) PersonAt(ctx context.Context, id int32, at time.Time) (store.Person, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.Person{}, m.Err
+	}
+	for i := len(m.changes) - 1; i >= 0; i-- {
+		c := m.changes[i]
+		if c.Person.ID != id || c.ChangedAt.After(at) {
+			continue
+		}
+		if c.Op == "delete" {
+			break
+		}
+		return c.Person, nil
+	}
+	return store.Person{}, store.ErrNotFound
+}
+
+// ListPersonsAt replays the recorded changes up to at and lists the result
+// like ListPersons.
+func (m *MemoryStore) ListPersonsAt(ctx context.Context, at time.Time, f store.ListFilter) ([]store.Person, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	persons := map[int32]store.Person{}
+	for _, c := range m.changes {
+		if c.ChangedAt.After(at) {
+			break
+		}
+		if c.Op == "delete" {
+			delete(persons, c.Person.ID)
+		} else {
+			persons[c.Person.ID] = c.Person
+		}
+	}
+	return list(persons, f)
+}
+
+func (m *MemoryStore) Changes(ctx context.Context, since int64, limit int) ([]store.Change, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	if since < 0 || since > m.lastSeq {
+		return nil, &store.ValidationError{Field: "since", Message: fmt.Sprintf("unknown change %d", since)}
+	}
+	i, _ := slices.BinarySearchFunc(m.changes, since+1, func(c store.Change, seq int64) int { return cmp.Compare(c.Seq, seq) })
+	rest := m.changes[i:]
+	return slices.Clone(rest[:min(limit, len(rest))]), nil
+}
+
+// PurgeHistory deletes the recorded changes of persons deleted before
+// before.
+func (m *MemoryStore) PurgeHistory(ctx context.Context, before time.Time, dryRun bool) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return 0, m.Err
+	}
+	deletedAt := map[int32]time.Time{}
+	for _, c := range m.changes {
+		if _, ok := m.persons[c.Person.ID]; !ok && c.Op == "delete" {
+			deletedAt[c.Person.ID] = c.ChangedAt
+		}
+	}
+	return m.purge(func(c store.Change) bool {
+		at, ok := deletedAt[c.Person.ID]
+		return ok && at.Before(before)
+	}, dryRun), nil
+}
+
+func (m *MemoryStore) PurgePersonHistory(ctx context.Context, id int32) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return 0, m.Err
+	}
+	return m.purge(func(c store.Change) bool { return c.Person.ID == id }, false), nil
+}
+
+func (m *MemoryStore) purge(match func(c store.Change) bool, dryRun bool) int64 {
+	kept := slices.DeleteFunc(slices.Clone(m.changes), match)
+	n := int64(len(m.changes) - len(kept))
+	if !dryRun {
+		m.changes = kept
+	}
+	return n
+}
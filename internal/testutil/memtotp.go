@@ -0,0 +1,70 @@
+package testutil
+
+import (
+	"context"
+	"sync"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+// MemoryTOTPStore is an in-memory store.TOTPStore for handler tests.
+type MemoryTOTPStore struct {
+	mu       sync.Mutex
+	totp     *store.AdminTOTP
+	lastStep int64
+	Err      error
+}
+
+func NewMemoryTOTPStore() *MemoryTOTPStore {
+	return &MemoryTOTPStore{}
+}
+
+func (m *MemoryTOTPStore) AdminTOTP(ctx context.Context) (store.AdminTOTP, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.AdminTOTP{}, m.Err
+	}
+	if m.totp == nil {
+		return store.AdminTOTP{}, store.ErrNotFound
+	}
+	return *m.totp, nil
+}
+
+func (m *MemoryTOTPStore) EnrollAdminTOTP(ctx context.Context, secret string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	m.totp = &store.AdminTOTP{Secret: secret}
+	m.lastStep = 0
+	return nil
+}
+
+func (m *MemoryTOTPStore) ConfirmAdminTOTP(ctx context.Context, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return false, m.Err
+	}
+	if m.totp == nil || m.totp.Confirmed || step <= m.lastStep {
+		return false, nil
+	}
+	m.totp.Confirmed = true
+	m.lastStep = step
+	return true, nil
+}
+
+func (m *MemoryTOTPStore) UseAdminTOTPStep(ctx context.Context, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return false, m.Err
+	}
+	if m.totp == nil || !m.totp.Confirmed || step <= m.lastStep {
+		return false, nil
+	}
+	m.lastStep = step
+	return true, nil
+}
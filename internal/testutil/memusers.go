@@ -0,0 +1,112 @@
+package testutil
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+// MemoryUserStore is an in-memory store.UserStore for handler tests.
+type MemoryUserStore struct {
+	mu       sync.Mutex
+	users    map[string]store.User
+	nextID   int32
+	sessions []memSession
+	Err      error
+}
+
+type memSession struct {
+	store.Session
+	refreshHash  string
+	previousHash string
+	revoked      bool
+}
+
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{users: map[string]store.User{}, nextID: 1}
+}
+
+func (m *MemoryUserStore) CreateUser(ctx context.Context, email, passwordHash string) (store.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.User{}, m.Err
+	}
+	if _, ok := m.users[email]; ok {
+		return store.User{}, store.ErrConflict
+	}
+	u := store.User{ID: m.nextID, Email: email, PasswordHash: passwordHash, CreatedAt: time.Now()}
+	m.nextID++
+	m.users[email] = u
+	return u, nil
+}
+
+func (m *MemoryUserStore) UserByEmail(ctx context.Context, email string) (store.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.User{}, m.Err
+	}
+	u, ok := m.users[email]
+	if !ok {
+		return store.User{}, store.ErrNotFound
+	}
+	return u, nil
+}
+
+func (m *MemoryUserStore) CreateSession(ctx context.Context, userID int32, refreshHash []byte, expiresAt time.Time) (store.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.Session{}, m.Err
+	}
+	sess := store.Session{ID: int64(len(m.sessions) + 1), UserID: userID, CreatedAt: time.Now(), ExpiresAt: expiresAt}
+	m.sessions = append(m.sessions, memSession{Session: sess, refreshHash: string(refreshHash)})
+	return sess, nil
+}
+
+func (m *MemoryUserStore) RotateSession(ctx context.Context, oldHash, newHash []byte) (store.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return store.Session{}, m.Err
+	}
+	for i := range m.sessions {
+		s := &m.sessions[i]
+		if s.revoked {
+			continue
+		}
+		if s.refreshHash == string(oldHash) && time.Now().Before(s.ExpiresAt) {
+			s.previousHash, s.refreshHash = s.refreshHash, string(newHash)
+			return s.Session, nil
+		}
+		if s.previousHash == string(oldHash) {
+			s.revoked = true
+			return store.Session{}, store.ErrTokenReused
+		}
+	}
+	return store.Session{}, store.ErrNotFound
+}
+
+func (m *MemoryUserStore) RevokeSession(ctx context.Context, refreshHash []byte, all bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	for i := range m.sessions {
+		if m.sessions[i].refreshHash != string(refreshHash) {
+			continue
+		}
+		userID := m.sessions[i].UserID
+		for j := range m.sessions {
+			if j == i || all && m.sessions[j].UserID == userID {
+				m.sessions[j].revoked = true
+			}
+		}
+		return nil
+	}
+	return store.ErrNotFound
+}
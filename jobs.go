@@ -0,0 +1,479 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/blob"
+	"ci_cd/rsoi_lab_1/internal/store"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// jobRetention is how long done jobs, and the files they produced, are
+	// kept. Dead jobs are kept until an admin retries them.
+	jobRetention  = 24 * time.Hour
+	jobPruneEvery = time.Hour
+	// exportPrefix is where jobs keep the files they produce.
+	exportPrefix = "exports/"
+
+	jobMaxAttempts = 3
+	// jobLease is how long a worker holds a job before another may take it
+	// over; the worker renews it every third of it while the job runs.
+	jobLease        = time.Minute
+	jobPollInterval = 2 * time.Second
+	jobRetryBackoff = 10 * time.Second
+	jobMaxBackoff   = time.Hour
+	// jobEventsPoll is how often job event streams look for new events.
+	jobEventsPoll = time.Second
+)
+
+var jobPageLimits = pageLimits{defaultSize: 100, maxSize: 1000}
+
+// JobResponse describes a long-running operation started with
+// Prefer: respond-async.
+type JobResponse struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	Status     string     `json:"status"`
+	Attempts   int        `json:"attempts"`
+	Progress   int        `json:"progress"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Result is what the operation would have responded with, once done.
+	Result json.RawMessage `json:"result,omitempty"`
+	// Error is why the last attempt failed.
+	Error string `json:"error,omitempty"`
+	// DownloadURL serves the file the job produced, once done.
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+func toJobResponse(j store.Job) JobResponse {
+	resp := JobResponse{
+		ID:         j.ID,
+		Type:       j.Type,
+		Status:     j.Status,
+		Attempts:   j.Attempts,
+		Progress:   j.Progress,
+		CreatedAt:  j.CreatedAt,
+		StartedAt:  j.StartedAt,
+		FinishedAt: j.FinishedAt,
+		Result:     j.Result,
+		Error:      j.Error,
+	}
+	if j.Status == store.JobDone && j.File != nil {
+		resp.DownloadURL = "/api/v1/jobs/" + j.ID + "/download"
+	}
+	return resp
+}
+
+// JobProgressEvent is sent to those following a job when its status or
+// progress changes.
+type JobProgressEvent struct {
+	Status   string `json:"status"`
+	Progress int    `json:"progress"`
+}
+
+// jobOutput is what a job produced: a result, a file, or both.
+type jobOutput struct {
+	result any
+	file   *store.JobFile
+}
+
+// jobHandler runs a job of one type, telling report how far it got. The
+// job's ID names the files it produces.
+type jobHandler func(ctx context.Context, j store.Job, report *jobReport) (jobOutput, error)
+
+// jobReport is how a running job tells those following it how far it got.
+// A nil jobReport reports nothing, for the same work done without a job.
+type jobReport struct {
+	queue   store.JobQueue
+	id      string
+	attempt int
+	percent int
+}
+
+// logf sets the job's progress to percent and logs a line. Failing to
+// report does not fail the job.
+func (r *jobReport) logf(ctx context.Context, percent int, format string, args ...any) {
+	if r == nil {
+		return
+	}
+	r.percent = max(r.percent, min(percent, 100))
+	if err := r.queue.ReportJobProgress(ctx, r.id, r.attempt, r.percent, fmt.Sprintf(format, args...)); err != nil {
+		slog.WarnContext(ctx, "failed to report job progress", "job_id", r.id, "err", err)
+	}
+}
+
+// jobHandlers are the handlers of the job types this instance can run.
+func (app *application) jobHandlers() map[string]jobHandler {
+	handlers := map[string]jobHandler{"cleanup": app.cleanupJob}
+	if app.audit != nil && app.blobs != nil {
+		handlers["audit_export"] = app.auditExportJob
+	}
+	return handlers
+}
+
+// runJobWorkers runs n workers taking jobs from the queue until ctx is done.
+func (app *application) runJobWorkers(ctx context.Context, n int) {
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				ran, err := app.runNextJob(ctx)
+				if err != nil {
+					slog.WarnContext(ctx, "job worker failed", "err", err)
+				}
+				if ran && err == nil {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(jobPollInterval):
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// runNextJob runs the job due first, if any, and reports whether there was
+// one. A failed job is retried with exponential backoff until it used up its
+// attempts and is dead.
+func (app *application) runNextJob(ctx context.Context) (bool, error) {
+	handlers := app.jobHandlers()
+	types := make([]string, 0, len(handlers))
+	for typ := range handlers {
+		types = append(types, typ)
+	}
+	j, ok, err := app.jobQueue.ClaimJob(ctx, types, jobLease)
+	if err != nil || !ok {
+		return false, err
+	}
+	// The worker running its last attempt stopped before it finished.
+	if j.Attempts > j.MaxAttempts {
+		slog.ErrorContext(ctx, "job abandoned", "job_id", j.ID, "type", j.Type, "attempts", j.MaxAttempts)
+		return true, app.jobQueue.FailJob(ctx, j.ID, j.Attempts, "abandoned by its worker", 0)
+	}
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		app.renewJob(jobCtx, cancel, j)
+	}()
+	out, err := handlers[j.Type](jobCtx, j, &jobReport{queue: app.jobQueue, id: j.ID, attempt: j.Attempts})
+	cancel()
+	<-renewed
+	var result json.RawMessage
+	if err == nil && out.result != nil {
+		result, err = json.Marshal(out.result)
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "job failed", "job_id", j.ID, "type", j.Type, "attempt", j.Attempts, "err", err)
+		return true, app.jobQueue.FailJob(ctx, j.ID, j.Attempts, err.Error(), jobBackoff(j.Attempts))
+	}
+	slog.InfoContext(ctx, "job done", "job_id", j.ID, "type", j.Type)
+	return true, app.jobQueue.CompleteJob(ctx, j.ID, j.Attempts, result, out.file)
+}
+
+// renewJob keeps the lease of the claimed job j until ctx is done. Once
+// another worker claimed the job, renewing fails with ErrNotFound and cancel
+// is called, so that the job stops here.
+func (app *application) renewJob(ctx context.Context, cancel context.CancelFunc, j store.Job) {
+	ticker := time.NewTicker(jobLease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := app.jobQueue.RenewJob(ctx, j.ID, j.Attempts, jobLease); err != nil && ctx.Err() == nil {
+			slog.WarnContext(ctx, "failed to renew job lease", "job_id", j.ID, "err", err)
+			if errors.Is(err, store.ErrNotFound) {
+				cancel()
+				return
+			}
+		}
+	}
+}
+
+// jobBackoff is how long to wait before attempt+1: jobRetryBackoff,
+// doubling with every attempt up to jobMaxBackoff.
+func jobBackoff(attempt int) time.Duration {
+	d := jobRetryBackoff
+	for i := 1; i < attempt && d < jobMaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, jobMaxBackoff)
+}
+
+// pruneJobs deletes the jobs done longer than jobRetention ago, and their
+// files, every jobPruneEvery until ctx is done.
+func (app *application) pruneJobs(ctx context.Context) {
+	ticker := time.NewTicker(jobPruneEvery)
+	defer ticker.Stop()
+	for {
+		jobs, err := app.jobQueue.PruneJobs(ctx, time.Now().Add(-jobRetention))
+		if err != nil {
+			slog.WarnContext(ctx, "failed to prune jobs", "err", err)
+		}
+		for _, j := range jobs {
+			if j.File == nil || app.blobs == nil {
+				continue
+			}
+			if err := app.blobs.Delete(ctx, j.File.Key); err != nil {
+				slog.WarnContext(ctx, "failed to delete expired job file", "key", j.File.Key, "err", err)
+			}
+		}
+		if len(jobs) > 0 {
+			slog.InfoContext(ctx, "pruned jobs", "jobs", len(jobs))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// wantsAsync reports whether the client asked not to wait for the operation
+// with Prefer: respond-async (RFC 7240).
+func wantsAsync(r *http.Request) bool {
+	for _, v := range r.Header.Values("Prefer") {
+		for _, pref := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(pref), "respond-async") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// startJob queues a job of type typ with payload and responds 202 with where
+// to follow it.
+func (app *application) startJob(w http.ResponseWriter, r *http.Request, typ string, payload any) {
+	j, err := app.enqueueJob(r.Context(), typ, payload)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to start job", "type", typ, "err", err)
+		sendDebugError(w, apierr.Internal, "Failed to start job", errorDebug(r.Context(), err, 0))
+		return
+	}
+	slog.InfoContext(r.Context(), "job queued", "job_id", j.ID, "type", typ)
+	w.Header().Set("Location", "/api/v1/jobs/"+j.ID)
+	w.Header().Set("Preference-Applied", "respond-async")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(toJobResponse(j))
+}
+
+func (app *application) enqueueJob(ctx context.Context, typ string, payload any) (store.Job, error) {
+	id, err := newUploadID()
+	if err != nil {
+		return store.Job{}, err
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return store.Job{}, fmt.Errorf("encode %s payload: %w", typ, err)
+	}
+	return app.jobQueue.EnqueueJob(ctx, store.Job{ID: id, Type: typ, Payload: raw, MaxAttempts: jobMaxAttempts})
+}
+
+// lookupJob sends JOB_NOT_FOUND or the store error when the job cannot be
+// found.
+func (app *application) lookupJob(w http.ResponseWriter, r *http.Request) (store.Job, bool) {
+	j, err := app.jobQueue.Job(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, store.ErrNotFound) {
+		sendError(w, apierr.JobNotFound, "Job not found")
+		return store.Job{}, false
+	}
+	if err != nil {
+		sendStoreError(w, r, err)
+		return store.Job{}, false
+	}
+	return j, true
+}
+
+func (app *application) getJob(w http.ResponseWriter, r *http.Request) {
+	j, ok := app.lookupJob(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toJobResponse(j))
+}
+
+// downloadJobFile serves the file a job produced. Job IDs cannot be guessed,
+// so like a presigned URL, the download URL is all it takes.
+func (app *application) downloadJobFile(w http.ResponseWriter, r *http.Request) {
+	j, ok := app.lookupJob(w, r)
+	if !ok {
+		return
+	}
+	if j.Status != store.JobDone || j.File == nil || app.blobs == nil {
+		sendError(w, apierr.JobNotFound, "The job produced no file")
+		return
+	}
+	o, err := app.blobs.Stat(r.Context(), j.File.Key)
+	if err != nil {
+		if errors.Is(err, blob.ErrNotFound) {
+			sendError(w, apierr.JobNotFound, "The job's file has expired")
+			return
+		}
+		sendStorageError(w, r, err)
+		return
+	}
+	body, err := app.blobs.Get(r.Context(), j.File.Key)
+	if err != nil {
+		sendStorageError(w, r, err)
+		return
+	}
+	defer body.Close()
+	w.Header().Set("Content-Type", j.File.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": j.File.Filename}))
+	w.Header().Set("Content-Length", strconv.FormatInt(o.Size, 10))
+	if _, err := io.Copy(w, body); err != nil {
+		slog.WarnContext(r.Context(), "job file download aborted", "job_id", j.ID, "err", err)
+	}
+}
+
+// streamJobEvents follows a job as Server-Sent Events until it is done or
+// dead: "log" events with the lines it logs, numbered so that a client
+// reconnecting with Last-Event-ID misses none, "progress" events when its
+// status or progress changes, and a last "end" event with the job. Jobs run
+// on any instance, so the stream polls the queue.
+func (app *application) streamJobEvents(w http.ResponseWriter, r *http.Request) {
+	j, ok := app.lookupJob(w, r)
+	if !ok {
+		return
+	}
+	id := j.ID
+	// An invalid Last-Event-ID replays the whole log.
+	after, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	rc := http.NewResponseController(w)
+	ctx := r.Context()
+	var last *JobProgressEvent
+	for {
+		lines, err := app.jobQueue.JobLog(ctx, id, after)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.WarnContext(ctx, "job event stream failed", "job_id", id, "err", err)
+			}
+			return
+		}
+		for _, l := range lines {
+			writeEvent(w, strconv.FormatInt(l.ID, 10), "log", l.Message)
+			after = l.ID
+		}
+		if p := (JobProgressEvent{Status: j.Status, Progress: j.Progress}); last == nil || p != *last {
+			data, _ := json.Marshal(p)
+			writeEvent(w, "", "progress", string(data))
+			last = &p
+		}
+		if j.Status == store.JobDone || j.Status == store.JobDead {
+			data, _ := json.Marshal(toJobResponse(j))
+			writeEvent(w, "", "end", string(data))
+			rc.Flush()
+			return
+		}
+		// Without flushing, clients would get every event at once at the end.
+		if err := rc.Flush(); err != nil {
+			slog.WarnContext(ctx, "job event stream cannot flush", "job_id", id, "err", err)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(jobEventsPoll):
+		}
+		// Clients reconnect to another instance, where they resume.
+		if app.drain.status().Draining {
+			return
+		}
+		if j, err = app.jobQueue.Job(ctx, id); err != nil {
+			if ctx.Err() == nil {
+				slog.WarnContext(ctx, "job event stream failed", "job_id", id, "err", err)
+			}
+			return
+		}
+	}
+}
+
+// writeEvent writes a Server-Sent Event, without an ID when id is empty.
+func writeEvent(w io.Writer, id, event, data string) {
+	if id != "" {
+		fmt.Fprintf(w, "id: %s\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\n", event)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
+
+// listJobs lists the jobs with ?status=, dead ones unless given, newest
+// first.
+func (app *application) listJobs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, errs := parseLimit(q, jobPageLimits, nil)
+	statuses := []string{store.JobPending, store.JobRunning, store.JobFailed, store.JobDone, store.JobDead}
+	status := q.Get("status")
+	if status == "" {
+		status = store.JobDead
+	} else if !slices.Contains(statuses, status) {
+		errs = append(errs, apierr.NewFieldError("status", apierr.KeyOneOf, map[string]any{"allowed": statuses, "actual": status}))
+	}
+	if len(errs) > 0 {
+		sendValidationError(w, apierr.ValidationFailed, "Invalid query parameters", errs)
+		return
+	}
+	jobs, err := app.jobQueue.Jobs(r.Context(), status, limit)
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	resp := make([]JobResponse, 0, len(jobs))
+	for _, j := range jobs {
+		resp = append(resp, toJobResponse(j))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// retryJob queues a dead job again, with all its attempts.
+func (app *application) retryJob(w http.ResponseWriter, r *http.Request) {
+	j, err := app.jobQueue.RetryJob(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, store.ErrNotFound) {
+		sendError(w, apierr.JobNotFound, "No dead job with this ID")
+		return
+	}
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "dead job retried by admin", "job_id", j.ID, "type", j.Type)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toJobResponse(j))
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/blob"
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestWantsAsync(t *testing.T) {
+	testCases := []struct {
+		prefer []string
+		want   bool
+	}{
+		{nil, false},
+		{[]string{"respond-async"}, true},
+		{[]string{"return=minimal, Respond-Async"}, true},
+		{[]string{"return=minimal", "respond-async"}, true},
+		{[]string{"wait=10"}, false},
+	}
+	for _, tc := range testCases {
+		r := httptest.NewRequest("GET", "/", nil)
+		for _, v := range tc.prefer {
+			r.Header.Add("Prefer", v)
+		}
+		if got := wantsAsync(r); got != tc.want {
+			t.Errorf("Prefer %q: expected %v, got %v", tc.prefer, tc.want, got)
+		}
+	}
+}
+
+func TestAsyncAuditExport(t *testing.T) {
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	app.cfg.adminToken = "s3cret"
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.blobs = blobs
+	app.audit = testutil.NewMemoryAuditLog()
+	app.jobQueue = testutil.NewMemoryJobQueue()
+	router := withContractCheck(t, app.routes())
+	testutil.Do(router, "POST", "/api/v1/persons", map[string]string{"name": "Ann"})
+
+	req := httptest.NewRequest("GET", "/admin/audit/export?format=csv", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	req.Header.Set("Prefer", "respond-async")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var j JobResponse
+	if err := json.NewDecoder(rr.Body).Decode(&j); err != nil || rr.Code != http.StatusAccepted || j.Type != "audit_export" {
+		t.Fatalf("Expected the export to start as a job, got %d %+v %v", rr.Code, j, err)
+	}
+	if loc := rr.Header().Get("Location"); loc != "/api/v1/jobs/"+j.ID {
+		t.Errorf("Expected the job's URL in Location, got %q", loc)
+	}
+	if ran, err := app.runNextJob(context.Background()); !ran || err != nil {
+		t.Fatalf("Expected the job to run, got %v %v", ran, err)
+	}
+
+	rr = testutil.Do(router, "GET", "/api/v1/jobs/"+j.ID, nil)
+	if err := json.NewDecoder(rr.Body).Decode(&j); err != nil || j.Status != store.JobDone || string(j.Result) != `{"entries":1}` || j.DownloadURL == "" {
+		t.Fatalf("Expected the job done with one entry, got %d %+v %v", rr.Code, j, err)
+	}
+	rr = testutil.Do(router, "GET", j.DownloadURL, nil)
+	if body := rr.Body.String(); rr.Code != http.StatusOK || !strings.HasPrefix(body, "id,at,actor") || strings.Count(body, "\n") != 2 {
+		t.Errorf("Expected the CSV export, got %d %q", rr.Code, body)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("Expected the export's content type, got %q", ct)
+	}
+
+	rr = testutil.Do(router, "GET", "/api/v1/jobs/nope", nil)
+	if rr.Code != http.StatusNotFound || decodeErrorCode(t, rr) != apierr.JobNotFound {
+		t.Errorf("Expected JOB_NOT_FOUND, got %d", rr.Code)
+	}
+}
+
+func TestJobRetries(t *testing.T) {
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	app.cfg.adminToken = "s3cret"
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit := testutil.NewMemoryAuditLog()
+	queue := testutil.NewMemoryJobQueue()
+	app.blobs, app.audit, app.jobQueue = blobs, audit, queue
+	router := withContractCheck(t, app.routes())
+	ctx := context.Background()
+	j, err := app.enqueueJob(ctx, "audit_export", auditExportPayload{Format: auditFormatJSONL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	audit.Err = errors.New("audit log unavailable")
+	var later time.Duration
+	for attempt := 1; attempt <= jobMaxAttempts; attempt++ {
+		if ran, err := app.runNextJob(ctx); !ran || err != nil {
+			t.Fatalf("Attempt %d: expected the job to run, got %v %v", attempt, ran, err)
+		}
+		if ran, _ := app.runNextJob(ctx); ran {
+			t.Fatalf("Attempt %d: expected the retry to wait for its backoff", attempt)
+		}
+		later += jobMaxBackoff
+		queue.Now = func() time.Time { return time.Now().Add(later) }
+	}
+	if j, _ := queue.Job(ctx, j.ID); j.Status != store.JobDead || j.Attempts != jobMaxAttempts || j.Error != "audit log unavailable" {
+		t.Fatalf("Expected the job dead after %d attempts, got %+v", jobMaxAttempts, j)
+	}
+	if ran, _ := app.runNextJob(ctx); ran {
+		t.Error("Expected a dead job not to run")
+	}
+
+	admin := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	var dead []JobResponse
+	if rr := admin("GET", "/admin/jobs"); json.NewDecoder(rr.Body).Decode(&dead) != nil || len(dead) != 1 || dead[0].ID != j.ID {
+		t.Errorf("Expected the dead job listed, got %d %+v", rr.Code, dead)
+	}
+	if rr := admin("GET", "/admin/jobs?status=lost"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown status to be refused, got %d", rr.Code)
+	}
+	// Retried long enough ago for the job to be pruned since.
+	queue.Now = func() time.Time { return time.Now().Add(-jobRetention - time.Hour) }
+	if rr := admin("POST", "/admin/jobs/"+j.ID+"/retry"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected the dead job retried, got %d", rr.Code)
+	}
+	if rr := admin("POST", "/admin/jobs/"+j.ID+"/retry"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected only dead jobs to be retried, got %d", rr.Code)
+	}
+	audit.Err = nil
+	if ran, err := app.runNextJob(ctx); !ran || err != nil {
+		t.Fatalf("Expected the retried job to run, got %v %v", ran, err)
+	}
+	j, _ = queue.Job(ctx, j.ID)
+	if j.Status != store.JobDone || j.Attempts != 1 || j.File == nil {
+		t.Fatalf("Expected the retried job done at its first attempt, got %+v", j)
+	}
+
+	stopped, stop := context.WithCancel(ctx)
+	stop()
+	app.pruneJobs(stopped)
+	if _, err := queue.Job(ctx, j.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected the done job pruned, got %v", err)
+	}
+	if _, err := blobs.Stat(ctx, j.File.Key); !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("Expected the pruned job's file deleted, got %v", err)
+	}
+}
+
+func TestAbandonedJob(t *testing.T) {
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	queue := testutil.NewMemoryJobQueue()
+	app.jobQueue = queue
+	ctx := context.Background()
+	j, err := app.enqueueJob(ctx, "cleanup", cleanupPayload{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Workers that stopped before finishing each attempt.
+	var later time.Duration
+	for range jobMaxAttempts {
+		if _, ok, err := queue.ClaimJob(ctx, []string{"cleanup"}, jobLease); !ok || err != nil {
+			t.Fatalf("Expected the job claimed, got %v %v", ok, err)
+		}
+		later += 2 * jobLease
+		queue.Now = func() time.Time { return time.Now().Add(later) }
+	}
+	if ran, err := app.runNextJob(ctx); !ran || err != nil {
+		t.Fatalf("Expected the abandoned job taken over, got %v %v", ran, err)
+	}
+	if j, _ := queue.Job(ctx, j.ID); j.Status != store.JobDead {
+		t.Errorf("Expected a job abandoned at its last attempt to be dead, got %+v", j)
+	}
+}
+
+// A worker whose lease ran out and whose job was claimed again cannot touch
+// it any more.
+func TestJobClaimedAgain(t *testing.T) {
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	queue := testutil.NewMemoryJobQueue()
+	app.jobQueue = queue
+	ctx := context.Background()
+	if _, err := app.enqueueJob(ctx, "cleanup", cleanupPayload{DryRun: true}); err != nil {
+		t.Fatal(err)
+	}
+	first, ok, err := queue.ClaimJob(ctx, []string{"cleanup"}, jobLease)
+	if !ok || err != nil {
+		t.Fatalf("Expected the job claimed, got %v %v", ok, err)
+	}
+	queue.Now = func() time.Time { return time.Now().Add(2 * jobLease) }
+	second, ok, err := queue.ClaimJob(ctx, []string{"cleanup"}, jobLease)
+	if !ok || err != nil {
+		t.Fatalf("Expected the job claimed again after its lease, got %v %v", ok, err)
+	}
+
+	if err := queue.RenewJob(ctx, first.ID, first.Attempts, jobLease); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected the first worker's renewal to fail with ErrNotFound, got %v", err)
+	}
+	if err := queue.ReportJobProgress(ctx, first.ID, first.Attempts, 50, "late"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected the first worker's progress to be refused, got %v", err)
+	}
+	if err := queue.CompleteJob(ctx, first.ID, first.Attempts, nil, nil); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected the first worker's completion to fail with ErrNotFound, got %v", err)
+	}
+	if err := queue.FailJob(ctx, first.ID, first.Attempts, "late", 0); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected the first worker's failure to be refused, got %v", err)
+	}
+	if err := queue.CompleteJob(ctx, second.ID, second.Attempts, nil, nil); err != nil {
+		t.Errorf("Expected the second worker to complete the job, got %v", err)
+	}
+	if j, _ := queue.Job(ctx, first.ID); j.Status != store.JobDone {
+		t.Errorf("Expected the job done, got %+v", j)
+	}
+}
+
+func TestJobBackoff(t *testing.T) {
+	testCases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{5, 160 * time.Second},
+		{12, time.Hour},
+		{100, time.Hour},
+	}
+	for _, tc := range testCases {
+		if got := jobBackoff(tc.attempt); got != tc.want {
+			t.Errorf("Attempt %d: expected %s, got %s", tc.attempt, tc.want, got)
+		}
+	}
+}
+
+func TestJobEvents(t *testing.T) {
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit := testutil.NewMemoryAuditLog()
+	audit.RecordAudit(context.Background(), store.AuditEntry{Actor: "admin", Method: "POST", Path: "/admin/cleanup", Status: 200})
+	app.blobs, app.audit, app.jobQueue = blobs, audit, testutil.NewMemoryJobQueue()
+	router := withContractCheck(t, app.routes())
+	ctx := context.Background()
+	j, err := app.enqueueJob(ctx, "audit_export", auditExportPayload{Format: auditFormatCSV, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ran, err := app.runNextJob(ctx); !ran || err != nil {
+		t.Fatalf("Expected the job to run, got %v %v", ran, err)
+	}
+
+	rr := testutil.Do(router, "GET", "/api/v1/jobs/"+j.ID+"/events", nil)
+	if ct := rr.Header().Get("Content-Type"); rr.Code != http.StatusOK || ct != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %d %q", rr.Code, ct)
+	}
+	events := strings.Split(strings.TrimSuffix(rr.Body.String(), "\n\n"), "\n\n")
+	if len(events) != 3 {
+		t.Fatalf("Expected a log line, the progress and the end, got %q", events)
+	}
+	logLine := strings.SplitN(events[0], "\n", 2)
+	if !strings.HasPrefix(logLine[0], "id: ") || logLine[1] != "event: log\ndata: Exported 1 entries" {
+		t.Errorf("Expected the job's log line, got %q", events[0])
+	}
+	if events[1] != `event: progress`+"\n"+`data: {"status":"done","progress":100}` {
+		t.Errorf("Expected the job's progress, got %q", events[1])
+	}
+	if !strings.HasPrefix(events[2], "event: end\ndata: {") {
+		t.Errorf("Expected the job at the end, got %q", events[2])
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/jobs/"+j.ID+"/events", nil)
+	req.Header.Set("Last-Event-ID", strings.TrimPrefix(logLine[0], "id: "))
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if body := rr.Body.String(); strings.Contains(body, "event: log") || !strings.Contains(body, "event: end") {
+		t.Errorf("Expected the stream resumed after the last line seen, got %q", body)
+	}
+
+	if rr := testutil.Do(router, "GET", "/api/v1/jobs/nope/events", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown job to be 404, got %d", rr.Code)
+	}
+}
+
+func TestJobEventsWhileRunning(t *testing.T) {
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.blobs, app.audit, app.jobQueue = blobs, testutil.NewMemoryAuditLog(), testutil.NewMemoryJobQueue()
+	srv := httptest.NewServer(app.routes())
+	defer srv.Close()
+	j, err := app.enqueueJob(context.Background(), "audit_export", auditExportPayload{Format: auditFormatCSV})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The job has not run yet, so its first event only arrives if flushed.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/api/v1/jobs/"+j.ID+"/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	sc := bufio.NewScanner(resp.Body)
+	next := func() []string {
+		var event []string
+		for sc.Scan() && sc.Text() != "" {
+			event = append(event, sc.Text())
+		}
+		return event
+	}
+	if event := next(); len(event) != 2 || event[0] != "event: progress" || !strings.Contains(event[1], `"status":"pending"`) {
+		t.Fatalf("Expected the progress of the pending job, got %q (%v)", event, sc.Err())
+	}
+
+	if ran, err := app.runNextJob(context.Background()); !ran || err != nil {
+		t.Fatalf("Expected the job to run, got %v %v", ran, err)
+	}
+	var events []string
+	for event := next(); len(event) > 0; event = next() {
+		events = append(events, event[0])
+	}
+	if len(events) == 0 || events[len(events)-1] != "event: end" {
+		t.Errorf("Expected the same stream to go on until the job ended, got %q (%v)", events, sc.Err())
+	}
+}
+
+func TestWriteEvent(t *testing.T) {
+	var b strings.Builder
+	writeEvent(&b, "", "log", "two\nlines")
+	if want := "event: log\ndata: two\ndata: lines\n\n"; b.String() != want {
+		t.Errorf("Expected %q, got %q", want, b.String())
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type loadtestConfig struct {
+	baseURL      string
+	path         string
+	method       string
+	body         string
+	rps          int
+	duration     time.Duration
+	workers      int
+	maxP99       time.Duration
+	maxErrorRate float64
+}
+
+type loadtestReport struct {
+	sent     int
+	errors   int
+	statuses map[int]int
+	elapsed  time.Duration
+	p50      time.Duration
+	p90      time.Duration
+	p99      time.Duration
+	max      time.Duration
+}
+
+func (r loadtestReport) errorRate() float64 {
+	if r.sent == 0 {
+		return 0
+	}
+	return float64(r.errors) / float64(r.sent)
+}
+
+func (r loadtestReport) print(w io.Writer) {
+	fmt.Fprintf(w, "requests: %d in %s (%.1f req/s)\n", r.sent, r.elapsed.Round(time.Millisecond), float64(r.sent)/r.elapsed.Seconds())
+	fmt.Fprintf(w, "errors:   %d (%.2f%%)\n", r.errors, 100*r.errorRate())
+	codes := make([]int, 0, len(r.statuses))
+	for code := range r.statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "  %d: %d\n", code, r.statuses[code])
+	}
+	fmt.Fprintf(w, "latency:  p50=%s p90=%s p99=%s max=%s\n", r.p50, r.p90, r.p99, r.max)
+}
+
+// runLoadtestCommand implements `lab1 loadtest [flags]`. It exits non-zero
+// (through the returned error) when the run breaks the configured thresholds.
+func runLoadtestCommand(args []string, out io.Writer) error {
+	var cfg loadtestConfig
+	fs := flag.NewFlagSet("loadtest", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.StringVar(&cfg.baseURL, "url", "http://localhost:8080", "base URL of the running instance")
+	fs.StringVar(&cfg.path, "path", "/api/v1/persons", "request path")
+	fs.StringVar(&cfg.method, "method", "GET", "HTTP method")
+	fs.StringVar(&cfg.body, "body", "", "JSON request body")
+	fs.IntVar(&cfg.rps, "rps", 50, "target requests per second")
+	fs.DurationVar(&cfg.duration, "duration", 10*time.Second, "how long to send requests")
+	fs.IntVar(&cfg.workers, "workers", 32, "maximum concurrent requests")
+	fs.DurationVar(&cfg.maxP99, "max-p99", 0, "fail if p99 latency exceeds this (0 disables)")
+	fs.Float64Var(&cfg.maxErrorRate, "max-error-rate", 0.01, "fail if the share of failed requests exceeds this")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if cfg.rps <= 0 || cfg.workers <= 0 {
+		return fmt.Errorf("rps and workers must be positive")
+	}
+
+	report := runLoadtest(context.Background(), cfg, http.DefaultClient)
+	report.print(out)
+
+	if cfg.maxP99 > 0 && report.p99 > cfg.maxP99 {
+		return fmt.Errorf("p99 latency %s exceeds %s", report.p99, cfg.maxP99)
+	}
+	if report.errorRate() > cfg.maxErrorRate {
+		return fmt.Errorf("error rate %.2f%% exceeds %.2f%%", 100*report.errorRate(), 100*cfg.maxErrorRate)
+	}
+	return nil
+}
+
+// runLoadtest sends requests at a fixed rate (open loop), so a slow server shows
+// up as growing latency rather than as a silently reduced request rate. Ticks
+// that find every worker busy are counted as errors.
+func runLoadtest(ctx context.Context, cfg loadtestConfig, client *http.Client) loadtestReport {
+	ctx, cancel := context.WithTimeout(ctx, cfg.duration)
+	defer cancel()
+
+	var mu sync.Mutex
+	var latencies []time.Duration
+	report := loadtestReport{statuses: map[int]int{}}
+
+	record := func(d time.Duration, status int, failed bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		report.sent++
+		if failed {
+			report.errors++
+		}
+		if status != 0 {
+			report.statuses[status]++
+		}
+		latencies = append(latencies, d)
+	}
+
+	slots := make(chan struct{}, cfg.workers)
+	var wg sync.WaitGroup
+	ticker := time.NewTicker(time.Second / time.Duration(cfg.rps))
+	defer ticker.Stop()
+	start := time.Now()
+
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-ticker.C:
+		}
+		select {
+		case slots <- struct{}{}:
+		default:
+			record(0, 0, true)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			status, d, err := sendLoadtestRequest(client, cfg)
+			record(d, status, err != nil || status >= 500)
+		}()
+	}
+	wg.Wait()
+	report.elapsed = time.Since(start)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	report.p50 = percentile(latencies, 50)
+	report.p90 = percentile(latencies, 90)
+	report.p99 = percentile(latencies, 99)
+	if len(latencies) > 0 {
+		report.max = latencies[len(latencies)-1]
+	}
+	return report
+}
+
+func sendLoadtestRequest(client *http.Client, cfg loadtestConfig) (int, time.Duration, error) {
+	req, err := http.NewRequest(cfg.method, strings.TrimRight(cfg.baseURL, "/")+cfg.path, strings.NewReader(cfg.body))
+	if err != nil {
+		return 0, 0, err
+	}
+	if cfg.body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, time.Since(start), err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode, time.Since(start), nil
+}
+
+// percentile expects sorted input and uses the nearest-rank method.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
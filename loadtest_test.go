@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	if got := percentile(sorted, 50); got != 50*time.Millisecond {
+		t.Errorf("Expected p50 of 50ms, got %s", got)
+	}
+	if got := percentile(sorted, 99); got != 99*time.Millisecond {
+		t.Errorf("Expected p99 of 99ms, got %s", got)
+	}
+	if got := percentile(nil, 99); got != 0 {
+		t.Errorf("Expected 0 for empty input, got %s", got)
+	}
+}
+
+func TestRunLoadtest(t *testing.T) {
+	srv := httptest.NewServer(setupTestRouterWithStore(t, testutil.NewMemoryStore()))
+	defer srv.Close()
+
+	report := runLoadtest(context.Background(), loadtestConfig{
+		baseURL:  srv.URL,
+		path:     "/api/v1/persons",
+		method:   "GET",
+		rps:      200,
+		duration: 200 * time.Millisecond,
+		workers:  4,
+	}, srv.Client())
+
+	if report.sent == 0 || report.statuses[http.StatusOK] != report.sent || report.errors != 0 {
+		t.Errorf("Unexpected report: %+v", report)
+	}
+}
+
+func TestRunLoadtestCommand_FailsOnErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	var out bytes.Buffer
+	err := runLoadtestCommand([]string{"-url", srv.URL, "-rps", "100", "-duration", "100ms"}, &out)
+	if err == nil || !strings.Contains(err.Error(), "error rate") {
+		t.Errorf("Expected error rate failure, got %v", err)
+	}
+	if !strings.Contains(out.String(), "503:") {
+		t.Errorf("Expected status breakdown in output, got:\n%s", out.String())
+	}
+}
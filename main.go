@@ -1,17 +1,32 @@
 package main
 
 import (
-	"database/sql"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"strconv"
-	"strings"
-
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/blob"
+	"ci_cd/rsoi_lab_1/internal/geocode"
+	"ci_cd/rsoi_lab_1/internal/health"
+	"ci_cd/rsoi_lab_1/internal/logging"
+	"ci_cd/rsoi_lab_1/internal/metrics"
+	"ci_cd/rsoi_lab_1/internal/redis"
+	"ci_cd/rsoi_lab_1/internal/scan"
+	"ci_cd/rsoi_lab_1/internal/store"
+
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getsentry/sentry-go"
 	"github.com/gorilla/mux"
-	_ "github.com/lib/pq"
+	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type PersonRequest struct {
@@ -19,327 +34,661 @@ type PersonRequest struct {
 	Age     *int32  `json:"age,omitempty"`
 	Address *string `json:"address,omitempty"`
 	Work    *string `json:"work,omitempty"`
+	// Latitude and Longitude are WGS 84 degrees and go together.
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
+	// Phone is stored in E.164 form. Numbers without a country code are read
+	// as numbers of PHONE_DEFAULT_REGION.
+	Phone *string `json:"phone,omitempty"`
+	// Email is stored lowercased and must not be used by another person.
+	Email *string `json:"email,omitempty"`
+	// Custom holds values of the fields defined under /schema/fields. A
+	// PATCH removes the ones set to null.
+	Custom map[string]any `json:"custom,omitempty"`
 }
 
 type PersonResponse struct {
-	ID      int32   `json:"id"`
-	Name    string  `json:"name,omitempty"`
-	Age     *int32  `json:"age,omitempty"`
-	Address *string `json:"address,omitempty"`
-	Work    *string `json:"work,omitempty"`
+	ID        int32      `json:"id"`
+	Name      string     `json:"name,omitempty"`
+	Age       *int32     `json:"age,omitempty"`
+	Address   *string    `json:"address,omitempty"`
+	Work      *string    `json:"work,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	Latitude  *float64   `json:"latitude,omitempty"`
+	Longitude *float64   `json:"longitude,omitempty"`
+	Phone     *string    `json:"phone,omitempty"`
+	// PhoneRegion is derived from Phone.
+	PhoneRegion *string        `json:"phone_region,omitempty"`
+	Email       *string        `json:"email,omitempty"`
+	Custom      map[string]any `json:"custom,omitempty"`
+	// Attachments and Photo are only given when named in ?expand=.
+	// Attachments is a pointer so that a person without any still has an
+	// empty list.
+	Attachments *[]AttachmentResponse `json:"attachments,omitempty"`
+	Photo       *PhotoResponse        `json:"photo,omitempty"`
 }
 
 type ErrorResponse struct {
-	Message string `json:"message"`
+	Code    apierr.Code `json:"code"`
+	Message string      `json:"message"`
+	TraceID string      `json:"trace_id,omitempty"`
+	Debug   *ErrorDebug `json:"debug,omitempty"`
 }
 
-type ValidationErrorResponse struct {
-	Message string            `json:"message"`
-	Errors  map[string]string `json:"errors"`
+// ErrorDebug is added to 5xx responses in debug mode.
+type ErrorDebug struct {
+	Error string `json:"error"`
+	// Query is the name of the last database query the request ran.
+	Query string `json:"query,omitempty"`
+	// Stack is where the error was handled, or where a panic was raised.
+	Stack string `json:"stack,omitempty"`
 }
 
-type application struct {
-	db *sql.DB
+type ProblemResponse struct {
+	Type     string      `json:"type"`
+	Title    string      `json:"title"`
+	Status   int         `json:"status"`
+	Code     apierr.Code `json:"code"`
+	Detail   string      `json:"detail,omitempty"`
+	Instance string      `json:"instance,omitempty"`
+	TraceID  string      `json:"trace_id,omitempty"`
 }
 
-func (app *application) initDB() (*sql.DB, error) {
-	dsn := os.Getenv("DATABASE_URL")
-	if dsn == "" {
-		dsn = "postgres://localhost:5432/persons?sslmode=disable"
-	}
-	var err error
-	app.db, err = sql.Open("postgres", dsn)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
-	}
-
-	if err = app.db.Ping(); err != nil {
-		return nil, fmt.Errorf("database connection failed: %w", err)
-	}
-
-	createTable :=
-		`CREATE TABLE IF NOT EXISTS persons (
-		id SERIAL PRIMARY KEY,
-		name TEXT NOT NULL,
-		age INT,
-		address TEXT,
-		work TEXT
-		);`
-
-	_, err = app.db.Exec(createTable)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create table %w", err)
-	}
-	return app.db, nil
-
+type ValidationErrorResponse struct {
+	Code    apierr.Code         `json:"code"`
+	Message string              `json:"message"`
+	Errors  map[string]string   `json:"errors"`
+	Details []apierr.FieldError `json:"details,omitempty"`
+	TraceID string              `json:"trace_id,omitempty"`
 }
 
-func main() {
-	app := &application{}
-	db, err := app.initDB()
-	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
-		return
-	}
-	defer db.Close()
-
-	r := mux.NewRouter()
+// ConflictErrorResponse names the unique field a write collided on and, when
+// known, the person already holding the value, or the relation that keeps a
+// person from being deleted.
+type ConflictErrorResponse struct {
+	Code       apierr.Code `json:"code"`
+	Message    string      `json:"message"`
+	Field      string      `json:"field,omitempty"`
+	ExistingID *int32      `json:"existing_id,omitempty"`
+	Relation   string      `json:"relation,omitempty"`
+	TraceID    string      `json:"trace_id,omitempty"`
+}
 
-	r.HandleFunc("/api/v1/persons", app.listPersons).Methods("GET")
-	r.HandleFunc("/api/v1/persons", app.createPerson).Methods("POST")
-	r.HandleFunc("/api/v1/persons/{id}", app.getPerson).Methods("GET")
-	r.HandleFunc("/api/v1/persons/{id}", app.updatePerson).Methods("PATCH")
-	r.HandleFunc("/api/v1/persons/{id}", app.deletePerson).Methods("DELETE")
+type AddressErrorResponse struct {
+	ValidationErrorResponse
+	Suggestions []AddressSuggestion `json:"suggestions"`
+}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-	}
-	log.Printf("Starting server on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
+type AddressSuggestion struct {
+	Address   string   `json:"address"`
+	Latitude  *float64 `json:"latitude,omitempty"`
+	Longitude *float64 `json:"longitude,omitempty"`
 }
 
-func sendError(w http.ResponseWriter, statusCode int, message string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(ErrorResponse{Message: message})
+type application struct {
+	db        *pgxpool.Pool
+	store     store.Store
+	cfg       config
+	shedder   *loadShedder
+	metrics   *appMetrics
+	expensive *concurrencyLimiter
+	limiter   *rateLimiter
+	logLevel  *slog.LevelVar
+	reporter  *errorReporter
+	accessLog *accessLogger
+	statsd    *metrics.StatsD
+	health    *health.Registry
+	cache     *store.Cache
+	changes   store.ChangeFeed
+	history   store.History
+	keys      store.KeyStore
+	users     store.UserStore
+	totp      store.TOTPStore
+	audit     store.AuditLog
+	search    store.Search
+	elastic   *store.Elastic
+	nearby    store.Nearby
+
+	// blobs keeps the content of attachments and photos, described by
+	// attachments and photos.
+	blobs       blob.Store
+	attachments store.AttachmentStore
+	scanner     scan.Scanner
+	photos      store.PhotoStore
+	integrity   *integrityChecker
+	dataCheck   store.DataChecker
+
+	drain  *drainer
+	shadow *shadowMirror
+
+	// historyPurge removes the history of deleted persons once
+	// historyRetention is over.
+	historyPurge store.HistoryPurger
+	// jobQueue runs operations in the background on any instance.
+	jobQueue store.JobQueue
+	// customFields defines the fields deployments add to persons.
+	customFields store.CustomFieldStore
+	// explain shows admins how the database runs list queries.
+	explain store.QueryExplainer
+
+	geocoder    geocode.Provider
+	geocodeJobs chan geocodeJob
+	addresses   geocode.Validator
+
+	// spec is openapi.yaml, loaded when requests or responses are validated
+	// against it.
+	spec routers.Router
 }
 
-func sendValidationError(w http.ResponseWriter, message string, errors map[string]string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusBadRequest)
-	json.NewEncoder(w).Encode(ValidationErrorResponse{
-		Message: message,
-		Errors:  errors,
+// newApplication keeps everything in db, except persons when shards are
+// given.
+func newApplication(cfg config, db *pgxpool.Pool, shards ...*pgxpool.Pool) *application {
+	app := &application{
+		db:        db,
+		cfg:       cfg,
+		shedder:   newLoadShedder(cfg.maxInFlight, cfg.maxQueueWait),
+		metrics:   newAppMetrics(),
+		expensive: newConcurrencyLimiter(cfg.expensiveMaxConcurrent, cfg.expensiveMaxQueue),
+		limiter:   newRateLimiter(cfg.rateLimit, cfg.rateLimitWindow),
+		logLevel:  new(slog.LevelVar),
+		health:    health.NewRegistry(cfg.healthCheckTimeout),
+		integrity: &integrityChecker{},
+		drain:     &drainer{},
+		shadow:    newShadowMirror(cfg.shadowURL, cfg.shadowSampleRate),
+	}
+	app.logLevel.Set(cfg.logLevel)
+	app.metrics.slowQuery = cfg.slowQueryThreshold
+	app.health.Register("drain", app.drain.check)
+	if app.shadow != nil {
+		app.shadow.results = app.metrics.registry.NewCounterVec("shadow_requests_total", "Sampled API reads by how their mirror compared: matched, mismatched, failed or skipped.", "result")
+	}
+	app.metrics.registry.NewGaugeFunc("http_requests_in_flight", "Requests being served.", func() float64 {
+		return float64(app.drain.inFlight.Load())
+	})
+	app.metrics.registry.NewGaugeFunc("http_draining", "1 while the server drains before stopping, else 0.", func() float64 {
+		if app.drain.status().Draining {
+			return 1
+		}
+		return 0
 	})
-}
 
-func (app *application) listPersons(w http.ResponseWriter, r *http.Request) {
-	rows, err := app.db.Query("SELECT id, name, age, address, work  FROM persons")
+	reporter, err := newErrorReporter(sentry.ClientOptions{
+		Dsn:         cfg.sentryDSN,
+		Environment: cfg.sentryEnvironment,
+		Release:     buildVersion.release(),
+	})
 	if err != nil {
-		sendError(w, http.StatusInternalServerError, "Database query error")
-		return
+		slog.Error("error reporting disabled", "err", err)
 	}
+	app.reporter = reporter
 
-	defer rows.Close()
-
-	persons := []PersonResponse{}
+	if cfg.metricsExport != metricsPrometheus {
+		if app.statsd, err = metrics.NewStatsD(cfg.statsdAddr, cfg.statsdPrefix, cfg.statsdTags); err != nil {
+			slog.Error("statsd metrics disabled", "err", err)
+		} else {
+			app.metrics.registry.AddSink(app.statsd)
+		}
+	}
 
-	for rows.Next() {
-		person := PersonResponse{}
-		var age sql.NullInt64
-		var address sql.NullString
-		var work sql.NullString
+	if app.accessLog, err = newAccessLogger(cfg.accessLog, cfg.accessLogFormat); err != nil {
+		slog.Error("access log disabled", "err", err)
+	}
 
-		err = rows.Scan(&person.ID, &person.Name, &age, &address, &work)
-		if err != nil {
-			sendError(w, http.StatusInternalServerError, "Rows scanning error")
-			return
+	if app.limiter != nil {
+		app.limiter.clientKey = rateLimitKey
+		if cfg.redis.Addr != "" {
+			client := redis.New(cfg.redis)
+			if err := client.Ping(context.Background()); err != nil {
+				slog.Warn("rate limits are per instance until redis answers", "addr", cfg.redis.Addr, "err", err)
+			}
+			app.limiter.shared = &redisCounter{client: client, prefix: "ratelimit:"}
 		}
-		if age.Valid {
-			ageval := int32(age.Int64)
-			person.Age = &ageval
+	}
+	if db != nil {
+		app.health.Register("postgres", db.Ping)
+	}
+
+	pg := store.NewPostgres(db, app.metrics.timeQuery)
+	pg.LockTimeout = cfg.lockTimeout
+	pg.DeletePolicies = cfg.deletePolicies
+	app.store = pg
+	if db != nil {
+		app.keys = pg
+		app.users = pg
+		app.totp = pg
+		app.audit = pg
+		app.search = pg
+		app.nearby = pg
+		app.attachments = pg
+		app.photos = pg
+		app.dataCheck = pg
+		app.historyPurge = pg
+		app.jobQueue = pg
+		app.customFields = pg
+		app.explain = pg
+	}
+	if app.blobs, err = newBlobStore(cfg); err != nil {
+		slog.Error("attachments disabled", "err", err)
+	}
+	// clamd being down only refuses uploads, so it is not a readiness check.
+	app.scanner = newScanner(cfg)
+	if c, ok := app.scanner.(*scan.ClamAV); ok {
+		if err := c.Ping(context.Background()); err != nil {
+			slog.Warn("uploads are refused until clamd answers", "addr", cfg.clamavAddr, "err", err)
 		}
-		if address.Valid {
-			addr := address.String
-			person.Address = &addr
+	}
+	if cfg.searchBackend == searchBackendElastic {
+		if cfg.elasticsearchURL == "" {
+			slog.Warn("SEARCH_BACKEND=elasticsearch needs ELASTICSEARCH_URL, searching in postgres")
+		} else {
+			app.elastic = store.NewElastic(cfg.elasticsearchURL, cfg.elasticsearchIndex)
+			app.search = app.elastic
 		}
-		if work.Valid {
-			wstr := work.String
-			person.Work = &wstr
+	}
+	if cfg.changeFeed {
+		app.changes = pg
+		app.history = pg
+	}
+	// The event log is complete, the change feed only covers the time it was
+	// enabled, so it is the better history when both exist.
+	if cfg.storeMode == storeModeEvents {
+		es := store.NewEventStore(pg)
+		app.store = es
+		app.history = es
+	}
+	if len(shards) > 0 {
+		app.useShards(shards)
+	}
+	if cfg.cacheTTL > 0 {
+		app.cache = store.NewCache(app.store, cfg.cacheTTL)
+		app.store = app.cache
+		if len(cfg.cachePeers) > 0 {
+			app.partitionCache()
 		}
-		persons = append(persons, person)
 	}
-	if err = rows.Err(); err != nil {
-		sendError(w, http.StatusInternalServerError, "Data iteration error")
-		return
+	if app.geocoder = newGeocoder(cfg); app.geocoder != nil {
+		app.geocodeJobs = make(chan geocodeJob, geocodeQueueSize)
 	}
-	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(persons)
-	if err != nil {
-		sendError(w, http.StatusInternalServerError, "json encoding error")
-		return
+	if cfg.addressValidatorURL != "" {
+		app.addresses = geocode.NewCachedValidator(geocode.NewWebhookValidator(cfg.addressValidatorURL), cfg.addressValidationCacheTTL, geocodeCacheSize)
 	}
-
+	if cfg.validateRequests || cfg.validateResponses {
+		if app.spec, err = loadSpec(); err != nil {
+			slog.Error("failed to load openapi.yaml, requests and responses are not validated", "err", err)
+		}
+	}
+	return app
 }
 
-func (app *application) createPerson(w http.ResponseWriter, r *http.Request) {
-	defer r.Body.Close()
-	var req PersonRequest
-
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		sendError(w, http.StatusBadRequest, "json decoding error")
-		return
-	}
-	if req.Name == nil || strings.TrimSpace(*req.Name) == "" {
-		sendValidationError(w, "name validation error", map[string]string{"name": "name is required"})
-		return
-	}
-	var id int32
-	err = app.db.QueryRow(
-		"INSERT INTO persons (name, age, address, work) VALUES ($1, $2, $3, $4) RETURNING id",
-		req.Name, req.Age, req.Address, req.Work,
-	).Scan(&id)
-	if err != nil {
-		sendError(w, http.StatusInternalServerError, "Query error")
-		return
-	}
-	w.Header().Set("Location", fmt.Sprintf("/api/v1/persons/%d", id))
-	w.WriteHeader(http.StatusCreated)
+// useShards moves persons to the shards. Rows of other tables reference
+// persons in their own database, so the features keeping such rows are off.
+func (app *application) useShards(pools []*pgxpool.Pool) {
+	shards := make([]store.Store, len(pools))
+	for i, pool := range pools {
+		pg := store.NewPostgres(pool, app.metrics.timeQuery)
+		pg.LockTimeout = app.cfg.lockTimeout
+		pg.DeletePolicies = app.cfg.deletePolicies
+		shards[i] = pg
+		app.health.Register(fmt.Sprintf("postgres_shard_%d", i), pool.Ping)
+	}
+	sharded := store.NewSharded(shards...)
+	app.store = sharded
+	if app.search != nil {
+		app.search = sharded
+	}
+	app.elastic = nil
+	app.nearby, app.attachments, app.photos = nil, nil, nil
+	app.changes, app.history = nil, nil
+	app.dataCheck, app.historyPurge, app.explain = nil, nil, nil
+	slog.Info("persons are sharded; attachments, photos, nearby search, history, elasticsearch, data checks and query plans are off", "shards", len(pools))
 }
 
-func (app *application) getPerson(w http.ResponseWriter, r *http.Request) {
-	var person PersonResponse
-	var age sql.NullInt32
-	var address, work sql.NullString
-	vars := mux.Vars(r)
-	idstr := vars["id"]
-	id, err := strconv.Atoi(idstr)
-	if err != nil {
-		sendError(w, http.StatusBadRequest, "Invalid ID format")
-		return
-	}
-	err = app.db.QueryRow(
-		"SELECT id, name, age, address, work FROM persons WHERE id = $1",
-		id,
-	).Scan(&person.ID, &person.Name, &age, &address, &work)
-	if err == sql.ErrNoRows {
-		sendError(w, http.StatusNotFound, "Person not found")
-		return
-	} else if err != nil {
-		sendError(w, http.StatusInternalServerError, "Scanning error")
-		return
-	}
-	if age.Valid {
-		person.Age = &age.Int32
-	}
-	if address.Valid {
-		addr := address.String
-		person.Address = &addr
-	}
-	if work.Valid {
-		wstr := work.String
-		person.Work = &wstr
-	}
-	w.Header().Set("Content-Type", "application/json")
-	err = json.NewEncoder(w).Encode(person)
-	if err != nil {
-		sendError(w, http.StatusInternalServerError, "Encoding error")
-		return
+// initShards opens the databases at urls as shards of persons.
+func initShards(urls []string) ([]*pgxpool.Pool, error) {
+	var pools []*pgxpool.Pool
+	for i, url := range urls {
+		pool, err := initDB(url)
+		if err == nil {
+			err = store.NewPostgres(pool, nil).ConfigureShard(context.Background(), i, len(urls))
+			pools = append(pools, pool)
+		}
+		if err != nil {
+			for _, p := range pools {
+				p.Close()
+			}
+			return nil, fmt.Errorf("shard %d: %w", i, err)
+		}
 	}
+	return pools, nil
 }
 
-func (app *application) updatePerson(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	idstr := vars["id"]
-	id, err := strconv.Atoi(idstr)
+func initDB(dsn string) (*pgxpool.Pool, error) {
+	ctx := context.Background()
+	db, err := pgxpool.New(ctx, dsn)
 	if err != nil {
-		sendError(w, http.StatusBadRequest, "Invalid id format")
-		return
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	var req struct {
-		Name    *string `json:"name,omitempty"`
-		Age     *int32  `json:"age,omitempty"`
-		Address *string `json:"address,omitempty"`
-		Work    *string `json:"work,omitempty"`
+	if err = db.Ping(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("database connection failed: %w", err)
 	}
 
-	err = json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		sendValidationError(w, "Invalid json", map[string]string{"body": "invalid json format"})
-		return
+	if err = store.NewPostgres(db, nil).Migrate(ctx); err != nil {
+		db.Close()
+		return nil, err
 	}
+	return db, nil
+}
 
-	var exists bool
-	err = app.db.QueryRow("SELECT EXISTS(SELECT 1 FROM persons WHERE id = $1)", id).Scan(&exists)
-	if err != nil || !exists {
-		sendError(w, http.StatusNotFound, "Person not found")
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "loadtest" {
+		if err := runLoadtestCommand(os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("Load test failed: %v", err)
+		}
 		return
 	}
 
-	var sname, saddress, swork sql.NullString
-	var sage sql.NullInt32
+	logLevel := new(slog.LevelVar)
+	slog.SetDefault(logging.New(os.Stdout, logLevel).With("version", buildVersion.Version, "commit", buildVersion.Commit))
 
-	err = app.db.QueryRow("SELECT name, age, address, work FROM persons WHERE id = $1", id).Scan(&sname, &sage, &saddress, &swork)
+	cfg := loadConfig()
+	logLevel.Set(cfg.logLevel)
+	db, err := initDB(cfg.databaseURL)
 	if err != nil {
-		sendError(w, http.StatusInternalServerError, "Scanning error")
+		slog.Error("failed to initialize database", "err", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+	if err := store.NewPostgres(db, nil).SetChangeFeed(context.Background(), cfg.changeFeed); err != nil {
+		slog.Error("failed to configure change feed", "err", err)
+		os.Exit(1)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "rebuild" {
+		if err := store.NewEventStore(store.NewPostgres(db, nil)).Rebuild(context.Background()); err != nil {
+			slog.Error("failed to rebuild persons from events", "err", err)
+			os.Exit(1)
+		}
+		slog.Info("rebuilt persons from events")
 		return
 	}
+	if len(os.Args) > 1 && os.Args[1] == "checkdb" {
+		os.Exit(checkDB(context.Background(), store.NewPostgres(db, nil), os.Stdout))
+	}
+	if cfg.storeMode == storeModeEvents {
+		if err := store.NewEventStore(store.NewPostgres(db, nil)).Backfill(context.Background()); err != nil {
+			slog.Error("failed to initialize event store", "err", err)
+			os.Exit(1)
+		}
+	}
 
-	if req.Name != nil {
-		sname.String = *req.Name
-		sname.Valid = true
+	var shards []*pgxpool.Pool
+	if len(cfg.shardURLs) > 0 {
+		if shards, err = initShards(cfg.shardURLs); err != nil {
+			slog.Error("failed to initialize shards", "err", err)
+			os.Exit(1)
+		}
+		for _, pool := range shards {
+			defer pool.Close()
+		}
 	}
 
-	if req.Age != nil {
-		sage.Int32 = *req.Age
-		sage.Valid = true
+	app := newApplication(cfg, db, shards...)
+	app.logLevel = logLevel
+	if len(os.Args) > 1 && os.Args[1] == "cleanup" {
+		os.Exit(runCleanupCommand(context.Background(), app, os.Args[2:], os.Stdout))
 	}
-	if req.Address != nil {
-		saddress.String = *req.Address
-		saddress.Valid = true
+	if len(os.Args) > 1 && os.Args[1] == "anonymize" {
+		os.Exit(runAnonymizeCommand(context.Background(), app, os.Args[2:], os.Stderr))
 	}
-	if req.Work != nil {
-		swork.String = *req.Work
-		swork.Valid = true
+	if app.cache != nil {
+		for _, pool := range append([]*pgxpool.Pool{db}, shards...) {
+			go store.NewPostgres(pool, nil).Listen(context.Background(), app.cache.Invalidate, app.cache.Purge)
+		}
 	}
-	var fname, fage, faddress, fwork interface{} = nil, nil, nil, nil
-
-	if sname.Valid {
-		fname = sname.String
+	if app.geocoder != nil {
+		go app.runGeocoder(context.Background())
+	}
+	if app.statsd != nil {
+		go app.statsd.Run(context.Background(), statsdFlushInterval)
+	}
+	if app.jobQueue != nil && app.cfg.jobWorkers > 0 {
+		go app.runJobWorkers(context.Background(), app.cfg.jobWorkers)
+	}
+	if jobs := app.leaderJobs(db); len(jobs) > 0 {
+		go store.NewPostgres(db, nil).Lead(context.Background(), "background jobs", func(ctx context.Context) {
+			runJobs(ctx, jobs)
+		})
+	}
+
+	srv := &http.Server{Addr: ":" + app.cfg.port, Handler: app.routes()}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	deregister := app.registerService(ctx)
+	app.drain.onDrain = deregister
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		slog.Info("shutting down")
+		// Clients stop being sent here before the listener closes.
+		app.drain.start()
+		ctx, cancel := context.WithTimeout(context.Background(), app.cfg.shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			slog.Error("requests cut off by shutdown", "err", err)
+		}
+	}()
+
+	slog.Info("starting server", "port", app.cfg.port, "build_time", buildVersion.BuildTime, "modified", buildVersion.Modified)
+	err = srv.ListenAndServe()
+	if !errors.Is(err, http.ErrServerClosed) {
+		deregister()
+		slog.Error("server stopped", "err", err)
+		app.reporter.flush(2 * time.Second)
+		if app.statsd != nil {
+			app.statsd.Flush()
+		}
+		os.Exit(1)
 	}
-
-	if sage.Valid {
-		fage = sage.Int32
+	<-stopped
+	app.reporter.flush(2 * time.Second)
+	if app.statsd != nil {
+		app.statsd.Flush()
 	}
+	slog.Info("server stopped")
+}
 
-	if saddress.Valid {
-		faddress = saddress.String
+// leaderJobs are the background jobs that work on shared state, so that
+// however many instances run, only the leader among them runs these.
+func (app *application) leaderJobs(db *pgxpool.Pool) []func(ctx context.Context) {
+	var jobs []func(ctx context.Context)
+	if app.elastic != nil {
+		jobs = append(jobs, func(ctx context.Context) { app.elastic.Sync(ctx, store.NewPostgres(db, nil)) })
 	}
-
-	if swork.Valid {
-		fwork = swork.String
+	if app.audit != nil && app.cfg.auditRetention > 0 {
+		jobs = append(jobs, app.pruneAudit)
 	}
-
-	_, err = app.db.Exec("UPDATE persons SET name = $1, age = $2, address = $3, work = $4 WHERE id = $5",
-		fname, fage, faddress, fwork, id)
-	if err != nil {
-		sendError(w, http.StatusInternalServerError, "Failed to update person")
-		return
+	if app.blobs != nil && (app.attachments != nil || app.photos != nil) {
+		jobs = append(jobs, app.sweepOrphanObjects)
+	}
+	if app.historyPurge != nil && app.cfg.historyRetention > 0 {
+		jobs = append(jobs, app.purgeHistory)
+	}
+	if app.cfg.anonymizeAfter > 0 {
+		jobs = append(jobs, app.anonymizeStaleEvery)
 	}
-	app.getPerson(w, r)
+	if app.blobs != nil && app.attachments != nil && app.cfg.integrityCheckInterval > 0 {
+		jobs = append(jobs, app.verifyAttachmentsEvery)
+	}
+	if app.jobQueue != nil {
+		jobs = append(jobs, app.pruneJobs)
+	}
+	return jobs
 }
 
-func (app *application) deletePerson(w http.ResponseWriter, r *http.Request) {
-	if app.db == nil {
-		sendError(w, http.StatusInternalServerError, "Database not initialized")
-		return
+// runJobs runs every job until ctx is done and they all returned.
+func runJobs(ctx context.Context, jobs []func(ctx context.Context)) {
+	var wg sync.WaitGroup
+	for _, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			job(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (app *application) routes() *mux.Router {
+	t := app.cfg.timeouts
+	r := mux.NewRouter()
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sendError(w, apierr.RouteNotFound, "Route not found")
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sendError(w, apierr.MethodNotAllowed, "Method not allowed")
+	})
+	r.Use(middlewares(app.serverStages())...)
+	if app.metrics != nil && app.cfg.metricsExport != metricsStatsD {
+		r.Handle("/metrics", app.metrics.registry.Handler()).Methods("GET")
+	}
+
+	r.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}` + "\n"))
+	}).Methods("GET")
+	r.Handle("/readyz", app.health.Handler()).Methods("GET")
+	r.HandleFunc("/version", getVersion).Methods("GET")
+
+	if app.cache != nil && len(app.cfg.cachePeers) > 0 {
+		peer := r.PathPrefix("/peer").Subrouter()
+		peer.Use(app.requireAdmin)
+		peer.HandleFunc("/cache/persons/{id}", app.peerGetPerson).Methods("GET")
+		peer.HandleFunc("/cache/persons/{id}", app.peerInvalidatePerson).Methods("DELETE")
+	}
+
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(middlewares(app.adminStages())...)
+	admin.HandleFunc("/loglevel", app.getLogLevel).Methods("GET")
+	admin.HandleFunc("/loglevel", app.setLogLevel).Methods("PUT")
+	admin.HandleFunc("/drain-status", app.getDrainStatus).Methods("GET")
+	admin.Handle("/drain", app.requireTOTP(app.startDrain)).Methods("POST")
+	admin.Handle("/cleanup", app.expensive.wrap(app.requireTOTP(app.runCleanup))).Methods("POST")
+	if app.dataCheck != nil {
+		admin.Handle("/checkdb", app.expensive.wrap(http.HandlerFunc(app.getDataCheck))).Methods("GET")
+	}
+	if app.shadow != nil {
+		admin.HandleFunc("/shadow", app.getShadow).Methods("GET")
+	}
+	if app.explain != nil {
+		admin.Handle("/explain", app.expensive.wrap(http.HandlerFunc(app.explainList))).Methods("POST")
+	}
+	admin.HandleFunc("/ui", app.dashboardPersons).Methods("GET")
+	admin.HandleFunc("/ui/persons/{id}", app.dashboardPerson).Methods("GET")
+	admin.HandleFunc("/ui/persons/{id}", app.dashboardSavePerson).Methods("POST")
+	admin.Handle("/ui/persons/{id}/delete", requireSameOrigin(app.requireTOTP(app.dashboardDeletePerson))).Methods("POST")
+	if app.totp != nil {
+		admin.Handle("/totp", app.totpGuard(app.enrollTOTP, false)).Methods("POST")
+		admin.HandleFunc("/totp/confirm", app.confirmTOTP).Methods("POST")
+	}
+	if app.audit != nil {
+		admin.Handle("/audit/export", app.expensive.wrap(http.HandlerFunc(app.exportAudit))).Methods("GET")
+	}
+	if app.keys != nil {
+		admin.HandleFunc("/api-keys", app.listAPIKeys).Methods("GET")
+		admin.HandleFunc("/api-keys", app.createAPIKey).Methods("POST")
+		admin.Handle("/api-keys/{id}/rotate", app.requireTOTP(app.rotateAPIKey)).Methods("POST")
+		admin.Handle("/api-keys/{id}", app.requireTOTP(app.revokeAPIKey)).Methods("DELETE")
+	}
+	if app.blobs != nil && app.attachments != nil {
+		admin.HandleFunc("/integrity", app.getVerification).Methods("GET")
+		admin.HandleFunc("/integrity/verify", app.startVerification).Methods("POST")
+	}
+	if app.jobQueue != nil {
+		admin.HandleFunc("/jobs", app.listJobs).Methods("GET")
+		admin.HandleFunc("/jobs/{id}/retry", app.retryJob).Methods("POST")
+	}
+
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.Use(middlewares(app.apiStages())...)
+
+	api.Handle("/persons", withTimeout(t.list, app.listPersons)).Methods("GET")
+	api.Handle("/persons", withTimeout(t.write, app.createPerson)).Methods("POST")
+	// Before /persons/{id}, which would take "search" and "nearby" for IDs.
+	if app.search != nil {
+		api.Handle("/persons/search", withTimeout(t.list, app.searchPersons)).Methods("GET")
+	}
+	if app.nearby != nil {
+		api.Handle("/persons/nearby", withTimeout(t.list, app.nearbyPersons)).Methods("GET")
+	}
+	api.Handle("/persons/{id}", withTimeout(t.get, app.getPerson)).Methods("GET")
+	api.Handle("/persons/{id}", withTimeout(t.write, app.putPerson)).Methods("PUT")
+	api.Handle("/persons/{id}", withTimeout(t.write, app.updatePerson)).Methods("PATCH")
+	api.Handle("/persons/{id}", withTimeout(t.write, app.deletePerson)).Methods("DELETE")
+	if app.history != nil {
+		api.Handle("/persons/{id}/snapshot", withTimeout(t.get, app.getPersonSnapshot)).Methods("GET")
+	}
+	// Not under withTimeout, which would hold whole files in memory.
+	if app.blobs != nil && app.attachments != nil {
+		api.HandleFunc("/persons/{id}/attachments", app.uploadAttachment).Methods("POST")
+		api.Handle("/persons/{id}/attachments", withTimeout(t.list, app.listAttachments)).Methods("GET")
+		api.HandleFunc("/persons/{id}/attachments/{attachmentId}", app.getAttachment).Methods("GET")
+		api.HandleFunc("/persons/{id}/attachments/{attachmentId}", app.deleteAttachment).Methods("DELETE")
+		if _, ok := app.blobs.(blob.Presigner); ok {
+			api.Handle("/persons/{id}/attachments/uploads", withTimeout(t.write, app.createUploadURL)).Methods("POST")
+			// Scanning may take longer than a write.
+			api.HandleFunc("/persons/{id}/attachments/uploads/{uploadId}", app.completeUpload).Methods("POST")
+			api.Handle("/persons/{id}/attachments/{attachmentId}/url", withTimeout(t.get, app.getDownloadURL)).Methods("GET")
+		}
 	}
-	vars := mux.Vars(r)
-	idstr := vars["id"]
-	id, err := strconv.Atoi(idstr)
-	if err != nil {
-		sendError(w, http.StatusBadRequest, "Invalid ID format")
-		return
+	if app.blobs != nil && app.photos != nil {
+		api.HandleFunc("/persons/{id}/photo", app.putPhoto).Methods("PUT")
+		api.HandleFunc("/persons/{id}/photo", app.getPhoto).Methods("GET")
+		api.HandleFunc("/persons/{id}/photo/thumbnail", app.getPhotoThumbnail).Methods("GET")
+		api.HandleFunc("/persons/{id}/photo", app.deletePhoto).Methods("DELETE")
 	}
-
-	res, err := app.db.Exec("DELETE FROM persons WHERE id = $1", id)
-	if err != nil {
-		sendError(w, http.StatusInternalServerError, "Database error")
-		return
+	if app.users != nil && app.cfg.jwtSecret != "" {
+		api.Handle("/auth/register", withTimeout(t.write, app.register)).Methods("POST")
+		api.Handle("/auth/login", withTimeout(t.write, app.login)).Methods("POST")
+		api.Handle("/auth/refresh", withTimeout(t.write, app.refresh)).Methods("POST")
+		api.Handle("/auth/logout", withTimeout(t.write, app.logout)).Methods("POST")
+	}
+	if app.keys != nil {
+		api.Handle("/account/usage", withTimeout(t.get, app.getUsage)).Methods("GET")
+	}
+	if app.changes != nil {
+		api.Handle("/changes", withTimeout(t.list, app.listChanges)).Methods("GET")
+	}
+	if app.jobQueue != nil {
+		api.Handle("/jobs/{id}", withTimeout(t.get, app.getJob)).Methods("GET")
+		api.HandleFunc("/jobs/{id}/download", app.downloadJobFile).Methods("GET")
+		// Streams for as long as the job runs.
+		api.HandleFunc("/jobs/{id}/events", app.streamJobEvents).Methods("GET")
+	}
+	api.Handle("/schema/person", withTimeout(t.get, app.getPersonSchema)).Methods("GET")
+	if app.customFields != nil {
+		api.Handle("/schema/fields", withTimeout(t.list, app.listCustomFields)).Methods("GET")
+		api.Handle("/schema/fields", withTimeout(t.write, app.defineCustomField)).Methods("POST")
+		api.Handle("/schema/fields/{name}", withTimeout(t.write, app.deleteCustomField)).Methods("DELETE")
 	}
 
-	rowaff, err := res.RowsAffected()
-	if err != nil {
-		sendError(w, http.StatusInternalServerError, "Database error")
-		return
+	if app.cfg.ui && !app.cfg.requireAPIKey {
+		ui := r.PathPrefix("/ui").Subrouter()
+		ui.Use(middlewares(app.uiStages())...)
+		ui.HandleFunc("", app.uiIndex).Methods("GET")
+		ui.HandleFunc("/persons", app.uiRows).Methods("GET")
+		ui.HandleFunc("/persons", uiWrite(app.uiCreatePerson)).Methods("POST")
+		ui.HandleFunc("/persons/{id}", app.uiPerson(false)).Methods("GET")
+		ui.HandleFunc("/persons/{id}/edit", app.uiPerson(true)).Methods("GET")
+		ui.HandleFunc("/persons/{id}", uiWrite(app.uiSavePerson)).Methods("PUT")
+		ui.HandleFunc("/persons/{id}", uiWrite(app.uiDeletePerson)).Methods("DELETE")
 	}
 
-	if rowaff == 0 {
-		sendError(w, http.StatusNotFound, "Person not found")
-		return
+	// Registered last so every route above wins over the frontend.
+	if app.cfg.staticDir != "" {
+		r.PathPrefix("/").Handler(staticHandler{fsys: os.DirFS(app.cfg.staticDir), maxAge: app.cfg.staticMaxAge}).Methods("GET", "HEAD")
 	}
-	w.WriteHeader(http.StatusNoContent)
+
+	return r
 }
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/logging"
+	"ci_cd/rsoi_lab_1/internal/metrics"
+
+	"github.com/gorilla/mux"
+)
+
+// statsdFlushInterval bounds how long observations wait in a StatsD batch.
+const statsdFlushInterval = time.Second
+
+type appMetrics struct {
+	registry *metrics.Registry
+	requests *metrics.CounterVec
+	errors   *metrics.CounterVec
+	latency  *metrics.HistogramVec
+	dbTime   *metrics.HistogramVec
+	queries  *metrics.HistogramVec
+
+	slowQueries *metrics.CounterVec
+	// slowQuery is the duration from which timeQuery logs a query as slow;
+	// zero never does.
+	slowQuery time.Duration
+}
+
+func newAppMetrics() *appMetrics {
+	r := metrics.NewRegistry()
+	r.RegisterRuntime()
+	return &appMetrics{
+		registry: r,
+		requests: r.NewCounterVec("http_requests_total", "HTTP requests by route, method and status code.", "route", "method", "code"),
+		errors:   r.NewCounterVec("http_request_errors_total", "HTTP requests that ended with a 5xx status.", "route", "method"),
+		latency:  r.NewHistogramVec("http_request_duration_seconds", "Total time spent serving a request.", nil, "route", "method"),
+		dbTime:   r.NewHistogramVec("http_request_db_duration_seconds", "Part of the request time spent waiting on the database.", nil, "route", "method"),
+		queries:  r.NewHistogramVec("db_query_duration_seconds", "Duration of individual database queries.", nil, "query"),
+
+		slowQueries: r.NewCounterVec("db_slow_queries_total", "Database queries that took longer than the slow query threshold.", "query"),
+	}
+}
+
+type ctxKey int
+
+const (
+	requestStatsKey ctxKey = iota
+	requestIDKey
+	apiKeyKey
+	userIDKey
+	debugKey
+)
+
+type requestStats struct {
+	dbNanos atomic.Int64
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	if sr.status == 0 {
+		sr.status = code
+	}
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Write(p []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	return sr.ResponseWriter.Write(p)
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter { return sr.ResponseWriter }
+
+func (m *appMetrics) middleware(next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(r)
+		stats := &requestStats{}
+		traceID := logging.TraceID(r.Context())
+		sr := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+
+		next.ServeHTTP(sr, r.WithContext(context.WithValue(r.Context(), requestStatsKey, stats)))
+
+		if sr.status == 0 {
+			sr.status = http.StatusOK
+		}
+		m.requests.Inc(route, r.Method, strconv.Itoa(sr.status))
+		if sr.status >= 500 {
+			m.errors.Inc(route, r.Method)
+		}
+		m.latency.ObserveWithExemplar(time.Since(start).Seconds(), traceID, route, r.Method)
+		m.dbTime.ObserveWithExemplar(time.Duration(stats.dbNanos.Load()).Seconds(), traceID, route, r.Method)
+	})
+}
+
+// routeTemplate is the mux path template of the matched route, which keeps
+// label cardinality bounded and IDs out of reports.
+func routeTemplate(r *http.Request) string {
+	if cr := mux.CurrentRoute(r); cr != nil {
+		if tpl, err := cr.GetPathTemplate(); err == nil {
+			return tpl
+		}
+	}
+	return "unmatched"
+}
+
+// timeQuery starts timing a database query; the returned func records it both
+// in the per-query histogram and in the DB share of the enclosing request.
+// Queries at or over slowQuery are also counted and logged by name only, as
+// their parameters may hold personal data.
+func (m *appMetrics) timeQuery(ctx context.Context, name string) func() {
+	if d, ok := ctx.Value(debugKey).(*debugInfo); ok {
+		d.setQuery(name)
+	}
+	start := time.Now()
+	return func() {
+		d := time.Since(start)
+		if stats, _ := ctx.Value(requestStatsKey).(*requestStats); stats != nil {
+			stats.dbNanos.Add(int64(d))
+		}
+		if m != nil {
+			m.queries.ObserveWithExemplar(d.Seconds(), logging.TraceID(ctx), name)
+			if m.slowQuery > 0 && d >= m.slowQuery {
+				m.slowQueries.Inc(name)
+				slog.WarnContext(ctx, "slow query", "query", name, "duration", d, "threshold", m.slowQuery)
+			}
+		}
+	}
+}
+
+// traceIDFromRequest extracts the trace ID from a W3C traceparent header. A
+// header that does not follow the spec is ignored rather than echoed into
+// logs, exemplars and responses.
+func traceIDFromRequest(r *http.Request) string {
+	parts := strings.Split(r.Header.Get("traceparent"), "-")
+	if len(parts) < 4 {
+		return ""
+	}
+	version, traceID, parentID, flags := parts[0], parts[1], parts[2], parts[3]
+	// Later versions may append fields, but 00 has exactly four and ff is
+	// forbidden.
+	if !isLowerHex(version, 2) || version == "ff" || (version == "00" && len(parts) != 4) {
+		return ""
+	}
+	if !isLowerHex(traceID, 32) || traceID == strings.Repeat("0", 32) ||
+		!isLowerHex(parentID, 16) || parentID == strings.Repeat("0", 16) ||
+		!isLowerHex(flags, 2) {
+		return ""
+	}
+	return traceID
+}
+
+func isLowerHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for _, c := range []byte(s) {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMetricsMiddleware_RecordsRouteAndDBTime(t *testing.T) {
+	m := newAppMetrics()
+	r := mux.NewRouter()
+	r.Use(withTraceID, m.middleware)
+	r.Handle("/metrics", m.registry.Handler())
+	r.HandleFunc("/api/v1/persons/{id}", func(w http.ResponseWriter, r *http.Request) {
+		done := m.timeQuery(r.Context(), "get_person")
+		time.Sleep(time.Millisecond)
+		done()
+		sendError(w, apierr.DBError, "Scanning error")
+	})
+
+	req := httptest.NewRequest("GET", "/api/v1/persons/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	out := rr.Body.String()
+	for _, want := range []string{
+		`http_requests_total{route="/api/v1/persons/{id}",method="GET",code="500"} 1`,
+		`http_request_errors_total{route="/api/v1/persons/{id}",method="GET"} 1`,
+		`db_query_duration_seconds_count{query="get_person"} 1`,
+		`http_request_db_duration_seconds_count{route="/api/v1/persons/{id}",method="GET"} 1`,
+		`trace_id="4bf92f3577b34da6a3ce929d0e0e4736"`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Metrics output missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestMetricsExport(t *testing.T) {
+	for _, tc := range []struct {
+		export string
+		want   int
+	}{
+		{metricsPrometheus, http.StatusOK},
+		{metricsBoth, http.StatusOK},
+		{metricsStatsD, http.StatusNotFound},
+	} {
+		cfg := loadConfig()
+		cfg.metricsExport = tc.export
+		app := newApplication(cfg, nil)
+		if (app.statsd != nil) != (tc.export != metricsPrometheus) {
+			t.Errorf("%s: unexpected statsd sink %v", tc.export, app.statsd)
+		}
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+		if rr.Code != tc.want {
+			t.Errorf("%s: expected /metrics to answer %d, got %d", tc.export, tc.want, rr.Code)
+		}
+	}
+}
+
+func TestTimeQuery_SlowQueries(t *testing.T) {
+	m := newAppMetrics()
+	m.slowQuery = time.Millisecond
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+	m.timeQuery(context.Background(), "get_person")()
+	done := m.timeQuery(context.Background(), "list_persons")
+	time.Sleep(2 * time.Millisecond)
+	done()
+
+	rr := httptest.NewRecorder()
+	m.registry.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if out := rr.Body.String(); !strings.Contains(out, `db_slow_queries_total{query="list_persons"} 1`) || strings.Contains(out, `db_slow_queries_total{query="get_person"}`) {
+		t.Errorf("Expected only list_persons counted as slow:\n%s", out)
+	}
+	if out := logs.String(); !strings.Contains(out, "slow query") || !strings.Contains(out, "query=list_persons") || strings.Contains(out, "get_person") {
+		t.Errorf("Expected only list_persons logged as slow, got %q", out)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/logging"
+)
+
+// withTimeout runs the handler with a request context that is cancelled after d.
+// If the handler has not finished by then, its buffered output is discarded and
+// the client gets a 504 problem+json response instead.
+func withTimeout(d time.Duration, h http.HandlerFunc) http.Handler {
+	if d <= 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+
+		tw := &timeoutWriter{header: w.Header().Clone()}
+		done := make(chan struct{})
+		panicked := make(chan any, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicked <- p
+				}
+			}()
+			h.ServeHTTP(tw, r.WithContext(ctx))
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			for k, v := range tw.header {
+				w.Header()[k] = v
+			}
+			if tw.code == 0 {
+				tw.code = http.StatusOK
+			}
+			w.WriteHeader(tw.code)
+			w.Write(tw.buf.Bytes())
+		case <-ctx.Done():
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+			tw.timedOut = true
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				sendProblem(w, r, apierr.Timeout, "request exceeded the "+d.String()+" deadline")
+			}
+		}
+	})
+}
+
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	code     int
+	timedOut bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.header }
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+	return tw.buf.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut || tw.code != 0 {
+		return
+	}
+	tw.code = code
+}
+
+// loadShedder bounds the number of requests being served at once. Writes wait for
+// a free slot; reads are considered non-essential and are rejected with 503 as soon
+// as the server is saturated and recent queue waits exceed maxQueueWait.
+type loadShedder struct {
+	slots        chan struct{}
+	maxQueueWait time.Duration
+
+	mu         sync.Mutex
+	recentWait time.Duration
+}
+
+func newLoadShedder(maxInFlight int, maxQueueWait time.Duration) *loadShedder {
+	if maxInFlight <= 0 {
+		return nil
+	}
+	return &loadShedder{
+		slots:        make(chan struct{}, maxInFlight),
+		maxQueueWait: maxQueueWait,
+	}
+}
+
+func (s *loadShedder) inFlight() int { return len(s.slots) }
+
+func (s *loadShedder) observeWait(d time.Duration) {
+	s.mu.Lock()
+	s.recentWait = (4*s.recentWait + d) / 5
+	s.mu.Unlock()
+}
+
+func (s *loadShedder) overloaded() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inFlight() >= cap(s.slots) && s.recentWait > s.maxQueueWait
+}
+
+func (s *loadShedder) acquire(r *http.Request, essential bool) bool {
+	start := time.Now()
+	select {
+	case s.slots <- struct{}{}:
+		s.observeWait(0)
+		return true
+	default:
+	}
+
+	var deadline <-chan time.Time
+	if !essential {
+		if s.overloaded() {
+			return false
+		}
+		timer := time.NewTimer(s.maxQueueWait)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		s.observeWait(time.Since(start))
+		return true
+	case <-deadline:
+		s.observeWait(time.Since(start))
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (s *loadShedder) middleware(next http.Handler) http.Handler {
+	if s == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		essential := r.Method != http.MethodGet
+		if !s.acquire(r, essential) {
+			w.Header().Set("Retry-After", "1")
+			sendProblem(w, r, apierr.Overloaded, "server is overloaded, retry later")
+			return
+		}
+		defer func() { <-s.slots }()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// concurrencyLimiter lets at most cap(running) requests through at once and queues
+// up to cap(admitted)-cap(running) more; anything beyond that is rejected with 429.
+type concurrencyLimiter struct {
+	running  chan struct{}
+	admitted chan struct{}
+}
+
+func newConcurrencyLimiter(maxConcurrent, maxQueue int) *concurrencyLimiter {
+	if maxConcurrent <= 0 {
+		return nil
+	}
+	if maxQueue < 0 {
+		maxQueue = 0
+	}
+	return &concurrencyLimiter{
+		running:  make(chan struct{}, maxConcurrent),
+		admitted: make(chan struct{}, maxConcurrent+maxQueue),
+	}
+}
+
+func (l *concurrencyLimiter) wrap(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case l.admitted <- struct{}{}:
+		default:
+			w.Header().Set("Retry-After", "1")
+			sendProblem(w, r, apierr.TooManyRequests, "too many concurrent requests for this endpoint, retry later")
+			return
+		}
+		defer func() { <-l.admitted }()
+
+		select {
+		case l.running <- struct{}{}:
+		case <-r.Context().Done():
+			return
+		}
+		defer func() { <-l.running }()
+		next.ServeHTTP(w, r)
+	})
+}
+
+var requestIDRe = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// withRequestID tags every request with an ID, taken from X-Request-ID when the
+// caller sent a sane one, and echoes it back so clients can quote it.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRe.MatchString(id) {
+			var b [16]byte
+			rand.Read(b[:])
+			id = hex.EncodeToString(b[:])
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+const traceIDHeader = "X-Trace-ID"
+
+// withTraceID picks up the trace ID of requests sent with a W3C traceparent
+// header, which callers set once tracing is enabled in front of the service.
+// Logs then carry it as trace_id and error responses as their trace_id field,
+// read back from the X-Trace-ID response header.
+func withTraceID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := traceIDFromRequest(r)
+		if id == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set(traceIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithTraceID(r.Context(), id)))
+	})
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
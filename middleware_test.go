@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestWithTimeout_Exceeded(t *testing.T) {
+	h := withTimeout(20*time.Millisecond, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		w.WriteHeader(http.StatusOK)
+	})
+
+	req, _ := http.NewRequest("GET", "/api/v1/persons", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusGatewayTimeout {
+		t.Fatalf("Expected status 504, got %d", status)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("Expected problem+json content type, got %q", ct)
+	}
+	var problem ProblemResponse
+	if err := json.NewDecoder(rr.Body).Decode(&problem); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if problem.Status != http.StatusGatewayTimeout || problem.Code != apierr.Timeout || problem.Instance != "/api/v1/persons" {
+		t.Errorf("Unexpected problem body: %+v", problem)
+	}
+}
+
+func TestWithTimeout_CompletesInTime(t *testing.T) {
+	h := withTimeout(time.Second, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/api/v1/persons/1")
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	req, _ := http.NewRequest("POST", "/api/v1/persons", nil)
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Errorf("Expected status 201, got %d", status)
+	}
+	if loc := rr.Header().Get("Location"); loc != "/api/v1/persons/1" {
+		t.Errorf("Expected Location header to be passed through, got %q", loc)
+	}
+}
+
+func TestLoadShedder_RejectsReadsWhenSaturated(t *testing.T) {
+	s := newLoadShedder(1, 10*time.Millisecond)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	h := s.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			close(started)
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/persons", nil))
+	<-started
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/persons", nil))
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", status)
+	}
+	if rr.Header().Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header on shed request")
+	}
+
+	close(release)
+	for s.inFlight() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/persons", nil))
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("Expected status 200 once load drops, got %d", status)
+	}
+}
+
+func TestConcurrencyLimiter_OnlyExpensiveRoutes(t *testing.T) {
+	app := newTestAppWithStore(testutil.NewMemoryStore(testutil.NewFactory(1).Persons(3)...))
+	app.cfg.adminToken = "s3cret"
+	app.expensive = newConcurrencyLimiter(1, 0)
+	app.expensive.admitted <- struct{}{}
+	app.expensive.running <- struct{}{}
+	router := app.routes()
+
+	if rr := testutil.Do(router, "GET", "/api/v1/persons", nil); rr.Code != http.StatusOK {
+		t.Errorf("Expected the list outside the limiter, got %d", rr.Code)
+	}
+	req := httptest.NewRequest("POST", "/admin/cleanup?dry_run=true", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected cleanup limited, got %d", rr.Code)
+	}
+}
+
+func TestConcurrencyLimiter_RejectsWhenQueueFull(t *testing.T) {
+	l := newConcurrencyLimiter(1, 1)
+	release := make(chan struct{})
+	entered := make(chan struct{}, 2)
+	h := l.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	results := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/persons", nil))
+			results <- rr.Code
+		}()
+	}
+	<-entered
+	for len(l.admitted) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/persons", nil))
+	if status := rr.Code; status != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429, got %d", status)
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if status := <-results; status != http.StatusOK {
+			t.Errorf("Expected queued request to finish with 200, got %d", status)
+		}
+	}
+}
+
+func TestWithTraceID(t *testing.T) {
+	router := setupTestRouterWithStore(t, testutil.NewMemoryStore())
+
+	req := httptest.NewRequest("GET", "/api/v1/persons/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	var body ErrorResponse
+	json.NewDecoder(rr.Body).Decode(&body)
+	if body.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || rr.Header().Get("X-Trace-ID") != body.TraceID {
+		t.Errorf("Expected the trace ID in the header and the error, got %q %q", rr.Header().Get("X-Trace-ID"), body.TraceID)
+	}
+
+	rr = testutil.Do(router, "GET", "/api/v1/persons/1", nil)
+	if rr.Header().Get("X-Trace-ID") != "" || strings.Contains(rr.Body.String(), "trace_id") {
+		t.Errorf("Expected no trace ID for an untraced request, got %s", rr.Body.String())
+	}
+
+	for _, header := range []string{
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-<script>alert(1)</script>aaaaaaaaaaaaaa-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-1",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		req := httptest.NewRequest("GET", "/api/v1/persons/1", nil)
+		req.Header.Set("traceparent", header)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if id := rr.Header().Get("X-Trace-ID"); id != "" {
+			t.Errorf("Expected traceparent %q ignored, got trace ID %q", header, id)
+		}
+	}
+	req = httptest.NewRequest("GET", "/api/v1/persons/1", nil)
+	req.Header.Set("traceparent", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if id := rr.Header().Get("X-Trace-ID"); id != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected a later version with extra fields accepted, got %q", id)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+// maxRadiusKm keeps nearby search local; beyond it the bounding box stops
+// narrowing anything.
+const maxRadiusKm = 1000
+
+type NearbyHitResponse struct {
+	PersonResponse
+	DistanceKm float64 `json:"distance_km"`
+}
+
+// nearbyPersons lists persons within radius_km of lat, lon, nearest first.
+// Persons without coordinates never match.
+func (app *application) nearbyPersons(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var errs []apierr.FieldError
+	lat, errs := parseFloatParam(q.Get("lat"), "lat", true, errs)
+	lon, errs := parseFloatParam(q.Get("lon"), "lon", true, errs)
+	radius, errs := parseFloatParam(q.Get("radius_km"), "radius_km", true, errs)
+	errs = appendRange(errs, "lat", lat, -90, 90)
+	errs = appendRange(errs, "lon", lon, -180, 180)
+	errs = appendRange(errs, "radius_km", radius, 0, maxRadiusKm)
+	var query store.NearbyQuery
+	query.Limit, query.Offset, errs = parsePage(q, app.cfg.page, errs)
+	if len(errs) > 0 {
+		sendValidationError(w, apierr.ValidationFailed, "Invalid query parameters", errs)
+		return
+	}
+	query.Lat, query.Lon, query.RadiusKm = *lat, *lon, *radius
+
+	slog.DebugContext(r.Context(), "finding nearby persons", "radius_km", query.RadiusKm, "limit", query.Limit, "offset", query.Offset)
+	hits, err := app.nearby.NearbyPersons(r.Context(), query)
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	resp := make([]NearbyHitResponse, 0, len(hits))
+	for _, h := range hits {
+		resp = append(resp, NearbyHitResponse{PersonResponse: toPersonResponse(h.Person), DistanceKm: h.DistanceKm})
+	}
+	if len(hits) == query.Limit {
+		w.Header().Set("Link", "<"+nextPageURL(r, query.Limit, query.Offset)+`>; rel="next"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		sendError(w, apierr.Internal, "Encoding error")
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestNearbyPersons(t *testing.T) {
+	st := testutil.NewMemoryStore(
+		// Red Square, Moscow.
+		store.Person{Name: "Ann", Latitude: float64Ptr(55.7539), Longitude: float64Ptr(37.6208)},
+		// Saint Petersburg, about 630 km away.
+		store.Person{Name: "Bob", Latitude: float64Ptr(59.9343), Longitude: float64Ptr(30.3351)},
+		store.Person{Name: "Carl"},
+		// Moscow State University, about 7 km away.
+		store.Person{Name: "Dana", Latitude: float64Ptr(55.7033), Longitude: float64Ptr(37.5302)},
+	)
+	app := newTestAppWithStore(st)
+	app.nearby = st
+	router := withContractCheck(t, app.routes())
+
+	testCases := []struct {
+		name     string
+		target   string
+		wantCode int
+		wantIDs  []int32
+		wantNext bool
+	}{
+		{"Within radius", "/api/v1/persons/nearby?lat=55.7558&lon=37.6173&radius_km=10", http.StatusOK, []int32{1, 4}, false},
+		{"Nearest first", "/api/v1/persons/nearby?lat=55.70&lon=37.53&radius_km=1000", http.StatusOK, []int32{4, 1, 2}, false},
+		{"Paged", "/api/v1/persons/nearby?lat=55.70&lon=37.53&radius_km=1000&limit=2", http.StatusOK, []int32{4, 1}, true},
+		{"Nothing near", "/api/v1/persons/nearby?lat=0&lon=0&radius_km=100", http.StatusOK, []int32{}, false},
+		{"Missing radius", "/api/v1/persons/nearby?lat=0&lon=0", http.StatusBadRequest, nil, false},
+		{"Not a number", "/api/v1/persons/nearby?lat=north&lon=0&radius_km=1", http.StatusBadRequest, nil, false},
+		{"Out of range", "/api/v1/persons/nearby?lat=91&lon=0&radius_km=1", http.StatusBadRequest, nil, false},
+		{"Radius too large", "/api/v1/persons/nearby?lat=0&lon=0&radius_km=5000", http.StatusBadRequest, nil, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", tc.target, nil))
+			if rr.Code != tc.wantCode {
+				t.Fatalf("Expected %d, got %d: %s", tc.wantCode, rr.Code, rr.Body.String())
+			}
+			if next := rr.Header().Get("Link"); (next != "") != tc.wantNext {
+				t.Errorf("Expected next link %v, got %q", tc.wantNext, next)
+			}
+			if tc.wantIDs == nil {
+				return
+			}
+			var hits []NearbyHitResponse
+			json.NewDecoder(rr.Body).Decode(&hits)
+			if len(hits) != len(tc.wantIDs) {
+				t.Fatalf("Expected %v, got %+v", tc.wantIDs, hits)
+			}
+			for i, h := range hits {
+				if h.ID != tc.wantIDs[i] || i > 0 && h.DistanceKm < hits[i-1].DistanceKm {
+					t.Errorf("Expected %v nearest first, got %+v", tc.wantIDs, hits)
+				}
+			}
+		})
+	}
+}
+
+func TestCreatePersonCoordinates(t *testing.T) {
+	st := testutil.NewMemoryStore()
+	router := withContractCheck(t, newTestAppWithStore(st).routes())
+
+	rr := testutil.Do(router, "POST", "/api/v1/persons", PersonRequest{Name: stringPtr("Ann"), Latitude: float64Ptr(55.75)})
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400 for a latitude without longitude, got %d", rr.Code)
+	}
+
+	rr = testutil.Do(router, "POST", "/api/v1/persons", PersonRequest{Name: stringPtr("Ann"), Latitude: float64Ptr(55.75), Longitude: float64Ptr(37.62)})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	p, err := st.GetPerson(context.Background(), 1)
+	if err != nil || p.Latitude == nil || *p.Latitude != 55.75 || *p.Longitude != 37.62 {
+		t.Errorf("Expected coordinates to be stored, got %+v %v", p, err)
+	}
+}
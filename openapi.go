@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strings"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+)
+
+//go:embed openapi.yaml
+var openapiSpec []byte
+
+// loadSpec parses the embedded openapi.yaml into a router that matches
+// requests to its operations regardless of the host they were sent to.
+func loadSpec() (routers.Router, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(openapiSpec)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, err
+	}
+	doc.Servers = nil
+	return gorillamux.NewRouter(doc)
+}
+
+// validateRequests refuses requests whose parameters or body do not match
+// openapi.yaml before they reach the handler. Routes the document does not
+// describe pass through. Authentication is left to the auth stages.
+func (app *application) validateRequests(next http.Handler) http.Handler {
+	if app.spec == nil || !app.cfg.validateRequests {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, pathParams, err := app.spec.FindRoute(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		err = openapi3filter.ValidateRequest(r.Context(), &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				MultiError:          true,
+				SkipSettingDefaults: true,
+				AuthenticationFunc:  openapi3filter.NoopAuthenticationFunc,
+				ExcludeRequestBody:  route.Operation.RequestBody != nil && fileContent(route.Operation.RequestBody.Value.Content),
+			},
+		})
+		if err == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		details, badJSON := requestFieldErrors(err)
+		if badJSON {
+			sendError(w, apierr.InvalidJSON, "json decoding error")
+			return
+		}
+		sendValidationError(w, apierr.ValidationFailed, "Request does not match the API schema", details)
+	})
+}
+
+// requestFieldErrors turns what ValidateRequest found into field errors named
+// after the parameter, or the dotted path into the body. badJSON reports a
+// body that could not be decoded at all.
+func requestFieldErrors(err error) (details []apierr.FieldError, badJSON bool) {
+	var walk func(err error, field string)
+	walk = func(err error, field string) {
+		switch e := err.(type) {
+		case openapi3.MultiError:
+			for _, err := range e {
+				walk(err, field)
+			}
+		case *openapi3filter.RequestError:
+			if e.Parameter != nil {
+				field = e.Parameter.Name
+			}
+			var parseErr *openapi3filter.ParseError
+			switch {
+			case e.RequestBody != nil && errors.As(e.Err, &parseErr):
+				badJSON = true
+			case e.Err != nil:
+				walk(e.Err, field)
+			default:
+				details = append(details, schemaFieldError(field, e.Reason))
+			}
+		case *openapi3.SchemaError:
+			if e.Origin != nil {
+				walk(e.Origin, field)
+				return
+			}
+			if path := e.JSONPointer(); len(path) > 0 {
+				field = strings.Join(path, ".")
+			}
+			details = append(details, schemaFieldError(field, e.Reason))
+		default:
+			details = append(details, schemaFieldError(field, err.Error()))
+		}
+	}
+	walk(err, "body")
+	return details, badJSON
+}
+
+// validateResponses checks API responses against openapi.yaml. It is meant
+// for development and tests: responses are held back until complete, and one
+// that does not match is logged and replaced with a 500, so that a wrong type
+// or a missing field fails loudly instead of reaching clients.
+func (app *application) validateResponses(next http.Handler) http.Handler {
+	if app.spec == nil || !app.cfg.validateResponses {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, pathParams, err := app.spec.FindRoute(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if ok := route.Operation.Responses.Status(http.StatusOK); ok != nil && fileContent(ok.Value.Content) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		buf := &bufferedResponse{header: w.Header().Clone()}
+		next.ServeHTTP(buf, r)
+		err = openapi3filter.ValidateResponse(r.Context(), &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: &openapi3filter.RequestValidationInput{
+				Request:    r,
+				PathParams: pathParams,
+				Route:      route,
+			},
+			Status: buf.status(),
+			Header: buf.header,
+			Body:   io.NopCloser(bytes.NewReader(buf.body.Bytes())),
+			Options: &openapi3filter.Options{
+				IncludeResponseStatus: true,
+				MultiError:            true,
+			},
+		})
+		if err != nil {
+			slog.ErrorContext(r.Context(), "response does not match the API schema",
+				"method", r.Method, "route", route.Path, "status", buf.status(), "err", err,
+				"request_id", requestIDFromContext(r.Context()))
+			sendError(w, apierr.Internal, "Response does not match the API schema")
+			return
+		}
+		maps.Copy(w.Header(), buf.header)
+		w.WriteHeader(buf.status())
+		w.Write(buf.body.Bytes())
+	})
+}
+
+// bufferedResponse holds a response back until it has been checked.
+type bufferedResponse struct {
+	header http.Header
+	body   bytes.Buffer
+	code   int
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.code == 0 {
+		b.code = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) WriteHeader(code int) {
+	if b.code == 0 {
+		b.code = code
+	}
+}
+
+func (b *bufferedResponse) status() int { return max(b.code, http.StatusOK) }
+
+// fileContent reports whether content is files, described without a
+// schema. Those are streamed, not held in memory to be validated.
+func fileContent(content openapi3.Content) bool {
+	for _, mt := range content {
+		if mt.Schema == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func schemaFieldError(field, reason string) apierr.FieldError {
+	return apierr.NewFieldError(field, apierr.KeyRejected, map[string]any{"reason": reason})
+}
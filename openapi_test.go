@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestValidateRequests(t *testing.T) {
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	spec, err := loadSpec()
+	if err != nil {
+		t.Fatalf("Failed to load the spec: %v", err)
+	}
+	app.spec, app.cfg.validateRequests = spec, true
+	router := withContractCheck(t, app.routes())
+
+	tests := []struct {
+		name      string
+		method    string
+		target    string
+		body      any
+		wantCode  int
+		wantError apierr.Code
+		wantField string
+	}{
+		{"Valid create", "POST", "/api/v1/persons", `{"name":"Ann","age":30}`, http.StatusCreated, "", ""},
+		{"Wrong type", "POST", "/api/v1/persons", `{"name":"Ann","age":"thirty"}`, http.StatusBadRequest, apierr.ValidationFailed, "age"},
+		{"Missing name", "POST", "/api/v1/persons", `{"age":30}`, http.StatusBadRequest, apierr.ValidationFailed, "name"},
+		{"Invalid JSON", "POST", "/api/v1/persons", `{"name":`, http.StatusBadRequest, apierr.InvalidJSON, ""},
+		{"Patch without name", "PATCH", "/api/v1/persons/1", `{"age":31}`, http.StatusOK, "", ""},
+		{"Bad path parameter", "GET", "/api/v1/persons/abc", nil, http.StatusBadRequest, apierr.ValidationFailed, "id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := testutil.Do(router, tt.method, tt.target, tt.body)
+			if rr.Code != tt.wantCode {
+				t.Fatalf("Expected %d, got %d: %s", tt.wantCode, rr.Code, rr.Body.String())
+			}
+			if tt.wantError == "" {
+				return
+			}
+			var body ValidationErrorResponse
+			json.NewDecoder(rr.Body).Decode(&body)
+			if body.Code != tt.wantError {
+				t.Errorf("Expected %s, got %s", tt.wantError, body.Code)
+			}
+			if _, ok := body.Errors[tt.wantField]; tt.wantField != "" && !ok {
+				t.Errorf("Expected an error for %s, got %v", tt.wantField, body.Errors)
+			}
+		})
+	}
+}
+
+func TestValidateResponses(t *testing.T) {
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	spec, err := loadSpec()
+	if err != nil {
+		t.Fatalf("Failed to load the spec: %v", err)
+	}
+	app.spec, app.cfg.validateResponses = spec, true
+
+	drifting := app.validateResponses(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"one","name":"Ann"}`))
+	}))
+	rr := httptest.NewRecorder()
+	drifting.ServeHTTP(rr, httptest.NewRequest("GET", "/api/v1/persons/1", nil))
+	if rr.Code != http.StatusInternalServerError || decodeErrorCode(t, rr) != apierr.Internal {
+		t.Errorf("Expected a response with a string id to become a 500, got %d", rr.Code)
+	}
+
+	router := app.routes()
+	testutil.Do(router, "POST", "/api/v1/persons", PersonRequest{Name: stringPtr("Ann")})
+	rr = testutil.Do(router, "GET", "/api/v1/persons/1", nil)
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected a matching response to pass through, got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+type pageLimits struct {
+	defaultSize int
+	maxSize     int
+}
+
+// parsePage reads limit and offset. A missing limit means the default page
+// size; anything above maxSize is rejected rather than silently clamped so
+// clients notice they are not getting everything they asked for.
+func parsePage(q url.Values, limits pageLimits, errs []apierr.FieldError) (limit, offset int, _ []apierr.FieldError) {
+	limit, errs = parseLimit(q, limits, errs)
+	if raw := q.Get("offset"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		switch {
+		case err != nil:
+			errs = append(errs, apierr.NewFieldError("offset", apierr.KeyNotInteger, map[string]any{"actual": raw}))
+		case n < 0:
+			errs = append(errs, apierr.NewFieldError("offset", apierr.KeyMinValue, map[string]any{"limit": 0, "actual": n}))
+		default:
+			offset = n
+		}
+	}
+	return limit, offset, errs
+}
+
+func parseLimit(q url.Values, limits pageLimits, errs []apierr.FieldError) (int, []apierr.FieldError) {
+	raw := q.Get("limit")
+	if raw == "" {
+		return limits.defaultSize, errs
+	}
+	n, err := strconv.Atoi(raw)
+	switch {
+	case err != nil:
+		errs = append(errs, apierr.NewFieldError("limit", apierr.KeyNotInteger, map[string]any{"actual": raw}))
+	case n < 1:
+		errs = append(errs, apierr.NewFieldError("limit", apierr.KeyMinValue, map[string]any{"limit": 1, "actual": n}))
+	case n > limits.maxSize:
+		errs = append(errs, apierr.NewFieldError("limit", apierr.KeyMaxValue, map[string]any{"limit": limits.maxSize, "actual": n}))
+	default:
+		return n, errs
+	}
+	return limits.defaultSize, errs
+}
+
+// nextPageURL is the request URL with offset moved one page forward.
+func nextPageURL(r *http.Request, limit, offset int) string {
+	q := r.URL.Query()
+	q.Set("limit", strconv.Itoa(limit))
+	q.Set("offset", strconv.Itoa(offset+limit))
+	return r.URL.Path + "?" + q.Encode()
+}
+
+// parseListFilter reads the list query string: name, min_age, max_age, sort
+// (comma separated fields, "-" prefix for descending, e.g. "name,-age") and
+// the page.
+func parseListFilter(r *http.Request, limits pageLimits) (store.ListFilter, []apierr.FieldError) {
+	return parseListQuery(r.URL.Query(), limits)
+}
+
+func parseListQuery(q url.Values, limits pageLimits) (store.ListFilter, []apierr.FieldError) {
+	var f store.ListFilter
+	var errs []apierr.FieldError
+
+	f.Name = strings.TrimSpace(q.Get("name"))
+	errs = appendMaxLength(errs, "name", &f.Name, maxNameLength)
+	f.MinAge, errs = parseInt32Param(q.Get("min_age"), "min_age", errs)
+	f.MaxAge, errs = parseInt32Param(q.Get("max_age"), "max_age", errs)
+
+	if raw := q.Get("sort"); raw != "" {
+		for _, field := range strings.Split(raw, ",") {
+			key := store.SortKey{Field: strings.TrimSpace(field)}
+			if strings.HasPrefix(key.Field, "-") {
+				key.Field, key.Desc = key.Field[1:], true
+			}
+			if !slices.Contains(store.SortFields, key.Field) {
+				errs = append(errs, apierr.NewFieldError("sort", apierr.KeyOneOf, map[string]any{
+					"allowed": strings.Join(store.SortFields, ", "),
+					"actual":  field,
+				}))
+				continue
+			}
+			f.Sort = append(f.Sort, key)
+		}
+	}
+	f.Limit, f.Offset, errs = parsePage(q, limits, errs)
+	return f, errs
+}
+
+func parseInt32Param(raw, field string, errs []apierr.FieldError) (*int32, []apierr.FieldError) {
+	if raw == "" {
+		return nil, errs
+	}
+	n, err := strconv.ParseInt(raw, 10, 32)
+	if err != nil {
+		return nil, append(errs, apierr.NewFieldError(field, apierr.KeyNotInteger, map[string]any{"actual": raw}))
+	}
+	v := int32(n)
+	return &v, errs
+}
+
+// parseFloatParam rejects NaN and infinities along with anything unparsable.
+func parseFloatParam(raw, field string, required bool, errs []apierr.FieldError) (*float64, []apierr.FieldError) {
+	if raw == "" {
+		if required {
+			errs = append(errs, apierr.NewFieldError(field, apierr.KeyRequired, nil))
+		}
+		return nil, errs
+	}
+	v, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, append(errs, apierr.NewFieldError(field, apierr.KeyNotNumber, map[string]any{"actual": raw}))
+	}
+	return &v, errs
+}
+
+// parseBoolParam takes only true and false; absent is false.
+func parseBoolParam(raw, field string, errs []apierr.FieldError) (bool, []apierr.FieldError) {
+	switch raw {
+	case "", "false":
+		return false, errs
+	case "true":
+		return true, errs
+	}
+	return false, append(errs, apierr.NewFieldError(field, apierr.KeyOneOf, map[string]any{"allowed": "true, false", "actual": raw}))
+}
+
+func parseTimeParam(raw, field string, required bool, errs []apierr.FieldError) (time.Time, []apierr.FieldError) {
+	if raw == "" {
+		if required {
+			errs = append(errs, apierr.NewFieldError(field, apierr.KeyRequired, nil))
+		}
+		return time.Time{}, errs
+	}
+	t, err := time.Parse(time.RFC3339Nano, raw)
+	if err != nil {
+		return time.Time{}, append(errs, apierr.NewFieldError(field, apierr.KeyNotTime, map[string]any{"actual": raw}))
+	}
+	return t, errs
+}
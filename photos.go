@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/blob"
+	"ci_cd/rsoi_lab_1/internal/imaging"
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+const (
+	// photoPrefix is where photo objects are kept: each photo is a directory
+	// with one JPEG per size.
+	photoPrefix   = "photos/"
+	photoOriginal = "original"
+	photoQuality  = 85
+	// photoHashLength is how many bytes of the SHA-256 of a photo name it.
+	photoHashLength = 16
+	// maxPhotoPixels bounds the memory a decoded photo takes, about 4 bytes
+	// per pixel.
+	maxPhotoPixels = 40_000_000
+)
+
+type PhotoResponse struct {
+	PersonID int32 `json:"person_id"`
+	Width    int   `json:"width"`
+	Height   int   `json:"height"`
+	// Sizes maps each size the photo is served in to its URL.
+	Sizes     map[string]string `json:"sizes"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// toPhotoResponse names the sizes of p by URLs carrying its hash, which
+// getPhoto lets be cached for good.
+func (app *application) toPhotoResponse(p store.Photo) PhotoResponse {
+	base := fmt.Sprintf("/api/v1/persons/%d/photo", *p.PersonID)
+	sizes := map[string]string{}
+	for _, size := range app.photoSizes() {
+		q := url.Values{}
+		if size != photoOriginal {
+			q.Set("size", size)
+		}
+		if p.Hash != "" {
+			q.Set("v", p.Hash)
+		}
+		sizes[size] = base
+		if len(q) > 0 {
+			sizes[size] += "?" + q.Encode()
+		}
+	}
+	return PhotoResponse{
+		PersonID:  *p.PersonID,
+		Width:     p.Width,
+		Height:    p.Height,
+		Sizes:     sizes,
+		CreatedAt: p.CreatedAt.UTC(),
+	}
+}
+
+func (app *application) photoSizes() []string {
+	sizes := []string{photoOriginal}
+	for _, v := range app.cfg.photoVariants {
+		sizes = append(sizes, v.Name)
+	}
+	return sizes
+}
+
+func photoKey(objectKey, size string) string {
+	return objectKey + size + ".jpg"
+}
+
+// photoDir is the photo a photo object belongs to, as referenced by its
+// row.
+func photoDir(key string) string {
+	return key[:strings.LastIndex(key, "/")+1]
+}
+
+// putPhoto replaces the person's photo with the image in the request body.
+// It is stored as JPEG in its original size and scaled down to every
+// configured variant, all written before the row so that a failure leaves
+// only orphans for sweepOrphanObjects.
+func (app *application) putPhoto(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		sendError(w, apierr.InvalidID, "Invalid ID format")
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, app.cfg.photoMaxSize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		sendError(w, apierr.PayloadTooLarge, fmt.Sprintf("Photos are limited to %d bytes", app.cfg.photoMaxSize))
+		return
+	}
+	if err != nil {
+		sendError(w, apierr.ValidationFailed, "Failed to read the photo")
+		return
+	}
+	if _, err := app.store.GetPerson(r.Context(), id); err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	img, detected, err := imaging.Decode(body, imaging.Limits{MaxPixels: maxPhotoPixels, MaxDimension: app.cfg.imageMaxDimension})
+	switch {
+	case errors.Is(err, imaging.ErrTooLarge):
+		sendError(w, apierr.PayloadTooLarge, "The photo has too many pixels")
+		return
+	case err != nil:
+		sendError(w, apierr.UnsupportedImage, "Photos must be JPEG, PNG or GIF images")
+		return
+	}
+	if declared := mediaType(normalizeContentType(r.Header.Get("Content-Type"))); declared != defaultContentType && declared != detected {
+		sendError(w, apierr.ContentTypeMismatch, fmt.Sprintf("The photo was sent as %s but is %s", declared, detected))
+		return
+	}
+
+	uploadID, err := newUploadID()
+	if err != nil {
+		sendDebugError(w, apierr.Internal, "Failed to name photo", errorDebug(r.Context(), err, 0))
+		return
+	}
+	original := imaging.Flatten(img)
+	hash := sha256.Sum256(body)
+	p := store.Photo{
+		PersonID:  &id,
+		ObjectKey: fmt.Sprintf("%s%d/%s/", photoPrefix, id, uploadID),
+		Hash:      hex.EncodeToString(hash[:photoHashLength]),
+		Width:     original.Bounds().Dx(),
+		Height:    original.Bounds().Dy(),
+	}
+	variants := append([]imaging.Variant{{Name: photoOriginal, Size: max(p.Width, p.Height)}}, app.cfg.photoVariants...)
+	for _, v := range variants {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, imaging.Fit(original, v.Size), &jpeg.Options{Quality: photoQuality}); err != nil {
+			app.deletePhotoObjects(r.Context(), p.ObjectKey)
+			sendDebugError(w, apierr.Internal, "Failed to encode photo", errorDebug(r.Context(), err, 0))
+			return
+		}
+		if err := app.blobs.Put(r.Context(), photoKey(p.ObjectKey, v.Name), &buf, int64(buf.Len()), "image/jpeg"); err != nil {
+			app.deletePhotoObjects(r.Context(), p.ObjectKey)
+			sendStorageError(w, r, err)
+			return
+		}
+	}
+
+	created, previous, err := app.photos.SetPhoto(r.Context(), p)
+	if err != nil {
+		app.deletePhotoObjects(r.Context(), p.ObjectKey)
+		sendStoreError(w, r, err)
+		return
+	}
+	if previous != "" {
+		app.deletePhotoObjects(r.Context(), previous)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(app.toPhotoResponse(created))
+}
+
+// getPhoto serves the photo in the size named by ?size=, the original by
+// default. With ?v= naming its hash the response never changes and may be
+// cached for photoCacheMaxAge; a replaced photo's hash is no longer found.
+func (app *application) getPhoto(w http.ResponseWriter, r *http.Request) {
+	size := r.URL.Query().Get("size")
+	if size == "" {
+		size = photoOriginal
+	}
+	if sizes := app.photoSizes(); !slices.Contains(sizes, size) {
+		sendValidationError(w, apierr.ValidationFailed, "Invalid query parameters", []apierr.FieldError{
+			apierr.NewFieldError("size", apierr.KeyOneOf, map[string]any{"allowed": strings.Join(sizes, ", "), "actual": size}),
+		})
+		return
+	}
+	p, ok := app.lookupPhoto(w, r)
+	if !ok {
+		return
+	}
+	version := r.URL.Query().Get("v")
+	if version != "" && version != p.Hash {
+		sendError(w, apierr.PhotoNotFound, "This version of the photo was replaced")
+		return
+	}
+	cacheControl := "no-cache"
+	if version != "" {
+		cacheControl = fmt.Sprintf("public, max-age=%d, immutable", int(app.cfg.photoCacheMaxAge/time.Second))
+	}
+	app.servePhoto(w, r, p, size, cacheControl)
+}
+
+// getPhotoThumbnail serves the smallest size of the photo, for lists showing
+// many persons at once. Clients revalidate it with If-None-Match.
+func (app *application) getPhotoThumbnail(w http.ResponseWriter, r *http.Request) {
+	p, ok := app.lookupPhoto(w, r)
+	if !ok {
+		return
+	}
+	size := photoOriginal
+	if len(app.cfg.photoVariants) > 0 {
+		size = slices.MinFunc(app.cfg.photoVariants, func(a, b imaging.Variant) int { return a.Size - b.Size }).Name
+	}
+	app.servePhoto(w, r, p, size, "no-cache")
+}
+
+func (app *application) lookupPhoto(w http.ResponseWriter, r *http.Request) (store.Photo, bool) {
+	id, err := parseID(r)
+	if err != nil {
+		sendError(w, apierr.InvalidID, "Invalid ID format")
+		return store.Photo{}, false
+	}
+	p, err := app.photos.Photo(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		sendError(w, apierr.PhotoNotFound, "Photo not found")
+		return store.Photo{}, false
+	}
+	if err != nil {
+		sendStoreError(w, r, err)
+		return store.Photo{}, false
+	}
+	return p, true
+}
+
+// servePhoto sends p in size, or 304 Not Modified when the client has it
+// already.
+func (app *application) servePhoto(w http.ResponseWriter, r *http.Request, p store.Photo, size, cacheControl string) {
+	var etag string
+	if p.Hash != "" {
+		etag = fmt.Sprintf(`"%s-%s"`, p.Hash, size)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Cache-Control", cacheControl)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	body, err := app.blobs.Get(r.Context(), photoKey(p.ObjectKey, size))
+	if errors.Is(err, blob.ErrNotFound) {
+		// Sizes configured after the photo was uploaded do not exist yet.
+		sendError(w, apierr.PhotoNotFound, fmt.Sprintf("The photo has no %s size, upload it again", size))
+		return
+	}
+	if err != nil {
+		sendStorageError(w, r, err)
+		return
+	}
+	defer body.Close()
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", cacheControl)
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		slog.WarnContext(r.Context(), "photo download aborted", "person_id", *p.PersonID, "err", err)
+	}
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, comparing
+// weakly as RFC 9110 asks for it.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func (app *application) deletePhoto(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		sendError(w, apierr.InvalidID, "Invalid ID format")
+		return
+	}
+	p, err := app.photos.DeletePhoto(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		sendError(w, apierr.PhotoNotFound, "Photo not found")
+		return
+	}
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	app.deletePhotoObjects(r.Context(), p.ObjectKey)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deletePhotoObjects deletes every size of the photo under objectKey. What
+// it fails to delete is left to sweepOrphanObjects.
+func (app *application) deletePhotoObjects(ctx context.Context, objectKey string) {
+	ctx = context.WithoutCancel(ctx)
+	err := app.blobs.List(ctx, objectKey, func(o blob.Object) error {
+		return app.blobs.Delete(ctx, o.Key)
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "failed to delete photo objects", "key", objectKey, "err", err)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/blob"
+	"ci_cd/rsoi_lab_1/internal/imaging"
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func testPNG(t *testing.T, w, h int) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestPhotos(t *testing.T) {
+	st := testutil.NewMemoryStore(store.Person{Name: "Ann"})
+	app := newTestAppWithStore(st)
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.blobs, app.photos = blobs, st
+	app.cfg.photoVariants = []imaging.Variant{{Name: "thumbnail", Size: 16}}
+	app.cfg.photoMaxSize = 64 << 10
+	app.cfg.photoCacheMaxAge = time.Hour
+	app.cfg.validateRequests = true
+	app.spec = loadSpecRouter(t)
+	router := withContractCheck(t, app.routes())
+
+	put := func(contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/v1/persons/1/photo", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	objects := func() (keys []string) {
+		blobs.List(context.Background(), photoPrefix, func(o blob.Object) error {
+			keys = append(keys, o.Key)
+			return nil
+		})
+		return keys
+	}
+
+	if rr := testutil.Do(router, "GET", "/api/v1/persons/1/photo", nil); rr.Code != http.StatusNotFound || decodeErrorCode(t, rr) != apierr.PhotoNotFound {
+		t.Errorf("Expected no photo yet, got %d", rr.Code)
+	}
+	rr := put("image/png", testPNG(t, 64, 32))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var photo PhotoResponse
+	json.NewDecoder(rr.Body).Decode(&photo)
+	thumbnail := photo.Sizes["thumbnail"]
+	if photo.Width != 64 || photo.Height != 32 || !strings.HasPrefix(thumbnail, "/api/v1/persons/1/photo?size=thumbnail&v=") {
+		t.Errorf("Unexpected photo %+v", photo)
+	}
+	if rr := testutil.Do(router, "GET", thumbnail, nil); rr.Code != http.StatusOK || rr.Header().Get("Cache-Control") != "public, max-age=3600, immutable" {
+		t.Errorf("Expected the versioned URL to be immutable, got %d %v", rr.Code, rr.Header())
+	}
+
+	for query, want := range map[string]image.Point{"": {64, 32}, "?size=original": {64, 32}, "?size=thumbnail": {16, 8}} {
+		rr := testutil.Do(router, "GET", "/api/v1/persons/1/photo"+query, nil)
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/jpeg" || rr.Header().Get("Cache-Control") != "no-cache" {
+			t.Fatalf("%q: unexpected response %d %v", query, rr.Code, rr.Header())
+		}
+		cfg, err := jpeg.DecodeConfig(rr.Body)
+		if err != nil || cfg.Width != want.X || cfg.Height != want.Y {
+			t.Errorf("%q: expected a %v JPEG, got %+v %v", query, want, cfg, err)
+		}
+	}
+	rr = testutil.Do(router, "GET", "/api/v1/persons/1/photo/thumbnail", nil)
+	etag := rr.Header().Get("ETag")
+	if cfg, err := jpeg.DecodeConfig(rr.Body); rr.Code != http.StatusOK || err != nil || cfg.Width != 16 || etag == "" {
+		t.Fatalf("Unexpected thumbnail %d %v %+v %v", rr.Code, rr.Header(), cfg, err)
+	}
+	for _, ifNoneMatch := range []string{etag, `"other", W/` + etag, "*"} {
+		req := httptest.NewRequest("GET", "/api/v1/persons/1/photo/thumbnail", nil)
+		req.Header.Set("If-None-Match", ifNoneMatch)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusNotModified || rr.Body.Len() != 0 || rr.Header().Get("ETag") != etag {
+			t.Errorf("%s: expected 304, got %d", ifNoneMatch, rr.Code)
+		}
+	}
+	req := httptest.NewRequest("GET", "/api/v1/persons/1/photo/thumbnail", nil)
+	req.Header.Set("If-None-Match", `"other"`)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected another ETag to get the thumbnail, got %d", rr.Code)
+	}
+
+	if rr := testutil.Do(router, "GET", "/api/v1/persons/1/photo?size=huge", nil); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown size to be refused, got %d", rr.Code)
+	}
+
+	if rr := put("image/png", "%PDF-1.7"); rr.Code != http.StatusUnsupportedMediaType || decodeErrorCode(t, rr) != apierr.UnsupportedImage {
+		t.Errorf("Expected 415, got %d", rr.Code)
+	}
+	if rr := put("image/jpeg", testPNG(t, 8, 8)); rr.Code != http.StatusUnsupportedMediaType || decodeErrorCode(t, rr) != apierr.ContentTypeMismatch {
+		t.Errorf("Expected a PNG sent as JPEG to be refused, got %d", rr.Code)
+	}
+	if rr := put("image/png", strings.Repeat("x", 64<<10+1)); rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413, got %d", rr.Code)
+	}
+	if rr := put("image/png", testPNG(t, 8, 8)); rr.Code != http.StatusOK {
+		t.Fatalf("Expected the photo replaced, got %d", rr.Code)
+	}
+	if keys := objects(); len(keys) != 2 {
+		t.Errorf("Expected the replaced photo's objects deleted, got %v", keys)
+	}
+	if rr := testutil.Do(router, "GET", thumbnail, nil); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected the replaced version to be gone, got %d", rr.Code)
+	}
+	req = httptest.NewRequest("GET", "/api/v1/persons/1/photo/thumbnail", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") == etag {
+		t.Errorf("Expected the replaced photo's thumbnail to be sent, got %d", rr.Code)
+	}
+
+	if rr := testutil.Do(router, "DELETE", "/api/v1/persons/1/photo", nil); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", rr.Code)
+	}
+	if keys := objects(); len(keys) != 0 {
+		t.Errorf("Expected no objects left, got %v", keys)
+	}
+}
+
+func TestSweepOrphanPhotos(t *testing.T) {
+	st := testutil.NewMemoryStore(store.Person{Name: "Ann"})
+	app := newTestAppWithStore(st)
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.blobs, app.photos = blobs, st
+	ctx := context.Background()
+	for _, key := range []string{"photos/1/kept/original.jpg", "photos/1/kept/thumbnail.jpg", "photos/1/orphan/original.jpg"} {
+		if err := blobs.Put(ctx, key, strings.NewReader("x"), 1, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	id := int32(1)
+	if _, _, err := st.SetPhoto(ctx, store.Photo{PersonID: &id, ObjectKey: "photos/1/kept/"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := app.sweepOrphans(ctx, time.Now().Add(time.Hour), false); err != nil || n != 1 {
+		t.Errorf("Expected one orphan deleted, got %d: %v", n, err)
+	}
+	if _, err := blobs.Stat(ctx, "photos/1/kept/thumbnail.jpg"); err != nil {
+		t.Errorf("Expected the referenced photo kept: %v", err)
+	}
+}
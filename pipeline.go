@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// stage is a named step of the request pipeline. Stages whose feature is not
+// configured return next unchanged.
+type stage struct {
+	name string
+	wrap mux.MiddlewareFunc
+}
+
+// The request pipeline is built here and only here. Each list runs in order,
+// the first stage seeing the request first; routes() applies them to the
+// router and its subrouters, so a new cross-cutting concern means a new entry
+// in one of these lists rather than touching routes. Per-route wrappers like
+// withTimeout stay with their routes.
+
+// serverStages run for every matched route.
+func (app *application) serverStages() []stage {
+	return []stage{
+		{"in_flight", app.drain.middleware},
+		{"debug", app.withDebug},
+		{"recovery", app.reporter.middleware},
+		{"request_id", withRequestID},
+		{"trace_id", withTraceID},
+		{"logging", logRequests},
+		{"access_log", app.accessLog.middleware},
+		{"metrics", app.metrics.middleware},
+	}
+}
+
+// apiStages run for /api/v1 after the server stages.
+func (app *application) apiStages() []stage {
+	return []stage{
+		{"response_validation", app.validateResponses},
+		{"api_key", app.withAPIKey},
+		{"user", app.withUser},
+		{"audit", app.auditWrites(requestActor)},
+		{"rate_limit", app.limiter.middleware},
+		{"load_shedding", app.shedder.middleware},
+		{"request_validation", app.validateRequests},
+		{"shadow", app.shadow.middleware},
+	}
+}
+
+// uiStages run for /ui after the server stages. The UI has no authentication
+// of its own and is disabled when API keys are required.
+func (app *application) uiStages() []stage {
+	return []stage{
+		{"audit", app.auditWrites(requestActor)},
+		{"rate_limit", app.limiter.middleware},
+		{"load_shedding", app.shedder.middleware},
+	}
+}
+
+// adminStages run for /admin after the server stages.
+func (app *application) adminStages() []stage {
+	return []stage{
+		{"admin_token", app.requireAdmin},
+		{"audit", app.auditWrites(adminActor)},
+	}
+}
+
+func middlewares(stages []stage) []mux.MiddlewareFunc {
+	mws := make([]mux.MiddlewareFunc, len(stages))
+	for i, s := range stages {
+		mws[i] = s.wrap
+	}
+	return mws
+}
+
+// logRequests logs every request at debug level once it is served.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if !slog.Default().Enabled(ctx, slog.LevelDebug) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sr := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(sr, r)
+		slog.DebugContext(ctx, "request served",
+			"method", r.Method, "route", routeTemplate(r), "status", max(sr.status, http.StatusOK),
+			"duration_ms", time.Since(start).Milliseconds(), "request_id", requestIDFromContext(ctx))
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestPipelineOrder(t *testing.T) {
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	names := func(stages []stage) []string {
+		var out []string
+		for _, s := range stages {
+			out = append(out, s.name)
+		}
+		return out
+	}
+	if got := names(app.serverStages()); !slices.Equal(got, []string{"in_flight", "debug", "recovery", "request_id", "trace_id", "logging", "access_log", "metrics"}) {
+		t.Errorf("Unexpected server stages %v", got)
+	}
+	if got := names(app.apiStages()); !slices.Equal(got, []string{"response_validation", "api_key", "user", "audit", "rate_limit", "load_shedding", "request_validation", "shadow"}) {
+		t.Errorf("Unexpected api stages %v", got)
+	}
+	if got := names(app.adminStages()); !slices.Equal(got, []string{"admin_token", "audit"}) {
+		t.Errorf("Unexpected admin stages %v", got)
+	}
+}
+
+func TestPipelineRecoversWithRequestID(t *testing.T) {
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic("boom") })
+	mws := middlewares(app.serverStages())
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/persons", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError || rr.Header().Get("X-Request-ID") != "req-1" {
+		t.Errorf("Expected a 500 carrying the request ID, got %d %v", rr.Code, rr.Header())
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/blob"
+	"ci_cd/rsoi_lab_1/internal/store"
+
+	"github.com/gorilla/mux"
+)
+
+type UploadURLRequest struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// UploadURLResponse tells the client how to upload the file: a request with
+// Method to URL, sending Headers as given, before ExpiresAt.
+type UploadURLResponse struct {
+	UploadID  string            `json:"upload_id"`
+	URL       string            `json:"url"`
+	Method    string            `json:"method"`
+	Headers   map[string]string `json:"headers"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+type CompleteUploadRequest struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+}
+
+type DownloadURLResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// createUploadURL starts an upload that bypasses the API: the client sends
+// the file straight to object storage through the returned URL, then
+// completes the upload to create the attachment. Uploads never completed
+// leave orphaned objects, which sweepOrphanObjects deletes.
+func (app *application) createUploadURL(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		sendError(w, apierr.InvalidID, "Invalid ID format")
+		return
+	}
+	var req UploadURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, apierr.InvalidJSON, "json decoding error")
+		return
+	}
+	errs := validateFilename(req.Filename)
+	switch {
+	case req.Size < 0:
+		errs = append(errs, apierr.NewFieldError("size", apierr.KeyMinValue, map[string]any{"limit": 0, "actual": req.Size}))
+	case req.Size > app.cfg.presignedMaxSize:
+		errs = append(errs, apierr.NewFieldError("size", apierr.KeyMaxValue, map[string]any{"limit": app.cfg.presignedMaxSize, "actual": req.Size}))
+	}
+	if len(errs) > 0 {
+		sendValidationError(w, apierr.ValidationFailed, "Invalid upload", errs)
+		return
+	}
+	if _, err := app.store.GetPerson(r.Context(), id); err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+
+	uploadID, err := newUploadID()
+	if err != nil {
+		sendDebugError(w, apierr.Internal, "Failed to name attachment", errorDebug(r.Context(), err, 0))
+		return
+	}
+	url, err := app.blobs.(blob.Presigner).PresignPut(attachmentKey(id, uploadID), app.cfg.presignTTL, req.Size)
+	if err != nil {
+		sendStorageError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(UploadURLResponse{
+		UploadID:  uploadID,
+		URL:       url,
+		Method:    http.MethodPut,
+		Headers:   map[string]string{"Content-Length": strconv.FormatInt(req.Size, 10)},
+		ExpiresAt: time.Now().Add(app.cfg.presignTTL).UTC().Truncate(time.Second),
+	})
+}
+
+// completeUpload creates the attachment for an object uploaded through
+// createUploadURL, once it passed inspection and the malware scan. Its size
+// is what was actually stored. The uploaded object is deleted, leaving the
+// upload ID unusable.
+func (app *application) completeUpload(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		sendError(w, apierr.InvalidID, "Invalid ID format")
+		return
+	}
+	uploadID := mux.Vars(r)["uploadId"]
+	if b, err := hex.DecodeString(uploadID); err != nil || len(b) != 16 {
+		sendError(w, apierr.UploadNotFound, "Upload not found")
+		return
+	}
+	var req CompleteUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, apierr.InvalidJSON, "json decoding error")
+		return
+	}
+	if errs := validateFilename(req.Filename); len(errs) > 0 {
+		sendValidationError(w, apierr.ValidationFailed, "Invalid upload", errs)
+		return
+	}
+
+	// The client can keep writing to the presigned key until the URL
+	// expires, so what is checked and kept is a copy under a key it never
+	// had a URL for.
+	uploadKey := attachmentKey(id, uploadID)
+	objectID, err := newUploadID()
+	if err != nil {
+		sendDebugError(w, apierr.Internal, "Failed to name attachment", errorDebug(r.Context(), err, 0))
+		return
+	}
+	key := attachmentKey(id, objectID)
+	err = app.blobs.Copy(r.Context(), uploadKey, key)
+	if errors.Is(err, blob.ErrNotFound) {
+		sendError(w, apierr.UploadNotFound, "Nothing was uploaded for this upload ID")
+		return
+	}
+	if err != nil {
+		sendStorageError(w, r, err)
+		return
+	}
+	obj, err := app.blobs.Stat(r.Context(), key)
+	if err != nil {
+		app.discardUpload(r.Context(), key)
+		sendStorageError(w, r, err)
+		return
+	}
+	a := store.Attachment{
+		PersonID:    &id,
+		ObjectKey:   key,
+		Filename:    req.Filename,
+		ContentType: normalizeContentType(req.ContentType),
+		Size:        obj.Size,
+		UploadedBy:  requestActor(r),
+	}
+	if a, err = app.inspectUpload(r.Context(), a); err != nil {
+		app.discardUpload(r.Context(), key)
+		var rejected *rejectedUpload
+		if errors.As(err, &rejected) {
+			app.discardUpload(r.Context(), uploadKey)
+		}
+		sendUploadError(w, r, err)
+		return
+	}
+	// An infected copy was quarantined. An upload that could not be scanned
+	// stays, so that completing can be retried.
+	if err := app.scanUpload(r.Context(), a); err != nil {
+		var merr *malwareError
+		if errors.As(err, &merr) {
+			app.discardUpload(r.Context(), uploadKey)
+		} else {
+			app.discardUpload(r.Context(), key)
+		}
+		sendUploadError(w, r, err)
+		return
+	}
+	if a.Checksum, err = app.checksumObject(r.Context(), key); err != nil {
+		app.discardUpload(r.Context(), key)
+		sendStorageError(w, r, err)
+		return
+	}
+	a, err = app.attachments.CreateAttachment(r.Context(), a)
+	if err != nil {
+		app.discardUpload(r.Context(), key)
+		sendStoreError(w, r, err)
+		return
+	}
+	app.discardUpload(r.Context(), uploadKey)
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/persons/%d/attachments/%d", id, a.ID))
+	w.Header().Set("Repr-Digest", reprDigest(a.Checksum))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toAttachmentResponse(a))
+}
+
+// getDownloadURL returns a URL that downloads the attachment straight from
+// object storage.
+func (app *application) getDownloadURL(w http.ResponseWriter, r *http.Request) {
+	personID, id, err := parseAttachmentID(r)
+	if err != nil {
+		sendError(w, apierr.InvalidID, "Invalid ID format")
+		return
+	}
+	a, err := app.attachments.Attachment(r.Context(), personID, id)
+	if errors.Is(err, store.ErrNotFound) {
+		sendError(w, apierr.AttachmentNotFound, "Attachment not found")
+		return
+	}
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	url, err := app.blobs.(blob.Presigner).PresignGet(a.ObjectKey, app.cfg.presignTTL, a.ContentType, a.Filename)
+	if err != nil {
+		sendStorageError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DownloadURLResponse{URL: url, ExpiresAt: time.Now().Add(app.cfg.presignTTL).UTC().Truncate(time.Second)})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/blob"
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+// presigningLocal hands out URLs naming the key, standing in for S3.
+type presigningLocal struct {
+	*blob.Local
+}
+
+func (presigningLocal) PresignGet(key string, expires time.Duration, contentType, filename string) (string, error) {
+	return "https://files.test/" + key + "?download=" + filename, nil
+}
+
+func (presigningLocal) PresignPut(key string, expires time.Duration, size int64) (string, error) {
+	return "https://files.test/" + key, nil
+}
+
+func TestPresignedAttachments(t *testing.T) {
+	st := testutil.NewMemoryStore(store.Person{Name: "Ann"})
+	app := newTestAppWithStore(st)
+	local, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.blobs, app.attachments = presigningLocal{local}, st
+	app.cfg.presignedMaxSize = 1 << 20
+	router := withContractCheck(t, app.routes())
+
+	rr := testutil.Do(router, "POST", "/api/v1/persons/1/attachments/uploads", UploadURLRequest{Filename: "scan.pdf", Size: 2 << 20})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected an upload above the limit to be refused, got %d", rr.Code)
+	}
+	rr = testutil.Do(router, "POST", "/api/v1/persons/1/attachments/uploads", UploadURLRequest{Filename: "scan.pdf", ContentType: "application/pdf", Size: 5})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var upload UploadURLResponse
+	json.NewDecoder(rr.Body).Decode(&upload)
+	key := attachmentKey(1, upload.UploadID)
+	if upload.URL != "https://files.test/"+key || upload.Method != "PUT" || upload.Headers["Content-Length"] != "5" {
+		t.Errorf("Unexpected upload %+v", upload)
+	}
+
+	complete := "/api/v1/persons/1/attachments/uploads/" + upload.UploadID
+	if rr := testutil.Do(router, "POST", complete, CompleteUploadRequest{Filename: "scan.pdf"}); rr.Code != http.StatusNotFound || decodeErrorCode(t, rr) != apierr.UploadNotFound {
+		t.Errorf("Expected completing before the upload to fail, got %d", rr.Code)
+	}
+	if err := local.Put(context.Background(), key, strings.NewReader("%PDF-"), 5, ""); err != nil {
+		t.Fatal(err)
+	}
+	rr = testutil.Do(router, "POST", complete, CompleteUploadRequest{Filename: "scan.pdf", ContentType: "application/pdf"})
+	var created AttachmentResponse
+	json.NewDecoder(rr.Body).Decode(&created)
+	if rr.Code != http.StatusCreated || created.Size != 5 || created.ContentType != "application/pdf" {
+		t.Fatalf("Unexpected completion %d %+v", rr.Code, created)
+	}
+	if rr := testutil.Do(router, "POST", complete, CompleteUploadRequest{Filename: "scan.pdf"}); rr.Code != http.StatusNotFound || decodeErrorCode(t, rr) != apierr.UploadNotFound {
+		t.Errorf("Expected completing twice to find no upload, got %d", rr.Code)
+	}
+	a, err := st.Attachment(context.Background(), 1, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.ObjectKey == key {
+		t.Errorf("Expected the attachment under another key than the presigned one")
+	}
+	if _, err := local.Stat(context.Background(), key); !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("Expected the uploaded object to be deleted, got %v", err)
+	}
+
+	rr = testutil.Do(router, "GET", "/api/v1/persons/1/attachments/1/url", nil)
+	var download DownloadURLResponse
+	json.NewDecoder(rr.Body).Decode(&download)
+	if rr.Code != http.StatusOK || download.URL != "https://files.test/"+a.ObjectKey+"?download=scan.pdf" {
+		t.Errorf("Unexpected download %d %+v", rr.Code, download)
+	}
+}
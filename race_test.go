@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+// slowStore delays every read so that some requests run into their deadline
+// while others complete, exercising the timeout path concurrently.
+type slowStore struct {
+	store.Store
+	delay time.Duration
+}
+
+func (s slowStore) GetPerson(ctx context.Context, id int32) (store.Person, error) {
+	select {
+	case <-time.After(s.delay):
+	case <-ctx.Done():
+		return store.Person{}, ctx.Err()
+	}
+	return s.Store.GetPerson(ctx, id)
+}
+
+// TestConcurrentRequests is meant to be run with -race: it drives every route,
+// the metrics endpoint and the timeout, shedding and limiter paths at once.
+func TestConcurrentRequests(t *testing.T) {
+	cfg := loadConfig()
+	cfg.timeouts.get = 5 * time.Millisecond
+	cfg.maxInFlight = 8
+	cfg.maxQueueWait = time.Millisecond
+	cfg.expensiveMaxConcurrent = 2
+	cfg.expensiveMaxQueue = 1
+
+	app := newApplication(cfg, nil)
+	app.store = slowStore{Store: testutil.NewMemoryStore(testutil.NewFactory(7).Persons(20)...), delay: 4 * time.Millisecond}
+	router := app.routes()
+
+	var wg sync.WaitGroup
+	for w := 0; w < 16; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				id := fmt.Sprint(1 + (w+i)%20)
+				var rr interface{ Result() *http.Response }
+				switch i % 6 {
+				case 0:
+					rr = testutil.Do(router, "GET", "/api/v1/persons", nil)
+				case 1:
+					rr = testutil.Do(router, "GET", "/api/v1/persons/"+id, nil)
+				case 2:
+					rr = testutil.Do(router, "POST", "/api/v1/persons", PersonRequest{Name: stringPtr("Racer")})
+				case 3:
+					rr = testutil.Do(router, "PATCH", "/api/v1/persons/"+id, PersonRequest{Age: int32Ptr(int32(i))})
+				case 4:
+					rr = testutil.Do(router, "DELETE", "/api/v1/persons/"+id, nil)
+				case 5:
+					rr = testutil.Do(router, "GET", "/metrics", nil)
+				}
+				if code := rr.Result().StatusCode; code >= 500 && code != http.StatusServiceUnavailable && code != http.StatusGatewayTimeout {
+					t.Errorf("Unexpected status %d", code)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
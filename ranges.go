@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// byteRange is the part of a file a download is asked for.
+type byteRange struct {
+	start, length int64
+}
+
+// errUnsatisfiable is a Range that starts beyond the end of the file.
+var errUnsatisfiable = errors.New("range not satisfiable")
+
+// parseRange reads a Range header for a file of size bytes. ok is false when
+// the whole file is served instead: without the header, for one that is not
+// understood, and for several ranges, which viewers resuming or seeking
+// never ask for.
+func parseRange(header string, size int64) (_ byteRange, ok bool, _ error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return byteRange{}, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return byteRange{}, false, nil
+	}
+	if first == "" {
+		// The last n bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return byteRange{}, false, nil
+		}
+		if n == 0 || size == 0 {
+			return byteRange{}, false, errUnsatisfiable
+		}
+		n = min(n, size)
+		return byteRange{start: size - n, length: n}, true, nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return byteRange{}, false, nil
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return byteRange{}, false, nil
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return byteRange{}, false, errUnsatisfiable
+	}
+	return byteRange{start: start, length: end - start + 1}, true, nil
+}
+
+// ifRangeMatches reports whether a Range may be honoured under an If-Range
+// header: when there is none, or it names the current version of the file by
+// its strong etag or its modification time. Otherwise the file changed since
+// the client got its first part, and it gets all of it again.
+func ifRangeMatches(ifRange, etag string, modTime time.Time) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		return etag != "" && ifRange == etag
+	}
+	t, err := http.ParseTime(ifRange)
+	return err == nil && t.Equal(modTime.UTC().Truncate(time.Second))
+}
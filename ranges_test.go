@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	testCases := []struct {
+		header  string
+		want    byteRange
+		ok      bool
+		wantErr error
+	}{
+		{"", byteRange{}, false, nil},
+		{"bytes=0-9", byteRange{0, 10}, true, nil},
+		{"bytes=90-", byteRange{90, 10}, true, nil},
+		{"bytes=90-500", byteRange{90, 10}, true, nil},
+		{"bytes=-5", byteRange{95, 5}, true, nil},
+		{"bytes=-500", byteRange{0, 100}, true, nil},
+		{"bytes=100-", byteRange{}, false, errUnsatisfiable},
+		{"bytes=-0", byteRange{}, false, errUnsatisfiable},
+		{"bytes=0-1,5-6", byteRange{}, false, nil},
+		{"bytes=9-5", byteRange{}, false, nil},
+		{"bytes=a-5", byteRange{}, false, nil},
+		{"bytes=5", byteRange{}, false, nil},
+		{"items=0-9", byteRange{}, false, nil},
+	}
+	for _, tc := range testCases {
+		got, ok, err := parseRange(tc.header, 100)
+		if got != tc.want || ok != tc.ok || !errors.Is(err, tc.wantErr) {
+			t.Errorf("%q: expected %v %v %v, got %v %v %v", tc.header, tc.want, tc.ok, tc.wantErr, got, ok, err)
+		}
+	}
+	if _, _, err := parseRange("bytes=-1", 0); !errors.Is(err, errUnsatisfiable) {
+		t.Errorf("Expected no range of an empty file, got %v", err)
+	}
+}
+
+func TestIfRangeMatches(t *testing.T) {
+	modTime := time.Date(2024, 5, 1, 10, 0, 0, 500, time.UTC)
+	testCases := []struct {
+		ifRange string
+		etag    string
+		want    bool
+	}{
+		{"", `"abc"`, true},
+		{`"abc"`, `"abc"`, true},
+		{`"abd"`, `"abc"`, false},
+		{`W/"abc"`, `"abc"`, false},
+		{`"abc"`, "", false},
+		{"Wed, 01 May 2024 10:00:00 GMT", "", true},
+		{"Wed, 01 May 2024 10:00:01 GMT", "", false},
+		{"yesterday", "", false},
+	}
+	for _, tc := range testCases {
+		if got := ifRangeMatches(tc.ifRange, tc.etag, modTime); got != tc.want {
+			t.Errorf("%q against %q: expected %v", tc.ifRange, tc.etag, tc.want)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/redis"
+)
+
+// rateLimiter allows each client limit requests per fixed window. Windows are
+// aligned to the clock, so all counters reset together and the reset time is
+// the same for everyone. Every response carries the current quota both as the
+// de facto X-RateLimit-* headers and as the IETF draft RateLimit-* fields.
+//
+// With shared set, requests are counted there instead, so that replicas
+// enforce one limit together; the local windows only count while it fails.
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+	now    func() time.Time
+	// clientKey identifies the caller; the remote IP by default.
+	clientKey func(r *http.Request) string
+	shared    sharedCounter
+	// sharedDown is set while shared fails, to log only the change.
+	sharedDown atomic.Bool
+
+	mu     sync.Mutex
+	start  time.Time
+	counts map[string]int
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	if limit <= 0 || window <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		limit:     limit,
+		window:    window,
+		now:       time.Now,
+		clientKey: remoteIP,
+		counts:    map[string]int{},
+	}
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// sharedCounter counts requests for all replicas. Take counts a request for
+// key unless limit were taken within the window, and reports how many remain
+// and how long until one more is allowed.
+type sharedCounter interface {
+	Take(ctx context.Context, key string, limit int, window time.Duration) (ok bool, remaining int, resetIn time.Duration, err error)
+}
+
+// take counts a request for key and reports whether it is allowed, how many
+// remain and when the window resets.
+func (l *rateLimiter) take(ctx context.Context, key string) (ok bool, remaining int, reset time.Time) {
+	if l.shared != nil {
+		ok, remaining, resetIn, err := l.shared.Take(ctx, key, l.limit, l.window)
+		if err == nil {
+			if l.sharedDown.CompareAndSwap(true, false) {
+				slog.InfoContext(ctx, "shared rate limits restored")
+			}
+			return ok, remaining, l.now().Add(resetIn)
+		}
+		if !l.sharedDown.Swap(true) {
+			slog.WarnContext(ctx, "shared rate limits unavailable, limiting per instance", "err", err)
+		}
+	}
+	return l.takeLocal(key)
+}
+
+func (l *rateLimiter) takeLocal(key string) (ok bool, remaining int, reset time.Time) {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if start := now.Truncate(l.window); !start.Equal(l.start) {
+		l.start = start
+		clear(l.counts)
+	}
+	reset = l.start.Add(l.window)
+	if l.counts[key] >= l.limit {
+		return false, 0, reset
+	}
+	l.counts[key]++
+	return true, l.limit - l.counts[key], reset
+}
+
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, remaining, reset := l.take(r.Context(), l.clientKey(r))
+		resetIn := int(reset.Sub(l.now()).Round(time.Second) / time.Second)
+		h := w.Header()
+		h.Set("X-RateLimit-Limit", strconv.Itoa(l.limit))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		h.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		h.Set("RateLimit-Limit", strconv.Itoa(l.limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(resetIn))
+		h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", l.limit, int(l.window/time.Second)))
+		if !ok {
+			h.Set("Retry-After", strconv.Itoa(max(resetIn, 1)))
+			sendProblem(w, r, apierr.TooManyRequests, "rate limit exceeded, retry after the window resets")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// slidingWindow keeps the times of each client's requests within the window
+// in a Redis sorted set, so the limit holds over any window-long span rather
+// than per aligned window. Times come from the Redis clock, so replicas need
+// not agree on theirs; reading it before writing needs Redis 5 or later.
+var slidingWindow = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local window = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', KEYS[1], now, now .. '-' .. ARGV[3])
+	count = count + 1
+	allowed = 1
+end
+redis.call('PEXPIRE', KEYS[1], math.ceil(window / 1000))
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+local resetIn = window
+if oldest[2] then
+	resetIn = tonumber(oldest[2]) + window - now
+end
+return {allowed, count, resetIn}
+`)
+
+// redisCounter is a sharedCounter over a sliding window in Redis.
+type redisCounter struct {
+	client *redis.Client
+	prefix string
+}
+
+func (c *redisCounter) Take(ctx context.Context, key string, limit int, window time.Duration) (bool, int, time.Duration, error) {
+	// Two requests in the same microsecond must not be one member.
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+	reply, err := slidingWindow.Run(ctx, c.client, []string{c.prefix + key},
+		window.Microseconds(), limit, hex.EncodeToString(nonce))
+	if err != nil {
+		return false, 0, 0, err
+	}
+	values, _ := reply.([]any)
+	if len(values) != 3 {
+		return false, 0, 0, fmt.Errorf("unexpected rate limit reply %v", reply)
+	}
+	allowed, ok1 := values[0].(int64)
+	count, ok2 := values[1].(int64)
+	resetIn, ok3 := values[2].(int64)
+	if !ok1 || !ok2 || !ok3 {
+		return false, 0, 0, fmt.Errorf("unexpected rate limit reply %v", reply)
+	}
+	return allowed == 1, max(limit-int(count), 0), time.Duration(resetIn) * time.Microsecond, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, time.Minute)
+	now := time.Date(2024, 3, 1, 12, 0, 15, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	h := l.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	do := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/persons", nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	testCases := []struct {
+		remoteAddr    string
+		wantCode      int
+		wantRemaining string
+	}{
+		{"10.0.0.1:1000", http.StatusOK, "1"},
+		{"10.0.0.1:1001", http.StatusOK, "0"},
+		{"10.0.0.1:1002", http.StatusTooManyRequests, "0"},
+		{"10.0.0.2:1000", http.StatusOK, "1"},
+	}
+	for _, tc := range testCases {
+		rr := do(tc.remoteAddr)
+		if rr.Code != tc.wantCode || rr.Header().Get("X-RateLimit-Remaining") != tc.wantRemaining {
+			t.Errorf("%s: expected %d with %s remaining, got %d with %s",
+				tc.remoteAddr, tc.wantCode, tc.wantRemaining, rr.Code, rr.Header().Get("X-RateLimit-Remaining"))
+		}
+	}
+
+	rr := do("10.0.0.1:1003")
+	want := map[string]string{
+		"X-RateLimit-Limit": "2",
+		"X-RateLimit-Reset": "1709294460",
+		"RateLimit-Limit":   "2",
+		"RateLimit-Reset":   "45",
+		"RateLimit-Policy":  "2;w=60",
+		"Retry-After":       "45",
+	}
+	for k, v := range want {
+		if got := rr.Header().Get(k); got != v {
+			t.Errorf("Expected %s %q, got %q", k, v, got)
+		}
+	}
+
+	now = now.Add(time.Minute)
+	if rr := do("10.0.0.1:1004"); rr.Code != http.StatusOK {
+		t.Errorf("Expected the next window to allow requests again, got %d", rr.Code)
+	}
+}
+
+// fakeCounter is a sharedCounter allowing limit requests per key in total,
+// or failing with err.
+type fakeCounter struct {
+	counts map[string]int
+	err    error
+}
+
+func (c *fakeCounter) Take(ctx context.Context, key string, limit int, window time.Duration) (bool, int, time.Duration, error) {
+	if c.err != nil {
+		return false, 0, 0, c.err
+	}
+	if c.counts[key] >= limit {
+		return false, 0, 10 * time.Second, nil
+	}
+	c.counts[key]++
+	return true, limit - c.counts[key], window, nil
+}
+
+func TestSharedRateLimits(t *testing.T) {
+	// Two replicas sharing one counter.
+	shared := &fakeCounter{counts: map[string]int{}}
+	var handlers []http.Handler
+	for range 2 {
+		l := newRateLimiter(2, time.Minute)
+		l.shared = shared
+		handlers = append(handlers, l.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})))
+	}
+	do := func(replica int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/persons", nil)
+		req.RemoteAddr = "10.0.0.1:1000"
+		rr := httptest.NewRecorder()
+		handlers[replica].ServeHTTP(rr, req)
+		return rr
+	}
+
+	do(0)
+	do(1)
+	rr := do(0)
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "10" {
+		t.Errorf("Expected the third request across replicas to be limited, got %d %v", rr.Code, rr.Header())
+	}
+
+	shared.err = errors.New("connection refused")
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if rr := do(1); rr.Code != want {
+			t.Errorf("Request %d without the shared counter: expected %d from the local limit, got %d", i+1, want, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/logging"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// errorReporter sends panics and 5xx responses to a Sentry-compatible DSN.
+// Events only carry the request ID, method, route template and status: no
+// headers, query strings, bodies or user data. A nil reporter still recovers
+// panics, it just has nowhere to send them but the log.
+type errorReporter struct {
+	client *sentry.Client
+}
+
+func newErrorReporter(opts sentry.ClientOptions) (*errorReporter, error) {
+	if opts.Dsn == "" && opts.Transport == nil {
+		return nil, nil
+	}
+	opts.SendDefaultPII = false
+	opts.BeforeSend = scrubEvent
+	client, err := sentry.NewClient(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create error reporter: %w", err)
+	}
+	return &errorReporter{client: client}, nil
+}
+
+// scrubEvent drops anything the SDK may have attached on its own that could
+// contain personal data.
+func scrubEvent(event *sentry.Event, _ *sentry.EventHint) *sentry.Event {
+	event.Request = nil
+	event.User = sentry.User{}
+	event.Breadcrumbs = nil
+	return event
+}
+
+func (rep *errorReporter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sr := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			r := withResponseIDs(r, sr)
+			slog.ErrorContext(r.Context(), "panic serving request",
+				"route", routeTemplate(r), "request_id", requestIDFromContext(r.Context()), "panic", fmt.Sprint(p))
+			err, ok := p.(error)
+			if !ok {
+				err = fmt.Errorf("panic: %v", p)
+			}
+			rep.capture(r, http.StatusInternalServerError, func(hub *sentry.Hub) {
+				hub.Recover(err)
+			})
+			if sr.status == 0 {
+				sendDebugError(sr, apierr.Internal, "Internal server error", errorDebug(r.Context(), err, 1))
+			}
+		}()
+
+		next.ServeHTTP(sr, r)
+
+		if sr.status >= 500 {
+			r := withResponseIDs(r, sr)
+			rep.capture(r, sr.status, func(hub *sentry.Hub) {
+				hub.CaptureMessage(fmt.Sprintf("%s %s responded %d", r.Method, routeTemplate(r), sr.status))
+			})
+		}
+	})
+}
+
+// withResponseIDs adds the request and trace IDs, which withRequestID and
+// withTraceID only set on requests further down the pipeline, from the
+// response to r.
+func withResponseIDs(r *http.Request, w http.ResponseWriter) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDKey, w.Header().Get("X-Request-ID"))
+	if id := w.Header().Get(traceIDHeader); id != "" {
+		ctx = logging.WithTraceID(ctx, id)
+	}
+	return r.WithContext(ctx)
+}
+
+func (rep *errorReporter) capture(r *http.Request, status int, send func(hub *sentry.Hub)) {
+	if rep == nil {
+		return
+	}
+	hub := sentry.NewHub(rep.client, sentry.NewScope())
+	hub.ConfigureScope(func(scope *sentry.Scope) {
+		scope.SetLevel(sentry.LevelError)
+		scope.SetTag("request_id", requestIDFromContext(r.Context()))
+		scope.SetTag("route", routeTemplate(r))
+		scope.SetTag("method", r.Method)
+		scope.SetTag("status", strconv.Itoa(status))
+		if id := logging.TraceID(r.Context()); id != "" {
+			scope.SetTag("trace_id", id)
+		}
+	})
+	send(hub)
+}
+
+// flush waits for queued events to be delivered, for use before exiting.
+func (rep *errorReporter) flush(timeout time.Duration) {
+	if rep == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	rep.client.FlushWithContext(ctx)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/gorilla/mux"
+)
+
+// recordingTransport keeps events in memory instead of sending them.
+type recordingTransport struct {
+	mu     sync.Mutex
+	events []*sentry.Event
+}
+
+func (t *recordingTransport) Configure(sentry.ClientOptions)        {}
+func (t *recordingTransport) Flush(time.Duration) bool              { return true }
+func (t *recordingTransport) FlushWithContext(context.Context) bool { return true }
+func (t *recordingTransport) Close()                                {}
+
+func (t *recordingTransport) SendEvent(e *sentry.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, e)
+}
+
+func (t *recordingTransport) Events() []*sentry.Event {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*sentry.Event(nil), t.events...)
+}
+
+func newRecordingReporter(t *testing.T) (*errorReporter, *recordingTransport) {
+	transport := &recordingTransport{}
+	rep, err := newErrorReporter(sentry.ClientOptions{Transport: transport})
+	if err != nil {
+		t.Fatalf("Failed to create reporter: %v", err)
+	}
+	return rep, transport
+}
+
+func TestReporterCapturesServerErrors(t *testing.T) {
+	st := testutil.NewMemoryStore(testutil.NewFactory(1).Persons(1)...)
+	st.Err = errors.New("boom")
+	app := newTestAppWithStore(st)
+	app.reporter, _ = newRecordingReporter(t)
+	transport := app.reporter.client.Transport.(*recordingTransport)
+	router := app.routes()
+
+	req := httptest.NewRequest("GET", "/api/v1/persons/1?name=Ivan", nil)
+	req.Header.Set("X-Request-ID", "req-42")
+	req.Header.Set("Authorization", "Bearer secret")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected 500, got %d", rr.Code)
+	}
+
+	events := transport.Events()
+	if len(events) != 1 {
+		t.Fatalf("Expected one event, got %d", len(events))
+	}
+	e := events[0]
+	if e.Tags["request_id"] != "req-42" || e.Tags["route"] != "/api/v1/persons/{id}" || e.Tags["status"] != "500" {
+		t.Errorf("Unexpected tags: %v", e.Tags)
+	}
+	if e.Request != nil || e.User.ID != "" {
+		t.Errorf("Event carries request or user data: %+v %+v", e.Request, e.User)
+	}
+
+	st.Err = nil
+	testutil.Do(router, "GET", "/api/v1/persons/1", nil)
+	testutil.Do(router, "GET", "/api/v1/persons/404", nil)
+	if n := len(transport.Events()); n != 1 {
+		t.Errorf("Expected 2xx and 4xx responses not to be reported, got %d events", n)
+	}
+}
+
+func TestReporterRecoversPanics(t *testing.T) {
+	testCases := []struct {
+		name     string
+		reporter bool
+	}{
+		{"With reporter", true},
+		{"Without reporter", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var rep *errorReporter
+			var transport *recordingTransport
+			if tc.reporter {
+				rep, transport = newRecordingReporter(t)
+			}
+			r := mux.NewRouter()
+			r.Use(withRequestID)
+			r.Use(rep.middleware)
+			r.HandleFunc("/boom", func(http.ResponseWriter, *http.Request) { panic("boom") })
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, httptest.NewRequest("GET", "/boom", nil))
+			if rr.Code != http.StatusInternalServerError || decodeErrorCode(t, rr) != apierr.Internal {
+				t.Errorf("Expected 500 INTERNAL_ERROR, got %d", rr.Code)
+			}
+			if rr.Header().Get("X-Request-ID") == "" {
+				t.Error("Expected a generated X-Request-ID")
+			}
+			if tc.reporter {
+				events := transport.Events()
+				if len(events) != 1 || len(events[0].Exception) == 0 {
+					t.Fatalf("Expected one exception event, got %+v", events)
+				}
+			}
+		})
+	}
+}
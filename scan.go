@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/scan"
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+const (
+	scannerClamAV = "clamav"
+
+	// quarantinePrefix is where infected uploads are moved, out of reach of
+	// the API and of the orphan sweeper, for security to inspect.
+	quarantinePrefix = "quarantine/"
+)
+
+// malwareError refuses an upload the scanner found infected.
+type malwareError struct {
+	signature string
+}
+
+func (e *malwareError) Error() string { return "infected with " + e.signature }
+
+func newScanner(cfg config) scan.Scanner {
+	if cfg.malwareScanner == scannerClamAV {
+		return scan.NewClamAV(cfg.clamavAddr, cfg.clamavTimeout)
+	}
+	return nil
+}
+
+// scanUpload runs the malware scanner over the uploaded object of a before
+// its attachment is created. An infected object is moved to quarantine and
+// refused with a *malwareError. Without a scanner every upload passes.
+func (app *application) scanUpload(ctx context.Context, a store.Attachment) error {
+	if app.scanner == nil {
+		return nil
+	}
+	body, err := app.blobs.Get(ctx, a.ObjectKey)
+	if err != nil {
+		return err
+	}
+	verdict, err := app.scanner.Scan(ctx, body)
+	body.Close()
+	if err != nil {
+		return err
+	}
+	if !verdict.Infected {
+		return nil
+	}
+	slog.WarnContext(ctx, "quarantined infected upload", "person_id", *a.PersonID, "filename", a.Filename,
+		"signature", verdict.Signature, "key", quarantinePrefix+a.ObjectKey)
+	if err := app.quarantine(ctx, a); err != nil {
+		slog.ErrorContext(ctx, "failed to quarantine infected upload", "key", a.ObjectKey, "err", err)
+	}
+	return &malwareError{signature: verdict.Signature}
+}
+
+// quarantine moves the object of a under quarantinePrefix.
+func (app *application) quarantine(ctx context.Context, a store.Attachment) error {
+	ctx = context.WithoutCancel(ctx)
+	body, err := app.blobs.Get(ctx, a.ObjectKey)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if err := app.blobs.Put(ctx, quarantinePrefix+a.ObjectKey, body, a.Size, a.ContentType); err != nil {
+		return err
+	}
+	return app.blobs.Delete(ctx, a.ObjectKey)
+}
+
+// sendUploadError answers for an upload inspectUpload or scanUpload refused. Uploads are
+// refused, not let through, while the scanner is unavailable.
+func sendUploadError(w http.ResponseWriter, r *http.Request, err error) {
+	var merr *malwareError
+	var rejected *rejectedUpload
+	switch {
+	case errors.As(err, &rejected):
+		sendError(w, rejected.code, rejected.message)
+	case errors.As(err, &merr):
+		sendError(w, apierr.MalwareDetected, fmt.Sprintf("The file is infected with %s and was quarantined", merr.signature))
+	case errors.Is(err, scan.ErrUnavailable):
+		slog.ErrorContext(r.Context(), "malware scan failed", "err", err)
+		w.Header().Set("Retry-After", "60")
+		sendDebugError(w, apierr.ScannerUnavailable, "The file could not be scanned for malware, retry later", errorDebug(r.Context(), err, 1))
+	default:
+		sendStorageError(w, r, err)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/blob"
+	"ci_cd/rsoi_lab_1/internal/scan"
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+// fakeScanner finds files containing "EICAR", or fails with err.
+type fakeScanner struct {
+	err error
+}
+
+func (s *fakeScanner) Scan(ctx context.Context, r io.Reader) (scan.Verdict, error) {
+	if s.err != nil {
+		return scan.Verdict{}, s.err
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return scan.Verdict{}, err
+	}
+	if strings.Contains(string(b), "EICAR") {
+		return scan.Verdict{Infected: true, Signature: "Eicar-Test-Signature"}, nil
+	}
+	return scan.Verdict{}, nil
+}
+
+func TestScanUploads(t *testing.T) {
+	st := testutil.NewMemoryStore(store.Person{Name: "Ann"})
+	app := newTestAppWithStore(st)
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	scanner := &fakeScanner{}
+	app.blobs, app.attachments, app.scanner = blobs, st, scanner
+	app.cfg.attachmentMaxSize = 1 << 10
+	router := withContractCheck(t, app.routes())
+
+	upload := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/persons/1/attachments?filename=a.pdf", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/pdf")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	objects := func(prefix string) (keys []string) {
+		blobs.List(context.Background(), prefix, func(o blob.Object) error {
+			keys = append(keys, o.Key)
+			return nil
+		})
+		return keys
+	}
+
+	if rr := upload("%PDF-clean"); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected a clean file to be stored, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr := upload("%PDF-X5O EICAR")
+	if rr.Code != http.StatusUnprocessableEntity || decodeErrorCode(t, rr) != apierr.MalwareDetected {
+		t.Fatalf("Expected an infected file to be refused, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if keys := objects(attachmentPrefix); len(keys) != 1 {
+		t.Errorf("Expected only the clean object to be left, got %v", keys)
+	}
+	if keys := objects(quarantinePrefix); len(keys) != 1 {
+		t.Errorf("Expected the infected object in quarantine, got %v", keys)
+	}
+	if _, err := st.Attachment(context.Background(), 1, 2); err == nil {
+		t.Error("Expected no attachment for the infected file")
+	}
+
+	scanner.err = fmt.Errorf("%w: connection refused", scan.ErrUnavailable)
+	rr = upload("%PDF-clean")
+	if rr.Code != http.StatusServiceUnavailable || decodeErrorCode(t, rr) != apierr.ScannerUnavailable || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected uploads to be refused without the scanner, got %d %v", rr.Code, rr.Header())
+	}
+	if keys := objects(attachmentPrefix); len(keys) != 1 {
+		t.Errorf("Expected the unscanned object to be discarded, got %v", keys)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+// PersonSchema describes the fields of a person for clients that build their
+// forms and filters from it.
+type PersonSchema struct {
+	Fields []SchemaField `json:"fields"`
+}
+
+type SchemaField struct {
+	Name string `json:"name"`
+	// Type is one of the custom field types, which built-in fields share.
+	Type string `json:"type"`
+	// Format narrows a string, e.g. to an email address.
+	Format string `json:"format,omitempty"`
+	// Custom fields are written and returned under PersonResponse.Custom.
+	Custom   bool `json:"custom"`
+	Required bool `json:"required"`
+	// ReadOnly fields are returned but not written.
+	ReadOnly    bool                   `json:"read_only"`
+	Constraints store.FieldConstraints `json:"constraints"`
+	Sortable    bool                   `json:"sortable"`
+	// Filters are the list parameters that filter on the field.
+	Filters []string `json:"filters"`
+}
+
+// builtinSchema mirrors validatePersonRequest and parseListFilter.
+func builtinSchema() []SchemaField {
+	limits := func(min, max float64) store.FieldConstraints { return store.FieldConstraints{Min: &min, Max: &max} }
+	maxLength := func(n int) store.FieldConstraints { return store.FieldConstraints{MaxLength: &n} }
+	fields := []SchemaField{
+		{Name: "id", Type: store.FieldInteger, ReadOnly: true},
+		{Name: "name", Type: store.FieldString, Required: true, Constraints: maxLength(maxNameLength), Filters: []string{"name"}},
+		{Name: "age", Type: store.FieldInteger, Constraints: limits(minAge, maxAge), Filters: []string{"min_age", "max_age"}},
+		{Name: "address", Type: store.FieldString, Constraints: maxLength(maxTextLength)},
+		{Name: "work", Type: store.FieldString, Constraints: maxLength(maxTextLength)},
+		{Name: "latitude", Type: store.FieldNumber, Constraints: limits(-90, 90)},
+		{Name: "longitude", Type: store.FieldNumber, Constraints: limits(-180, 180)},
+		{Name: "phone", Type: store.FieldString, Format: "phone"},
+		{Name: "phone_region", Type: store.FieldString, ReadOnly: true},
+		{Name: "email", Type: store.FieldString, Format: "email", Constraints: maxLength(maxEmailLength)},
+		{Name: "updated_at", Type: store.FieldString, Format: "date-time", ReadOnly: true},
+	}
+	for i := range fields {
+		fields[i].Sortable = slices.Contains(store.SortFields, fields[i].Name)
+		if fields[i].Filters == nil {
+			fields[i].Filters = []string{}
+		}
+	}
+	return fields
+}
+
+func (app *application) getPersonSchema(w http.ResponseWriter, r *http.Request) {
+	schema := PersonSchema{Fields: builtinSchema()}
+	if app.customFields != nil {
+		custom, err := app.customFields.CustomFields(r.Context())
+		if err != nil {
+			sendStoreError(w, r, err)
+			return
+		}
+		for _, f := range custom {
+			schema.Fields = append(schema.Fields, SchemaField{
+				Name: f.Name, Type: f.Type, Custom: true, Required: f.Required, Constraints: f.Constraints, Filters: []string{},
+			})
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schema)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestPersonSchema(t *testing.T) {
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	app.customFields = testutil.NewMemoryFieldStore(store.CustomField{Name: "badge", Type: store.FieldInteger, Required: true})
+	router := withContractCheck(t, app.routes())
+
+	rr := testutil.Do(router, "GET", "/api/v1/schema/person", nil)
+	var schema PersonSchema
+	if err := json.NewDecoder(rr.Body).Decode(&schema); err != nil || rr.Code != http.StatusOK {
+		t.Fatalf("Expected the schema, got %d %v", rr.Code, err)
+	}
+	fields := map[string]SchemaField{}
+	for _, f := range schema.Fields {
+		fields[f.Name] = f
+	}
+	if f := fields["name"]; !f.Required || !f.Sortable || f.Constraints.MaxLength == nil || *f.Constraints.MaxLength != maxNameLength {
+		t.Errorf("Expected name required, sortable and limited in length, got %+v", f)
+	}
+	if f := fields["age"]; !f.Sortable || len(f.Filters) != 2 || f.Constraints.Max == nil || *f.Constraints.Max != maxAge {
+		t.Errorf("Expected age sortable, filtered by min_age and max_age, got %+v", f)
+	}
+	if f := fields["updated_at"]; !f.ReadOnly || f.Sortable {
+		t.Errorf("Expected updated_at read-only, got %+v", f)
+	}
+	if f, ok := fields["badge"]; !ok || !f.Custom || !f.Required || f.Type != store.FieldInteger {
+		t.Errorf("Expected the custom field, got %+v", f)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"html"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/logging"
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+const maxSearchLength = 255
+
+type SearchHitResponse struct {
+	PersonResponse
+	// Highlights has an HTML-escaped excerpt of every field the query matched
+	// in, with the matches wrapped in <mark>.
+	Highlights map[string]string `json:"highlights,omitempty"`
+	Score      float32           `json:"_score"`
+}
+
+// searchSorts are the orders ?sort= accepts for search.
+var searchSorts = []string{"id", "relevance"}
+
+var highlightMarkup = strings.NewReplacer(store.HighlightStart, "<mark>", store.HighlightStop, "</mark>")
+
+func toSearchHitResponse(h store.SearchHit) SearchHitResponse {
+	resp := SearchHitResponse{PersonResponse: toPersonResponse(h.Person), Score: h.Score}
+	for field, excerpt := range h.Highlights {
+		if resp.Highlights == nil {
+			resp.Highlights = map[string]string{}
+		}
+		resp.Highlights[field] = highlightMarkup.Replace(html.EscapeString(excerpt))
+	}
+	return resp
+}
+
+func (app *application) searchPersons(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var errs []apierr.FieldError
+	query := store.SearchQuery{Text: strings.TrimSpace(q.Get("q"))}
+	if query.Text == "" {
+		errs = append(errs, apierr.NewFieldError("q", apierr.KeyRequired, nil))
+	}
+	errs = appendMaxLength(errs, "q", &query.Text, maxSearchLength)
+	switch sort := q.Get("sort"); sort {
+	case "", "id":
+	case "relevance":
+		query.ByRelevance = true
+	default:
+		errs = append(errs, apierr.NewFieldError("sort", apierr.KeyOneOf, map[string]any{
+			"allowed": strings.Join(searchSorts, ", "),
+			"actual":  sort,
+		}))
+	}
+	query.Limit, query.Offset, errs = parsePage(q, app.cfg.page, errs)
+	if len(errs) > 0 {
+		sendValidationError(w, apierr.ValidationFailed, "Invalid query parameters", errs)
+		return
+	}
+
+	slog.DebugContext(r.Context(), "searching persons", "query", logging.Redact(query.Text), "limit", query.Limit, "offset", query.Offset)
+	res, err := app.search.SearchPersons(r.Context(), query)
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	resp := make([]SearchHitResponse, 0, len(res.Hits))
+	for _, h := range res.Hits {
+		resp = append(resp, toSearchHitResponse(h))
+	}
+	// Unlike the list, search knows its total, so a page that happens to end
+	// exactly at the last match gets no next link.
+	if res.Total >= 0 {
+		w.Header().Set("X-Total-Count", strconv.Itoa(res.Total))
+	}
+	if len(res.Hits) > 0 && query.Offset+len(res.Hits) < res.Total {
+		w.Header().Set("Link", "<"+nextPageURL(r, query.Limit, query.Offset)+`>; rel="next"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		sendError(w, apierr.Internal, "Encoding error")
+	}
+}
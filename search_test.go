@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestSearchPersons(t *testing.T) {
+	st := testutil.NewMemoryStore(
+		store.Person{Name: "Ann Lee", Address: stringPtr("12 Main Street")},
+		store.Person{Name: "Bob <b>", Work: stringPtr("Main & Co")},
+		store.Person{Name: "Carl Main", Address: stringPtr("Main Main Street")},
+	)
+	app := newTestAppWithStore(st)
+	app.search = st
+	router := withContractCheck(t, app.routes())
+
+	testCases := []struct {
+		name      string
+		target    string
+		wantCode  int
+		wantIDs   []int32
+		wantTotal string
+		wantNext  bool
+	}{
+		{"Across fields", "/api/v1/persons/search?q=main", http.StatusOK, []int32{1, 2, 3}, "3", false},
+		{"All words", "/api/v1/persons/search?q=ann+main", http.StatusOK, []int32{1}, "1", false},
+		{"Whole words only", "/api/v1/persons/search?q=mai", http.StatusOK, []int32{}, "0", false},
+		{"By relevance", "/api/v1/persons/search?q=main&sort=relevance", http.StatusOK, []int32{3, 1, 2}, "3", false},
+		{"First page", "/api/v1/persons/search?q=main&limit=2", http.StatusOK, []int32{1, 2}, "3", true},
+		{"Last page", "/api/v1/persons/search?q=main&limit=2&offset=2", http.StatusOK, []int32{3}, "3", false},
+		{"Past the end", "/api/v1/persons/search?q=main&offset=5", http.StatusOK, []int32{}, "", false},
+		{"Missing query", "/api/v1/persons/search", http.StatusBadRequest, nil, "", false},
+		{"Unknown sort", "/api/v1/persons/search?q=main&sort=name", http.StatusBadRequest, nil, "", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", tc.target, nil))
+			if rr.Code != tc.wantCode {
+				t.Fatalf("Expected %d, got %d: %s", tc.wantCode, rr.Code, rr.Body.String())
+			}
+			if got := rr.Header().Get("X-Total-Count"); got != tc.wantTotal {
+				t.Errorf("Expected total %q, got %q", tc.wantTotal, got)
+			}
+			if next := rr.Header().Get("Link"); (next != "") != tc.wantNext {
+				t.Errorf("Expected next link %v, got %q", tc.wantNext, next)
+			}
+			if tc.wantIDs == nil {
+				return
+			}
+			var hits []SearchHitResponse
+			json.NewDecoder(rr.Body).Decode(&hits)
+			if len(hits) != len(tc.wantIDs) {
+				t.Fatalf("Expected %v, got %+v", tc.wantIDs, hits)
+			}
+			for i, h := range hits {
+				if h.ID != tc.wantIDs[i] || h.Score <= 0 {
+					t.Errorf("Expected %v with scores, got %+v", tc.wantIDs, hits)
+				}
+			}
+		})
+	}
+}
+
+func TestSearchHighlights(t *testing.T) {
+	hit := toSearchHitResponse(store.SearchHit{
+		Person: store.Person{ID: 1, Name: "Bob <b>"},
+		Highlights: map[string]string{
+			"name": store.HighlightStart + "Bob" + store.HighlightStop + " <b>",
+		},
+	})
+	if want := "<mark>Bob</mark> &lt;b&gt;"; hit.Highlights["name"] != want {
+		t.Errorf("Expected %q, got %q", want, hit.Highlights["name"])
+	}
+	if hit.Name != "Bob <b>" {
+		t.Errorf("Expected the plain name left alone, got %q", hit.Name)
+	}
+}
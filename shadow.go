@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/metrics"
+)
+
+const (
+	// shadowMaxBody bounds the responses compared; larger ones are not
+	// mirrored.
+	shadowMaxBody = 1 << 20
+	// shadowMaxConcurrent bounds the mirrored requests waiting on the
+	// secondary; reads beyond are not mirrored rather than queued.
+	shadowMaxConcurrent = 16
+	shadowTimeout       = 10 * time.Second
+	// shadowMaxDiffs is how many of the latest differences are kept.
+	shadowMaxDiffs = 100
+	// shadowMaxFields bounds the differing fields listed for one response.
+	shadowMaxFields = 20
+
+	shadowHeader = "X-Shadow-Request"
+)
+
+// ShadowDiff is a mirrored request whose response differed from the one
+// served, or that failed.
+type ShadowDiff struct {
+	Time          time.Time `json:"time"`
+	Method        string    `json:"method"`
+	URI           string    `json:"uri"`
+	Route         string    `json:"route"`
+	PrimaryStatus int       `json:"primary_status"`
+	ShadowStatus  int       `json:"shadow_status,omitempty"`
+	// Fields are the JSON paths whose values differ; "$" for the whole body
+	// when either is not JSON.
+	Fields []string `json:"fields,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+type ShadowReport struct {
+	Target     string  `json:"target"`
+	SampleRate float64 `json:"sample_rate"`
+	Mirrored   int     `json:"mirrored"`
+	Matched    int     `json:"matched"`
+	Mismatched int     `json:"mismatched"`
+	Failed     int     `json:"failed"`
+	// Skipped are sampled reads not mirrored, because the response was too
+	// large or too many mirrored requests were pending.
+	Skipped int `json:"skipped"`
+	// Diffs are the latest differences and failures, newest first.
+	Diffs []ShadowDiff `json:"diffs"`
+}
+
+// shadowMirror sends a sample of API reads again to a secondary deployment
+// after serving them, and compares its responses with those served. Clients
+// never wait for the secondary nor see its responses. Mirrored requests carry
+// the client's headers, credentials included, the request ID of the original
+// and the X-Shadow-Request header.
+type shadowMirror struct {
+	target string
+	rate   float64
+	client *http.Client
+	random func() float64
+	slots  chan struct{}
+	// results counts mirrored requests by outcome, if set.
+	results *metrics.CounterVec
+	// pending tracks the comparisons in progress.
+	pending sync.WaitGroup
+
+	mu     sync.Mutex
+	report ShadowReport
+}
+
+func newShadowMirror(target string, rate float64) *shadowMirror {
+	if target == "" || rate <= 0 {
+		return nil
+	}
+	target = strings.TrimSuffix(target, "/")
+	return &shadowMirror{
+		target: target,
+		rate:   rate,
+		client: &http.Client{Timeout: shadowTimeout},
+		random: rand.Float64,
+		slots:  make(chan struct{}, shadowMaxConcurrent),
+		report: ShadowReport{Target: target, SampleRate: rate, Diffs: []ShadowDiff{}},
+	}
+}
+
+// shadowRecorder keeps a copy of the response body, up to shadowMaxBody.
+type shadowRecorder struct {
+	statusRecorder
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (sr *shadowRecorder) Write(p []byte) (int, error) {
+	if !sr.truncated {
+		if sr.body.Len()+len(p) > shadowMaxBody {
+			sr.truncated = true
+			sr.body.Reset()
+		} else {
+			sr.body.Write(p)
+		}
+	}
+	return sr.statusRecorder.Write(p)
+}
+
+func (m *shadowMirror) middleware(next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || r.Header.Get(shadowHeader) != "" || m.random() >= m.rate {
+			next.ServeHTTP(w, r)
+			return
+		}
+		sr := &shadowRecorder{statusRecorder: statusRecorder{ResponseWriter: w}}
+		next.ServeHTTP(sr, r)
+		if sr.status == 0 {
+			sr.status = http.StatusOK
+		}
+
+		if sr.truncated {
+			m.record("skipped", nil)
+			return
+		}
+		req, err := http.NewRequest(r.Method, m.target+r.URL.RequestURI(), nil)
+		if err != nil {
+			m.record("skipped", nil)
+			return
+		}
+		req.Header = r.Header.Clone()
+		req.Header.Set(shadowHeader, "1")
+		req.Header.Set("X-Request-ID", requestIDFromContext(r.Context()))
+		diff := ShadowDiff{Method: r.Method, URI: r.URL.RequestURI(), Route: routeTemplate(r), PrimaryStatus: sr.status}
+		select {
+		case m.slots <- struct{}{}:
+		default:
+			m.record("skipped", nil)
+			return
+		}
+		m.pending.Add(1)
+		go func() {
+			defer m.pending.Done()
+			defer func() { <-m.slots }()
+			m.compare(req, diff, sr.body.Bytes())
+		}()
+	})
+}
+
+// compare sends req to the secondary and records how its response differs
+// from the primary's, described by diff and primaryBody.
+func (m *shadowMirror) compare(req *http.Request, diff ShadowDiff, primaryBody []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), shadowTimeout)
+	defer cancel()
+	resp, err := m.client.Do(req.WithContext(ctx))
+	if err != nil {
+		diff.Error = err.Error()
+		m.record("failed", &diff)
+		return
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, shadowMaxBody+1))
+	if err != nil {
+		diff.Error = err.Error()
+		m.record("failed", &diff)
+		return
+	}
+	diff.ShadowStatus = resp.StatusCode
+	diff.Fields = bodyDiff(primaryBody, body)
+	if diff.ShadowStatus == diff.PrimaryStatus && len(diff.Fields) == 0 {
+		m.record("matched", nil)
+		return
+	}
+	slog.Warn("shadow response differs", "method", diff.Method, "uri", diff.URI,
+		"primary_status", diff.PrimaryStatus, "shadow_status", diff.ShadowStatus, "fields", diff.Fields)
+	m.record("mismatched", &diff)
+}
+
+// record counts a sampled read by result, keeping diff if it is one.
+func (m *shadowMirror) record(result string, diff *ShadowDiff) {
+	if m.results != nil {
+		m.results.Inc(result)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch result {
+	case "skipped":
+		m.report.Skipped++
+		return
+	case "matched":
+		m.report.Matched++
+	case "mismatched":
+		m.report.Mismatched++
+	case "failed":
+		m.report.Failed++
+	}
+	m.report.Mirrored++
+	if diff != nil {
+		diff.Time = time.Now().UTC()
+		m.report.Diffs = append(m.report.Diffs, *diff)
+		if len(m.report.Diffs) > shadowMaxDiffs {
+			m.report.Diffs = m.report.Diffs[1:]
+		}
+	}
+}
+
+func (m *shadowMirror) status() ShadowReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := m.report
+	r.Diffs = slices.Clone(m.report.Diffs)
+	slices.Reverse(r.Diffs)
+	return r
+}
+
+// bodyDiff lists the JSON paths where the bodies differ, or "$" when they
+// differ and are not both JSON.
+func bodyDiff(a, b []byte) []string {
+	var va, vb any
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		if bytes.Equal(a, b) {
+			return nil
+		}
+		return []string{"$"}
+	}
+	var fields []string
+	jsonDiff("$", va, vb, &fields)
+	return fields
+}
+
+func jsonDiff(path string, a, b any, fields *[]string) {
+	if len(*fields) >= shadowMaxFields {
+		return
+	}
+	switch a := a.(type) {
+	case map[string]any:
+		b, ok := b.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(a)+len(b))
+		for k := range a {
+			keys = append(keys, k)
+		}
+		for k := range b {
+			if _, ok := a[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			jsonDiff(path+"."+k, a[k], b[k], fields)
+		}
+		return
+	case []any:
+		b, ok := b.([]any)
+		if !ok || len(a) != len(b) {
+			break
+		}
+		for i := range a {
+			jsonDiff(fmt.Sprintf("%s[%d]", path, i), a[i], b[i], fields)
+		}
+		return
+	}
+	if !reflect.DeepEqual(a, b) {
+		*fields = append(*fields, path)
+	}
+}
+
+// getShadow reports how mirrored reads compared.
+func (app *application) getShadow(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(app.shadow.status())
+}
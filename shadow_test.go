@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestShadowTraffic(t *testing.T) {
+	work := "nurse"
+	primary := testutil.NewMemoryStore(store.Person{Name: "Ann"}, store.Person{Name: "Bob", Work: &work})
+	secondary := testutil.NewMemoryStore(store.Person{Name: "Ann"}, store.Person{Name: "Bobby"})
+	var mirrored []*http.Request
+	canary := newTestAppWithStore(secondary).routes()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrored = append(mirrored, r)
+		canary.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	app := newTestAppWithStore(primary)
+	app.cfg.adminToken = "s3cret"
+	app.shadow = newShadowMirror(srv.URL+"/", 1)
+	router := app.routes()
+	do := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		app.shadow.pending.Wait()
+		return rr
+	}
+
+	if rr := do("GET", "/api/v1/persons/1"); rr.Code != http.StatusOK {
+		t.Fatalf("Expected the primary's response, got %d", rr.Code)
+	}
+	if rr := do("GET", "/api/v1/persons/2"); rr.Code != http.StatusOK || !json.Valid(rr.Body.Bytes()) {
+		t.Fatalf("Expected the primary's response, got %d", rr.Code)
+	}
+	do("DELETE", "/api/v1/persons/1")
+	do("GET", "/api/v1/persons/1")
+	if len(mirrored) != 3 || mirrored[0].Header.Get(shadowHeader) != "1" || mirrored[0].Header.Get("X-Request-ID") == "" {
+		t.Fatalf("Expected the three reads mirrored with their request IDs, got %d", len(mirrored))
+	}
+
+	var report ShadowReport
+	json.NewDecoder(do("GET", "/admin/shadow").Body).Decode(&report)
+	if report.Mirrored != 3 || report.Matched != 1 || report.Mismatched != 2 || len(report.Diffs) != 2 {
+		t.Fatalf("Expected one match and two mismatches, got %+v", report)
+	}
+	if d := report.Diffs[0]; d.URI != "/api/v1/persons/1" || d.PrimaryStatus != http.StatusNotFound || d.ShadowStatus != http.StatusOK {
+		t.Errorf("Expected the person deleted on the primary only to be the latest diff, got %+v", d)
+	}
+	if d := report.Diffs[1]; d.Route != "/api/v1/persons/{id}" || !slices.Equal(d.Fields, []string{"$.name", "$.work"}) {
+		t.Errorf("Expected name and work to differ, got %+v", d)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/imaging"
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+// allowedTypes are the types attachments may have, as detected from their
+// content, with the filename extensions each may be named with.
+var allowedTypes = map[string][]string{
+	"image/jpeg":      {".jpg", ".jpeg"},
+	"image/png":       {".png"},
+	"image/gif":       {".gif"},
+	"image/webp":      {".webp"},
+	"application/pdf": {".pdf"},
+}
+
+// sniffLength is how much of a file http.DetectContentType looks at.
+const sniffLength = 512
+
+// rejectedUpload refuses a file for what it contains.
+type rejectedUpload struct {
+	code    apierr.Code
+	message string
+}
+
+func (e *rejectedUpload) Error() string { return e.message }
+
+func mediaType(contentType string) string {
+	mt, _, _ := mime.ParseMediaType(contentType)
+	return mt
+}
+
+// inspectUpload detects the type of the uploaded object of a from its
+// content instead of trusting the client. The type must be allowed and
+// agree with the declared Content-Type and the filename extension, and
+// images must be within the configured dimensions. Files declared without a
+// type get the detected one. Refused files are reported with a
+// *rejectedUpload.
+func (app *application) inspectUpload(ctx context.Context, a store.Attachment) (store.Attachment, error) {
+	body, err := app.blobs.Get(ctx, a.ObjectKey)
+	if err != nil {
+		return a, err
+	}
+	defer body.Close()
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(body, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return a, err
+	}
+	head = head[:n]
+
+	detected := mediaType(http.DetectContentType(head))
+	extensions, ok := allowedTypes[detected]
+	if !ok {
+		return a, &rejectedUpload{apierr.FileTypeNotAllowed, fmt.Sprintf("Files of type %s are not accepted", detected)}
+	}
+	if declared := mediaType(a.ContentType); declared != defaultContentType && declared != detected {
+		return a, &rejectedUpload{apierr.ContentTypeMismatch, fmt.Sprintf("The file was sent as %s but is %s", declared, detected)}
+	}
+	if ext := strings.ToLower(path.Ext(a.Filename)); !slices.Contains(extensions, ext) {
+		return a, &rejectedUpload{apierr.ContentTypeMismatch, fmt.Sprintf("%s files must be named %s", detected, strings.Join(extensions, " or "))}
+	}
+	if mediaType(a.ContentType) == defaultContentType {
+		a.ContentType = detected
+	}
+
+	if strings.HasPrefix(detected, "image/") {
+		cfg, _, err := image.DecodeConfig(io.MultiReader(bytes.NewReader(head), body))
+		// WebP cannot be decoded here and is not checked.
+		if errors.Is(err, image.ErrFormat) {
+			return a, nil
+		}
+		if err != nil {
+			return a, &rejectedUpload{apierr.ContentTypeMismatch, fmt.Sprintf("The file is not a valid %s image", detected)}
+		}
+		if err := (imaging.Limits{MaxDimension: app.cfg.imageMaxDimension}).Check(cfg); err != nil {
+			return a, &rejectedUpload{apierr.PayloadTooLarge, fmt.Sprintf("Images are limited to %d pixels on their longer side", app.cfg.imageMaxDimension)}
+		}
+	}
+	return a, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/blob"
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestInspectUploads(t *testing.T) {
+	st := testutil.NewMemoryStore(store.Person{Name: "Ann"})
+	app := newTestAppWithStore(st)
+	blobs, err := blob.NewLocal(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.blobs, app.attachments = blobs, st
+	app.cfg.attachmentMaxSize = 1 << 20
+	app.cfg.imageMaxDimension = 100
+	router := withContractCheck(t, app.routes())
+
+	testCases := []struct {
+		name        string
+		filename    string
+		contentType string
+		body        string
+		wantCode    apierr.Code
+		wantType    string
+	}{
+		{"png", "a.png", "image/png", testPNG(t, 100, 10), "", "image/png"},
+		{"pdf with upper case extension", "a.PDF", "application/pdf", "%PDF-1.7", "", "application/pdf"},
+		{"undeclared type", "a.pdf", "", "%PDF-1.7", "", "application/pdf"},
+		{"text", "a.txt", "text/plain", "hello", apierr.FileTypeNotAllowed, ""},
+		{"html sent as an image", "a.png", "image/png", "<html><script>", apierr.FileTypeNotAllowed, ""},
+		{"declared type mismatch", "a.pdf", "application/pdf", testPNG(t, 1, 1), apierr.ContentTypeMismatch, ""},
+		{"extension mismatch", "a.png", "application/pdf", "%PDF-1.7", apierr.ContentTypeMismatch, ""},
+		{"no extension", "a", "application/pdf", "%PDF-1.7", apierr.ContentTypeMismatch, ""},
+		{"corrupt image", "a.png", "image/png", "\x89PNG\r\n\x1a\ngarbage", apierr.ContentTypeMismatch, ""},
+		{"too large image", "a.png", "image/png", testPNG(t, 10, 101), apierr.PayloadTooLarge, ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/v1/persons/1/attachments?filename="+tc.filename, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+			if tc.wantCode != "" {
+				if rr.Code == http.StatusCreated || decodeErrorCode(t, rr) != tc.wantCode {
+					t.Errorf("Expected %s, got %d: %s", tc.wantCode, rr.Code, rr.Body.String())
+				}
+				return
+			}
+			var created AttachmentResponse
+			json.NewDecoder(rr.Body).Decode(&created)
+			if rr.Code != http.StatusCreated || created.ContentType != tc.wantType {
+				t.Errorf("Expected a %s attachment, got %d %+v", tc.wantType, rr.Code, created)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+)
+
+// staticReserved are prefixes that belong to the service, so a typo there is
+// a JSON 404 rather than the frontend's index page.
+var staticReserved = []string{"/api/", "/admin/", "/ui/"}
+
+// staticHandler serves a single-page frontend from fsys. Paths without a file
+// behind them get index.html so the frontend's router can handle them, unless
+// they look like a file name: a missing script should be a 404, not HTML.
+type staticHandler struct {
+	fsys   fs.FS
+	maxAge time.Duration
+}
+
+func (h staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, prefix := range staticReserved {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			sendError(w, apierr.RouteNotFound, "Route not found")
+			return
+		}
+	}
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+	if name == "" || name == "index.html" {
+		h.serveIndex(w, r)
+		return
+	}
+	info, err := fs.Stat(h.fsys, name)
+	switch {
+	case err == nil && !info.IsDir():
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(h.maxAge/time.Second)))
+		http.ServeFileFS(w, r, h.fsys, name)
+	case (err == nil || errors.Is(err, fs.ErrNotExist)) && path.Ext(name) == "":
+		h.serveIndex(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveIndex never lets index.html be cached, since it names the current
+// versions of every other file.
+func (h staticHandler) serveIndex(w http.ResponseWriter, r *http.Request) {
+	index, err := fs.ReadFile(h.fsys, "index.html")
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(index)
+}
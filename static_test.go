@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestStaticFrontend(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "index.html"), []byte("<html>app</html>"), 0o644)
+	os.Mkdir(filepath.Join(dir, "assets"), 0o755)
+	os.WriteFile(filepath.Join(dir, "assets", "app.123.js"), []byte("console.log(1)"), 0o644)
+
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	app.cfg.staticDir = dir
+	app.cfg.staticMaxAge = time.Hour
+	router := app.routes()
+
+	testCases := []struct {
+		name      string
+		method    string
+		target    string
+		wantCode  int
+		wantBody  string
+		wantCache string
+	}{
+		{"Root", "GET", "/", http.StatusOK, "app", "no-cache"},
+		{"Asset", "GET", "/assets/app.123.js", http.StatusOK, "console.log", "public, max-age=3600"},
+		{"Client route", "GET", "/persons/3", http.StatusOK, "app", "no-cache"},
+		{"Directory", "GET", "/assets", http.StatusOK, "app", "no-cache"},
+		{"Missing asset", "GET", "/assets/app.456.js", http.StatusNotFound, "", ""},
+		{"Outside the directory", "GET", "/../go.mod", http.StatusMovedPermanently, "", ""},
+		{"Unknown API route", "GET", "/api/v1/nope", http.StatusNotFound, "ROUTE_NOT_FOUND", ""},
+		{"API route", "GET", "/api/v1/persons", http.StatusOK, "[", ""},
+		{"Health", "GET", "/livez", http.StatusOK, "ok", ""},
+		{"Not a GET", "POST", "/persons/3", http.StatusMethodNotAllowed, "", ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(tc.method, tc.target, nil))
+			if rr.Code != tc.wantCode || !strings.Contains(rr.Body.String(), tc.wantBody) {
+				t.Fatalf("Expected %d with %q, got %d: %s", tc.wantCode, tc.wantBody, rr.Code, rr.Body.String())
+			}
+			if got := rr.Header().Get("Cache-Control"); got != tc.wantCache {
+				t.Errorf("Expected Cache-Control %q, got %q", tc.wantCache, got)
+			}
+		})
+	}
+}
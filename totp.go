@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/store"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	totpHeader = "X-TOTP-Code"
+	// totpField carries the code in dashboard forms, which cannot set headers.
+	totpField  = "totp_code"
+	totpPeriod = 30
+	totpIssuer = "persons-service"
+)
+
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URL    string `json:"otpauth_url"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// verifyTOTP returns the time step code belongs to, allowing one step of
+// clock skew either way.
+func verifyTOTP(secret, code string, now time.Time) (int64, bool) {
+	opts := totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+	for _, skew := range []int64{0, -1, 1} {
+		step := now.Unix()/totpPeriod + skew
+		want, err := totp.GenerateCodeCustom(secret, time.Unix(step*totpPeriod, 0), opts)
+		if err == nil && subtle.ConstantTimeCompare([]byte(want), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// requireTOTP guards destructive admin endpoints with a code from the admin's
+// authenticator in the X-TOTP-Code header, or the totp_code field of a form
+// posted from the dashboard, on top of the admin token. Each code works once.
+// Until a secret is enrolled and confirmed the check is skipped, unless
+// adminTOTPRequired is set.
+func (app *application) requireTOTP(next http.HandlerFunc) http.Handler {
+	return app.totpGuard(next, app.cfg.adminTOTPRequired)
+}
+
+// totpGuard is requireTOTP with the enrollment requirement spelled out, since
+// enrollment itself has to work before there is anything to verify against.
+func (app *application) totpGuard(next http.HandlerFunc, enrolledOnly bool) http.Handler {
+	if app.totp == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret, err := app.totp.AdminTOTP(r.Context())
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			sendStoreError(w, r, err)
+			return
+		}
+		if err != nil || !secret.Confirmed {
+			if enrolledOnly {
+				sendError(w, apierr.Forbidden, "Enroll TOTP at /admin/totp before using this endpoint")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		code := r.Header.Get(totpHeader)
+		if code == "" {
+			code = r.PostFormValue(totpField)
+		}
+		step, ok := verifyTOTP(secret.Secret, code, time.Now())
+		if ok {
+			ok, err = app.totp.UseAdminTOTPStep(r.Context(), step)
+			if err != nil {
+				sendStoreError(w, r, err)
+				return
+			}
+		}
+		if !ok {
+			slog.WarnContext(r.Context(), "admin request with invalid totp code", "route", routeTemplate(r), "code_sent", code != "")
+			sendError(w, apierr.TOTPRequired, "A valid, unused TOTP code is required in "+totpHeader)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// enrollTOTP creates a new, unconfirmed secret for the admin's authenticator.
+// Replacing a confirmed secret goes through requireTOTP like any other
+// destructive endpoint.
+func (app *application) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	key, err := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: "admin", Period: totpPeriod})
+	if err != nil {
+		sendDebugError(w, apierr.Internal, "Failed to generate secret", errorDebug(r.Context(), err, 0))
+		return
+	}
+	if err := app.totp.EnrollAdminTOTP(r.Context(), key.Secret()); err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "admin totp enrolled, awaiting confirmation")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(TOTPEnrollment{Secret: key.Secret(), URL: key.URL()})
+}
+
+// confirmTOTP activates the enrolled secret once the admin shows a code from
+// it, so a mistyped secret cannot lock them out.
+func (app *application) confirmTOTP(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, apierr.InvalidJSON, "json decoding error")
+		return
+	}
+	secret, err := app.totp.AdminTOTP(r.Context())
+	if errors.Is(err, store.ErrNotFound) || err == nil && secret.Confirmed {
+		sendError(w, apierr.Conflict, "No TOTP enrollment is awaiting confirmation")
+		return
+	}
+	if err != nil {
+		sendStoreError(w, r, err)
+		return
+	}
+	step, ok := verifyTOTP(secret.Secret, req.Code, time.Now())
+	if ok {
+		ok, err = app.totp.ConfirmAdminTOTP(r.Context(), step)
+		if err != nil {
+			sendStoreError(w, r, err)
+			return
+		}
+	}
+	if !ok {
+		sendValidationError(w, apierr.ValidationFailed, "Invalid TOTP code", []apierr.FieldError{
+			apierr.NewFieldError("code", apierr.KeyRejected, map[string]any{"reason": "does not match the enrolled secret"}),
+		})
+		return
+	}
+	slog.InfoContext(r.Context(), "admin totp confirmed")
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+func totpCode(t *testing.T, secret string, at time.Time) string {
+	t.Helper()
+	code, err := totp.GenerateCodeCustom(secret, at, totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1})
+	if err != nil {
+		t.Fatalf("Failed to generate code: %v", err)
+	}
+	return code
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	key, _ := totp.Generate(totp.GenerateOpts{Issuer: totpIssuer, AccountName: "admin"})
+	now := time.Unix(1_700_000_010, 0)
+	step := now.Unix() / totpPeriod
+
+	testCases := []struct {
+		name     string
+		at       time.Time
+		wantOK   bool
+		wantStep int64
+	}{
+		{"Current step", now, true, step},
+		{"Previous step", now.Add(-totpPeriod * time.Second), true, step - 1},
+		{"Next step", now.Add(totpPeriod * time.Second), true, step + 1},
+		{"Too old", now.Add(-2 * totpPeriod * time.Second), false, 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := verifyTOTP(key.Secret(), totpCode(t, key.Secret(), tc.at), now)
+			if ok != tc.wantOK || got != tc.wantStep {
+				t.Errorf("Expected step %d ok %v, got %d %v", tc.wantStep, tc.wantOK, got, ok)
+			}
+		})
+	}
+}
+
+func TestAdminTOTP(t *testing.T) {
+	// Keep the codes below within one step of the clock for the whole test.
+	if into := time.Now().Unix() % totpPeriod; into > totpPeriod-3 {
+		time.Sleep(time.Duration(totpPeriod-into) * time.Second)
+	}
+	app := newTestAppWithStore(testutil.NewMemoryStore())
+	keys := testutil.NewMemoryKeyStore()
+	keys.Add(hashSecret("one"), store.APIKey{Name: "one"})
+	keys.Add(hashSecret("two"), store.APIKey{Name: "two"})
+	app.keys = keys
+	app.totp = testutil.NewMemoryTOTPStore()
+	app.cfg.adminToken = "s3cret"
+	app.cfg.adminTOTPRequired = true
+	router := withContractCheck(t, app.routes())
+
+	do := func(method, target, code string, body any) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if body != nil {
+			req = httptest.NewRequest(method, target, testutil.JSONBody(body))
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("Authorization", "Bearer s3cret")
+		if code != "" {
+			req.Header.Set(totpHeader, code)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := do("DELETE", "/admin/api-keys/1", "", nil); rr.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 before enrollment when TOTP is required, got %d", rr.Code)
+	}
+
+	rr := do("POST", "/admin/totp", "", nil)
+	var enrollment TOTPEnrollment
+	json.NewDecoder(rr.Body).Decode(&enrollment)
+	if rr.Code != http.StatusCreated || enrollment.Secret == "" {
+		t.Fatalf("Expected a new secret, got %d %+v", rr.Code, enrollment)
+	}
+	now := time.Now()
+	if rr := do("POST", "/admin/totp/confirm", "", TOTPCodeRequest{Code: "000000"}); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a wrong confirmation code to be refused, got %d", rr.Code)
+	}
+	previous := totpCode(t, enrollment.Secret, now.Add(-totpPeriod*time.Second))
+	if rr := do("POST", "/admin/totp/confirm", "", TOTPCodeRequest{Code: previous}); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected confirmation, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	current := totpCode(t, enrollment.Secret, now)
+	testCases := []struct {
+		name     string
+		method   string
+		target   string
+		code     string
+		wantCode int
+	}{
+		{"No code", "DELETE", "/admin/api-keys/1", "", http.StatusUnauthorized},
+		{"Code used for confirmation", "DELETE", "/admin/api-keys/1", previous, http.StatusUnauthorized},
+		{"Valid code", "DELETE", "/admin/api-keys/1", current, http.StatusNoContent},
+		{"Replayed code", "POST", "/admin/api-keys/2/rotate", current, http.StatusUnauthorized},
+		{"Next code", "POST", "/admin/api-keys/2/rotate", totpCode(t, enrollment.Secret, now.Add(totpPeriod*time.Second)), http.StatusOK},
+		{"Non-destructive endpoint", "GET", "/admin/api-keys", "", http.StatusOK},
+		{"Cleanup without code", "POST", "/admin/cleanup", "", http.StatusUnauthorized},
+		{"Drain without code", "POST", "/admin/drain", "", http.StatusUnauthorized},
+	}
+	for _, tc := range testCases {
+		rr := do(tc.method, tc.target, tc.code, nil)
+		if rr.Code != tc.wantCode {
+			t.Fatalf("%s: expected %d, got %d: %s", tc.name, tc.wantCode, rr.Code, rr.Body.String())
+		}
+		if rr.Code == http.StatusUnauthorized && decodeErrorCode(t, rr) != apierr.TOTPRequired {
+			t.Errorf("%s: expected TOTP_REQUIRED", tc.name)
+		}
+	}
+}
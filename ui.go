@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"ci_cd/rsoi_lab_1/internal/store"
+)
+
+var uiTemplates = template.Must(template.ParseFS(templateFS, "templates/ui/*.html"))
+
+type uiPage struct {
+	Create  personPage
+	Persons []store.Person
+}
+
+// renderPartial writes an HTML fragment for htmx to swap in. Validation
+// errors are answered with 200 too, since htmx only swaps successful
+// responses and the form with its messages is what should be shown.
+func renderPartial(w http.ResponseWriter, r *http.Request, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := uiTemplates.ExecuteTemplate(w, name, data); err != nil {
+		slog.ErrorContext(r.Context(), "failed to render ui", "template", name, "err", err)
+	}
+}
+
+// uiWrite refuses writes that did not come from the UI itself: htmx always
+// sends HX-Request, which a cross-site form cannot.
+func uiWrite(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("HX-Request") != "true" || !sameOrigin(r) {
+			renderError(w, http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (app *application) uiListPersons(r *http.Request) ([]store.Person, error) {
+	return app.store.ListPersons(r.Context(), store.ListFilter{
+		Name:  strings.TrimSpace(r.URL.Query().Get("q")),
+		Sort:  []store.SortKey{{Field: "id"}},
+		Limit: app.cfg.page.defaultSize,
+	})
+}
+
+func (app *application) uiIndex(w http.ResponseWriter, r *http.Request) {
+	list, err := app.uiListPersons(r)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "ui failed to list persons", "err", err)
+		renderError(w, http.StatusServiceUnavailable)
+		return
+	}
+	renderPartial(w, r, "index.html", uiPage{Persons: list})
+}
+
+func (app *application) uiRows(w http.ResponseWriter, r *http.Request) {
+	list, err := app.uiListPersons(r)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "ui failed to list persons", "err", err)
+		renderError(w, http.StatusServiceUnavailable)
+		return
+	}
+	renderPartial(w, r, "ui-rows", list)
+}
+
+// uiCreatePerson answers with an empty form and tells the table to reload, so
+// the new person shows up wherever the current search puts it.
+func (app *application) uiCreatePerson(w http.ResponseWriter, r *http.Request) {
+	form := readPersonForm(r)
+	req, errs := app.checkPersonForm(r.Context(), form, true)
+	if len(errs) > 0 {
+		renderPartial(w, r, "ui-create", personPage{Form: form, Errors: errs})
+		return
+	}
+	id, err := app.store.CreatePerson(r.Context(), store.Person{Name: *req.Name, Age: req.Age, Address: req.Address, Work: req.Work})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "ui failed to create person", "err", err)
+		renderPartial(w, r, "ui-create", personPage{Form: form, Errors: map[string]string{"form": "Saving failed, try again."}})
+		return
+	}
+	slog.InfoContext(r.Context(), "person created from ui", "id", id)
+	app.geocodeAddress(id, req)
+	w.Header().Set("HX-Trigger", "personsChanged")
+	renderPartial(w, r, "ui-create", personPage{})
+}
+
+// uiPerson renders one row, for display or, under /edit, as a form.
+func (app *application) uiPerson(edit bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := parseID(r)
+		if err != nil {
+			renderError(w, http.StatusNotFound)
+			return
+		}
+		p, err := app.store.GetPerson(r.Context(), id)
+		if errors.Is(err, store.ErrNotFound) {
+			renderError(w, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "ui failed to load person", "id", id, "err", err)
+			renderError(w, http.StatusServiceUnavailable)
+			return
+		}
+		if edit {
+			renderPartial(w, r, "ui-edit-row", personPage{ID: id, Form: formFromPerson(p)})
+			return
+		}
+		renderPartial(w, r, "ui-row", p)
+	}
+}
+
+func (app *application) uiSavePerson(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		renderError(w, http.StatusNotFound)
+		return
+	}
+	form := readPersonForm(r)
+	req, errs := app.checkPersonForm(r.Context(), form, false)
+	if len(errs) > 0 {
+		renderPartial(w, r, "ui-edit-row", personPage{ID: id, Form: form, Errors: errs})
+		return
+	}
+	var moved bool
+	p, err := app.store.ModifyPerson(r.Context(), id, func(p *store.Person) error {
+		moved = !equalPtr(p.Address, req.Address)
+		p.Name, p.Age, p.Address, p.Work = *req.Name, req.Age, req.Address, req.Work
+		return nil
+	})
+	if errors.Is(err, store.ErrNotFound) {
+		renderError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "ui failed to save person", "id", id, "err", err)
+		renderPartial(w, r, "ui-edit-row", personPage{ID: id, Form: form, Errors: map[string]string{"form": "Saving failed, try again."}})
+		return
+	}
+	slog.InfoContext(r.Context(), "person edited from ui", "id", id)
+	if moved {
+		app.geocodeAddress(id, req)
+	}
+	renderPartial(w, r, "ui-row", p)
+}
+
+// uiDeletePerson answers with nothing, which htmx swaps in for the row.
+func (app *application) uiDeletePerson(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(r)
+	if err != nil {
+		renderError(w, http.StatusNotFound)
+		return
+	}
+	err = app.store.DeletePerson(r.Context(), id)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		slog.ErrorContext(r.Context(), "ui failed to delete person", "id", id, "err", err)
+		renderError(w, http.StatusServiceUnavailable)
+		return
+	}
+	slog.InfoContext(r.Context(), "person deleted from ui", "id", id)
+	w.WriteHeader(http.StatusOK)
+}
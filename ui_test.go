@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/geocode"
+	"ci_cd/rsoi_lab_1/internal/store"
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestUI(t *testing.T) {
+	ctx := context.Background()
+	st := testutil.NewMemoryStore()
+	st.CreatePerson(ctx, store.Person{Name: "Ann", Age: testutil.Ptr[int32](30)})
+	app := newTestAppWithStore(st)
+	app.cfg.ui = true
+	app.addresses = fakeValidator{"12 Mian Stret": {Suggestions: []geocode.Suggestion{{Address: "12 Main Street"}}}}
+	router := app.routes()
+
+	do := func(method, target string, form url.Values, htmx bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		if form != nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		if htmx {
+			req.Header.Set("HX-Request", "true")
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	testCases := []struct {
+		name     string
+		method   string
+		target   string
+		form     url.Values
+		htmx     bool
+		wantCode int
+		wantBody string
+	}{
+		{"Page", "GET", "/ui", nil, false, http.StatusOK, "<td>Ann</td>"},
+		{"Search", "GET", "/ui/persons?q=zed", nil, true, http.StatusOK, "No persons found."},
+		{"Create without htmx", "POST", "/ui/persons", url.Values{"name": {"Bob"}}, false, http.StatusForbidden, ""},
+		{"Create invalid", "POST", "/ui/persons", url.Values{"name": {" "}, "age": {"x"}}, true, http.StatusOK, "age must be a whole number"},
+		{"Create unverified address", "POST", "/ui/persons", url.Values{"name": {"Bob"}, "address": {"12 Mian Stret"}}, true, http.StatusOK, "Did you mean 12 Main Street?"},
+		{"Create", "POST", "/ui/persons", url.Values{"name": {"Bob"}, "age": {"41"}}, true, http.StatusOK, `id="create"`},
+		{"Created row", "GET", "/ui/persons?q=bob", nil, true, http.StatusOK, "<td>41</td>"},
+		{"Edit form", "GET", "/ui/persons/1/edit", nil, true, http.StatusOK, `value="Ann"`},
+		{"Save", "PUT", "/ui/persons/1", url.Values{"name": {"Anna"}}, true, http.StatusOK, "<td>Anna</td>"},
+		{"Save unknown", "PUT", "/ui/persons/99", url.Values{"name": {"Anna"}}, true, http.StatusNotFound, ""},
+		{"Delete", "DELETE", "/ui/persons/2", nil, true, http.StatusOK, ""},
+		{"Deleted", "GET", "/ui/persons/2", nil, true, http.StatusNotFound, ""},
+	}
+	for _, tc := range testCases {
+		rr := do(tc.method, tc.target, tc.form, tc.htmx)
+		if rr.Code != tc.wantCode || !strings.Contains(rr.Body.String(), tc.wantBody) {
+			t.Fatalf("%s: expected %d with %q, got %d: %s", tc.name, tc.wantCode, tc.wantBody, rr.Code, rr.Body.String())
+		}
+		if tc.name == "Create" && rr.Header().Get("HX-Trigger") != "personsChanged" {
+			t.Errorf("Expected the table to be told to reload")
+		}
+	}
+	if p, _ := st.GetPerson(ctx, 1); p.Name != "Anna" || p.Age != nil {
+		t.Errorf("Expected the person replaced by the form, got %+v", p)
+	}
+
+	app.cfg.requireAPIKey = true
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest("GET", "/ui", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected no UI when API keys are required, got %d", rr.Code)
+	}
+}
+
+// The form has no inputs for custom fields, so a required one blocks
+// creating persons but not editing them.
+func TestUIRequiredCustomField(t *testing.T) {
+	ctx := context.Background()
+	st := testutil.NewMemoryStore()
+	st.CreatePerson(ctx, store.Person{Name: "Ann", Custom: map[string]any{"badge": 7.0}})
+	app := newTestAppWithStore(st)
+	app.cfg.ui = true
+	app.customFields = testutil.NewMemoryFieldStore(store.CustomField{Name: "badge", Type: store.FieldInteger, Required: true})
+	router := app.routes()
+
+	do := func(method, target string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("HX-Request", "true")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	rr := do("POST", "/ui/persons", url.Values{"name": {"Bob"}})
+	if !strings.Contains(rr.Body.String(), "custom.badge") || rr.Header().Get("HX-Trigger") != "" {
+		t.Errorf("Expected the missing custom field on the form, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if list, _ := st.ListPersons(ctx, store.ListFilter{}); len(list) != 1 {
+		t.Errorf("Expected no person created, got %+v", list)
+	}
+	rr = do("PUT", "/ui/persons/1", url.Values{"name": {"Anna"}})
+	if p, _ := st.GetPerson(ctx, 1); rr.Code != http.StatusOK || p.Name != "Anna" || p.Custom["badge"] != 7.0 {
+		t.Errorf("Expected the edit saved with the custom value kept, got %d %+v", rr.Code, p)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"cmp"
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+
+	"github.com/ttacon/libphonenumber"
+)
+
+const (
+	maxNameLength = 255
+	maxTextLength = 1024
+	minAge        = 0
+	maxAge        = 150
+)
+
+// validatePersonRequest checks a create (partial == false) or PATCH body and
+// returns every failure instead of stopping at the first one.
+func validatePersonRequest(req PersonRequest, partial bool) []apierr.FieldError {
+	var errs []apierr.FieldError
+	if req.Name == nil && !partial || req.Name != nil && strings.TrimSpace(*req.Name) == "" {
+		errs = append(errs, apierr.NewFieldError("name", apierr.KeyRequired, nil))
+	}
+	errs = appendMaxLength(errs, "name", req.Name, maxNameLength)
+	errs = appendMaxLength(errs, "address", req.Address, maxTextLength)
+	errs = appendMaxLength(errs, "work", req.Work, maxTextLength)
+	if req.Age != nil {
+		if *req.Age < minAge {
+			errs = append(errs, apierr.NewFieldError("age", apierr.KeyMinValue, map[string]any{"limit": minAge, "actual": *req.Age}))
+		}
+		if *req.Age > maxAge {
+			errs = append(errs, apierr.NewFieldError("age", apierr.KeyMaxValue, map[string]any{"limit": maxAge, "actual": *req.Age}))
+		}
+	}
+	errs = appendCoordinates(errs, "latitude", "longitude", req.Latitude, req.Longitude)
+	errs = appendEmail(errs, "email", req.Email)
+	return errs
+}
+
+// appendEmail checks a bare address, without a display name.
+func appendEmail(errs []apierr.FieldError, field string, email *string) []apierr.FieldError {
+	if email == nil {
+		return errs
+	}
+	if addr, err := mail.ParseAddress(*email); err != nil || addr.Address != strings.TrimSpace(*email) {
+		errs = append(errs, apierr.NewFieldError(field, apierr.KeyRejected, map[string]any{"reason": "not a valid email address"}))
+	}
+	return appendMaxLength(errs, field, email, maxEmailLength)
+}
+
+func optionalEmail(email *string) *string {
+	if email == nil {
+		return nil
+	}
+	e := normalizeEmail(*email)
+	return &e
+}
+
+// appendCoordinates checks a WGS 84 point. Latitude and longitude only make
+// sense together, so one without the other is rejected.
+func appendCoordinates(errs []apierr.FieldError, latField, lonField string, lat, lon *float64) []apierr.FieldError {
+	if lat == nil && lon != nil {
+		errs = append(errs, apierr.NewFieldError(latField, apierr.KeyRequired, nil))
+	}
+	if lon == nil && lat != nil {
+		errs = append(errs, apierr.NewFieldError(lonField, apierr.KeyRequired, nil))
+	}
+	errs = appendRange(errs, latField, lat, -90, 90)
+	errs = appendRange(errs, lonField, lon, -180, 180)
+	return errs
+}
+
+func appendRange(errs []apierr.FieldError, field string, value *float64, min, max float64) []apierr.FieldError {
+	if value == nil {
+		return errs
+	}
+	if *value < min {
+		errs = append(errs, apierr.NewFieldError(field, apierr.KeyMinValue, map[string]any{"limit": min, "actual": *value}))
+	}
+	if *value > max {
+		errs = append(errs, apierr.NewFieldError(field, apierr.KeyMaxValue, map[string]any{"limit": max, "actual": *value}))
+	}
+	return errs
+}
+
+// normalizePhone parses a phone number written internationally, or nationally
+// for defaultRegion (an ISO 3166 code, empty for none), and rewrites it in
+// E.164.
+func normalizePhone(phone *string, defaultRegion string, errs []apierr.FieldError) (*string, []apierr.FieldError) {
+	if phone == nil {
+		return nil, errs
+	}
+	num, err := libphonenumber.Parse(*phone, cmp.Or(defaultRegion, unknownRegion))
+	if err != nil || !libphonenumber.IsValidNumber(num) {
+		return phone, append(errs, apierr.NewFieldError("phone", apierr.KeyPhone, map[string]any{"actual": *phone}))
+	}
+	e164 := libphonenumber.Format(num, libphonenumber.E164)
+	return &e164, errs
+}
+
+// unknownRegion is libphonenumber's region for numbers that must carry their
+// country code.
+const unknownRegion = "ZZ"
+
+// phoneRegion returns the ISO 3166 region of a normalized number, or nil for
+// numbers not tied to one, such as international toll-free numbers.
+func phoneRegion(phone *string) *string {
+	if phone == nil {
+		return nil
+	}
+	num, err := libphonenumber.Parse(*phone, unknownRegion)
+	if err != nil {
+		return nil
+	}
+	region := libphonenumber.GetRegionCodeForNumber(num)
+	if region == "" || region == "001" || region == unknownRegion {
+		return nil
+	}
+	return &region
+}
+
+func appendMaxLength(errs []apierr.FieldError, field string, value *string, limit int) []apierr.FieldError {
+	if value == nil {
+		return errs
+	}
+	if n := utf8.RuneCountInString(*value); n > limit {
+		errs = append(errs, apierr.NewFieldError(field, apierr.KeyMaxLength, map[string]any{"limit": limit, "actual": n}))
+	}
+	return errs
+}
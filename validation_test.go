@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/apierr"
+)
+
+func TestValidatePersonRequest(t *testing.T) {
+	testCases := []struct {
+		name    string
+		req     PersonRequest
+		partial bool
+		keys    []string
+	}{
+		{"Valid create", PersonRequest{Name: stringPtr("Ann"), Age: int32Ptr(30)}, false, nil},
+		{"Missing name on create", PersonRequest{}, false, []string{apierr.KeyRequired}},
+		{"Missing name on patch", PersonRequest{Age: int32Ptr(30)}, true, nil},
+		{"Blank name on patch", PersonRequest{Name: stringPtr("  ")}, true, []string{apierr.KeyRequired}},
+		{"Name too long", PersonRequest{Name: stringPtr(strings.Repeat("a", maxNameLength+1))}, false, []string{apierr.KeyMaxLength}},
+		{"Negative age and long work", PersonRequest{Name: stringPtr("Ann"), Age: int32Ptr(-1), Work: stringPtr(strings.Repeat("w", maxTextLength+1))}, false, []string{apierr.KeyMaxLength, apierr.KeyMinValue}},
+		{"Age too large", PersonRequest{Name: stringPtr("Ann"), Age: int32Ptr(maxAge + 1)}, false, []string{apierr.KeyMaxValue}},
+		{"Coordinates", PersonRequest{Name: stringPtr("Ann"), Latitude: float64Ptr(55.75), Longitude: float64Ptr(-37.62)}, false, nil},
+		{"Latitude without longitude", PersonRequest{Latitude: float64Ptr(55.75)}, true, []string{apierr.KeyRequired}},
+		{"Coordinates out of range", PersonRequest{Name: stringPtr("Ann"), Latitude: float64Ptr(-91), Longitude: float64Ptr(181)}, false, []string{apierr.KeyMinValue, apierr.KeyMaxValue}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			errs := validatePersonRequest(tc.req, tc.partial)
+			if len(errs) != len(tc.keys) {
+				t.Fatalf("Expected %d errors, got %+v", len(tc.keys), errs)
+			}
+			for i, key := range tc.keys {
+				if errs[i].Key != key {
+					t.Errorf("Expected key %s, got %s", key, errs[i].Key)
+				}
+			}
+		})
+	}
+}
+
+func TestValidatePersonRequest_Params(t *testing.T) {
+	errs := validatePersonRequest(PersonRequest{Name: stringPtr("Ann"), Age: int32Ptr(200)}, false)
+	if len(errs) != 1 {
+		t.Fatalf("Expected one error, got %+v", errs)
+	}
+	if errs[0].Field != "age" || errs[0].Params["limit"] != maxAge || errs[0].Params["actual"] != int32(200) {
+		t.Errorf("Unexpected params: %+v", errs[0])
+	}
+}
+
+func TestNormalizePhone(t *testing.T) {
+	testCases := []struct {
+		name   string
+		phone  string
+		region string
+		want   string
+	}{
+		{"International", "+7 (495) 123-45-67", "", "+74951234567"},
+		{"National with region", "8 495 123-45-67", "RU", "+74951234567"},
+		{"Other country than region", "+1 650-253-0000", "RU", "+16502530000"},
+		{"National without region", "495 123-45-67", "", ""},
+		{"Too short", "+7 495", "", ""},
+		{"Garbage", "call me", "RU", ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, errs := normalizePhone(&tc.phone, tc.region, nil)
+			if tc.want == "" {
+				if len(errs) != 1 || errs[0].Field != "phone" || errs[0].Key != apierr.KeyPhone {
+					t.Errorf("Expected a phone error, got %+v", errs)
+				}
+				return
+			}
+			if len(errs) != 0 || *got != tc.want {
+				t.Errorf("Expected %s, got %v %+v", tc.want, *got, errs)
+			}
+		})
+	}
+	if r := phoneRegion(stringPtr("+16502530000")); r == nil || *r != "US" {
+		t.Errorf("Expected region US, got %v", r)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%FT%TZ)"
+//
+// Without ldflags commit and buildTime fall back to what the go tool recorded
+// from the checkout.
+var (
+	version   = "dev"
+	commit    = ""
+	buildTime = ""
+)
+
+type VersionResponse struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"build_time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+var buildVersion = readBuildVersion()
+
+func readBuildVersion() VersionResponse {
+	v := VersionResponse{Version: version, Commit: commit, BuildTime: buildTime, GoVersion: runtime.Version()}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v
+	}
+	for _, s := range info.Settings {
+		switch {
+		case s.Key == "vcs.revision" && v.Commit == "":
+			v.Commit = s.Value
+		case s.Key == "vcs.time" && v.BuildTime == "":
+			v.BuildTime = s.Value
+		case s.Key == "vcs.modified":
+			v.Modified = s.Value == "true"
+		}
+	}
+	return v
+}
+
+// release names the build for Sentry, e.g. "persons-service@1.4.0+3f2a1bc".
+func (v VersionResponse) release() string {
+	r := "persons-service@" + v.Version
+	if len(v.Commit) >= 7 {
+		r += "+" + v.Commit[:7]
+	}
+	return r
+}
+
+func getVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildVersion)
+}
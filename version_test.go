@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ci_cd/rsoi_lab_1/internal/testutil"
+)
+
+func TestVersion(t *testing.T) {
+	router := newTestAppWithStore(testutil.NewMemoryStore()).routes()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/version", nil))
+	var v VersionResponse
+	json.NewDecoder(rr.Body).Decode(&v)
+	if rr.Code != http.StatusOK || v.Version != version || v.GoVersion == "" {
+		t.Errorf("Unexpected version response %d %+v", rr.Code, v)
+	}
+}
+
+func TestVersionRelease(t *testing.T) {
+	testCases := []struct {
+		v    VersionResponse
+		want string
+	}{
+		{VersionResponse{Version: "dev"}, "persons-service@dev"},
+		{VersionResponse{Version: "1.4.0", Commit: "3f2a1bc9e0d1"}, "persons-service@1.4.0+3f2a1bc"},
+	}
+	for _, tc := range testCases {
+		if got := tc.v.release(); got != tc.want {
+			t.Errorf("Expected %q, got %q", tc.want, got)
+		}
+	}
+}